@@ -18,9 +18,14 @@ package denier // import "istio.io/mixer/adapter/denier"
 //       templates known to Mixer. For now, it's manually curated.
 
 import (
+	"bytes"
 	"context"
+	"net/url"
+	"text/template"
 	"time"
 
+	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
 	rpc "github.com/googleapis/googleapis/google/rpc"
 
 	"istio.io/mixer/adapter/denier/config"
@@ -32,6 +37,12 @@ import (
 
 type handler struct {
 	result adapter.CheckResult
+
+	// expands the message of the result with the fields of the instance, nil if the
+	// message is static
+	message *template.Template
+
+	log adapter.Logger
 }
 
 func defaultParam() *config.Params {
@@ -43,21 +54,83 @@ func defaultParam() *config.Params {
 }
 
 func newResult(c *config.Params) adapter.CheckResult {
+	s := c.Status
+	s.Details = newDetails(c)
+
 	return adapter.CheckResult{
-		Status:        c.Status,
+		Status:        s,
 		ValidDuration: c.ValidDuration,
 		ValidUseCount: c.ValidUseCount,
 	}
 }
 
+// newDetails returns the details of the configured status, followed by the RetryInfo and
+// Help details of the config.
+func newDetails(c *config.Params) []*types.Any {
+	var extra []proto.Message
+
+	if c.RetryDelay > 0 {
+		extra = append(extra, &rpc.RetryInfo{RetryDelay: types.DurationProto(c.RetryDelay)})
+	}
+
+	if len(c.HelpLinks) > 0 {
+		help := &rpc.Help{Links: make([]*rpc.Help_Link, 0, len(c.HelpLinks))}
+		for _, l := range c.HelpLinks {
+			help.Links = append(help.Links, &rpc.Help_Link{Description: l.Description, Url: l.Url})
+		}
+		extra = append(extra, help)
+	}
+
+	if len(extra) == 0 {
+		return c.Status.Details
+	}
+
+	// copy, so that the config is left untouched
+	details := make([]*types.Any, len(c.Status.Details), len(c.Status.Details)+len(extra))
+	copy(details, c.Status.Details)
+	for _, pb := range extra {
+		// the details are only missing if they fail to marshal, like in status.InvalidWithDetails
+		if any, err := types.MarshalAny(pb); err == nil {
+			details = append(details, any)
+		}
+	}
+	return details
+}
+
+// newMessage parses the template of the message, nil if there is none.
+func newMessage(c *config.Params) (*template.Template, error) {
+	if c.MessageTemplate == "" {
+		return nil, nil
+	}
+	return template.New("message").Parse(c.MessageTemplate)
+}
+
 ////////////////// Runtime Methods //////////////////////////
 
-func (h *handler) HandleCheckNothing(context.Context, *checknothing.Instance) (adapter.CheckResult, error) {
-	return h.result, nil
+func (h *handler) HandleCheckNothing(_ context.Context, instance *checknothing.Instance) (adapter.CheckResult, error) {
+	return h.check(instance), nil
 }
 
-func (h *handler) HandleListEntry(context.Context, *listentry.Instance) (adapter.CheckResult, error) {
-	return h.result, nil
+func (h *handler) HandleListEntry(_ context.Context, instance *listentry.Instance) (adapter.CheckResult, error) {
+	return h.check(instance), nil
+}
+
+// check returns the result, with its message expanded from the instance.
+func (h *handler) check(instance interface{}) adapter.CheckResult {
+	if h.message == nil {
+		return h.result
+	}
+
+	var buf bytes.Buffer
+	if err := h.message.Execute(&buf, instance); err != nil {
+		// still deny, with the message of the status
+		h.log.Warningf("Could not expand the denial message: %v", err)
+		return h.result
+	}
+
+	result := h.result
+	result.Status.Message = buf.String()
+	return result
 }
 
 func (*handler) HandleQuota(context.Context, *quota.Instance, adapter.QuotaArgs) (adapter.QuotaResult, error) {
@@ -85,17 +158,63 @@ func GetInfo() adapter.Info {
 }
 
 type builder struct {
-	adapterConfig *config.Params
+	adapterConfig     *config.Params
+	checkNothingTypes map[string]*checknothing.Type
+	listEntryTypes    map[string]*listentry.Type
+}
+
+func (b *builder) SetCheckNothingTypes(t map[string]*checknothing.Type) { b.checkNothingTypes = t }
+func (b *builder) SetListEntryTypes(t map[string]*listentry.Type)       { b.listEntryTypes = t }
+func (*builder) SetQuotaTypes(map[string]*quota.Type)                   {}
+func (b *builder) SetAdapterConfig(cfg adapter.Config)                  { b.adapterConfig = cfg.(*config.Params) }
+
+func (b *builder) Validate() (ce *adapter.ConfigErrors) {
+	ac := b.adapterConfig
+
+	if msg, err := newMessage(ac); err != nil {
+		ce = ce.Appendf("messageTemplate", "could not parse message template: %v", err)
+	} else if msg != nil {
+		// the template may only refer to the fields of the instances it's expanded with
+		if len(b.checkNothingTypes) > 0 {
+			ce = validateMessage(ce, msg, &checknothing.Instance{}, checknothing.TemplateName)
+		}
+		if len(b.listEntryTypes) > 0 {
+			ce = validateMessage(ce, msg, &listentry.Instance{}, listentry.TemplateName)
+		}
+	}
+
+	if ac.RetryDelay < 0 {
+		ce = ce.Appendf("retryDelay", "retry delay must be >= 0, it is %v", ac.RetryDelay)
+	}
+
+	for _, l := range ac.HelpLinks {
+		if u, err := url.Parse(l.Url); err != nil {
+			ce = ce.Append("helpLinks", err)
+		} else if u.Scheme == "" || u.Host == "" {
+			ce = ce.Appendf("helpLinks", "URL scheme and host cannot be empty in %s", l.Url)
+		}
+	}
+
+	return
 }
 
-func (*builder) SetCheckNothingTypes(map[string]*checknothing.Type) {}
-func (*builder) SetListEntryTypes(map[string]*listentry.Type)       {}
-func (*builder) SetQuotaTypes(map[string]*quota.Type)               {}
-func (b *builder) SetAdapterConfig(cfg adapter.Config)              { b.adapterConfig = cfg.(*config.Params) }
-func (*builder) Validate() (ce *adapter.ConfigErrors)               { return }
+// validateMessage checks that the message template can be expanded with an instance of
+// the given template.
+func validateMessage(ce *adapter.ConfigErrors, msg *template.Template, instance interface{}, name string) *adapter.ConfigErrors {
+	var buf bytes.Buffer
+	if err := msg.Execute(&buf, instance); err != nil {
+		ce = ce.Appendf("messageTemplate", "could not expand message template for %s instances: %v", name, err)
+	}
+	return ce
+}
 
 func (b *builder) Build(context context.Context, env adapter.Env) (adapter.Handler, error) {
+	// guaranteed to parse, since config was validated
+	msg, _ := newMessage(b.adapterConfig)
+
 	return &handler{
-		result: newResult(b.adapterConfig),
+		result:  newResult(b.adapterConfig),
+		message: msg,
+		log:     env.Logger(),
 	}, nil
 }
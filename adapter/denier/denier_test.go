@@ -21,7 +21,12 @@ import (
 	"context"
 	"reflect"
 	"testing"
+	"time"
 
+	"github.com/gogo/protobuf/types"
+	rpc "github.com/googleapis/googleapis/google/rpc"
+
+	"istio.io/mixer/adapter/denier/config"
 	"istio.io/mixer/pkg/adapter"
 	"istio.io/mixer/pkg/adapter/test"
 	"istio.io/mixer/template/checknothing"
@@ -91,6 +96,132 @@ func TestBasic(t *testing.T) {
 	}
 }
 
+func TestMessageTemplate(t *testing.T) {
+	info := GetInfo()
+	b := info.NewBuilder().(*builder)
+
+	cfg := defaultParam()
+	cfg.Status.Message = "denied"
+	cfg.MessageTemplate = `{{if .Value}}{{.Value}} is not allowed{{else}}{{.Name}} denied{{end}}`
+	b.SetAdapterConfig(cfg)
+	b.SetListEntryTypes(map[string]*listentry.Type{"list": {}})
+
+	if ce := b.Validate(); ce != nil {
+		t.Fatalf("Got error %v, expecting success", ce)
+	}
+
+	h, err := b.Build(context.Background(), test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	handler := h.(*handler)
+
+	cases := []struct {
+		instance *listentry.Instance
+		msg      string
+	}{
+		{&listentry.Instance{Name: "list", Value: "1.2.3.4"}, "1.2.3.4 is not allowed"},
+		{&listentry.Instance{Name: "list"}, "list denied"},
+		{nil, "denied"}, // falls back to the message of the status
+	}
+
+	for _, c := range cases {
+		result, err := handler.HandleListEntry(context.Background(), c.instance)
+		if err != nil {
+			t.Errorf("Got error %v, expecting success", err)
+		}
+		if result.Status.Message != c.msg {
+			t.Errorf("Got message '%s', expecting '%s'", result.Status.Message, c.msg)
+		}
+		if result.Status.Code != int32(rpc.FAILED_PRECONDITION) {
+			t.Errorf("Got code %v, expecting %v", result.Status.Code, rpc.FAILED_PRECONDITION)
+		}
+	}
+
+	// checknothing instances don't have a value
+	b.SetCheckNothingTypes(map[string]*checknothing.Type{"nothing": {}})
+	if ce := b.Validate(); ce == nil {
+		t.Error("Got success, expecting the template not to expand for checknothing instances")
+	}
+}
+
+func TestDetails(t *testing.T) {
+	info := GetInfo()
+	b := info.NewBuilder().(*builder)
+
+	cfg := defaultParam()
+	cfg.Status = rpc.Status{Code: int32(rpc.RESOURCE_EXHAUSTED), Message: "slow down"}
+	cfg.RetryDelay = 30 * time.Second
+	cfg.HelpLinks = []*config.Params_Link{{Description: "Rate limits", Url: "https://example.com/limits"}}
+	b.SetAdapterConfig(cfg)
+
+	if ce := b.Validate(); ce != nil {
+		t.Fatalf("Got error %v, expecting success", ce)
+	}
+
+	h, err := b.Build(context.Background(), test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+
+	result, err := h.(checknothing.Handler).HandleCheckNothing(context.Background(), &checknothing.Instance{})
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+
+	if len(result.Status.Details) != 2 {
+		t.Fatalf("Got %d details, expecting 2", len(result.Status.Details))
+	}
+
+	var retry rpc.RetryInfo
+	if err = types.UnmarshalAny(result.Status.Details[0], &retry); err != nil {
+		t.Fatalf("Got error %v, expecting RetryInfo", err)
+	}
+	if d, _ := types.DurationFromProto(retry.RetryDelay); d != 30*time.Second {
+		t.Errorf("Got retry delay %v, expecting 30s", d)
+	}
+
+	var help rpc.Help
+	if err = types.UnmarshalAny(result.Status.Details[1], &help); err != nil {
+		t.Fatalf("Got error %v, expecting Help", err)
+	}
+	if len(help.Links) != 1 || help.Links[0].Url != "https://example.com/limits" || help.Links[0].Description != "Rate limits" {
+		t.Errorf("Got links %v, expecting the configured link", help.Links)
+	}
+
+	if len(cfg.Status.Details) != 0 {
+		t.Errorf("Got %d details in the config, expecting it untouched", len(cfg.Status.Details))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name  string
+		cfg   config.Params
+		field string
+	}{
+		{"bad template", config.Params{MessageTemplate: "{{.Value"}, "messageTemplate"},
+		{"negative delay", config.Params{RetryDelay: -time.Second}, "retryDelay"},
+		{"bad link", config.Params{HelpLinks: []*config.Params_Link{{Url: "example.com/limits"}}}, "helpLinks"},
+		{"bad link URL", config.Params{HelpLinks: []*config.Params_Link{{Url: "%"}}}, "helpLinks"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			b := GetInfo().NewBuilder().(*builder)
+			b.SetAdapterConfig(&c.cfg)
+
+			ce := b.Validate()
+			if ce == nil {
+				t.Fatal("Got success, expecting failure")
+			}
+			if len(ce.Multi.Errors) != 1 || ce.Multi.Errors[0].(adapter.ConfigError).Field != c.field {
+				t.Errorf("Got %v, expecting an error for %s", ce, c.field)
+			}
+		})
+	}
+}
+
 func contains(s []string, e string) bool {
 	for _, a := range s {
 		if a == e {
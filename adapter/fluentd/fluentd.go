@@ -0,0 +1,234 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package fluentd provides an adapter that sends logentry instances to Fluentd over the forward protocol.
+package fluentd // import "istio.io/mixer/adapter/fluentd"
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sync"
+	"text/template"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/mixer/adapter/fluentd/config"
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/pool"
+	"istio.io/mixer/template/logentry"
+)
+
+const (
+	defaultTag          = "istio.{{.Name}}"
+	defaultBatchSize    = 100
+	defaultInterval     = 1 * time.Second
+	defaultBufferSize   = 10000
+	defaultTimeout      = 5 * time.Second
+	defaultMinReconnect = 100 * time.Millisecond
+	defaultMaxReconnect = 30 * time.Second
+
+	// severityKey is the record key of the severity of a log entry.
+	severityKey = "severity"
+)
+
+type handler struct {
+	tag       *template.Template
+	now       func() time.Time // used to control time in tests
+	f         *forwarder
+	closeOnce sync.Once
+}
+
+var _ logentry.Handler = &handler{}
+
+// HandleLogEntry buffers the log entries as events to be sent to Fluentd.
+func (h *handler) HandleLogEntry(_ context.Context, instances []*logentry.Instance) error {
+	var result *multierror.Error
+	dropped := 0
+	for _, instance := range instances {
+		buf := pool.GetBuffer()
+		err := h.tag.Execute(buf, instance)
+		tag := buf.String()
+		pool.PutBuffer(buf)
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("could not compute the tag of log entry %s: %v", instance.Name, err))
+			continue
+		}
+
+		ts := instance.Timestamp
+		if ts.IsZero() {
+			ts = h.now()
+		}
+		if !h.f.add(&event{tag: tag, time: ts.Unix(), record: toRecord(instance)}) {
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		result = multierror.Append(result, fmt.Errorf("fluentd buffer is full, dropped %d events", dropped))
+	}
+	return result.ErrorOrNil()
+}
+
+func (h *handler) Close() error {
+	var err error
+	h.closeOnce.Do(func() { err = h.f.Close() })
+	return err
+}
+
+// toRecord converts the variables of the log entry, and its severity, to an event record.
+func toRecord(instance *logentry.Instance) map[string]interface{} {
+	record := make(map[string]interface{}, len(instance.Variables)+1)
+	if instance.Severity != "" {
+		record[severityKey] = instance.Severity
+	}
+	for k, v := range instance.Variables {
+		record[k] = toValue(v)
+	}
+	return record
+}
+
+// toValue converts a variable to a value msgpack encodes natively.
+func toValue(v interface{}) interface{} {
+	switch vt := v.(type) {
+	case string, int64, float64, bool:
+		return vt
+	case int:
+		return int64(vt)
+	case time.Time:
+		return vt.Format(time.RFC3339Nano)
+	case time.Duration:
+		return vt.String()
+	case []byte:
+		// 4 and 16 byte values are IP addresses, other bytes are encoded as base64.
+		if len(vt) == net.IPv4len || len(vt) == net.IPv6len {
+			return net.IP(vt).String()
+		}
+		return base64.StdEncoding.EncodeToString(vt)
+	case nil:
+		return nil
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+////////////////// Config //////////////////////////
+
+// GetInfo returns the Info associated with this adapter implementation.
+func GetInfo() adapter.Info {
+	return adapter.Info{
+		Name:        "fluentd",
+		Impl:        "istio.io/mixer/adapter/fluentd",
+		Description: "Sends logs to Fluentd over the forward protocol",
+		SupportedTemplates: []string{
+			logentry.TemplateName,
+		},
+		DefaultConfig: &config.Params{
+			Address:              "localhost:24224",
+			Tag:                  defaultTag,
+			BatchSize:            defaultBatchSize,
+			FlushInterval:        defaultInterval,
+			BufferSize:           defaultBufferSize,
+			Timeout:              defaultTimeout,
+			MinReconnectInterval: defaultMinReconnect,
+			MaxReconnectInterval: defaultMaxReconnect,
+		},
+
+		NewBuilder: func() adapter.HandlerBuilder { return &builder{} },
+	}
+}
+
+type builder struct {
+	adapterConfig *config.Params
+}
+
+func (*builder) SetLogEntryTypes(map[string]*logentry.Type) {}
+func (b *builder) SetAdapterConfig(cfg adapter.Config)      { b.adapterConfig = cfg.(*config.Params) }
+
+func (b *builder) Validate() (ce *adapter.ConfigErrors) {
+	ac := b.adapterConfig
+	if _, _, err := net.SplitHostPort(ac.Address); err != nil {
+		ce = ce.Appendf("address", "invalid address '%s': %v", ac.Address, err)
+	}
+	if _, err := template.New("tag").Parse(ac.Tag); err != nil {
+		ce = ce.Appendf("tag", "failed to parse tag template '%s': %v", ac.Tag, err)
+	}
+	if ac.BatchSize < 0 {
+		ce = ce.Appendf("batchSize", "batch size must be >= 0")
+	}
+	if ac.FlushInterval < 0 {
+		ce = ce.Appendf("flushInterval", "flush interval must be >= 0")
+	}
+	if ac.BufferSize < 0 {
+		ce = ce.Appendf("bufferSize", "buffer size must be >= 0")
+	}
+	if ac.Timeout < 0 {
+		ce = ce.Appendf("timeout", "timeout must be >= 0")
+	}
+	if ac.MinReconnectInterval < 0 {
+		ce = ce.Appendf("minReconnectInterval", "min reconnect interval must be >= 0")
+	}
+	if ac.MaxReconnectInterval < 0 {
+		ce = ce.Appendf("maxReconnectInterval", "max reconnect interval must be >= 0")
+	} else if ac.MaxReconnectInterval > 0 && ac.MaxReconnectInterval < ac.MinReconnectInterval {
+		ce = ce.Appendf("maxReconnectInterval", "max reconnect interval must be >= min reconnect interval")
+	}
+	return
+}
+
+func (b *builder) Build(_ context.Context, env adapter.Env) (adapter.Handler, error) {
+	ac := b.adapterConfig
+
+	tagTmpl := ac.Tag
+	if tagTmpl == "" {
+		tagTmpl = defaultTag
+	}
+	tag, err := template.New("tag").Parse(tagTmpl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse tag template '%s': %v", tagTmpl, err)
+	}
+
+	f := &forwarder{
+		address:      ac.Address,
+		timeout:      orDefault(ac.Timeout, defaultTimeout),
+		requireAck:   ac.RequireAck,
+		batchSize:    int(ac.BatchSize),
+		interval:     orDefault(ac.FlushInterval, defaultInterval),
+		bufferSize:   int(ac.BufferSize),
+		dropOldest:   ac.DropPolicy == config.DROP_OLDEST,
+		minReconnect: orDefault(ac.MinReconnectInterval, defaultMinReconnect),
+		maxReconnect: orDefault(ac.MaxReconnectInterval, defaultMaxReconnect),
+		l:            env.Logger(),
+		wake:         make(chan struct{}, 1),
+		done:         make(chan struct{}),
+		stopped:      make(chan struct{}),
+	}
+	if f.batchSize == 0 {
+		f.batchSize = defaultBatchSize
+	}
+	if f.bufferSize == 0 {
+		f.bufferSize = defaultBufferSize
+	}
+	env.ScheduleDaemon(f.run)
+
+	return &handler{tag: tag, now: time.Now, f: f}, nil
+}
+
+func orDefault(d, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return d
+}
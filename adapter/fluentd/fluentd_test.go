@@ -0,0 +1,398 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fluentd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/ugorji/go/codec"
+
+	"istio.io/mixer/adapter/fluentd/config"
+	"istio.io/mixer/pkg/adapter/test"
+	"istio.io/mixer/template/logentry"
+)
+
+// message is a forward mode message as received by the server.
+type message struct {
+	tag     string
+	entries [][]interface{}
+	chunk   string
+}
+
+// server is a stand-in for a Fluentd forward input. It acknowledges the messages that carry a chunk, except on
+// the first dropConns connections which are closed as soon as a message is read, and delivers the messages it
+// accepts on a channel.
+type server struct {
+	net.Listener
+
+	lock      sync.Mutex
+	conns     int
+	dropConns int
+	msgs      chan message
+}
+
+func newServer(t *testing.T, dropConns int) *server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	s := &server{Listener: l, dropConns: dropConns, msgs: make(chan message, 10)}
+	go s.serve()
+	return s
+}
+
+func (s *server) serve() {
+	for {
+		conn, err := s.Accept()
+		if err != nil {
+			return
+		}
+		s.lock.Lock()
+		s.conns++
+		drop := s.conns <= s.dropConns
+		s.lock.Unlock()
+		go s.handle(conn, drop)
+	}
+}
+
+func (s *server) handle(conn net.Conn, drop bool) {
+	defer func() { _ = conn.Close() }()
+
+	h := &codec.MsgpackHandle{RawToString: true}
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	h.SignedInteger = true
+	dec := codec.NewDecoder(conn, h)
+	enc := codec.NewEncoder(conn, h)
+	for {
+		var raw []interface{}
+		if err := dec.Decode(&raw); err != nil || drop {
+			return
+		}
+
+		msg := message{tag: raw[0].(string)}
+		for _, e := range raw[1].([]interface{}) {
+			msg.entries = append(msg.entries, e.([]interface{}))
+		}
+		if len(raw) > 2 {
+			msg.chunk, _ = raw[2].(map[string]interface{})["chunk"].(string)
+		}
+		if msg.chunk != "" {
+			if err := enc.Encode(map[string]interface{}{"ack": msg.chunk}); err != nil {
+				return
+			}
+		}
+		s.msgs <- msg
+	}
+}
+
+func (s *server) connCount() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.conns
+}
+
+func (s *server) next(t *testing.T) message {
+	select {
+	case m := <-s.msgs:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the server to receive a message")
+		return message{}
+	}
+}
+
+func newHandler(t *testing.T, cfg *config.Params) *handler {
+	b := GetInfo().NewBuilder().(*builder)
+	b.SetAdapterConfig(cfg)
+	b.SetLogEntryTypes(map[string]*logentry.Type{"log": {}})
+	if err := b.Validate(); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	h, err := b.Build(context.Background(), test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	return h.(*handler)
+}
+
+func mustParse(t *testing.T, text string) *template.Template {
+	tmpl, err := template.New("tag").Parse(text)
+	if err != nil {
+		t.Fatalf("Unable to parse template '%s': %v", text, err)
+	}
+	return tmpl
+}
+
+func TestBasic(t *testing.T) {
+	info := GetInfo()
+	if !reflect.DeepEqual(info.SupportedTemplates, []string{logentry.TemplateName}) {
+		t.Errorf("Got templates %v, expecting %s", info.SupportedTemplates, logentry.TemplateName)
+	}
+
+	h := newHandler(t, info.DefaultConfig.(*config.Params))
+	if err := h.Close(); err != nil {
+		t.Errorf("Got error %v, expecting success", err)
+	}
+	if err := h.Close(); err != nil {
+		t.Errorf("Got error %v, expecting the second close to be a no-op", err)
+	}
+}
+
+func TestHandleLogEntry(t *testing.T) {
+	s := newServer(t, 0)
+	defer func() { _ = s.Close() }()
+
+	h := newHandler(t, &config.Params{
+		Address:    s.Addr().String(),
+		Tag:        "istio.{{.Name}}.{{.Severity}}",
+		RequireAck: true,
+		BatchSize:  3,
+	})
+	defer func() { _ = h.Close() }()
+
+	ts := time.Date(2017, time.October, 1, 12, 0, 0, 0, time.UTC)
+	entries := []*logentry.Instance{
+		{Name: "access", Severity: "INFO", Timestamp: ts, Variables: map[string]interface{}{
+			"method":   "GET",
+			"code":     int64(200),
+			"latency":  1500 * time.Millisecond,
+			"sourceIp": []byte{10, 0, 0, 1},
+			"received": ts,
+			"digest":   []byte{1, 2, 3},
+		}},
+		{Name: "access", Severity: "INFO", Timestamp: ts.Add(time.Second)},
+		{Name: "error", Severity: "ERROR", Timestamp: ts, Variables: map[string]interface{}{"retried": true}},
+	}
+	if err := h.HandleLogEntry(context.Background(), entries); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+
+	// a full batch is sent right away, as one message per tag.
+	m := s.next(t)
+	if m.tag != "istio.access.INFO" || len(m.entries) != 2 || m.chunk == "" {
+		t.Fatalf("Got message %v, expecting the two acknowledged access entries", m)
+	}
+	if m.entries[0][0] != ts.Unix() || m.entries[1][0] != ts.Unix()+1 {
+		t.Errorf("Got times %v and %v, expecting %d and %d", m.entries[0][0], m.entries[1][0], ts.Unix(), ts.Unix()+1)
+	}
+	want := map[string]interface{}{
+		"severity": "INFO",
+		"method":   "GET",
+		"code":     int64(200),
+		"latency":  "1.5s",
+		"sourceIp": "10.0.0.1",
+		"received": "2017-10-01T12:00:00Z",
+		"digest":   "AQID",
+	}
+	if got := m.entries[0][1]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Got record %v, expecting %v", got, want)
+	}
+
+	m = s.next(t)
+	want = map[string]interface{}{"severity": "ERROR", "retried": true}
+	if m.tag != "istio.error.ERROR" || len(m.entries) != 1 || !reflect.DeepEqual(m.entries[0][1], want) {
+		t.Errorf("Got message %v, expecting the error entry", m)
+	}
+}
+
+func TestFlushInterval(t *testing.T) {
+	s := newServer(t, 0)
+	defer func() { _ = s.Close() }()
+
+	h := newHandler(t, &config.Params{Address: s.Addr().String(), FlushInterval: 10 * time.Millisecond})
+	defer func() { _ = h.Close() }()
+
+	if err := h.HandleLogEntry(context.Background(), []*logentry.Instance{{Name: "log"}}); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	if m := s.next(t); m.tag != "istio.log" || len(m.entries) != 1 || m.chunk != "" {
+		t.Errorf("Got message %v, expecting a single unacknowledged entry", m)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	// the first two connections are closed without acknowledging the message.
+	s := newServer(t, 2)
+	defer func() { _ = s.Close() }()
+
+	h := newHandler(t, &config.Params{
+		Address:              s.Addr().String(),
+		RequireAck:           true,
+		BatchSize:            1,
+		MinReconnectInterval: time.Millisecond,
+		MaxReconnectInterval: 2 * time.Millisecond,
+	})
+	defer func() { _ = h.Close() }()
+
+	if err := h.HandleLogEntry(context.Background(), []*logentry.Instance{{Name: "log"}}); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	if m := s.next(t); m.tag != "istio.log" || len(m.entries) != 1 {
+		t.Errorf("Got message %v, expecting the entry to be sent again", m)
+	}
+	if got := s.connCount(); got != 3 {
+		t.Errorf("Got %d connections, expecting 3", got)
+	}
+}
+
+func TestClose(t *testing.T) {
+	s := newServer(t, 0)
+	defer func() { _ = s.Close() }()
+
+	h := newHandler(t, &config.Params{Address: s.Addr().String(), RequireAck: true, FlushInterval: time.Hour})
+	if err := h.HandleLogEntry(context.Background(), []*logentry.Instance{{Name: "log"}, {Name: "log"}}); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	if m := s.next(t); len(m.entries) != 2 {
+		t.Errorf("Got message %v, expecting the buffered entries to be sent on close", m)
+	}
+}
+
+func TestClose_Unreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	h := newHandler(t, &config.Params{
+		Address:              addr,
+		BatchSize:            2,
+		FlushInterval:        time.Hour,
+		MinReconnectInterval: time.Hour,
+		MaxReconnectInterval: time.Hour,
+	})
+	if err := h.HandleLogEntry(context.Background(), []*logentry.Instance{{Name: "a"}, {Name: "b"}, {Name: "c"}}); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+
+	// the forwarder gives up on the first failure instead of trying to send each message.
+	closed := make(chan error)
+	go func() { closed <- h.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("Got error %v, expecting success", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the handler to close")
+	}
+
+	found := false
+	logs := h.f.l.(*test.Env).GetLogs()
+	for _, l := range logs {
+		found = found || strings.Contains(l, "dropping 2 buffered events")
+	}
+	if !found {
+		t.Errorf("Expected a log about the buffered events being dropped, got logs: %v", logs)
+	}
+}
+
+func TestDropPolicy(t *testing.T) {
+	tests := []struct {
+		policy config.Params_DropPolicy
+		want   []string
+	}{
+		{config.DROP_NEWEST, []string{"a", "b"}},
+		{config.DROP_OLDEST, []string{"c", "d"}},
+	}
+
+	for idx, tt := range tests {
+		t.Run(fmt.Sprintf("[%d] %v", idx, tt.policy), func(t *testing.T) {
+			// the forwarder isn't running, so nothing is taken off the buffer.
+			f := &forwarder{bufferSize: 2, batchSize: 10, dropOldest: tt.policy == config.DROP_OLDEST, wake: make(chan struct{}, 1)}
+			h := &handler{tag: mustParse(t, "{{.Name}}"), now: time.Now, f: f}
+
+			entries := []*logentry.Instance{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}}
+			err := h.HandleLogEntry(context.Background(), entries)
+			if err == nil || !strings.Contains(err.Error(), "dropped 2 events") {
+				t.Errorf("Got error %v, expecting 2 events to be dropped", err)
+			}
+
+			var got []string
+			for _, e := range f.buffer {
+				got = append(got, e.tag)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Got buffered events %v, expecting %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleLogEntry_BadTag(t *testing.T) {
+	f := &forwarder{bufferSize: 10, batchSize: 10, wake: make(chan struct{}, 1)}
+	h := &handler{tag: mustParse(t, "{{.Name.Missing}}"), now: time.Now, f: f}
+
+	err := h.HandleLogEntry(context.Background(), []*logentry.Instance{{Name: "log"}})
+	if err == nil || !strings.Contains(err.Error(), "could not compute the tag") {
+		t.Errorf("Got error %v, expecting the tag to fail", err)
+	}
+	if len(f.buffer) != 0 {
+		t.Error("Got a buffered event, expecting it to be skipped")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   config.Params
+		field string
+	}{
+		{"default", *GetInfo().DefaultConfig.(*config.Params), ""},
+		{"no address", config.Params{}, "address"},
+		{"no port", config.Params{Address: "localhost"}, "address"},
+		{"tag", config.Params{Address: "fluentd:24224", Tag: "{{.Name"}, "tag"},
+		{"batch size", config.Params{Address: "fluentd:24224", BatchSize: -1}, "batchSize"},
+		{"flush interval", config.Params{Address: "fluentd:24224", FlushInterval: -time.Second}, "flushInterval"},
+		{"buffer size", config.Params{Address: "fluentd:24224", BufferSize: -1}, "bufferSize"},
+		{"timeout", config.Params{Address: "fluentd:24224", Timeout: -time.Second}, "timeout"},
+		{"min reconnect", config.Params{Address: "fluentd:24224", MinReconnectInterval: -time.Second}, "minReconnectInterval"},
+		{"max reconnect", config.Params{Address: "fluentd:24224", MaxReconnectInterval: -time.Second}, "maxReconnectInterval"},
+		{"reconnect range", config.Params{Address: "fluentd:24224", MinReconnectInterval: time.Minute,
+			MaxReconnectInterval: time.Second}, "maxReconnectInterval"},
+	}
+
+	for idx, tt := range tests {
+		t.Run(fmt.Sprintf("[%d] %s", idx, tt.name), func(t *testing.T) {
+			b := GetInfo().NewBuilder().(*builder)
+			cfg := tt.cfg
+			b.SetAdapterConfig(&cfg)
+
+			ce := b.Validate()
+			if tt.field == "" {
+				if ce != nil {
+					t.Errorf("Got error %v, expecting success", ce)
+				}
+				return
+			}
+			if ce == nil || !strings.Contains(ce.Error(), tt.field) {
+				t.Errorf("Got error %v, expecting one for %s", ce, tt.field)
+			}
+		})
+	}
+}
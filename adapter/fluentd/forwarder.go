@@ -0,0 +1,280 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package fluentd
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ugorji/go/codec"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+type (
+	// event is a single Fluentd event: a record logged at a time, routed by its tag.
+	event struct {
+		tag    string
+		time   int64 // seconds since the epoch
+		record map[string]interface{}
+	}
+
+	// forwarder buffers events and sends them in batches over the Fluentd forward protocol.
+	// See https://github.com/fluent/fluentd/wiki/Forward-Protocol-Specification-v1
+	forwarder struct {
+		address      string
+		timeout      time.Duration
+		requireAck   bool
+		batchSize    int
+		interval     time.Duration
+		bufferSize   int
+		dropOldest   bool
+		minReconnect time.Duration
+		maxReconnect time.Duration
+		l            adapter.Logger
+
+		lock   sync.Mutex // protects buffer
+		buffer []*event
+
+		wake    chan struct{} // signals a full batch is buffered
+		done    chan struct{} // closed to stop the forwarder
+		stopped chan struct{} // closed once the forwarder has sent the last batch
+
+		// only used by the run goroutine
+		conn    net.Conn
+		backoff time.Duration
+	}
+)
+
+// mh encodes messages in the msgpack format the forward protocol is built on.
+var mh = &codec.MsgpackHandle{RawToString: true, WriteExt: true}
+
+// add buffers the event, it returns false when an event had to be dropped because the buffer is full.
+func (f *forwarder) add(e *event) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	ok := true
+	if len(f.buffer) >= f.bufferSize {
+		if !f.dropOldest {
+			return false
+		}
+		f.buffer = f.buffer[1:]
+		ok = false
+	}
+	f.buffer = append(f.buffer, e)
+
+	if len(f.buffer) >= f.batchSize {
+		select {
+		case f.wake <- struct{}{}:
+		default:
+		}
+	}
+	return ok
+}
+
+// next takes up to a batch of events off the buffer.
+func (f *forwarder) next() []*event {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	n := len(f.buffer)
+	if n > f.batchSize {
+		n = f.batchSize
+	}
+	batch := make([]*event, n)
+	copy(batch, f.buffer)
+	f.buffer = f.buffer[n:]
+	return batch
+}
+
+// run sends the buffered events until the forwarder is closed.
+func (f *forwarder) run() {
+	defer close(f.stopped)
+
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.wake:
+			f.flush()
+		case <-ticker.C:
+			f.flush()
+		case <-f.done:
+			f.flush()
+			if f.conn != nil {
+				_ = f.conn.Close()
+			}
+			return
+		}
+	}
+}
+
+// flush sends all the buffered events, a batch at a time. Once the forwarder is closed, the events left
+// are dropped as soon as a message can't be sent, rather than trying to connect for each of them.
+func (f *forwarder) flush() {
+	for {
+		batch := f.next()
+		if len(batch) == 0 {
+			return
+		}
+		msgs := byTag(batch)
+		for i, msg := range msgs {
+			if !f.sendWithRetries(msg) && f.closing() {
+				n := 0
+				for _, m := range msgs[i+1:] {
+					n += len(m)
+				}
+				f.drop(n)
+				return
+			}
+		}
+	}
+}
+
+// sendWithRetries sends the events, reconnecting with an exponential backoff until they are sent. The events are
+// dropped, and false returned, when the forwarder is closed while they can't be sent.
+func (f *forwarder) sendWithRetries(events []*event) bool {
+	for {
+		err := f.send(events)
+		if err == nil {
+			f.backoff = 0
+			return true
+		}
+		if f.closing() {
+			_ = f.l.Errorf("dropping %d events for %s: %v", len(events), events[0].tag, err)
+			return false
+		}
+
+		if f.backoff == 0 {
+			f.backoff = f.minReconnect
+		} else if f.backoff *= 2; f.backoff > f.maxReconnect {
+			f.backoff = f.maxReconnect
+		}
+		f.l.Warningf("could not send %d events to %s, retrying in %v: %v", len(events), f.address, f.backoff, err)
+
+		select {
+		case <-time.After(f.backoff):
+		case <-f.done:
+			_ = f.l.Errorf("dropping %d events for %s: %v", len(events), events[0].tag, err)
+			return false
+		}
+	}
+}
+
+// closing returns true once the forwarder is closed.
+func (f *forwarder) closing() bool {
+	select {
+	case <-f.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// drop drops the buffered events, along with n events taken off the buffer which were not sent.
+func (f *forwarder) drop(n int) {
+	f.lock.Lock()
+	n += len(f.buffer)
+	f.buffer = nil
+	f.lock.Unlock()
+
+	if n > 0 {
+		_ = f.l.Errorf("dropping %d buffered events, the forwarder is closed", n)
+	}
+}
+
+// send writes the events, which share a tag, as a single forward mode message and waits for its acknowledgement
+// when required. The connection is closed on errors so that the next send reconnects.
+func (f *forwarder) send(events []*event) (err error) {
+	if f.conn == nil {
+		if f.conn, err = net.DialTimeout("tcp", f.address, f.timeout); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		if err != nil {
+			_ = f.conn.Close()
+			f.conn = nil
+		}
+	}()
+
+	entries := make([]interface{}, len(events))
+	for i, e := range events {
+		entries[i] = []interface{}{e.time, e.record}
+	}
+	msg := []interface{}{events[0].tag, entries}
+
+	var chunk string
+	if f.requireAck {
+		if chunk, err = newChunkID(); err != nil {
+			return err
+		}
+		msg = append(msg, map[string]interface{}{"chunk": chunk})
+	}
+
+	if err = f.conn.SetDeadline(time.Now().Add(f.timeout)); err != nil {
+		return err
+	}
+	if err = codec.NewEncoder(f.conn, mh).Encode(msg); err != nil {
+		return fmt.Errorf("could not write message: %v", err)
+	}
+	if !f.requireAck {
+		return nil
+	}
+
+	var resp map[string]interface{}
+	if err = codec.NewDecoder(f.conn, mh).Decode(&resp); err != nil {
+		return fmt.Errorf("could not read acknowledgement: %v", err)
+	}
+	if ack, _ := resp["ack"].(string); ack != chunk {
+		return fmt.Errorf("got acknowledgement '%v', expected '%s'", resp["ack"], chunk)
+	}
+	return nil
+}
+
+// Close stops the forwarder once the buffered events are sent.
+func (f *forwarder) Close() error {
+	close(f.done)
+	<-f.stopped
+	return nil
+}
+
+// byTag splits the batch into runs of events with the same tag, each sent as one message.
+func byTag(batch []*event) [][]*event {
+	var msgs [][]*event
+	start := 0
+	for i := 1; i <= len(batch); i++ {
+		if i == len(batch) || batch[i].tag != batch[start].tag {
+			msgs = append(msgs, batch[start:i])
+			start = i
+		}
+	}
+	return msgs
+}
+
+// newChunkID returns a unique ID for a message, acknowledged by the server.
+func newChunkID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
@@ -21,6 +21,7 @@ import (
 	"time"
 
 	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
@@ -35,6 +36,9 @@ type (
 	cacheController interface {
 		Run(<-chan struct{})
 		GetPod(string) (*v1.Pod, bool)
+		GetWorkload(*v1.Pod) (workload, bool)
+		GetNode(string) (*v1.Node, bool)
+		GetServices(*v1.Pod) []string
 		HasSynced() bool
 	}
 
@@ -42,12 +46,21 @@ type (
 		clientset     kubernetes.Interface
 		env           adapter.Env
 		pods          cache.SharedInformer
+		replicaSets   cache.SharedInformer
+		nodes         cache.SharedInformer
+		endpoints     cache.SharedIndexInformer
 		mutationsChan chan resourceMutation
 
 		ipPodMap      map[string]string
 		ipPodMapMutex *sync.RWMutex
 	}
 
+	// the workload owning a pod
+	workload struct {
+		name string
+		kind string
+	}
+
 	// used to send updates to the logger
 	resourceMutation struct {
 		kind eventType
@@ -77,10 +90,21 @@ const errorDelay = 1 * time.Second
 
 const debugVerbosityLevel = 4
 
+const (
+	// the kinds of the owners of pods which are looked up
+	replicaSetKind = "ReplicaSet"
+	podKind        = "Pod"
+
+	// indexes endpoints by the keys of the pods they target
+	podIndex = "pod"
+)
+
 // Responsible for setting up the cacheController, based on the supplied client.
 // It configures the index informer to list/watch pods and send update events
-// to a mutations channel for processing (in this case, logging).
-func newCacheController(clientset *kubernetes.Clientset, refreshDuration time.Duration, env adapter.Env) cacheController {
+// to a mutations channel for processing (in this case, logging). It also
+// configures the informers for the replica sets owning pods, the nodes running
+// them and the endpoints of the services selecting them.
+func newCacheController(clientset kubernetes.Interface, refreshDuration time.Duration, env adapter.Env) cacheController {
 	c := &controllerImpl{
 		clientset:     clientset,
 		env:           env,
@@ -94,10 +118,10 @@ func newCacheController(clientset *kubernetes.Clientset, refreshDuration time.Du
 	c.pods = cache.NewSharedIndexInformer(
 		&cache.ListWatch{
 			ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
-				return clientset.Pods(namespace).List(opts)
+				return clientset.CoreV1().Pods(namespace).List(opts)
 			},
 			WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
-				return clientset.Pods(namespace).Watch(opts)
+				return clientset.CoreV1().Pods(namespace).Watch(opts)
 			},
 		},
 		&v1.Pod{},
@@ -105,6 +129,48 @@ func newCacheController(clientset *kubernetes.Clientset, refreshDuration time.Du
 		cache.Indexers{},
 	)
 
+	c.replicaSets = cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+				return clientset.ExtensionsV1beta1().ReplicaSets(namespace).List(opts)
+			},
+			WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+				return clientset.ExtensionsV1beta1().ReplicaSets(namespace).Watch(opts)
+			},
+		},
+		&v1beta1.ReplicaSet{},
+		refreshDuration,
+		cache.Indexers{},
+	)
+
+	c.nodes = cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+				return clientset.CoreV1().Nodes().List(opts)
+			},
+			WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+				return clientset.CoreV1().Nodes().Watch(opts)
+			},
+		},
+		&v1.Node{},
+		refreshDuration,
+		cache.Indexers{},
+	)
+
+	c.endpoints = cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(opts metav1.ListOptions) (runtime.Object, error) {
+				return clientset.CoreV1().Endpoints(namespace).List(opts)
+			},
+			WatchFunc: func(opts metav1.ListOptions) (watch.Interface, error) {
+				return clientset.CoreV1().Endpoints(namespace).Watch(opts)
+			},
+		},
+		&v1.Endpoints{},
+		refreshDuration,
+		cache.Indexers{podIndex: endpointsPodKeys},
+	)
+
 	c.pods.AddEventHandler(
 		cache.ResourceEventHandlerFuncs{
 			AddFunc:    c.updateIPPodMap,
@@ -150,6 +216,10 @@ func (c *controllerImpl) Run(stop <-chan struct{}) {
 		c.pods.Run(stop)
 		c.env.Logger().Infof("pod cache started")
 	})
+	for _, informer := range c.clusterInformers() {
+		informer := informer
+		c.env.ScheduleDaemon(func() { informer.Run(stop) })
+	}
 	<-stop
 	c.env.Logger().Infof("cluster cache updating terminated")
 }
@@ -184,7 +254,31 @@ func (c *controllerImpl) runLogger(stop <-chan struct{}) {
 }
 
 func (c *controllerImpl) HasSynced() bool {
-	return c.pods.HasSynced()
+	if !c.pods.HasSynced() {
+		return false
+	}
+	for _, informer := range c.clusterInformers() {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
+// clusterInformers returns the informers of the resources related to pods, which
+// are configured along with the pod informer.
+func (c *controllerImpl) clusterInformers() []cache.SharedInformer {
+	var informers []cache.SharedInformer
+	if c.replicaSets != nil {
+		informers = append(informers, c.replicaSets)
+	}
+	if c.nodes != nil {
+		informers = append(informers, c.nodes)
+	}
+	if c.endpoints != nil {
+		informers = append(informers, c.endpoints)
+	}
+	return informers
 }
 
 // log is used to record all updates to a cache.
@@ -219,6 +313,92 @@ func (c *controllerImpl) GetPod(podKey string) (*v1.Pod, bool) {
 	return item.(*v1.Pod), true
 }
 
+// GetWorkload returns the workload owning the pod, found by following the
+// controller owner references of the pod, and of its replica set if it has one.
+func (c *controllerImpl) GetWorkload(pod *v1.Pod) (workload, bool) {
+	ref := controllerRef(pod.OwnerReferences)
+	if ref == nil {
+		return workload{}, false
+	}
+
+	if ref.Kind == replicaSetKind && c.replicaSets != nil {
+		item, exists, err := c.replicaSets.GetStore().GetByKey(key(pod.Namespace, ref.Name))
+		if exists && err == nil {
+			if rsRef := controllerRef(item.(*v1beta1.ReplicaSet).OwnerReferences); rsRef != nil {
+				return workload{name: rsRef.Name, kind: rsRef.Kind}, true
+			}
+		}
+	}
+
+	return workload{name: ref.Name, kind: ref.Kind}, true
+}
+
+// GetNode returns the Node object with the supplied name, if one exists (and is
+// known to the store).
+func (c *controllerImpl) GetNode(name string) (*v1.Node, bool) {
+	if c.nodes == nil {
+		return nil, false
+	}
+	item, exists, err := c.nodes.GetStore().GetByKey(name)
+	if !exists || err != nil {
+		return nil, false
+	}
+	return item.(*v1.Node), true
+}
+
+// GetServices returns the keys (namespace/name) of the services whose endpoints
+// target the pod.
+func (c *controllerImpl) GetServices(pod *v1.Pod) []string {
+	if c.endpoints == nil {
+		return nil
+	}
+	items, err := c.endpoints.GetIndexer().ByIndex(podIndex, key(pod.Namespace, pod.Name))
+	if err != nil {
+		return nil
+	}
+	services := make([]string, 0, len(items))
+	for _, item := range items {
+		ep := item.(*v1.Endpoints)
+		services = append(services, key(ep.Namespace, ep.Name))
+	}
+	return services
+}
+
+// controllerRef returns the owner reference of the controller, if any.
+func controllerRef(refs []metav1.OwnerReference) *metav1.OwnerReference {
+	for i := range refs {
+		if refs[i].Controller != nil && *refs[i].Controller {
+			return &refs[i]
+		}
+	}
+	return nil
+}
+
+// endpointsPodKeys returns the keys of the pods targeted by the endpoints, ready
+// or not, to index the endpoints by pod.
+func endpointsPodKeys(obj interface{}) ([]string, error) {
+	ep, ok := obj.(*v1.Endpoints)
+	if !ok {
+		return nil, nil
+	}
+	var keys []string
+	for _, subset := range ep.Subsets {
+		for _, addresses := range [][]v1.EndpointAddress{subset.Addresses, subset.NotReadyAddresses} {
+			for _, a := range addresses {
+				if a.TargetRef == nil || a.TargetRef.Kind != podKind {
+					continue
+				}
+				namespace := a.TargetRef.Namespace
+				if len(namespace) == 0 {
+					namespace = ep.Namespace
+				}
+				keys = append(keys, key(namespace, a.TargetRef.Name))
+			}
+		}
+	}
+	return keys, nil
+}
+
 func (e eventType) String() string {
 	switch e {
 	case addition:
@@ -15,12 +15,16 @@
 package kubernetes
 
 import (
+	"reflect"
 	"sync"
 	"testing"
 	"time"
 
 	"k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/tools/cache"
 
 	"istio.io/mixer/pkg/adapter/test"
@@ -279,3 +283,90 @@ func TestClusterInfoCache(t *testing.T) {
 		})
 	}
 }
+
+func controlledBy(kind, name string) []metav1.OwnerReference {
+	controller := true
+	return []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &controller}}
+}
+
+func TestClusterInfoCache_Clientset(t *testing.T) {
+	objects := []runtime.Object{
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "reviews-1", Namespace: "default", OwnerReferences: controlledBy("ReplicaSet", "reviews-1234")},
+			Spec:       v1.PodSpec{NodeName: "node-1"},
+			Status:     v1.PodStatus{PodIP: "10.1.1.1"},
+		},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db-0", Namespace: "default", OwnerReferences: controlledBy("StatefulSet", "db")}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "batch-1", Namespace: "default", OwnerReferences: controlledBy("ReplicaSet", "batch")}},
+		&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "standalone", Namespace: "default"}},
+		&v1beta1.ReplicaSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "reviews-1234", Namespace: "default", OwnerReferences: controlledBy("Deployment", "reviews")},
+		},
+		&v1beta1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "batch", Namespace: "default"}},
+		&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{zoneLabel: "us-central1-a"}}},
+		&v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "reviews", Namespace: "default"},
+			Subsets: []v1.EndpointSubset{{
+				Addresses: []v1.EndpointAddress{
+					{IP: "10.1.1.1", TargetRef: &v1.ObjectReference{Kind: "Pod", Name: "reviews-1", Namespace: "default"}},
+				},
+				NotReadyAddresses: []v1.EndpointAddress{
+					{IP: "10.1.1.2", TargetRef: &v1.ObjectReference{Kind: "Pod", Name: "db-0"}},
+				},
+			}},
+		},
+		&v1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{Name: "external", Namespace: "default"},
+			Subsets:    []v1.EndpointSubset{{Addresses: []v1.EndpointAddress{{IP: "192.168.1.1"}}}},
+		},
+	}
+
+	c := newCacheController(fake.NewSimpleClientset(objects...), 0, test.NewEnv(t))
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.Run(stop)
+
+	if !cache.WaitForCacheSync(stop, c.HasSynced) {
+		t.Fatal("Expected the caches to sync")
+	}
+
+	tests := []struct {
+		name     string
+		key      string
+		workload workload
+		found    bool
+		services []string
+	}{
+		{"deployment", "10.1.1.1", workload{name: "reviews", kind: "Deployment"}, true, []string{"default/reviews"}},
+		{"stateful set", "default/db-0", workload{name: "db", kind: "StatefulSet"}, true, []string{"default/reviews"}},
+		{"replica set", "default/batch-1", workload{name: "batch", kind: "ReplicaSet"}, true, []string{}},
+		{"no owner", "default/standalone", workload{}, false, []string{}},
+	}
+
+	for _, v := range tests {
+		t.Run(v.name, func(t *testing.T) {
+			pod, found := c.GetPod(v.key)
+			if !found {
+				t.Fatalf("GetPod(%s) => (_, false), wanted (_, true)", v.key)
+			}
+
+			w, found := c.GetWorkload(pod)
+			if found != v.found || w != v.workload {
+				t.Errorf("GetWorkload(%s) => (%v, %t), wanted (%v, %t)", v.key, w, found, v.workload, v.found)
+			}
+
+			if got := c.GetServices(pod); !reflect.DeepEqual(got, v.services) {
+				t.Errorf("GetServices(%s) => %v, wanted %v", v.key, got, v.services)
+			}
+		})
+	}
+
+	node, found := c.GetNode("node-1")
+	if !found || node.Labels[zoneLabel] != "us-central1-a" {
+		t.Errorf("GetNode(node-1) => (%v, %t), wanted the node with its zone", node, found)
+	}
+	if _, found = c.GetNode("node-2"); found {
+		t.Error("GetNode(node-2) => (_, true), wanted (_, false)")
+	}
+}
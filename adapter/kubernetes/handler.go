@@ -0,0 +1,154 @@
+// Copyright 2017 Istio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubernetes
+
+import (
+	"context"
+	"net"
+
+	"istio.io/mixer/adapter/kubernetes/config"
+	ktmpl "istio.io/mixer/adapter/kubernetes/template"
+	"istio.io/mixer/pkg/adapter"
+)
+
+type (
+	// handlerBuilder builds handlers for the kubernetesenv template. All the
+	// builders of an adapter share the same legacy builder, so that a single
+	// cache of the cluster state serves all the handlers.
+	handlerBuilder struct {
+		shared        *builder
+		adapterConfig *config.Params
+	}
+
+	handler struct {
+		*kubegen
+	}
+)
+
+var _ ktmpl.HandlerBuilder = &handlerBuilder{}
+var _ ktmpl.Handler = &handler{}
+
+// GetInfo returns the Info associated with this adapter implementation.
+func GetInfo() adapter.Info {
+	shared := newBuilder(newCacheFromConfig)
+	return adapter.Info{
+		Name:               name,
+		Impl:               "istio.io/mixer/adapter/kubernetes",
+		Description:        desc,
+		SupportedTemplates: []string{ktmpl.TemplateName},
+		DefaultConfig:      conf,
+		NewBuilder:         func() adapter.HandlerBuilder { return &handlerBuilder{shared: shared} },
+	}
+}
+
+func (*handlerBuilder) SetKubernetesenvTypes(map[string]*ktmpl.Type) {}
+func (b *handlerBuilder) SetAdapterConfig(cfg adapter.Config)        { b.adapterConfig = cfg.(*config.Params) }
+func (b *handlerBuilder) Validate() *adapter.ConfigErrors {
+	return b.shared.ValidateConfig(b.adapterConfig)
+}
+
+func (b *handlerBuilder) Build(_ context.Context, env adapter.Env) (adapter.Handler, error) {
+	g, err := b.shared.BuildAttributesGenerator(env, b.adapterConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &handler{g.(*kubegen)}, nil
+}
+
+// HandleKubernetesenv looks up the pods of the instance and returns their information.
+func (h *handler) HandleKubernetesenv(_ context.Context, inst *ktmpl.Instance) (*ktmpl.Output, error) {
+	p := h.params
+	inputs := make(map[string]interface{}, 6)
+	addInputs(inputs, p.SourceUidInputName, inst.SourceUid, p.SourceIpInputName, inst.SourceIp)
+	addInputs(inputs, p.DestinationUidInputName, inst.DestinationUid, p.DestinationIpInputName, inst.DestinationIp)
+	addInputs(inputs, p.OriginUidInputName, inst.OriginUid, p.OriginIpInputName, inst.OriginIp)
+
+	values, err := h.Generate(inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	out := ktmpl.NewOutput()
+	str := func(prefix, value string, set func(string)) {
+		if v, ok := values[valueName(prefix, value)].(string); ok {
+			set(v)
+		}
+	}
+	ip := func(prefix, value string, set func([]byte)) {
+		if v, ok := values[valueName(prefix, value)].(net.IP); ok {
+			set(v)
+		}
+	}
+	labels := func(prefix string, set func(map[string]string)) {
+		if v, ok := values[valueName(prefix, p.LabelsValueName)].(map[string]string); ok {
+			set(v)
+		}
+	}
+
+	labels(p.SourcePrefix, out.SetSourceLabels)
+	str(p.SourcePrefix, p.PodNameValueName, out.SetSourcePodName)
+	str(p.SourcePrefix, p.NamespaceValueName, out.SetSourceNamespace)
+	str(p.SourcePrefix, p.ServiceAccountValueName, out.SetSourceServiceAccountName)
+	ip(p.SourcePrefix, p.PodIpValueName, out.SetSourcePodIp)
+	ip(p.SourcePrefix, p.HostIpValueName, out.SetSourceHostIp)
+	str(p.SourcePrefix, p.ServiceValueName, out.SetSourceService)
+	str(p.SourcePrefix, p.ServicesValueName, out.SetSourceServices)
+	str(p.SourcePrefix, p.WorkloadNameValueName, out.SetSourceWorkloadName)
+	str(p.SourcePrefix, p.WorkloadKindValueName, out.SetSourceWorkloadKind)
+	str(p.SourcePrefix, p.NodeNameValueName, out.SetSourceNodeName)
+	str(p.SourcePrefix, p.ZoneValueName, out.SetSourceZone)
+	str(p.SourcePrefix, p.RegionValueName, out.SetSourceRegion)
+
+	labels(p.DestinationPrefix, out.SetDestinationLabels)
+	str(p.DestinationPrefix, p.PodNameValueName, out.SetDestinationPodName)
+	str(p.DestinationPrefix, p.NamespaceValueName, out.SetDestinationNamespace)
+	str(p.DestinationPrefix, p.ServiceAccountValueName, out.SetDestinationServiceAccountName)
+	ip(p.DestinationPrefix, p.PodIpValueName, out.SetDestinationPodIp)
+	ip(p.DestinationPrefix, p.HostIpValueName, out.SetDestinationHostIp)
+	str(p.DestinationPrefix, p.ServiceValueName, out.SetDestinationService)
+	str(p.DestinationPrefix, p.ServicesValueName, out.SetDestinationServices)
+	str(p.DestinationPrefix, p.WorkloadNameValueName, out.SetDestinationWorkloadName)
+	str(p.DestinationPrefix, p.WorkloadKindValueName, out.SetDestinationWorkloadKind)
+	str(p.DestinationPrefix, p.NodeNameValueName, out.SetDestinationNodeName)
+	str(p.DestinationPrefix, p.ZoneValueName, out.SetDestinationZone)
+	str(p.DestinationPrefix, p.RegionValueName, out.SetDestinationRegion)
+
+	labels(p.OriginPrefix, out.SetOriginLabels)
+	str(p.OriginPrefix, p.PodNameValueName, out.SetOriginPodName)
+	str(p.OriginPrefix, p.NamespaceValueName, out.SetOriginNamespace)
+	str(p.OriginPrefix, p.ServiceAccountValueName, out.SetOriginServiceAccountName)
+	ip(p.OriginPrefix, p.PodIpValueName, out.SetOriginPodIp)
+	ip(p.OriginPrefix, p.HostIpValueName, out.SetOriginHostIp)
+	str(p.OriginPrefix, p.ServiceValueName, out.SetOriginService)
+	str(p.OriginPrefix, p.ServicesValueName, out.SetOriginServices)
+	str(p.OriginPrefix, p.WorkloadNameValueName, out.SetOriginWorkloadName)
+	str(p.OriginPrefix, p.WorkloadKindValueName, out.SetOriginWorkloadKind)
+	str(p.OriginPrefix, p.NodeNameValueName, out.SetOriginNodeName)
+	str(p.OriginPrefix, p.ZoneValueName, out.SetOriginZone)
+	str(p.OriginPrefix, p.RegionValueName, out.SetOriginRegion)
+
+	return out, nil
+}
+
+// addInputs adds the uid and ip of a pod to the inputs of the legacy generator.
+// An empty uid or a missing ip is left out, so that the other is used for the lookup.
+func addInputs(inputs map[string]interface{}, uidName, uid, ipName string, ip interface{}) {
+	if len(uid) > 0 {
+		inputs[uidName] = uid
+	}
+	if ip != nil {
+		inputs[ipName] = ip
+	}
+}
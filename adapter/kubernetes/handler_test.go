@@ -0,0 +1,207 @@
+// Copyright 2017 Istio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kubernetes
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"istio.io/mixer/adapter/kubernetes/config"
+	ktmpl "istio.io/mixer/adapter/kubernetes/template"
+	"istio.io/mixer/pkg/adapter/test"
+)
+
+func TestGetInfo(t *testing.T) {
+	info := GetInfo()
+	if info.Name != name {
+		t.Errorf("Name = %s, want %s", info.Name, name)
+	}
+	if !reflect.DeepEqual(info.SupportedTemplates, []string{ktmpl.TemplateName}) {
+		t.Errorf("SupportedTemplates = %v, want [%s]", info.SupportedTemplates, ktmpl.TemplateName)
+	}
+
+	b1 := info.NewBuilder().(*handlerBuilder)
+	b2 := info.NewBuilder().(*handlerBuilder)
+	if b1.shared != b2.shared {
+		t.Error("NewBuilder() => builders should share the cluster state")
+	}
+
+	b1.SetKubernetesenvTypes(nil)
+	b1.SetAdapterConfig(info.DefaultConfig)
+	if err := b1.Validate(); err != nil {
+		t.Errorf("Validate() => can't validate the default configuration: %v", err)
+	}
+
+	b1.SetAdapterConfig(&config.Params{})
+	if err := b1.Validate(); err == nil {
+		t.Error("Validate() => expected errors for an empty configuration")
+	}
+}
+
+func TestHandlerBuilder_Build(t *testing.T) {
+	tests := []struct {
+		name    string
+		testFn  controllerFactoryFn
+		wantErr bool
+	}{
+		{"success", fakePodCache, false},
+		{"builder error", errorStartingPodCache, true},
+	}
+
+	for _, v := range tests {
+		t.Run(v.name, func(t *testing.T) {
+			b := &handlerBuilder{shared: newBuilder(v.testFn)}
+			b.SetAdapterConfig(conf)
+			h, err := b.Build(context.Background(), test.NewEnv(t))
+			if v.wantErr {
+				if err == nil {
+					t.Fatal("Expected error building handler")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got error, wanted none: %v", err)
+			}
+			if _, ok := h.(ktmpl.Handler); !ok {
+				t.Errorf("Build() => %T does not implement the kubernetesenv handler", h)
+			}
+			if err := h.Close(); err != nil {
+				t.Errorf("Close() => unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestHandler_HandleKubernetesenv(t *testing.T) {
+	pods := map[string]*v1.Pod{
+		"testns/test-pod": {
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "test-pod",
+				Namespace: "testns",
+				Labels:    map[string]string{"app": "test"},
+			},
+			Status: v1.PodStatus{
+				HostIP: "10.1.1.10",
+				PodIP:  "10.10.10.1",
+			},
+			Spec: v1.PodSpec{
+				ServiceAccountName: "test",
+				NodeName:           "node-1",
+			},
+		},
+		"192.168.234.3": {ObjectMeta: metav1.ObjectMeta{Name: "ip-pod", Namespace: "testns"}},
+	}
+	workloads := map[string]workload{
+		"testns/test-pod": {name: "test", kind: "Deployment"},
+	}
+	nodes := map[string]*v1.Node{
+		"node-1": {
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "node-1",
+				Labels: map[string]string{
+					"failure-domain.beta.kubernetes.io/zone":   "us-central1-a",
+					"failure-domain.beta.kubernetes.io/region": "us-central1",
+				},
+			},
+		},
+	}
+	services := map[string][]string{
+		"testns/test-pod": {"testns/test"},
+	}
+
+	tests := []struct {
+		name  string
+		inst  *ktmpl.Instance
+		check func(*testing.T, *ktmpl.Output)
+	}{
+		{
+			name: "source uid",
+			inst: &ktmpl.Instance{SourceUid: "kubernetes://test-pod.testns"},
+			check: func(t *testing.T, out *ktmpl.Output) {
+				want := ktmpl.NewOutput()
+				want.SetSourceLabels(map[string]string{"app": "test"})
+				want.SetSourcePodName("test-pod")
+				want.SetSourceNamespace("testns")
+				want.SetSourceServiceAccountName("test")
+				want.SetSourcePodIp(net.ParseIP("10.10.10.1"))
+				want.SetSourceHostIp(net.ParseIP("10.1.1.10"))
+				want.SetSourceService("test.testns.svc.cluster.local")
+				want.SetSourceServices("test.testns.svc.cluster.local")
+				want.SetSourceWorkloadName("test")
+				want.SetSourceWorkloadKind("Deployment")
+				want.SetSourceNodeName("node-1")
+				want.SetSourceZone("us-central1-a")
+				want.SetSourceRegion("us-central1")
+				if !reflect.DeepEqual(out, want) {
+					t.Errorf("got %#v, want %#v", out, want)
+				}
+			},
+		},
+		{
+			name: "destination ip",
+			inst: &ktmpl.Instance{DestinationIp: []byte(net.ParseIP("192.168.234.3"))},
+			check: func(t *testing.T, out *ktmpl.Output) {
+				if out.DestinationPodName != "ip-pod" || out.DestinationNamespace != "testns" {
+					t.Errorf("got pod %s.%s, want ip-pod.testns", out.DestinationPodName, out.DestinationNamespace)
+				}
+				if out.WasSet("destination_labels") || out.WasSet("source_pod_name") {
+					t.Errorf("got unexpected fields set: %#v", out)
+				}
+			},
+		},
+		{
+			name: "uid before ip",
+			inst: &ktmpl.Instance{
+				OriginUid: "kubernetes://test-pod.testns",
+				OriginIp:  []byte(net.ParseIP("192.168.234.3")),
+			},
+			check: func(t *testing.T, out *ktmpl.Output) {
+				if out.OriginPodName != "test-pod" {
+					t.Errorf("OriginPodName = %s, want test-pod", out.OriginPodName)
+				}
+			},
+		},
+		{
+			name: "unknown pods",
+			inst: &ktmpl.Instance{
+				SourceUid:     "kubernetes://missing.testns",
+				DestinationIp: []byte(net.IPv4zero),
+			},
+			check: func(t *testing.T, out *ktmpl.Output) {
+				if want := ktmpl.NewOutput(); !reflect.DeepEqual(out, want) {
+					t.Errorf("got %#v, want no fields set", out)
+				}
+			},
+		},
+	}
+
+	for _, v := range tests {
+		t.Run(v.name, func(t *testing.T) {
+			pc := fakeCache{pods: pods, workloads: workloads, nodes: nodes, services: services}
+			h := &handler{&kubegen{log: test.NewEnv(t).Logger(), params: *conf, pods: pc}}
+
+			out, err := h.HandleKubernetesenv(context.Background(), v.inst)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			v.check(t, out)
+		})
+	}
+}
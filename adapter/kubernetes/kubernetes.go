@@ -24,6 +24,7 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -84,6 +85,12 @@ const (
 	namespaceVal      = "Namespace"
 	serviceAccountVal = "ServiceAccountName"
 	serviceVal        = "Service"
+	workloadNameVal   = "WorkloadName"
+	workloadKindVal   = "WorkloadKind"
+	nodeNameVal       = "NodeName"
+	zoneVal           = "Zone"
+	regionVal         = "Region"
+	servicesVal       = "Services"
 
 	// value extraction
 	clusterDomain                      = "svc.cluster.local"
@@ -91,6 +98,8 @@ const (
 	istioPodServiceLabel               = "istio"
 	lookupIngressSourceAndOriginValues = false
 	istioIngressSvc                    = "ingress.istio-system.svc.cluster.local"
+	zoneLabel                          = "failure-domain.beta.kubernetes.io/zone"
+	regionLabel                        = "failure-domain.beta.kubernetes.io/region"
 
 	// cache invaliation
 	// TODO: determine a reasonable default
@@ -122,6 +131,12 @@ var (
 		ServiceValueName:                      serviceVal,
 		FullyQualifiedIstioIngressServiceName: istioIngressSvc,
 		LookupIngressSourceAndOriginValues:    lookupIngressSourceAndOriginValues,
+		WorkloadNameValueName:                 workloadNameVal,
+		WorkloadKindValueName:                 workloadKindVal,
+		NodeNameValueName:                     nodeNameVal,
+		ZoneValueName:                         zoneVal,
+		RegionValueName:                       regionVal,
+		ServicesValueName:                     servicesVal,
 	}
 )
 
@@ -197,6 +212,24 @@ func (*builder) ValidateConfig(c adapter.Config) (ce *adapter.ConfigErrors) {
 	if len(params.ServiceValueName) == 0 {
 		ce = ce.Appendf("serviceValueName", "field must be populated")
 	}
+	if len(params.WorkloadNameValueName) == 0 {
+		ce = ce.Appendf("workloadNameValueName", "field must be populated")
+	}
+	if len(params.WorkloadKindValueName) == 0 {
+		ce = ce.Appendf("workloadKindValueName", "field must be populated")
+	}
+	if len(params.NodeNameValueName) == 0 {
+		ce = ce.Appendf("nodeNameValueName", "field must be populated")
+	}
+	if len(params.ZoneValueName) == 0 {
+		ce = ce.Appendf("zoneValueName", "field must be populated")
+	}
+	if len(params.RegionValueName) == 0 {
+		ce = ce.Appendf("regionValueName", "field must be populated")
+	}
+	if len(params.ServicesValueName) == 0 {
+		ce = ce.Appendf("servicesValueName", "field must be populated")
+	}
 	if len(params.PodLabelForService) == 0 {
 		ce = ce.Appendf("podLabelForService", "field must be populated")
 	}
@@ -294,6 +327,18 @@ func (k *kubegen) addValues(vals map[string]interface{}, uid, valPrefix string)
 		return
 	}
 	addPodValues(vals, valPrefix, k.params, pod)
+	if w, found := k.pods.GetWorkload(pod); found {
+		addWorkloadValues(vals, valPrefix, k.params, w)
+	}
+	if len(pod.Spec.NodeName) > 0 {
+		vals[valueName(valPrefix, k.params.NodeNameValueName)] = pod.Spec.NodeName
+		if node, found := k.pods.GetNode(pod.Spec.NodeName); found {
+			addNodeValues(vals, valPrefix, k.params, node)
+		}
+	}
+	if services := k.pods.GetServices(pod); len(services) > 0 {
+		addServiceValues(vals, valPrefix, k.params, services)
+	}
 }
 
 func (k *kubegen) skipIngressLookups(values map[string]interface{}) bool {
@@ -347,6 +392,42 @@ func addPodValues(m map[string]interface{}, prefix string, params config.Params,
 	}
 }
 
+func addWorkloadValues(m map[string]interface{}, prefix string, params config.Params, w workload) {
+	m[valueName(prefix, params.WorkloadNameValueName)] = w.name
+	m[valueName(prefix, params.WorkloadKindValueName)] = w.kind
+}
+
+func addNodeValues(m map[string]interface{}, prefix string, params config.Params, n *v1.Node) {
+	if zone, found := n.Labels[zoneLabel]; found {
+		m[valueName(prefix, params.ZoneValueName)] = zone
+	}
+	if region, found := n.Labels[regionLabel]; found {
+		m[valueName(prefix, params.RegionValueName)] = region
+	}
+}
+
+// addServiceValues adds the names of the services, given as namespace/name keys.
+// The first service also becomes the service of the pod, unless its labels name one.
+func addServiceValues(m map[string]interface{}, prefix string, params config.Params, services []string) {
+	names := make([]string, 0, len(services))
+	for _, s := range services {
+		parts := strings.SplitN(s, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		names = append(names, fmt.Sprintf("%s.%s.%s", parts[1], parts[0], params.ClusterDomainName))
+	}
+	if len(names) == 0 {
+		return
+	}
+	sort.Strings(names)
+
+	m[valueName(prefix, params.ServicesValueName)] = strings.Join(names, ",")
+	if _, found := m[valueName(prefix, params.ServiceValueName)]; !found {
+		m[valueName(prefix, params.ServiceValueName)] = names[0]
+	}
+}
+
 func valueName(prefix, value string) string {
 	return fmt.Sprintf("%s%s", prefix, value)
 }
@@ -33,8 +33,11 @@ import (
 type fakeCache struct {
 	cacheController
 
-	pods map[string]*v1.Pod
-	path string
+	pods      map[string]*v1.Pod
+	workloads map[string]workload
+	nodes     map[string]*v1.Node
+	services  map[string][]string
+	path      string
 }
 
 func (fakeCache) HasSynced() bool {
@@ -50,6 +53,20 @@ func (f fakeCache) GetPod(pod string) (*v1.Pod, bool) {
 	return p, ok
 }
 
+func (f fakeCache) GetWorkload(pod *v1.Pod) (workload, bool) {
+	w, ok := f.workloads[key(pod.Namespace, pod.Name)]
+	return w, ok
+}
+
+func (f fakeCache) GetNode(name string) (*v1.Node, bool) {
+	n, ok := f.nodes[name]
+	return n, ok
+}
+
+func (f fakeCache) GetServices(pod *v1.Pod) []string {
+	return f.services[key(pod.Namespace, pod.Name)]
+}
+
 func errorStartingPodCache(ignored string, empty time.Duration, e adapter.Env) (cacheController, error) {
 	return nil, errors.New("cache build error")
 }
@@ -233,6 +250,35 @@ func TestKubegen_Generate(t *testing.T) {
 		"192.168.234.3":        {ObjectMeta: metav1.ObjectMeta{Name: "ip-svc-pod", Namespace: "testns", Labels: map[string]string{"app": "ipAddr"}}},
 		"istio-system/ingress": {ObjectMeta: metav1.ObjectMeta{Name: "ingress", Namespace: "istio-system", Labels: map[string]string{"istio": "ingress"}}},
 		"testns/ipApp":         {ObjectMeta: metav1.ObjectMeta{Name: "ipApp", Namespace: "testns", Labels: map[string]string{"app": "10.1.10.1"}}},
+		"testns/workload-pod": {
+			ObjectMeta: metav1.ObjectMeta{Name: "workload-pod", Namespace: "testns"},
+			Spec:       v1.PodSpec{NodeName: "node-1"},
+		},
+		"testns/labeled-pod": {
+			ObjectMeta: metav1.ObjectMeta{Name: "labeled-pod", Namespace: "testns", Labels: map[string]string{"app": "labeled"}},
+			Spec:       v1.PodSpec{NodeName: "unknown-node"},
+		},
+	}
+
+	workloads := map[string]workload{
+		"testns/workload-pod": {name: "reviews", kind: "Deployment"},
+	}
+
+	nodes := map[string]*v1.Node{
+		"node-1": {
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "node-1",
+				Labels: map[string]string{
+					"failure-domain.beta.kubernetes.io/zone":   "us-central1-a",
+					"failure-domain.beta.kubernetes.io/region": "us-central1",
+				},
+			},
+		},
+	}
+
+	services := map[string][]string{
+		"testns/workload-pod": {"testns/reviews-v2", "testns/reviews"},
+		"testns/labeled-pod":  {"testns/other"},
 	}
 
 	sourceUIDIn := map[string]interface{}{
@@ -358,6 +404,31 @@ func TestKubegen_Generate(t *testing.T) {
 		"destinationPodName":   "ipApp",
 	}
 
+	workloadIn := map[string]interface{}{"destinationUID": "kubernetes://workload-pod.testns"}
+
+	workloadOut := map[string]interface{}{
+		"destinationNamespace":    "testns",
+		"destinationPodName":      "workload-pod",
+		"destinationWorkloadName": "reviews",
+		"destinationWorkloadKind": "Deployment",
+		"destinationNodeName":     "node-1",
+		"destinationZone":         "us-central1-a",
+		"destinationRegion":       "us-central1",
+		"destinationServices":     "reviews-v2.testns.svc.cluster.local,reviews.testns.svc.cluster.local",
+		"destinationService":      "reviews-v2.testns.svc.cluster.local",
+	}
+
+	labeledServiceIn := map[string]interface{}{"destinationUID": "kubernetes://labeled-pod.testns"}
+
+	labeledServiceOut := map[string]interface{}{
+		"destinationLabels":    map[string]string{"app": "labeled"},
+		"destinationNamespace": "testns",
+		"destinationPodName":   "labeled-pod",
+		"destinationNodeName":  "unknown-node",
+		"destinationServices":  "other.testns.svc.cluster.local",
+		"destinationService":   "labeled.testns.svc.cluster.local",
+	}
+
 	confWithIngressLookups := *conf
 	confWithIngressLookups.LookupIngressSourceAndOriginValues = true
 
@@ -377,12 +448,14 @@ func TestKubegen_Generate(t *testing.T) {
 		{"istio ingress service (no lookup source)", istioDestinationSvcIn, istioDestinationOut, *conf},
 		{"istio ingress service (lookup source)", istioDestinationSvcIn, istioDestinationWithSrcOut, confWithIngressLookups},
 		{"ip app", ipAppSvcIn, ipAppDestinationOut, *conf},
+		{"workload, node and services", workloadIn, workloadOut, *conf},
+		{"service from labels", labeledServiceIn, labeledServiceOut, *conf},
 	}
 
 	for _, v := range tests {
 		t.Run(v.name, func(t *testing.T) {
-
-			kg := &kubegen{log: test.NewEnv(t).Logger(), params: v.params, pods: fakeCache{pods: pods}}
+			pc := fakeCache{pods: pods, workloads: workloads, nodes: nodes, services: services}
+			kg := &kubegen{log: test.NewEnv(t).Logger(), params: v.params, pods: pc}
 
 			got, err := kg.Generate(v.inputs)
 			if err != nil {
@@ -0,0 +1,178 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package list
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"istio.io/mixer/adapter/list/config"
+)
+
+type (
+	// listEntry is an entry of a list, along with the metadata of JSON and CSV lists.
+	listEntry struct {
+		Value  string `json:"value"`
+		Reason string `json:"reason"`
+		Owner  string `json:"owner"`
+
+		// when the entry stops being part of the list, zero if it never does
+		Expiry time.Time `json:"expiry"`
+	}
+
+	// represents the format of the data in a JSON list
+	listDocument struct {
+		Entries []listEntry `json:"entries"`
+	}
+)
+
+// the columns of a CSV list
+const (
+	valueColumn  = "value"
+	reasonColumn = "reason"
+	ownerColumn  = "owner"
+	expiryColumn = "expiry"
+)
+
+// parseEntries returns the entries of a list in the given format.
+func parseEntries(buf []byte, format config.Params_ListFormat, entryType config.Params_ListEntryType) ([]listEntry, error) {
+	switch format {
+	case config.JSON:
+		return parseJSONEntries(buf)
+	case config.CSV:
+		return parseCSVEntries(buf)
+	}
+
+	var values []string
+	if entryType == config.IP_ADDRESSES {
+		var err error
+		if values, err = parseIPPayload(buf); err != nil {
+			return nil, err
+		}
+	} else {
+		values = strings.Split(string(buf), "\n")
+	}
+
+	entries := make([]listEntry, len(values))
+	for i, v := range values {
+		entries[i].Value = v
+	}
+	return entries, nil
+}
+
+func parseJSONEntries(buf []byte) ([]listEntry, error) {
+	if len(bytes.TrimSpace(buf)) == 0 {
+		return nil, nil
+	}
+
+	var doc listDocument
+	if err := json.Unmarshal(buf, &doc); err != nil {
+		return nil, fmt.Errorf("could not unmarshal data from list %v", err)
+	}
+	return doc.Entries, nil
+}
+
+func parseCSVEntries(buf []byte) ([]listEntry, error) {
+	r := csv.NewReader(bytes.NewReader(buf))
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("could not read list header: %v", err)
+	}
+
+	// the index of each column, other columns are ignored
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns[valueColumn]; !ok {
+		return nil, fmt.Errorf("list header %q has no %s column", strings.Join(header, ","), valueColumn)
+	}
+
+	field := func(record []string, column string) string {
+		if i, ok := columns[column]; ok {
+			return record[i]
+		}
+		return ""
+	}
+
+	var entries []listEntry
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("could not read list entry: %v", err)
+		}
+
+		e := listEntry{
+			Value:  field(record, valueColumn),
+			Reason: field(record, reasonColumn),
+			Owner:  field(record, ownerColumn),
+		}
+
+		if expiry := field(record, expiryColumn); expiry != "" {
+			if e.Expiry, err = time.Parse(time.RFC3339, expiry); err != nil {
+				return nil, fmt.Errorf("could not parse expiry of list entry %s: %v", e.Value, err)
+			}
+		}
+
+		entries = append(entries, e)
+	}
+}
+
+// expired returns true if the entry is no longer part of the list at the given time.
+func (e *listEntry) expired(now time.Time) bool {
+	return !e.Expiry.IsZero() && !now.Before(e.Expiry)
+}
+
+// liveEntries returns the values of the entries which haven't expired at the given time,
+// the metadata of those which have any, and the time at which the next of them expires,
+// zero if none does.
+func liveEntries(entries []listEntry, now time.Time) ([]string, map[string]*listEntry, time.Time) {
+	values := make([]string, 0, len(entries))
+	var metadata map[string]*listEntry
+	var next time.Time
+
+	for i := range entries {
+		e := &entries[i]
+		if e.expired(now) {
+			continue
+		}
+		values = append(values, e.Value)
+
+		if e.Reason == "" && e.Owner == "" && e.Expiry.IsZero() {
+			continue
+		}
+		if metadata == nil {
+			metadata = make(map[string]*listEntry)
+		}
+		metadata[e.Value] = e
+
+		if !e.Expiry.IsZero() && (next.IsZero() || e.Expiry.Before(next)) {
+			next = e.Expiry
+		}
+	}
+
+	return values, metadata, next
+}
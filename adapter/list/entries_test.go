@@ -0,0 +1,100 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package list
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"istio.io/mixer/adapter/list/config"
+)
+
+func TestParseEntries(t *testing.T) {
+	expiry := time.Date(2017, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name      string
+		list      string
+		format    config.Params_ListFormat
+		entryType config.Params_ListEntryType
+		entries   []listEntry
+		fail      bool
+	}{
+		{"plain", "ABC\nDEF", config.PLAIN, config.STRINGS,
+			[]listEntry{{Value: "ABC"}, {Value: "DEF"}}, false},
+		{"plain IP", "whitelist:\n- 10.0.0.1\n- 10.0.1.0/24\n", config.PLAIN, config.IP_ADDRESSES,
+			[]listEntry{{Value: "10.0.0.1"}, {Value: "10.0.1.0/24"}}, false},
+		{"bad plain IP", "whitelist: 10.0.0.1\n", config.PLAIN, config.IP_ADDRESSES, nil, true},
+
+		{"JSON", `{"entries": [{"value": "ABC", "reason": "abuse", "owner": "secops", "expiry": "2017-10-01T00:00:00Z"}, {"value": "DEF"}]}`,
+			config.JSON, config.STRINGS,
+			[]listEntry{{Value: "ABC", Reason: "abuse", Owner: "secops", Expiry: expiry}, {Value: "DEF"}}, false},
+		{"empty JSON", " \n", config.JSON, config.STRINGS, nil, false},
+		{"bad JSON", `{"entries": ["ABC"]}`, config.JSON, config.STRINGS, nil, true},
+		{"bad JSON expiry", `{"entries": [{"value": "ABC", "expiry": "tomorrow"}]}`, config.JSON, config.STRINGS, nil, true},
+
+		{"CSV", "Value, Reason, Expiry, Comment\nABC, \"spam, mostly\", 2017-10-01T00:00:00Z, x\nDEF,,,\n",
+			config.CSV, config.STRINGS,
+			[]listEntry{{Value: "ABC", Reason: "spam, mostly", Expiry: expiry}, {Value: "DEF"}}, false},
+		{"CSV value only", "value\nABC\n", config.CSV, config.STRINGS, []listEntry{{Value: "ABC"}}, false},
+		{"empty CSV", "", config.CSV, config.STRINGS, nil, false},
+		{"CSV without value", "reason\nabuse\n", config.CSV, config.STRINGS, nil, true},
+		{"bad CSV expiry", "value,expiry\nABC,tomorrow\n", config.CSV, config.STRINGS, nil, true},
+		{"bad CSV record", "value,reason\nABC\n", config.CSV, config.STRINGS, nil, true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			entries, err := parseEntries([]byte(c.list), c.format, c.entryType)
+			if (err != nil) != c.fail {
+				t.Fatalf("Got error %v, expecting failure %v", err, c.fail)
+			}
+			if !reflect.DeepEqual(entries, c.entries) {
+				t.Errorf("Got %v, expecting %v", entries, c.entries)
+			}
+		})
+	}
+}
+
+func TestLiveEntries(t *testing.T) {
+	now := time.Now()
+	entries := []listEntry{
+		{Value: "ABC"},
+		{Value: "DEF", Reason: "abuse"},
+		{Value: "GHI", Expiry: now.Add(-time.Second)},
+		{Value: "JKL", Expiry: now.Add(time.Hour)},
+		{Value: "MNO", Expiry: now.Add(time.Minute)},
+		{Value: "PQR", Expiry: now},
+	}
+
+	values, metadata, next := liveEntries(entries, now)
+
+	if want := []string{"ABC", "DEF", "JKL", "MNO"}; !reflect.DeepEqual(values, want) {
+		t.Errorf("Got values %v, expecting %v", values, want)
+	}
+
+	if len(metadata) != 3 || metadata["DEF"] != &entries[1] || metadata["JKL"] != &entries[3] || metadata["MNO"] != &entries[4] {
+		t.Errorf("Got unexpected metadata %v", metadata)
+	}
+
+	if !next.Equal(now.Add(time.Minute)) {
+		t.Errorf("Got next expiry %v, expecting %v", next, now.Add(time.Minute))
+	}
+
+	if _, metadata, next = liveEntries(entries[:2], now); !next.IsZero() || len(metadata) != 1 {
+		t.Errorf("Got next expiry %v and metadata %v, expecting none to expire", next, metadata)
+	}
+}
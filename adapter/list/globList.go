@@ -0,0 +1,186 @@
+// Copyright 2017 Google Ina.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package list
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+type (
+	// globList indexes the patterns by their literal prefix or suffix, so that a symbol is
+	// only matched against the few patterns which share its prefix or suffix.
+	globList struct {
+		// the entries without wildcards
+		exact map[string]bool
+
+		prefixes globIndex
+		suffixes globIndex
+
+		// the patterns starting and ending with a wildcard
+		others []string
+
+		entries int
+	}
+
+	// globIndex holds patterns by the length and the value of their literal prefix or suffix
+	globIndex struct {
+		byLength map[int]map[string][]string
+
+		// the keys of byLength
+		lengths []int
+	}
+)
+
+const globWildcards = "*?"
+
+func newGlobList(entries []string, overrides []string) list {
+	ls := &globList{exact: make(map[string]bool)}
+
+	// copy the main patterns
+	for _, s := range entries {
+		ls.addEntry(s)
+	}
+
+	// apply overrides
+	for _, s := range overrides {
+		ls.addEntry(s)
+	}
+
+	return ls
+}
+
+func (ls *globList) addEntry(pattern string) {
+	if pattern == "" {
+		return
+	}
+	ls.entries++
+
+	first := strings.IndexAny(pattern, globWildcards)
+	if first < 0 {
+		ls.exact[pattern] = true
+		return
+	}
+
+	// index by the longest literal part, which selects the fewest patterns
+	last := strings.LastIndexAny(pattern, globWildcards)
+	prefix, suffix := pattern[:first], pattern[last+1:]
+	switch {
+	case prefix == "" && suffix == "":
+		ls.others = append(ls.others, pattern)
+	case len(prefix) >= len(suffix):
+		ls.prefixes.add(prefix, pattern)
+	default:
+		ls.suffixes.add(suffix, pattern)
+	}
+}
+
+func (gi *globIndex) add(key string, pattern string) {
+	if gi.byLength == nil {
+		gi.byLength = make(map[int]map[string][]string)
+	}
+
+	m := gi.byLength[len(key)]
+	if m == nil {
+		m = make(map[string][]string)
+		gi.byLength[len(key)] = m
+		gi.lengths = append(gi.lengths, len(key))
+	}
+	m[key] = append(m[key], pattern)
+}
+
+func (ls *globList) checkList(symbol string) (string, bool, error) {
+	if ls.exact[symbol] {
+		return symbol, true, nil
+	}
+
+	for _, l := range ls.prefixes.lengths {
+		if l <= len(symbol) {
+			if p, ok := matchAny(ls.prefixes.byLength[l][symbol[:l]], symbol); ok {
+				return p, true, nil
+			}
+		}
+	}
+
+	for _, l := range ls.suffixes.lengths {
+		if l <= len(symbol) {
+			if p, ok := matchAny(ls.suffixes.byLength[l][symbol[len(symbol)-l:]], symbol); ok {
+				return p, true, nil
+			}
+		}
+	}
+
+	p, ok := matchAny(ls.others, symbol)
+	return p, ok, nil
+}
+
+func (ls *globList) numEntries() int {
+	return ls.entries
+}
+
+// matchAny returns the first of the patterns matching the symbol.
+func matchAny(patterns []string, symbol string) (string, bool) {
+	for _, p := range patterns {
+		if matchGlob(p, symbol) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// matchGlob returns true if the whole symbol matches the pattern, where '*' matches any
+// sequence of characters and '?' matches a single character. When a match fails after a
+// '*', it's retried with the '*' matching one more character, which is enough since any
+// later '*' can match whatever an earlier one could.
+func matchGlob(pattern string, symbol string) bool {
+	px, sx := 0, 0
+
+	// where to restart after the last '*', if any
+	starPx, starSx := -1, 0
+
+	for px < len(pattern) || sx < len(symbol) {
+		if px < len(pattern) {
+			switch c := pattern[px]; c {
+			case '*':
+				starPx, starSx = px, sx
+				px++
+				continue
+			case '?':
+				if sx < len(symbol) {
+					_, w := utf8.DecodeRuneInString(symbol[sx:])
+					px++
+					sx += w
+					continue
+				}
+			default:
+				if sx < len(symbol) && symbol[sx] == c {
+					px++
+					sx++
+					continue
+				}
+			}
+		}
+
+		// let the last '*' match one more character
+		if starPx >= 0 && starSx < len(symbol) {
+			_, w := utf8.DecodeRuneInString(symbol[starSx:])
+			starSx += w
+			px, sx = starPx+1, starSx
+			continue
+		}
+		return false
+	}
+	return true
+}
@@ -0,0 +1,108 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package list
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestGlobList(t *testing.T) {
+	l := newGlobList([]string{"*.example.com", "host-??.local", "a+b", "*-canary-*", "staging.*"}, []string{"exact"})
+
+	cases := []struct {
+		symbol string
+		result bool
+		entry  string
+	}{
+		{"www.example.com", true, "*.example.com"},
+		{"a.b.example.com", true, "*.example.com"},
+		{"example.com", false, ""},
+		{"www.example.com.evil", false, ""},
+		{"wwwxexample.com", false, ""}, // '.' is not a wildcard
+		{"host-01.local", true, "host-??.local"},
+		{"host-1.local", false, ""},
+		{"a+b", true, "a+b"},
+		{"aab", false, ""},
+		{"web-canary-1", true, "*-canary-*"},
+		{"web-canary", false, ""},
+		{"staging.example.org", true, "staging.*"},
+		{"exact", true, "exact"},
+		{"exactly", false, ""},
+	}
+
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			entry, result, _ := l.checkList(c.symbol)
+			if result != c.result {
+				t.Errorf("Got %v, Want %v for %s", result, c.result, c.symbol)
+			}
+			if entry != c.entry {
+				t.Errorf("Got entry %q, Want %q for %s", entry, c.entry, c.symbol)
+			}
+		})
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern string
+		symbol  string
+		result  bool
+	}{
+		{"", "", true},
+		{"", "a", false},
+		{"*", "", true},
+		{"*", "abc", true},
+		{"a*", "a", true},
+		{"a*c", "abbbc", true},
+		{"a*c", "abbbd", false},
+		{"a*b*c", "axbyc", true},
+		{"a*b*c", "axcyb", false},
+		{"*a*a*a*a*b", "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", false},
+		{"?", "é", true},
+		{"??", "é", false},
+		{"*é", "café", true},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+	}
+
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if result := matchGlob(c.pattern, c.symbol); result != c.result {
+				t.Errorf("Got %v, Want %v for %s and %s", result, c.result, c.pattern, c.symbol)
+			}
+		})
+	}
+}
+
+func benchmarkGlobLookup(b *testing.B, n int) {
+	entries := make([]string, n)
+	for i := range entries {
+		entries[i] = fmt.Sprintf("*.host%d.example.com", i)
+	}
+
+	l := newGlobList(entries, nil)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _, _ = l.checkList(fmt.Sprintf("www.host%d.example.com", i%(2*n)))
+	}
+}
+
+func BenchmarkGlobLookup100(b *testing.B) { benchmarkGlobLookup(b, 100) }
+func BenchmarkGlobLookup10k(b *testing.B) { benchmarkGlobLookup(b, 10000) }
+func BenchmarkGlobLookup1m(b *testing.B)  { benchmarkGlobLookup(b, 1000000) }
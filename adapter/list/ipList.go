@@ -24,7 +24,7 @@ import (
 
 type (
 	ipList struct {
-		entries []*net.IPNet
+		entries ipTrie
 	}
 
 	// represents the format of the data in a list
@@ -33,18 +33,23 @@ type (
 	}
 )
 
-func parseIPList(buf []byte, overrides []string) (list, error) {
+// parseIPPayload returns the entries of a YAML list of addresses.
+func parseIPPayload(buf []byte) ([]string, error) {
 	var lp listPayload
 
 	if err := yaml.Unmarshal(buf, &lp); err != nil {
 		return nil, fmt.Errorf("could not unmarshal data from list %s", err)
 	}
 
-	ls := &ipList{make([]*net.IPNet, 0, len(lp.WhiteList)+len(overrides))}
+	return lp.WhiteList, nil
+}
+
+func newIPList(entries []string, overrides []string) (list, error) {
+	ls := &ipList{}
 	var err error
 
 	// copy to the internal format
-	for _, ip := range lp.WhiteList {
+	for _, ip := range entries {
 		if err = ls.addEntry(ip); err != nil {
 			return nil, err
 		}
@@ -69,28 +74,23 @@ func (ls *ipList) addEntry(ip string) error {
 	if err != nil {
 		return fmt.Errorf("could not parse list entry %s: %v", orig, err)
 	}
-	ls.entries = append(ls.entries, ipnet)
+	key, length := trieKey(ipnet)
+	ls.entries.insert(key, length, orig)
 
 	return nil
 }
 
-func (ls *ipList) checkList(symbol string) (bool, error) {
+func (ls *ipList) checkList(symbol string) (string, bool, error) {
 	ipa := net.ParseIP(symbol)
 	if ipa == nil {
 		// invalid symbol format
-		return false, fmt.Errorf("%s is not a valid IP address", symbol)
-	}
-
-	for _, ipnet := range ls.entries {
-		if ipnet.Contains(ipa) {
-			return true, nil
-		}
+		return "", false, fmt.Errorf("%s is not a valid IP address", symbol)
 	}
 
-	// not found in the list
-	return false, nil
+	entry, ok := ls.entries.lookup(ipa)
+	return entry, ok, nil
 }
 
 func (ls *ipList) numEntries() int {
-	return len(ls.entries)
+	return ls.entries.size
 }
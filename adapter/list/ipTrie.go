@@ -0,0 +1,159 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package list
+
+import (
+	"math/bits"
+	"net"
+)
+
+type (
+	// ipTrie is a path-compressed binary trie of IP ranges, where IPv4 ranges are
+	// stored as IPv4-mapped IPv6 ranges. A lookup walks at most one node per bit of
+	// the address, whatever the number of ranges, and the trie holds fewer than two
+	// nodes per range.
+	ipTrie struct {
+		root *trieNode
+		size int
+	}
+
+	trieNode struct {
+		// the bits of the prefix of the node, the bits past length are zero
+		prefix [net.IPv6len]byte
+		length int
+
+		// whether the prefix is a range of the list, or only joins its children
+		entry bool
+
+		// the list entry the range was parsed from
+		value string
+
+		// the longer prefixes, by the value of their bit following the prefix
+		children [2]*trieNode
+	}
+)
+
+// ipv4Offset is the length of the prefix of the IPv4-mapped IPv6 addresses.
+const ipv4Offset = 8 * (net.IPv6len - net.IPv4len)
+
+// trieKey returns the IPv6 form of the IP network and the length of its prefix.
+func trieKey(ipnet *net.IPNet) ([net.IPv6len]byte, int) {
+	ones, size := ipnet.Mask.Size()
+	if size == 8*net.IPv4len {
+		ones += ipv4Offset
+	}
+
+	var key [net.IPv6len]byte
+	copy(key[:], ipnet.IP.To16())
+	return maskKey(key, ones), ones
+}
+
+// maskKey returns the key with the bits past length cleared.
+func maskKey(key [net.IPv6len]byte, length int) [net.IPv6len]byte {
+	for i := range key {
+		switch {
+		case length >= 8*(i+1):
+		case length <= 8*i:
+			key[i] = 0
+		default:
+			key[i] &= ^byte(0xff >> uint(length-8*i))
+		}
+	}
+	return key
+}
+
+// bitAt returns the bit of the key at the given position, starting from the most
+// significant bit.
+func bitAt(key *[net.IPv6len]byte, i int) int {
+	return int(key[i/8]>>uint(7-i%8)) & 1
+}
+
+// commonLength returns the length of the common prefix of a and b, up to max bits.
+func commonLength(a *[net.IPv6len]byte, b *[net.IPv6len]byte, max int) int {
+	for i := 0; i < max; i += 8 {
+		if x := a[i/8] ^ b[i/8]; x != 0 {
+			if n := i + bits.LeadingZeros8(x); n < max {
+				return n
+			}
+			return max
+		}
+	}
+	return max
+}
+
+// insert adds the range of the given prefix to the trie, along with the list entry it
+// was parsed from.
+func (t *ipTrie) insert(key [net.IPv6len]byte, length int, value string) {
+	p := &t.root
+	for {
+		n := *p
+		if n == nil {
+			*p = &trieNode{prefix: key, length: length, entry: true, value: value}
+			t.size++
+			return
+		}
+
+		max := n.length
+		if length < max {
+			max = length
+		}
+
+		common := commonLength(&n.prefix, &key, max)
+		if common == n.length {
+			if length == n.length {
+				if !n.entry {
+					n.entry = true
+					t.size++
+				}
+				n.value = value
+				return
+			}
+
+			// the range belongs under the node
+			p = &n.children[bitAt(&key, n.length)]
+			continue
+		}
+
+		// the node and the range diverge, or the range contains the node: both go
+		// under a node of their common prefix.
+		split := &trieNode{prefix: maskKey(key, common), length: common}
+		split.children[bitAt(&n.prefix, common)] = n
+		if common == length {
+			split.entry = true
+			split.value = value
+		} else {
+			split.children[bitAt(&key, common)] = &trieNode{prefix: key, length: length, entry: true, value: value}
+		}
+		*p = split
+		t.size++
+		return
+	}
+}
+
+// lookup returns the list entry of the range the address belongs to, if any.
+func (t *ipTrie) lookup(ip net.IP) (string, bool) {
+	var key [net.IPv6len]byte
+	copy(key[:], ip.To16())
+
+	for n := t.root; n != nil; n = n.children[bitAt(&key, n.length)] {
+		if commonLength(&n.prefix, &key, n.length) < n.length {
+			return "", false
+		}
+		if n.entry || n.length == 8*net.IPv6len {
+			return n.value, n.entry
+		}
+	}
+	return "", false
+}
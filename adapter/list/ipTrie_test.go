@@ -0,0 +1,186 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package list
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"testing"
+)
+
+// linearIPList is the previous implementation of the IP list, kept to check and
+// benchmark the trie against.
+type linearIPList []*net.IPNet
+
+func (l linearIPList) contains(ip net.IP) bool {
+	for _, ipnet := range l {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func mustParseCIDR(t testing.TB, s string) *net.IPNet {
+	_, ipnet, err := net.ParseCIDR(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ipnet
+}
+
+func TestIPTrie(t *testing.T) {
+	var trie ipTrie
+	for _, s := range []string{
+		"10.0.0.0/8",
+		"10.1.0.0/16", // nested in a range
+		"192.168.1.0/24",
+		"192.168.0.0/24", // sibling of a range
+		"192.168.0.0/23", // contains ranges
+		"172.16.5.4/32",
+		"172.16.5.4/32", // duplicate
+		"2001:db8::/32",
+		"2001:db8:1::1/128",
+		"fe80::/10",
+	} {
+		key, length := trieKey(mustParseCIDR(t, s))
+		trie.insert(key, length, s)
+	}
+
+	if trie.size != 9 {
+		t.Errorf("Got %d entries, expecting 9", trie.size)
+	}
+
+	cases := []struct {
+		ip     string
+		result bool
+		entry  string
+	}{
+		{"10.0.0.1", true, "10.0.0.0/8"},
+		{"10.1.2.3", true, "10.0.0.0/8"},
+		{"10.255.255.255", true, "10.0.0.0/8"},
+		{"11.0.0.0", false, ""},
+		{"192.168.0.7", true, "192.168.0.0/23"},
+		{"192.168.1.255", true, "192.168.0.0/23"},
+		{"192.168.2.0", false, ""},
+		{"172.16.5.4", true, "172.16.5.4/32"},
+		{"172.16.5.5", false, ""},
+		{"::ffff:10.1.2.3", true, "10.0.0.0/8"},
+		{"2001:db8:ffff::1", true, "2001:db8::/32"},
+		{"2001:db9::1", false, ""},
+		{"fe80::1", true, "fe80::/10"},
+		{"fec0::1", false, ""},
+		{"::1", false, ""},
+	}
+
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			entry, result := trie.lookup(net.ParseIP(c.ip))
+			if result != c.result {
+				t.Errorf("Got %v, Want %v for %s", result, c.result, c.ip)
+			}
+			if entry != c.entry {
+				t.Errorf("Got entry %q, Want %q for %s", entry, c.entry, c.ip)
+			}
+		})
+	}
+}
+
+func TestIPTrieEverything(t *testing.T) {
+	var trie ipTrie
+	key, length := trieKey(mustParseCIDR(t, "0.0.0.0/0"))
+	trie.insert(key, length, "0.0.0.0/0")
+
+	if _, ok := trie.lookup(net.ParseIP("1.2.3.4")); !ok {
+		t.Error("Expecting the IPv4 addresses to be contained")
+	}
+	if _, ok := trie.lookup(net.ParseIP("2001:db8::1")); ok {
+		t.Error("Expecting the IPv6 addresses not to be contained")
+	}
+
+	key, length = trieKey(mustParseCIDR(t, "::/0"))
+	trie.insert(key, length, "::/0")
+	if _, ok := trie.lookup(net.ParseIP("2001:db8::1")); !ok {
+		t.Error("Expecting the IPv6 addresses to be contained")
+	}
+}
+
+// randomRanges returns n random IPv4 ranges of 8 to 32 bits.
+func randomRanges(r *rand.Rand, n int) []string {
+	ranges := make([]string, n)
+	for i := range ranges {
+		ranges[i] = fmt.Sprintf("%d.%d.%d.%d/%d", r.Intn(256), r.Intn(256), r.Intn(256), r.Intn(256), 8+r.Intn(25))
+	}
+	return ranges
+}
+
+func randomIP(r *rand.Rand) net.IP {
+	return net.IPv4(byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)), byte(r.Intn(256)))
+}
+
+func TestIPTrieMatchesLinearScan(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	var trie ipTrie
+	var linear linearIPList
+	for _, s := range randomRanges(r, 1000) {
+		ipnet := mustParseCIDR(t, s)
+		key, length := trieKey(ipnet)
+		trie.insert(key, length, s)
+		linear = append(linear, ipnet)
+	}
+
+	for i := 0; i < 10000; i++ {
+		ip := randomIP(r)
+		if _, got := trie.lookup(ip); got != linear.contains(ip) {
+			t.Fatalf("Got %v, Want %v for %s", got, !got, ip)
+		}
+	}
+}
+
+func benchmarkIPLookup(b *testing.B, n int, linear bool) {
+	r := rand.New(rand.NewSource(1))
+
+	var trie ipTrie
+	var ll linearIPList
+	for _, s := range randomRanges(r, n) {
+		ipnet := mustParseCIDR(b, s)
+		key, length := trieKey(ipnet)
+		trie.insert(key, length, s)
+		ll = append(ll, ipnet)
+	}
+
+	ips := make([]net.IP, 1024)
+	for i := range ips {
+		ips[i] = randomIP(r)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if linear {
+			_ = ll.contains(ips[i%len(ips)])
+		} else {
+			_, _ = trie.lookup(ips[i%len(ips)])
+		}
+	}
+}
+
+func BenchmarkIPLookupLinear1k(b *testing.B)   { benchmarkIPLookup(b, 1000, true) }
+func BenchmarkIPLookupTrie1k(b *testing.B)     { benchmarkIPLookup(b, 1000, false) }
+func BenchmarkIPLookupLinear100k(b *testing.B) { benchmarkIPLookup(b, 100000, true) }
+func BenchmarkIPLookupTrie100k(b *testing.B)   { benchmarkIPLookup(b, 100000, false) }
+func BenchmarkIPLookupTrie500k(b *testing.B)   { benchmarkIPLookup(b, 500000, false) }
@@ -16,17 +16,20 @@ package list // import "istio.io/mixer/adapter/list"
 
 import (
 	"context"
+	"crypto"
 	"crypto/sha1"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
-	"net/http"
 	"net/url"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	rpc "github.com/googleapis/googleapis/google/rpc"
 
 	"istio.io/mixer/adapter/list/config"
@@ -34,27 +37,50 @@ import (
 	"istio.io/mixer/template/listentry"
 )
 
+// minRetryInterval is the delay before retrying the first failed fetch of a list.
+const minRetryInterval = time.Second
+
 type (
 	handler struct {
 		log           adapter.Logger
 		closing       chan bool
-		refreshTicker *time.Ticker
+		refresherDone chan struct{}
+		refreshTimer  *time.Timer
 		purgeTimer    *time.Timer
+		expiryTimer   *time.Timer
 		config        config.Params
 
+		// the delay before retrying a failed fetch, zero after a successful fetch
+		retryInterval time.Duration
+
+		// watches the file or directory of a file provider, nil otherwise
+		watcher     *fsnotify.Watcher
+		watchedPath string
+
+		// verifies the signature of the list, nil if the list is not signed
+		publicKey crypto.PublicKey
+
 		lock           sync.Mutex
 		list           list
 		lastFetchError error
 
+		// the metadata of the entries of the list, by their value
+		metadata map[string]*listEntry
+
 		latestSHA [sha1.Size]byte
 
+		// the entries of the list while some of them are yet to expire, to rebuild the
+		// list without them once they do
+		entries []listEntry
+
 		// indirection to enable fault injection
 		readAll func(io.Reader) ([]byte, error)
 	}
 
 	// a specific list we use to check against
 	list interface {
-		checkList(symbol string) (bool, error)
+		// checkList returns the entry matching the symbol, if any.
+		checkList(symbol string) (string, bool, error)
 		numEntries() int
 	}
 )
@@ -64,6 +90,7 @@ type (
 func (h *handler) HandleListEntry(_ context.Context, entry *listentry.Instance) (adapter.CheckResult, error) {
 	h.lock.Lock()
 	l := h.list
+	metadata := h.metadata
 	err := h.lastFetchError
 	h.lock.Unlock()
 
@@ -72,9 +99,25 @@ func (h *handler) HandleListEntry(_ context.Context, entry *listentry.Instance)
 		return adapter.CheckResult{}, err
 	}
 
-	found, err := l.checkList(entry.Value)
+	match, found, err := l.checkList(entry.Value)
 	code := rpc.OK
 	msg := ""
+	validDuration := h.config.CachingInterval
+
+	var reason string
+	if md := metadata[match]; found && md != nil {
+		if !md.Expiry.IsZero() {
+			// the answer is only valid until the entry expires
+			ttl := md.Expiry.Sub(time.Now())
+			if ttl <= 0 {
+				// expired since the list was built, the refresher is about to remove it
+				found = false
+			} else if ttl < validDuration {
+				validDuration = ttl
+			}
+		}
+		reason = md.Reason
+	}
 
 	if err != nil {
 		code = rpc.INVALID_ARGUMENT
@@ -83,6 +126,9 @@ func (h *handler) HandleListEntry(_ context.Context, entry *listentry.Instance)
 		if found {
 			code = rpc.PERMISSION_DENIED
 			msg = fmt.Sprintf("%s is blacklisted", entry.Value)
+			if reason != "" {
+				msg = fmt.Sprintf("%s is blacklisted: %s", entry.Value, reason)
+			}
 		}
 	} else if !found {
 		code = rpc.NOT_FOUND
@@ -91,7 +137,7 @@ func (h *handler) HandleListEntry(_ context.Context, entry *listentry.Instance)
 
 	return adapter.CheckResult{
 		Status:        rpc.Status{Code: int32(code), Message: msg},
-		ValidDuration: h.config.CachingInterval,
+		ValidDuration: validDuration,
 		ValidUseCount: h.config.CachingUseCount,
 	}, nil
 }
@@ -99,38 +145,98 @@ func (h *handler) HandleListEntry(_ context.Context, entry *listentry.Instance)
 func (h *handler) Close() error {
 	close(h.closing)
 
-	if h.refreshTicker != nil {
-		h.refreshTicker.Stop()
+	if h.refresherDone != nil {
+		// wait for any fetch in progress, the refresher may reset the timers
+		<-h.refresherDone
+	}
+
+	if h.refreshTimer != nil {
+		h.refreshTimer.Stop()
 		h.purgeTimer.Stop()
+		h.expiryTimer.Stop()
 	}
 
 	return nil
 }
 
-// listRefresher updates the list by polling from the provider on a fixed interval
+// listRefresher updates the list by polling from the provider, and when the provider
+// file changes.
 func (h *handler) listRefresher() {
+	defer close(h.refresherDone)
+
+	var events <-chan fsnotify.Event
+	var errors <-chan error
+	if h.watcher != nil {
+		events = h.watcher.Events
+		errors = h.watcher.Errors
+	}
+
+	var debounce <-chan time.Time
 	for {
 		select {
-		case <-h.refreshTicker.C:
-			h.fetchList()
+		case <-h.refreshTimer.C:
+			h.refresh()
+
+		case ev := <-events:
+			if h.watched(ev.Name) {
+				debounce = time.After(watchDebounce)
+			}
+
+		case err := <-errors:
+			// the list is still refreshed periodically.
+			h.log.Warningf("Error while watching %s: %v", h.config.ProviderUrl, err)
+
+		case <-debounce:
+			debounce = nil
+			h.refresh()
 
 		case <-h.purgeTimer.C:
 			h.purgeList()
 
+		case <-h.expiryTimer.C:
+			h.expireEntries()
+
 		case <-h.closing:
+			if h.watcher != nil {
+				_ = h.watcher.Close()
+			}
 			return
 		}
 	}
 }
 
+// refresh fetches the list and schedules the next fetch, sooner when the fetch failed.
+func (h *handler) refresh() {
+	h.fetchList()
+
+	h.lock.Lock()
+	failed := h.lastFetchError != nil
+	h.lock.Unlock()
+
+	resetTimer(h.refreshTimer, h.nextRefresh(failed))
+}
+
+// nextRefresh returns the delay before the next fetch. After a failure, the fetch is
+// retried after minRetryInterval, doubling after each failure up to the refresh interval.
+func (h *handler) nextRefresh(failed bool) time.Duration {
+	if !failed {
+		h.retryInterval = 0
+		return h.config.RefreshInterval
+	}
+
+	if h.retryInterval == 0 {
+		h.retryInterval = minRetryInterval
+	} else {
+		h.retryInterval *= 2
+	}
+
+	if h.retryInterval > h.config.RefreshInterval {
+		h.retryInterval = h.config.RefreshInterval
+	}
+	return h.retryInterval
+}
+
 // fetchList retrieves and prepares an updated list
-//
-// TODO: This should implement some more aggressive retry mechanism.
-//       Right now, it a fetch fails, the code will just punt and wait
-//       until the next refresh timer tick to try again. When a failure
-//       happens, the code should switch to a more aggressive incremental
-//       backoff approach so that an updated list can be gotten as soon as
-//       things are back online.
 func (h *handler) fetchList() {
 	buf := []byte{}
 	sha := h.latestSHA
@@ -140,24 +246,19 @@ func (h *handler) fetchList() {
 	if h.config.ProviderUrl != "" {
 		h.log.Infof("Fetching list from %s", h.config.ProviderUrl)
 
-		var resp *http.Response
-		resp, err = http.Get(h.config.ProviderUrl)
-		if err != nil || resp.StatusCode != http.StatusOK {
-			if err != nil {
-				err = h.log.Errorf("could not fetch list from %s: %v", h.config.ProviderUrl, err)
-			} else {
-				err = h.log.Errorf("could not fetch list from %s: %v", h.config.ProviderUrl, resp.StatusCode)
-			}
+		buf, err = h.readProvider(h.config.ProviderUrl, h.config.MaxListSize)
+		if err != nil {
+			err = h.log.Errorf("could not fetch list from %s: %v", h.config.ProviderUrl, err)
 			h.lock.Lock()
 			h.lastFetchError = err
 			h.lock.Unlock()
 			return
 		}
 
-		// TODO: could lead to OOM since this is unbounded
-		buf, err = h.readAll(resp.Body)
-		if err != nil {
-			err = h.log.Errorf("Could not read from %s: %v", h.config.ProviderUrl, err)
+		// a list which can't be verified is rejected, keeping the previous list, even when its
+		// content is unchanged, as the checksum or signature served alongside it may have changed
+		if err = h.verifyList(buf); err != nil {
+			err = h.log.Errorf("could not verify list from %s: %v", h.config.ProviderUrl, err)
 			h.lock.Lock()
 			h.lastFetchError = err
 			h.lock.Unlock()
@@ -175,21 +276,20 @@ func (h *handler) fetchList() {
 	}
 
 	var l list
+	var metadata map[string]*listEntry
+	var next time.Time
 
-	switch h.config.EntryType {
-	case config.STRINGS:
-		l = parseStringList(buf, h.config.Overrides)
-	case config.CASE_INSENSITIVE_STRINGS:
-		l = parseCaseInsensitiveStringList(buf, h.config.Overrides)
-	case config.IP_ADDRESSES:
-		l, err = parseIPList(buf, h.config.Overrides)
-		if err != nil {
-			err = h.log.Errorf("Could not parse data from %s: %v", h.config.ProviderUrl, err)
-			h.lock.Lock()
-			h.lastFetchError = err
-			h.lock.Unlock()
-			return
-		}
+	entries, err := parseEntries(buf, h.config.ListFormat, h.config.EntryType)
+	if err == nil {
+		l, metadata, next, err = h.buildList(entries, time.Now())
+	}
+
+	if err != nil {
+		err = h.log.Errorf("Could not parse data from %s: %v", h.config.ProviderUrl, err)
+		h.lock.Lock()
+		h.lastFetchError = err
+		h.lock.Unlock()
+		return
 	}
 
 	// install the new list
@@ -197,11 +297,80 @@ func (h *handler) fetchList() {
 
 	h.lock.Lock()
 	h.list = l
+	h.metadata = metadata
 	h.lastFetchError = nil
 	h.lock.Unlock()
 
 	h.latestSHA = sha
 	h.resetPurgeTimer()
+	h.resetExpiryTimer(entries, next)
+}
+
+// buildList returns the list of the entries which haven't expired at the given time,
+// along with their metadata, and the time at which the next of them expires.
+func (h *handler) buildList(entries []listEntry, now time.Time) (list, map[string]*listEntry, time.Time, error) {
+	values, metadata, next := liveEntries(entries, now)
+
+	// overrides never expire
+	for _, o := range h.config.Overrides {
+		delete(metadata, o)
+	}
+
+	var l list
+	var err error
+
+	switch h.config.EntryType {
+	case config.STRINGS:
+		l = newStringList(values, h.config.Overrides)
+	case config.CASE_INSENSITIVE_STRINGS:
+		l = newCaseInsensitiveStringList(values, h.config.Overrides)
+	case config.IP_ADDRESSES:
+		l, err = newIPList(values, h.config.Overrides)
+	case config.REGEX:
+		l, err = newRegexList(values, h.config.Overrides)
+	case config.GLOB:
+		l = newGlobList(values, h.config.Overrides)
+	}
+
+	return l, metadata, next, err
+}
+
+// expireEntries rebuilds the list without the entries which expired since it was built.
+func (h *handler) expireEntries() {
+	l, metadata, next, err := h.buildList(h.entries, time.Now())
+	if err != nil {
+		// unexpected, the entries were parsed successfully when the list was fetched
+		h.log.Warningf("Could not remove expired entries: %v", err)
+		return
+	}
+
+	h.log.Infof("Removing expired entries, %d entries left", l.numEntries())
+
+	h.lock.Lock()
+	if h.list != nil {
+		h.list = l
+		h.metadata = metadata
+	}
+	h.lock.Unlock()
+
+	h.resetExpiryTimer(h.entries, next)
+}
+
+// resetExpiryTimer sets up the expiry timer to rebuild the list when the next of its
+// entries expires, keeping the entries until then.
+func (h *handler) resetExpiryTimer(entries []listEntry, next time.Time) {
+	if h.expiryTimer == nil {
+		return
+	}
+
+	if next.IsZero() {
+		h.entries = nil
+		stopTimer(h.expiryTimer)
+		return
+	}
+
+	h.entries = entries
+	resetTimer(h.expiryTimer, next.Sub(time.Now()))
 }
 
 func (h *handler) resetPurgeTimer() {
@@ -209,17 +378,25 @@ func (h *handler) resetPurgeTimer() {
 		return
 	}
 
-	// prevent the next purge from happening
-	h.purgeTimer.Stop()
+	// setup the purge timer to clean up the list if it doesn't get refreshed soon enough
+	resetTimer(h.purgeTimer, h.config.Ttl)
+}
+
+// resetTimer changes the timer to expire after d, dropping any pending expiration.
+func resetTimer(t *time.Timer, d time.Duration) {
+	stopTimer(t)
+	t.Reset(d)
+}
+
+// stopTimer prevents the next expiration of the timer, dropping any pending expiration.
+func stopTimer(t *time.Timer) {
+	t.Stop()
 
 	// clean up the channel in case a message is already pending
 	select {
-	case <-h.purgeTimer.C:
+	case <-t.C:
 	default:
 	}
-
-	// setup the purge timer to clean up the list if it doesn't get refreshed soon enough
-	h.purgeTimer.Reset(h.config.Ttl)
 }
 
 func (h *handler) purgeList() {
@@ -227,7 +404,10 @@ func (h *handler) purgeList() {
 
 	h.lock.Lock()
 	h.list = nil
+	h.metadata = nil
 	h.lock.Unlock()
+
+	h.resetExpiryTimer(nil, time.Time{})
 }
 
 ///////////////// Bootstrap ///////////////
@@ -263,12 +443,7 @@ func (b *builder) Validate() (ce *adapter.ConfigErrors) {
 	ac := b.adapterConfig
 
 	if ac.ProviderUrl != "" {
-		u, err := url.Parse(ac.ProviderUrl)
-		if err != nil {
-			ce = ce.Append("providerUrl", err)
-		} else if u.Scheme == "" || u.Host == "" {
-			ce = ce.Appendf("providerUrl", "URL scheme and host cannot be empty")
-		}
+		ce = validateURL(ce, "providerUrl", ac.ProviderUrl)
 
 		if ac.RefreshInterval < 1*time.Second {
 			ce = ce.Appendf("refreshInterval", "refresh interval must be at least 1 second, it is %v", ac.RefreshInterval)
@@ -279,6 +454,34 @@ func (b *builder) Validate() (ce *adapter.ConfigErrors) {
 		}
 	}
 
+	if ac.MaxListSize < 0 {
+		ce = ce.Appendf("maxListSize", "maximum list size must be >= 0, it is %d", ac.MaxListSize)
+	}
+
+	if ac.ChecksumUrl != "" {
+		if ac.ProviderUrl == "" {
+			ce = ce.Appendf("checksumUrl", "checksum URL requires a provider URL")
+		}
+		ce = validateURL(ce, "checksumUrl", ac.ChecksumUrl)
+	}
+
+	if ac.SignatureUrl != "" {
+		if ac.ProviderUrl == "" {
+			ce = ce.Appendf("signatureUrl", "signature URL requires a provider URL")
+		}
+		ce = validateURL(ce, "signatureUrl", ac.SignatureUrl)
+
+		if ac.PublicKey == "" {
+			ce = ce.Appendf("publicKey", "public key is required to verify the signature")
+		}
+	}
+
+	if ac.PublicKey != "" {
+		if _, err := parsePublicKey(ac.PublicKey); err != nil {
+			ce = ce.Appendf("publicKey", "could not parse public key: %v", err)
+		}
+	}
+
 	if ac.CachingInterval < 0 {
 		ce = ce.Appendf("cachingInterval", "caching interval must be >= 0, it is %v", ac.CachingInterval)
 	}
@@ -301,9 +504,34 @@ func (b *builder) Validate() (ce *adapter.ConfigErrors) {
 		}
 	}
 
+	if ac.EntryType == config.REGEX {
+		for _, re := range ac.Overrides {
+			if _, err := regexp.Compile(re); err != nil {
+				ce = ce.Appendf("overrides", "could not parse override %s: %v", re, err)
+			}
+		}
+	}
+
 	return
 }
 
+// validateURL checks that the URL has a scheme and a host, or is a file URL with a path.
+func validateURL(ce *adapter.ConfigErrors, field string, rawurl string) *adapter.ConfigErrors {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return ce.Append(field, err)
+	}
+
+	if u.Scheme == fileScheme {
+		if u.Path == "" {
+			ce = ce.Appendf(field, "URL path cannot be empty")
+		}
+	} else if u.Scheme == "" || u.Host == "" {
+		ce = ce.Appendf(field, "URL scheme and host cannot be empty")
+	}
+	return ce
+}
+
 func (b *builder) Build(context context.Context, env adapter.Env) (adapter.Handler, error) {
 	ac := b.adapterConfig
 
@@ -314,15 +542,36 @@ func (b *builder) Build(context context.Context, env adapter.Env) (adapter.Handl
 		readAll: ioutil.ReadAll,
 	}
 
+	if ac.PublicKey != "" {
+		// guaranteed to parse, since config was validated
+		h.publicKey, _ = parsePublicKey(ac.PublicKey)
+	}
+
 	if ac.ProviderUrl != "" {
-		h.refreshTicker = time.NewTicker(ac.RefreshInterval)
 		h.purgeTimer = time.NewTimer(ac.Ttl)
+
+		// started once a list with expiring entries is fetched
+		h.expiryTimer = time.NewTimer(ac.Ttl)
+		h.expiryTimer.Stop()
+
+		if u, err := url.Parse(ac.ProviderUrl); err == nil && u.Scheme == fileScheme {
+			// the watch is set up before the first fetch, so that no change is missed.
+			if err = h.watchProvider(filepath.Clean(u.Path)); err != nil {
+				h.log.Warningf("Unable to watch %s, the list will only be refreshed periodically: %v", ac.ProviderUrl, err)
+			}
+		}
 	}
 
 	// Load up the list synchronously so we're ready to accept traffic immediately.
 	h.fetchList()
 
 	if ac.ProviderUrl != "" {
+		h.lock.Lock()
+		failed := h.lastFetchError != nil
+		h.lock.Unlock()
+		h.refreshTimer = time.NewTimer(h.nextRefresh(failed))
+		h.refresherDone = make(chan struct{})
+
 		// goroutine to periodically refresh the list
 		env.ScheduleDaemon(h.listRefresher)
 	}
@@ -16,11 +16,24 @@ package list
 
 import (
 	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
 	"errors"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -193,6 +206,7 @@ func TestStringList(t *testing.T) {
 	}
 
 	leh := h.(*handler)
+	defer func() { _ = leh.Close() }()
 
 	cases := []struct {
 		addr   string
@@ -252,6 +266,7 @@ func TestBlackStringList(t *testing.T) {
 	}
 
 	leh := h.(*handler)
+	defer func() { _ = leh.Close() }()
 
 	cases := []struct {
 		addr   string
@@ -310,6 +325,7 @@ func TestCaseInsensitiveStringList(t *testing.T) {
 	}
 
 	leh := h.(*handler)
+	defer func() { _ = leh.Close() }()
 
 	cases := []struct {
 		addr   string
@@ -500,6 +516,7 @@ func TestRefreshAndPurge(t *testing.T) {
 	}
 
 	leh = h.(*handler)
+	defer func() { _ = leh.Close() }()
 
 	_, err = leh.HandleListEntry(context.Background(), &listentry.Instance{Value: "ABC"})
 	if err != nil {
@@ -582,6 +599,51 @@ func TestValidateConfig(t *testing.T) {
 			cfg:   config.Params{EntryType: config.IP_ADDRESSES, Overrides: []string{"1.2.3.4"}},
 			field: "",
 		},
+
+		{
+			cfg:   config.Params{EntryType: config.REGEX, Overrides: []string{"ab(c"}},
+			field: "overrides",
+		},
+
+		{
+			cfg:   config.Params{EntryType: config.GLOB, Overrides: []string{"*.example.com"}},
+			field: "",
+		},
+
+		{
+			cfg:   config.Params{ProviderUrl: "file://", RefreshInterval: 1 * time.Second, Ttl: 2 * time.Second},
+			field: "providerUrl",
+		},
+
+		{
+			cfg:   config.Params{ProviderUrl: "file:///etc/list.txt", RefreshInterval: 1 * time.Second, Ttl: 2 * time.Second},
+			field: "",
+		},
+
+		{
+			cfg:   config.Params{MaxListSize: -1},
+			field: "maxListSize",
+		},
+
+		{
+			cfg:   config.Params{ChecksumUrl: "http://foo.com/list.sha256"},
+			field: "checksumUrl",
+		},
+
+		{
+			cfg:   config.Params{ProviderUrl: "http://foo.com", RefreshInterval: 1 * time.Second, Ttl: 2 * time.Second, ChecksumUrl: "foo"},
+			field: "checksumUrl",
+		},
+
+		{
+			cfg:   config.Params{ProviderUrl: "http://foo.com", RefreshInterval: 1 * time.Second, Ttl: 2 * time.Second, SignatureUrl: "http://foo.com/sig"},
+			field: "publicKey",
+		},
+
+		{
+			cfg:   config.Params{PublicKey: "JUNK"},
+			field: "publicKey",
+		},
 	}
 
 	for i, c := range cases {
@@ -603,3 +665,507 @@ func TestValidateConfig(t *testing.T) {
 		})
 	}
 }
+
+func checkEntries(t *testing.T, leh *handler, cases map[string]rpc.Code) {
+	for value, code := range cases {
+		result, err := leh.HandleListEntry(context.Background(), &listentry.Instance{Value: value})
+		if err != nil {
+			t.Errorf("Got error %v for %s, expecting success", err, value)
+		} else if result.Status.Code != int32(code) {
+			t.Errorf("Got '%v' for %s, expecting '%v'", result.Status.Code, value, code)
+		}
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "list.txt")
+	if err = ioutil.WriteFile(path, []byte("ABC\nDEF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Params{
+		ProviderUrl:     "file://" + path,
+		RefreshInterval: 10000 * time.Second,
+		Ttl:             20000 * time.Second,
+		EntryType:       config.STRINGS,
+	}
+	info := GetInfo()
+	b := info.NewBuilder().(*builder)
+	b.SetAdapterConfig(&cfg)
+	if ce := b.Validate(); ce != nil {
+		t.Fatalf("Got error %v, expecting success", ce)
+	}
+
+	h, err := b.Build(context.Background(), test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	leh := h.(*handler)
+	defer func() { _ = leh.Close() }()
+
+	checkEntries(t, leh, map[string]rpc.Code{"ABC": rpc.OK, "GHI": rpc.NOT_FOUND})
+
+	// replace the file the way most tools do, by renaming a new file over it
+	tmp := filepath.Join(dir, ".list.txt.tmp")
+	if err = ioutil.WriteFile(tmp, []byte("GHI"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+
+	// the change is picked up long before the next refresh
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		result, err := leh.HandleListEntry(context.Background(), &listentry.Instance{Value: "GHI"})
+		if err == nil && result.Status.Code == int32(rpc.OK) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("The list was not updated after the file changed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	checkEntries(t, leh, map[string]rpc.Code{"ABC": rpc.NOT_FOUND})
+}
+
+func TestDirectoryProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	files := map[string]string{
+		"a.txt":   "ABC",
+		"b.txt":   "DEF\nGHI\n",
+		".hidden": "XYZ",
+	}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Params{
+		ProviderUrl:     "file://" + dir,
+		RefreshInterval: 10000 * time.Second,
+		Ttl:             20000 * time.Second,
+		EntryType:       config.STRINGS,
+	}
+	info := GetInfo()
+	b := info.NewBuilder().(*builder)
+	b.SetAdapterConfig(&cfg)
+
+	h, err := b.Build(context.Background(), test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	leh := h.(*handler)
+	defer func() { _ = leh.Close() }()
+
+	checkEntries(t, leh, map[string]rpc.Code{
+		"ABC": rpc.OK,
+		"DEF": rpc.OK,
+		"GHI": rpc.OK,
+		"XYZ": rpc.NOT_FOUND,
+	})
+
+	// the size limit applies to the whole directory
+	leh.config.MaxListSize = 10
+	if _, err = leh.readProvider(cfg.ProviderUrl, leh.config.MaxListSize); err == nil {
+		t.Error("Got success, expecting failure")
+	}
+}
+
+func TestMaxListSize(t *testing.T) {
+	listToServe := "ABC\nDEF"
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := w.Write([]byte(listToServe)); err != nil {
+			t.Errorf("w.Write failed: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := config.Params{
+		ProviderUrl:     ts.URL,
+		RefreshInterval: 10000 * time.Second,
+		Ttl:             20000 * time.Second,
+		EntryType:       config.STRINGS,
+		MaxListSize:     int64(len(listToServe)),
+	}
+	info := GetInfo()
+	b := info.NewBuilder().(*builder)
+	b.SetAdapterConfig(&cfg)
+
+	h, err := b.Build(context.Background(), test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	leh := h.(*handler)
+	defer func() { _ = leh.Close() }()
+
+	checkEntries(t, leh, map[string]rpc.Code{"DEF": rpc.OK})
+
+	// a list too large is rejected, keeping the previous one
+	listToServe = "ABC\nDEF\nGHI"
+	leh.fetchList()
+	if leh.lastFetchError == nil {
+		t.Error("Got success, expecting failure")
+	}
+	checkEntries(t, leh, map[string]rpc.Code{"DEF": rpc.OK, "GHI": rpc.NOT_FOUND})
+}
+
+// signedListServer serves a list along with its checksum and its signature. When tampered,
+// the list is changed after the checksum and the signature are computed.
+type signedListServer struct {
+	list     string
+	tampered bool
+	signed   string // the list covered by the checksum and signature, when not the served one
+	sign     func(digest []byte) []byte
+}
+
+func (s *signedListServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	signed := s.list
+	if s.signed != "" {
+		signed = s.signed
+	}
+	digest := sha256.Sum256([]byte(signed))
+
+	var out []byte
+	switch r.URL.Path {
+	case "/list":
+		out = []byte(s.list)
+		if s.tampered {
+			out = append(out, "\nEVIL"...)
+		}
+	case "/list.sha256":
+		out = []byte(hex.EncodeToString(digest[:]) + "  list\n")
+	case "/list.sig":
+		out = s.sign(digest[:])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if _, err := w.Write(out); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func buildVerifiedList(t *testing.T, cfg config.Params) *handler {
+	info := GetInfo()
+	b := info.NewBuilder().(*builder)
+	b.SetAdapterConfig(&cfg)
+	if err := b.Validate(); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+
+	h, err := b.Build(context.Background(), test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	return h.(*handler)
+}
+
+func publicKeyPEM(t *testing.T, key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestVerifiedList(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signRSA := func(digest []byte) []byte {
+		sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+	signEC := func(digest []byte) []byte {
+		sig, err := ecKey.Sign(rand.Reader, digest, crypto.SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+
+	cases := []struct {
+		name      string
+		sign      func([]byte) []byte
+		checksum  bool
+		publicKey string
+	}{
+		{"checksum", nil, true, ""},
+		{"rsa", signRSA, false, publicKeyPEM(t, &rsaKey.PublicKey)},
+		{"ecdsa", signEC, false, publicKeyPEM(t, &ecKey.PublicKey)},
+		{"both", signEC, true, publicKeyPEM(t, &ecKey.PublicKey)},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &signedListServer{list: "ABC\nDEF", sign: c.sign}
+			ts := httptest.NewServer(s)
+			defer ts.Close()
+
+			cfg := config.Params{
+				ProviderUrl:     ts.URL + "/list",
+				RefreshInterval: 10000 * time.Second,
+				Ttl:             20000 * time.Second,
+				EntryType:       config.STRINGS,
+				PublicKey:       c.publicKey,
+			}
+			if c.checksum {
+				cfg.ChecksumUrl = ts.URL + "/list.sha256"
+			}
+			if c.sign != nil {
+				cfg.SignatureUrl = ts.URL + "/list.sig"
+			}
+
+			leh := buildVerifiedList(t, cfg)
+			defer func() { _ = leh.Close() }()
+
+			if leh.lastFetchError != nil {
+				t.Fatalf("Got error %v, expecting success", leh.lastFetchError)
+			}
+			checkEntries(t, leh, map[string]rpc.Code{"ABC": rpc.OK, "EVIL": rpc.NOT_FOUND})
+
+			// a tampered list is rejected, keeping the previous one
+			s.list = "ABC\nGHI"
+			s.tampered = true
+			leh.fetchList()
+			if leh.lastFetchError == nil || !strings.Contains(leh.lastFetchError.Error(), "could not verify") {
+				t.Errorf("Got %v, expecting a verification failure", leh.lastFetchError)
+			}
+			checkEntries(t, leh, map[string]rpc.Code{"DEF": rpc.OK, "GHI": rpc.NOT_FOUND, "EVIL": rpc.NOT_FOUND})
+
+			// the genuine update is accepted
+			s.tampered = false
+			leh.fetchList()
+			if leh.lastFetchError != nil {
+				t.Errorf("Got error %v, expecting success", leh.lastFetchError)
+			}
+			checkEntries(t, leh, map[string]rpc.Code{"DEF": rpc.NOT_FOUND, "GHI": rpc.OK})
+
+			// an unchanged list is verified again
+			s.signed = "ABC\nJKL"
+			leh.fetchList()
+			if leh.lastFetchError == nil || !strings.Contains(leh.lastFetchError.Error(), "could not verify") {
+				t.Errorf("Got %v, expecting a verification failure", leh.lastFetchError)
+			}
+			checkEntries(t, leh, map[string]rpc.Code{"GHI": rpc.OK, "JKL": rpc.NOT_FOUND})
+		})
+	}
+}
+
+func TestVerifyChecksum(t *testing.T) {
+	digest := sha256.Sum256([]byte("ABC"))
+	sum := hex.EncodeToString(digest[:])
+	digest = sha256.Sum256([]byte("XYZ"))
+	other := hex.EncodeToString(digest[:])
+
+	cases := []struct {
+		doc  string
+		fail bool
+	}{
+		{sum, false},
+		{sum + "  list.txt\n", false},
+		{strings.ToUpper(sum), false},
+		{"", true},
+		{"XYZ", true},
+		{sum[:10], true},
+		{other, true},
+	}
+
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if err := verifyChecksum([]byte("ABC"), []byte(c.doc)); (err != nil) != c.fail {
+				t.Errorf("Got %v, expecting failure %v", err, c.fail)
+			}
+		})
+	}
+}
+
+func TestNextRefresh(t *testing.T) {
+	h := &handler{config: config.Params{RefreshInterval: 5 * time.Second}}
+
+	cases := []struct {
+		failed bool
+		delay  time.Duration
+	}{
+		{false, 5 * time.Second},
+		{true, time.Second},
+		{true, 2 * time.Second},
+		{true, 4 * time.Second},
+		{true, 5 * time.Second},
+		{true, 5 * time.Second},
+		{false, 5 * time.Second},
+		{true, time.Second},
+	}
+
+	for i, c := range cases {
+		if delay := h.nextRefresh(c.failed); delay != c.delay {
+			t.Errorf("Expecting %v, got %v, case %d", c.delay, delay, i)
+		}
+	}
+}
+
+func TestListMetadata(t *testing.T) {
+	expiring := time.Now().Add(500 * time.Millisecond).UTC()
+
+	listToServe := "value,reason,owner,expiry\n" +
+		"10.0.0.0/8,internal,netops,\n" +
+		"11.0.0.1,abuse,secops," + expiring.Format(time.RFC3339Nano) + "\n" +
+		"11.0.0.2,,secops," + time.Now().Add(-time.Second).UTC().Format(time.RFC3339) + "\n" +
+		"11.0.0.3,spam,," + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + "\n" +
+		"11.0.0.4,,," + expiring.Format(time.RFC3339Nano) + "\n"
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := w.Write([]byte(listToServe)); err != nil {
+			t.Errorf("w.Write failed: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := config.Params{
+		ProviderUrl:     ts.URL,
+		RefreshInterval: 10000 * time.Second,
+		Ttl:             20000 * time.Second,
+		CachingInterval: 10 * time.Second,
+		Overrides:       []string{"11.0.0.4"},
+		EntryType:       config.IP_ADDRESSES,
+		ListFormat:      config.CSV,
+		Blacklist:       true,
+	}
+	info := GetInfo()
+	b := info.NewBuilder().(*builder)
+	b.SetAdapterConfig(&cfg)
+
+	h, err := b.Build(context.Background(), test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	leh := h.(*handler)
+	defer func() { _ = leh.Close() }()
+
+	cases := []struct {
+		addr          string
+		result        rpc.Code
+		msg           string
+		validDuration time.Duration
+	}{
+		{"10.1.2.3", rpc.PERMISSION_DENIED, "10.1.2.3 is blacklisted: internal", 10 * time.Second},
+		{"11.0.0.1", rpc.PERMISSION_DENIED, "11.0.0.1 is blacklisted: abuse", 500 * time.Millisecond},
+		{"11.0.0.2", rpc.OK, "", 10 * time.Second},
+		{"11.0.0.3", rpc.PERMISSION_DENIED, "11.0.0.3 is blacklisted: spam", 10 * time.Second},
+		{"11.0.0.4", rpc.PERMISSION_DENIED, "11.0.0.4 is blacklisted", 10 * time.Second},
+	}
+
+	for _, c := range cases {
+		t.Run(c.addr, func(t *testing.T) {
+			result, err := leh.HandleListEntry(context.Background(), &listentry.Instance{Value: c.addr})
+			if err != nil {
+				t.Fatalf("Got error %v, expecting success", err)
+			}
+			if result.Status.Code != int32(c.result) || result.Status.Message != c.msg {
+				t.Errorf("Got '%v' '%s', expecting '%v' '%s'", result.Status.Code, result.Status.Message, c.result, c.msg)
+			}
+
+			// the answer can't be cached past the expiry of the entry
+			if result.ValidDuration > c.validDuration || result.ValidDuration < c.validDuration-200*time.Millisecond {
+				t.Errorf("Got valid duration %v, expecting %v", result.ValidDuration, c.validDuration)
+			}
+		})
+	}
+
+	// the entry is removed once it expires, long before the next refresh
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		leh.lock.Lock()
+		n := leh.list.numEntries()
+		leh.lock.Unlock()
+
+		if n == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Got %d entries, expecting the expired entry to be removed", n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// the override doesn't expire along with the entry
+	checkEntries(t, leh, map[string]rpc.Code{
+		"11.0.0.1": rpc.OK,
+		"11.0.0.3": rpc.PERMISSION_DENIED,
+		"11.0.0.4": rpc.PERMISSION_DENIED,
+	})
+}
+
+func TestJSONList(t *testing.T) {
+	listToServe := `{"entries": [{"value": "ABC", "reason": "abuse"}, {"value": "DEF", "expiry": "2017-01-01T00:00:00Z"}]}`
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := w.Write([]byte(listToServe)); err != nil {
+			t.Errorf("w.Write failed: %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	cfg := config.Params{
+		ProviderUrl:     ts.URL,
+		RefreshInterval: 10000 * time.Second,
+		Ttl:             20000 * time.Second,
+		EntryType:       config.CASE_INSENSITIVE_STRINGS,
+		ListFormat:      config.JSON,
+	}
+	info := GetInfo()
+	b := info.NewBuilder().(*builder)
+	b.SetAdapterConfig(&cfg)
+
+	h, err := b.Build(context.Background(), test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	leh := h.(*handler)
+	defer func() { _ = leh.Close() }()
+
+	checkEntries(t, leh, map[string]rpc.Code{"abc": rpc.OK, "DEF": rpc.NOT_FOUND})
+
+	// no entry left to expire
+	if leh.entries != nil {
+		t.Errorf("Got %d entries kept to expire, expecting none", len(leh.entries))
+	}
+
+	listToServe = `{"entries": "ABC"}`
+	leh.fetchList()
+
+	leh.lock.Lock()
+	err = leh.lastFetchError
+	leh.lock.Unlock()
+	if err == nil {
+		t.Error("Got success, expecting an error for the malformed list")
+	}
+}
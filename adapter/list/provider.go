@@ -0,0 +1,164 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package list
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// fileScheme is the scheme of the provider URLs naming a file or a directory.
+	fileScheme = "file"
+
+	// watchDebounce is how long the watcher waits for a burst of changes to settle
+	// before fetching the list.
+	watchDebounce = 100 * time.Millisecond
+)
+
+// readProvider reads the document at the given URL, failing if it's larger than
+// maxSize bytes. A maxSize of zero means no limit.
+func (h *handler) readProvider(rawurl string, maxSize int64) ([]byte, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == fileScheme {
+		return h.readPath(filepath.Clean(u.Path), maxSize)
+	}
+
+	resp, err := http.Get(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	return h.readLimited(resp.Body, maxSize)
+}
+
+// readPath reads a file, or the concatenation of the files of a directory ordered by
+// name, ignoring the hidden files and the subdirectories.
+func (h *handler) readPath(path string, maxSize int64) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return h.readFile(path, maxSize)
+	}
+
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for _, f := range files {
+		if f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+
+		b, err := h.readFile(filepath.Join(path, f.Name()), maxSize)
+		if err != nil {
+			return nil, err
+		}
+
+		if maxSize > 0 && int64(buf.Len()+len(b)) > maxSize {
+			return nil, fmt.Errorf("list is larger than %d bytes", maxSize)
+		}
+
+		buf.Write(b) // nolint: gas
+		if len(b) > 0 && b[len(b)-1] != '\n' {
+			buf.WriteByte('\n') // nolint: gas
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (h *handler) readFile(path string, maxSize int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	return h.readLimited(f, maxSize)
+}
+
+// readLimited reads r to the end, failing if there is more than maxSize bytes to read.
+// A maxSize of zero means no limit.
+func (h *handler) readLimited(r io.Reader, maxSize int64) ([]byte, error) {
+	if maxSize <= 0 {
+		return h.readAll(r)
+	}
+
+	buf, err := h.readAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(buf)) > maxSize {
+		return nil, fmt.Errorf("list is larger than %d bytes", maxSize)
+	}
+	return buf, nil
+}
+
+// watchProvider starts watching the file or directory of a file provider, so that the
+// changes are picked up without waiting for the next refresh.
+func (h *handler) watchProvider(path string) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// A file is usually updated by renaming a new file over it, which is only reported
+	// by the watch of its directory.
+	dir := path
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		dir = filepath.Dir(path)
+	}
+
+	if err = w.Add(dir); err != nil {
+		_ = w.Close()
+		return err
+	}
+
+	h.watchedPath = path
+	h.watcher = w
+	return nil
+}
+
+// watched returns true if the named file is the provider file or one of the files of
+// the provider directory.
+func (h *handler) watched(name string) bool {
+	name = filepath.Clean(name)
+	return name == h.watchedPath || filepath.Dir(name) == h.watchedPath
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 Google Ina.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package list
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+)
+
+type regexList struct {
+	// all the entries, combined into a single expression matching whole symbols
+	re *regexp.Regexp
+
+	// the entries, and the index of the group enclosing each of them in re
+	entries []string
+	groups  []int
+}
+
+// newRegexList combines the entries and the overrides into a single expression, which
+// RE2 matches in a single pass over the symbol.
+func newRegexList(entries []string, overrides []string) (list, error) {
+	var re bytes.Buffer
+	ls := &regexList{}
+
+	// group 0 is the whole symbol
+	group := 1
+
+	add := func(s string) error {
+		if s == "" {
+			return nil
+		}
+
+		// compile each entry on its own, so that errors point at the faulty entry
+		r, err := regexp.Compile(s)
+		if err != nil {
+			return fmt.Errorf("could not parse list entry %s: %v", s, err)
+		}
+
+		if len(ls.entries) > 0 {
+			re.WriteByte('|') // nolint: gas
+		}
+		re.WriteString("(") // nolint: gas
+		re.WriteString(s)   // nolint: gas
+		re.WriteString(")") // nolint: gas
+
+		ls.entries = append(ls.entries, s)
+		ls.groups = append(ls.groups, group)
+		group += 1 + r.NumSubexp()
+		return nil
+	}
+
+	// copy the main entries
+	for _, s := range entries {
+		if err := add(s); err != nil {
+			return nil, err
+		}
+	}
+
+	// apply overrides, guaranteed correctly formatted, since config was validated
+	for _, s := range overrides {
+		_ = add(s)
+	}
+
+	combined, err := regexp.Compile("^(?:" + re.String() + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("could not combine list entries: %v", err)
+	}
+	ls.re = combined
+
+	return ls, nil
+}
+
+func (ls *regexList) checkList(symbol string) (string, bool, error) {
+	if len(ls.entries) == 0 || !ls.re.MatchString(symbol) {
+		return "", false, nil
+	}
+
+	// only look for the matching entry once the symbol is known to match, since
+	// capturing the groups is much slower than matching.
+	m := ls.re.FindStringSubmatchIndex(symbol)
+	for i, g := range ls.groups {
+		if m[2*g] >= 0 {
+			return ls.entries[i], true, nil
+		}
+	}
+	return "", true, nil
+}
+
+func (ls *regexList) numEntries() int {
+	return len(ls.entries)
+}
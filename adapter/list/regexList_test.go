@@ -0,0 +1,92 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package list
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+)
+
+func TestRegexList(t *testing.T) {
+	l, err := newRegexList([]string{"ab+c", "([0-9])[0-9]{2}", ""}, []string{"over(.*)"})
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+
+	if l.numEntries() != 3 {
+		t.Errorf("Got %d entries, expecting 3", l.numEntries())
+	}
+
+	cases := []struct {
+		symbol string
+		result bool
+		entry  string
+	}{
+		{"abbbc", true, "ab+c"},
+		{"xabc", false, ""}, // the whole symbol must match
+		{"abcx", false, ""},
+		{"123", true, "([0-9])[0-9]{2}"},
+		{"1234", false, ""},
+		{"override", true, "over(.*)"}, // groups of earlier entries don't shift the match
+		{"", false, ""},
+	}
+
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			entry, result, _ := l.checkList(c.symbol)
+			if result != c.result {
+				t.Errorf("Got %v, Want %v for %s", result, c.result, c.symbol)
+			}
+			if entry != c.entry {
+				t.Errorf("Got entry %q, Want %q for %s", entry, c.entry, c.symbol)
+			}
+		})
+	}
+
+	if _, err = newRegexList([]string{"ab(c"}, nil); err == nil {
+		t.Error("Got success, expecting failure")
+	}
+}
+
+func TestEmptyRegexList(t *testing.T) {
+	l, err := newRegexList(nil, nil)
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	if _, result, _ := l.checkList(""); result {
+		t.Error("Expecting an empty list not to match anything")
+	}
+}
+
+func benchmarkRegexLookup(b *testing.B, n int) {
+	entries := make([]string, n)
+	for i := range entries {
+		entries[i] = fmt.Sprintf("[a-z]+\\.host%d\\.example\\.com", i)
+	}
+
+	l, err := newRegexList(entries, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _, _ = l.checkList(fmt.Sprintf("www.host%d.example.com", i%(2*n)))
+	}
+}
+
+func BenchmarkRegexLookup10(b *testing.B)  { benchmarkRegexLookup(b, 10) }
+func BenchmarkRegexLookup100(b *testing.B) { benchmarkRegexLookup(b, 100) }
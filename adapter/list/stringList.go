@@ -23,12 +23,11 @@ type stringList struct {
 }
 
 type caseInsensitiveStringList struct {
-	entries map[string]bool
+	// the entries by their upper case form
+	entries map[string]string
 }
 
-func parseStringList(buf []byte, overrides []string) list {
-	lines := strings.Split(string(buf), "\n")
-
+func newStringList(lines []string, overrides []string) list {
 	entries := make(map[string]bool, len(lines)+len(overrides))
 
 	// copy the main strings
@@ -48,36 +47,34 @@ func parseStringList(buf []byte, overrides []string) list {
 	return &stringList{entries}
 }
 
-func parseCaseInsensitiveStringList(buf []byte, overrides []string) list {
-	lines := strings.Split(string(buf), "\n")
-
-	entries := make(map[string]bool, len(lines)+len(overrides))
+func newCaseInsensitiveStringList(lines []string, overrides []string) list {
+	entries := make(map[string]string, len(lines)+len(overrides))
 
 	// copy the main strings
 	for _, s := range lines {
 		if s != "" {
-			entries[strings.ToUpper(s)] = true
+			entries[strings.ToUpper(s)] = s
 		}
 	}
 
 	// apply overrides
 	for _, s := range overrides {
 		if s != "" {
-			entries[strings.ToUpper(s)] = true
+			entries[strings.ToUpper(s)] = s
 		}
 	}
 
 	return &caseInsensitiveStringList{entries}
 }
 
-func (ls *stringList) checkList(symbol string) (bool, error) {
+func (ls *stringList) checkList(symbol string) (string, bool, error) {
 	_, ok := ls.entries[symbol]
-	return ok, nil
+	return symbol, ok, nil
 }
 
-func (ls *caseInsensitiveStringList) checkList(symbol string) (bool, error) {
-	_, ok := ls.entries[strings.ToUpper(symbol)]
-	return ok, nil
+func (ls *caseInsensitiveStringList) checkList(symbol string) (string, bool, error) {
+	s, ok := ls.entries[strings.ToUpper(symbol)]
+	return s, ok, nil
 }
 
 func (ls *stringList) numEntries() int {
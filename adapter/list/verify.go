@@ -0,0 +1,120 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package list
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// maxVerifierSize bounds the size of checksum and signature documents.
+const maxVerifierSize = 64 * 1024
+
+// verifyList checks the list against its checksum and signature, when configured.
+func (h *handler) verifyList(buf []byte) error {
+	if h.config.ChecksumUrl != "" {
+		doc, err := h.readProvider(h.config.ChecksumUrl, maxVerifierSize)
+		if err != nil {
+			return fmt.Errorf("could not fetch checksum from %s: %v", h.config.ChecksumUrl, err)
+		}
+
+		if err = verifyChecksum(buf, doc); err != nil {
+			return err
+		}
+	}
+
+	if h.config.SignatureUrl != "" {
+		sig, err := h.readProvider(h.config.SignatureUrl, maxVerifierSize)
+		if err != nil {
+			return fmt.Errorf("could not fetch signature from %s: %v", h.config.SignatureUrl, err)
+		}
+
+		if err = verifySignature(h.publicKey, buf, sig); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyChecksum checks the list against a checksum document as produced by sha256sum.
+func verifyChecksum(buf []byte, doc []byte) error {
+	fields := strings.Fields(string(doc))
+	if len(fields) == 0 {
+		return errors.New("checksum is empty")
+	}
+
+	want, err := hex.DecodeString(fields[0])
+	if err != nil || len(want) != sha256.Size {
+		return fmt.Errorf("%s is not a SHA-256 checksum", fields[0])
+	}
+
+	got := sha256.Sum256(buf)
+	if !bytes.Equal(got[:], want) {
+		return errors.New("list does not match its checksum")
+	}
+	return nil
+}
+
+// verifySignature checks the detached signature of the list.
+func verifySignature(key crypto.PublicKey, buf []byte, sig []byte) error {
+	digest := sha256.Sum256(buf)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		var s struct {
+			R, S *big.Int
+		}
+		if rest, err := asn1.Unmarshal(sig, &s); err == nil && len(rest) == 0 && ecdsa.Verify(k, digest[:], s.R, s.S) {
+			return nil
+		}
+	}
+
+	return errors.New("list does not match its signature")
+}
+
+// parsePublicKey parses a PEM encoded RSA or ECDSA public key.
+func parsePublicKey(s string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", key)
+}
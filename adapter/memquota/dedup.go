@@ -19,11 +19,13 @@ import (
 	"time"
 
 	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/adapter/quotas"
 	"istio.io/mixer/template/quota"
 )
 
 type (
-	// dedupUtil stores common information for in-memory and redis quota adapters
+	// dedupUtil stores the deduplication state of the in-memory quota adapter. The
+	// redis quota adapter keeps its deduplication state in redis instead.
 	dedupUtil struct {
 		sync.Mutex
 
@@ -59,7 +61,7 @@ const (
 
 // handleDedup is a wrapper function that handles dedupping semantics.
 func (du *dedupUtil) handleDedup(instance *quota.Instance, args adapter.QuotaArgs, qf quotaFunc) (int64, time.Duration, string, error) {
-	key := makeKey(instance.Name, instance.Dimensions)
+	key := quotas.MakeKey(instance.Name, instance.Dimensions)
 
 	du.Lock()
 
@@ -0,0 +1,95 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memquota
+
+// Implements a fixed window that allows N units to be allocated per time interval, with
+// the intervals aligned to tick 0. Unlike the rolling window, only a single counter is kept,
+// so a caller can allocate up to 2*N units across the boundary of two windows.
+type fixedWindow struct {
+	// the number of ticks in a window
+	ticksInWindow int64
+
+	// the index of the current window, which is currentTick / ticksInWindow
+	window int64
+
+	// the maximum amount that can be allocated within a window
+	limit int64
+
+	// the total # of units allocated in the current window
+	used int64
+}
+
+// Creates a new fixed window tracker used to implement rate limiting.
+//
+// The limit parameter determines the maximum amount that can be allocated within a
+// window, and the ticksInWindow parameter determines the length of a window.
+func newFixedWindow(limit int64, ticksInWindow int64) *fixedWindow {
+	return &fixedWindow{
+		ticksInWindow: ticksInWindow,
+		limit:         limit,
+	}
+}
+
+func (w *fixedWindow) alloc(amount int64, currentTick int64) bool {
+	w.roll(currentTick)
+
+	if amount > w.limit-w.used {
+		// not enough room
+		return false
+	}
+
+	w.used += amount
+	return true
+}
+
+func (w *fixedWindow) release(amount int64, currentTick int64) int64 {
+	w.roll(currentTick)
+
+	if amount > w.used {
+		amount = w.used
+	}
+	w.used -= amount
+	return amount
+}
+
+func (w *fixedWindow) roll(currentTick int64) {
+	if window := currentTick / w.ticksInWindow; window != w.window {
+		w.window = window
+		w.used = 0
+	}
+}
+
+func (w *fixedWindow) available() int64 {
+	return w.limit - w.used
+}
+
+// expired returns true if the window holds no allocation at the given tick.
+func (w *fixedWindow) expired(currentTick int64) bool {
+	return w.used == 0 || currentTick/w.ticksInWindow != w.window
+}
+
+func (w *fixedWindow) nextRelease(currentTick int64) int64 {
+	w.roll(currentTick)
+
+	if w.used == 0 {
+		return 0
+	}
+	return w.end()
+}
+
+// end returns the first tick after the current window.
+func (w *fixedWindow) end() int64 {
+	return (w.window + 1) * w.ticksInWindow
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memquota
+
+import (
+	"testing"
+)
+
+func TestFixedWindowAlloc(t *testing.T) {
+	cases := []struct {
+		amount int64
+		tick   int64
+		avail  int64
+		result bool
+	}{
+		{6, 1, 5, false},
+		{1, 1, 4, true},
+		{4, 2, 0, true},
+		{1, 2, 0, false},
+		{1, 3, 4, true},
+		{4, 5, 0, true},
+		{1, 5, 0, false},
+		{5, 6, 0, true},
+		{1, 13, 4, true},
+	}
+
+	w := newFixedWindow(5, 3)
+
+	for i, c := range cases {
+		if ok := w.alloc(c.amount, c.tick); ok != c.result {
+			t.Errorf("Expecting %v got %v, case %d", c.result, ok, i)
+		}
+
+		if w.available() != c.avail {
+			t.Errorf("Expecting %d available, got %d, case %d", c.avail, w.available(), i)
+		}
+	}
+}
+
+func TestFixedWindowRelease(t *testing.T) {
+	cases := []struct {
+		allocAmount   int64
+		allocTick     int64
+		releaseAmount int64
+		releaseTick   int64
+		releaseResult int64
+		avail         int64
+	}{
+		{4, 0, 4, 0, 4, 5},
+		{4, 0, 2, 2, 2, 3},
+		{0, 2, 4, 2, 2, 5},
+		{4, 4, 4, 6, 0, 5},
+		{5, 7, 1, 8, 1, 1},
+	}
+
+	w := newFixedWindow(5, 3)
+
+	for i, c := range cases {
+		if ok := w.alloc(c.allocAmount, c.allocTick); !ok {
+			t.Errorf("Expecting to succeed, case %d", i)
+		}
+
+		result := w.release(c.releaseAmount, c.releaseTick)
+		if result != c.releaseResult {
+			t.Errorf("Expecting %d, got %d, case %d", c.releaseResult, result, i)
+		}
+
+		if w.available() != c.avail {
+			t.Errorf("Expecting %d available, got %d, case %d", c.avail, w.available(), i)
+		}
+	}
+}
+
+func TestFixedWindowExpired(t *testing.T) {
+	w := newFixedWindow(5, 3)
+	if !w.expired(0) {
+		t.Error("Expecting an unused window to be expired")
+	}
+
+	_ = w.alloc(1, 4)
+	if w.expired(5) {
+		t.Error("Expecting the window not to be expired within the window")
+	}
+	if w.end() != 6 {
+		t.Errorf("Expecting the window to end at tick 6, got %d", w.end())
+	}
+	if !w.expired(6) {
+		t.Error("Expecting the window to be expired after the window")
+	}
+}
+
+func TestFixedWindowNextRelease(t *testing.T) {
+	w := newFixedWindow(5, 3)
+	if tick := w.nextRelease(0); tick != 0 {
+		t.Errorf("Expecting no release, got %d", tick)
+	}
+
+	_ = w.alloc(1, 4)
+	if tick := w.nextRelease(5); tick != 6 {
+		t.Errorf("Expecting a release at tick 6, got %d", tick)
+	}
+	if tick := w.nextRelease(6); tick != 0 {
+		t.Errorf("Expecting no release after the window, got %d", tick)
+	}
+}
@@ -0,0 +1,325 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memquota
+
+import (
+	"container/heap"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"istio.io/mixer/adapter/memquota/config"
+)
+
+// introspectionPath is where the quota usage is served on the monitoring port.
+const introspectionPath = "/debug/memquota"
+
+type (
+	// keyUsage is the current usage of a quota key.
+	keyUsage struct {
+		// Key is the key of the quota and the dimensions of the instances.
+		Key string `json:"key"`
+
+		// Used is the amount currently allocated.
+		Used int64 `json:"used"`
+
+		// MaxAmount is the limit which applies to the key.
+		MaxAmount int64 `json:"maxAmount"`
+
+		// Override is the dimensions of the matched override, nil if no override matched.
+		Override map[string]string `json:"override,omitempty"`
+
+		// NextRelease is the time until some of the allocated amount is released,
+		// empty for non-expiring quotas.
+		NextRelease string `json:"nextRelease,omitempty"`
+	}
+
+	// quotaUsage is the current usage of the keys of a quota.
+	quotaUsage struct {
+		Name string     `json:"name"`
+		Keys []keyUsage `json:"keys"`
+	}
+)
+
+// ratio returns the ratio of the limit used by the key.
+func (k *keyUsage) ratio() float64 {
+	if k.MaxAmount <= 0 {
+		return 1
+	}
+	return float64(k.Used) / float64(k.MaxAmount)
+}
+
+// the handlers whose usage is introspected
+var liveHandlers = struct {
+	sync.Mutex
+	m map[*handler]bool
+}{m: make(map[*handler]bool)}
+
+func registerHandler(h *handler) {
+	liveHandlers.Lock()
+	liveHandlers.m[h] = true
+	liveHandlers.Unlock()
+}
+
+func unregisterHandler(h *handler) {
+	liveHandlers.Lock()
+	delete(liveHandlers.m, h)
+	liveHandlers.Unlock()
+}
+
+func handlerList() []*handler {
+	liveHandlers.Lock()
+	defer liveHandlers.Unlock()
+	l := make([]*handler, 0, len(liveHandlers.m))
+	for h := range liveHandlers.m {
+		l = append(l, h)
+	}
+	return l
+}
+
+// usage returns the usage of the keys which start with prefix, sorted by key.
+func (h *handler) usage(prefix string) []keyUsage {
+	h.common.Lock()
+	defer h.common.Unlock()
+
+	currentTime := h.common.getTime()
+	currentTick := currentTime.UnixNano() / nanosPerTick
+
+	var keys []keyUsage
+	for k, inUse := range h.cells {
+		if strings.HasPrefix(k, prefix) {
+			if ku, ok := h.usageOf(k, inUse, nil, currentTime, currentTick); ok {
+				keys = append(keys, ku)
+			}
+		}
+	}
+	for k, w := range h.windows {
+		if strings.HasPrefix(k, prefix) {
+			if ku, ok := h.usageOf(k, 0, w, currentTime, currentTick); ok {
+				keys = append(keys, ku)
+			}
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+	return keys
+}
+
+// usageOf returns the usage of a key, for a cell if w is nil. The caller must hold the lock.
+func (h *handler) usageOf(key string, inUse int64, w window, currentTime time.Time, currentTick int64) (keyUsage, bool) {
+	ku, tick, ok := h.amountsOf(key, inUse, w, currentTick)
+	if ok && tick != 0 {
+		next := time.Unix(0, tick*nanosPerTick).Sub(currentTime)
+		if next < 0 {
+			next = 0
+		}
+		ku.NextRelease = next.String()
+	}
+	return ku, ok
+}
+
+// amountsOf returns the usage of a key without its next release, along with the tick at
+// which it is next released. The caller must hold the lock.
+func (h *handler) amountsOf(key string, inUse int64, w window, currentTick int64) (keyUsage, int64, bool) {
+	cfg := h.limits[quotaName(key)]
+	if cfg == nil {
+		return keyUsage{}, 0, false
+	}
+
+	l, ok := h.keyLimits[key]
+	if !ok {
+		// restored from a snapshot, and not used since.
+		l = cfg
+	}
+
+	ku := keyUsage{Key: key, Used: inUse, MaxAmount: l.GetMaxAmount()}
+	if o, ok := l.(*config.Params_Override); ok {
+		ku.Override = o.Dimensions
+	}
+
+	var tick int64
+	if w != nil {
+		alg := algorithm(cfg, l)
+		ku.MaxAmount = capacity(l, alg)
+
+		// this also brings the window up to date.
+		tick = w.nextRelease(currentTick)
+		ku.Used = ku.MaxAmount - w.available()
+	}
+
+	return ku, tick, true
+}
+
+// topUsage returns the n keys with the highest usage ratio, highest first. Only n keys are
+// kept while walking the state.
+func (h *handler) topUsage(n int) []keyUsage {
+	top := &topKeys{n: n}
+
+	h.common.Lock()
+	currentTick := h.common.getTime().UnixNano() / nanosPerTick
+	for k, inUse := range h.cells {
+		if ku, _, ok := h.amountsOf(k, inUse, nil, currentTick); ok {
+			top.add(ku)
+		}
+	}
+	for k, w := range h.windows {
+		if ku, _, ok := h.amountsOf(k, 0, w, currentTick); ok {
+			top.add(ku)
+		}
+	}
+	h.common.Unlock()
+
+	return top.sorted()
+}
+
+// collectUsage returns the usage of the keys which start with prefix, of the quota
+// named name or of all the quotas if name is empty, grouped by quota.
+func collectUsage(name string, prefix string) []quotaUsage {
+	byName := make(map[string]*quotaUsage)
+	for _, h := range handlerList() {
+		for _, ku := range h.usage(prefix) {
+			n := quotaName(ku.Key)
+			if name != "" && n != name {
+				continue
+			}
+			qu := byName[n]
+			if qu == nil {
+				qu = &quotaUsage{Name: n}
+				byName[n] = qu
+			}
+			qu.Keys = append(qu.Keys, ku)
+		}
+	}
+
+	result := make([]quotaUsage, 0, len(byName))
+	for _, qu := range byName {
+		result = append(result, *qu)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// serveUsage serves the usage of the quotas as JSON. The quota query parameter selects a
+// quota by name, the prefix query parameter selects the keys starting with the prefix.
+func serveUsage(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "only GET is supported", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := req.URL.Query()
+	out, err := json.MarshalIndent(collectUsage(q.Get("quota"), q.Get("prefix")), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(out)
+}
+
+var usageRatioDesc = prometheus.NewDesc(
+	"mixer_memquota_usage_ratio",
+	"Ratio of the limit used by the memquota keys with the highest usage.",
+	[]string{"quota", "key"}, nil)
+
+// usageCollector exports the usage ratio of the keys with the highest usage of each
+// handler. The number of keys is bounded by the maxExportedKeys of the handlers.
+type usageCollector struct{}
+
+func (usageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- usageRatioDesc
+}
+
+func (usageCollector) Collect(ch chan<- prometheus.Metric) {
+	seen := make(map[string]bool)
+	for _, h := range handlerList() {
+		if h.maxExportedKeys <= 0 {
+			continue
+		}
+		for _, ku := range h.topUsage(h.maxExportedKeys) {
+			// several handlers may track the same key while the config is updated.
+			if seen[ku.Key] {
+				continue
+			}
+			seen[ku.Key] = true
+			ch <- prometheus.MustNewConstMetric(usageRatioDesc, prometheus.GaugeValue, ku.ratio(), quotaName(ku.Key), ku.Key)
+		}
+	}
+}
+
+// registerCollector registers the usage collector with Prometheus. The collector is shared by the
+// handlers, registering it again is not an error.
+func registerCollector() error {
+	if err := prometheus.Register(usageCollector{}); err != nil {
+		if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// topKeys keeps the n keys with the highest usage ratio added to it, in a heap whose root is
+// the key with the lowest ratio.
+type topKeys struct {
+	n    int
+	keys []keyUsage
+}
+
+// add keeps the key if it is one of the n keys with the highest usage ratio so far.
+func (t *topKeys) add(ku keyUsage) {
+	switch {
+	case len(t.keys) < t.n:
+		heap.Push(t, ku)
+	case len(t.keys) > 0 && lowerUsage(&t.keys[0], &ku):
+		t.keys[0] = ku
+		heap.Fix(t, 0)
+	}
+}
+
+// sorted returns the keys kept, highest usage ratio first.
+func (t *topKeys) sorted() []keyUsage {
+	keys := t.keys
+	sort.Slice(keys, func(i, j int) bool { return lowerUsage(&keys[j], &keys[i]) })
+	return keys
+}
+
+func (t *topKeys) Len() int           { return len(t.keys) }
+func (t *topKeys) Less(i, j int) bool { return lowerUsage(&t.keys[i], &t.keys[j]) }
+func (t *topKeys) Swap(i, j int)      { t.keys[i], t.keys[j] = t.keys[j], t.keys[i] }
+func (t *topKeys) Push(x interface{}) { t.keys = append(t.keys, x.(keyUsage)) }
+
+func (t *topKeys) Pop() interface{} {
+	last := t.keys[len(t.keys)-1]
+	t.keys = t.keys[:len(t.keys)-1]
+	return last
+}
+
+// lowerUsage returns true if key a has a lower usage ratio than key b. Among keys with the
+// same ratio, the ones which sort first are ranked higher.
+func lowerUsage(a *keyUsage, b *keyUsage) bool {
+	if ra, rb := a.ratio(), b.ratio(); ra != rb {
+		return ra < rb
+	}
+	return a.Key > b.Key
+}
+
+func init() {
+	http.HandleFunc(introspectionPath, serveUsage)
+}
@@ -0,0 +1,248 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memquota
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"istio.io/mixer/adapter/memquota/config"
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/template/quota"
+)
+
+func buildIntrospectionHandler(t *testing.T, now time.Time) *handler {
+	h := buildSnapshotHandler(t, &config.Params{
+		MinDeduplicationDuration: time.Second,
+		MaxExportedKeys:          2,
+		Quotas: []config.Params_Quota{
+			{Name: "IntroCell", MaxAmount: 10},
+			{Name: "IntroRW", MaxAmount: 10, ValidDuration: 10 * time.Second,
+				Overrides: []config.Params_Override{
+					{Dimensions: map[string]string{"source": "a"}, MaxAmount: 2, ValidDuration: 10 * time.Second},
+				},
+			},
+			{Name: "IntroFW", MaxAmount: 10, ValidDuration: 10 * time.Second, Algorithm: config.FIXED_WINDOW},
+			{Name: "IntroTB", MaxAmount: 10, ValidDuration: 10 * time.Second, Algorithm: config.TOKEN_BUCKET, BurstSize: 5},
+		},
+	})
+	h.common.getTime = func() time.Time {
+		return now
+	}
+
+	allocs := []struct {
+		name   string
+		dims   map[string]interface{}
+		amount int64
+	}{
+		{"IntroCell", nil, 3},
+		{"IntroRW", map[string]interface{}{"source": "a"}, 1},
+		{"IntroRW", map[string]interface{}{"source": "b"}, 6},
+		{"IntroFW", nil, 4},
+		{"IntroTB", nil, 4},
+	}
+	for i, a := range allocs {
+		qa := adapter.QuotaArgs{DeduplicationID: strconv.Itoa(i), QuotaAmount: a.amount}
+		if _, err := h.HandleQuota(context.Background(), &quota.Instance{Name: a.name, Dimensions: a.dims}, qa); err != nil {
+			t.Fatalf("Expecting success, got %v", err)
+		}
+	}
+
+	return h
+}
+
+func TestServeUsage(t *testing.T) {
+	now := time.Unix(1000, 0)
+	h := buildIntrospectionHandler(t, now)
+	defer func() { _ = h.Close() }()
+
+	cases := []struct {
+		query  url.Values
+		result []quotaUsage
+	}{
+		{url.Values{"quota": {"IntroCell"}}, []quotaUsage{
+			{Name: "IntroCell", Keys: []keyUsage{{Key: "IntroCell", Used: 3, MaxAmount: 10}}},
+		}},
+		{url.Values{"quota": {"IntroRW"}}, []quotaUsage{
+			{Name: "IntroRW", Keys: []keyUsage{
+				{Key: "IntroRW;source=a", Used: 1, MaxAmount: 2, Override: map[string]string{"source": "a"}, NextRelease: "10s"},
+				{Key: "IntroRW;source=b", Used: 6, MaxAmount: 10, NextRelease: "10s"},
+			}},
+		}},
+		{url.Values{"prefix": {"IntroRW;source=b"}}, []quotaUsage{
+			{Name: "IntroRW", Keys: []keyUsage{{Key: "IntroRW;source=b", Used: 6, MaxAmount: 10, NextRelease: "10s"}}},
+		}},
+		{url.Values{"quota": {"IntroFW"}}, []quotaUsage{
+			{Name: "IntroFW", Keys: []keyUsage{{Key: "IntroFW", Used: 4, MaxAmount: 10, NextRelease: "10s"}}},
+		}},
+		{url.Values{"quota": {"IntroTB"}}, []quotaUsage{
+			{Name: "IntroTB", Keys: []keyUsage{{Key: "IntroTB", Used: 4, MaxAmount: 5, NextRelease: "1s"}}},
+		}},
+		{url.Values{"quota": {"Unknown"}}, []quotaUsage{}},
+	}
+
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			serveUsage(rec, httptest.NewRequest(http.MethodGet, introspectionPath+"?"+c.query.Encode(), nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("Got status %d, expecting %d", rec.Code, http.StatusOK)
+			}
+
+			var result []quotaUsage
+			if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+				t.Fatalf("Unable to decode %s: %v", rec.Body.String(), err)
+			}
+			if !reflect.DeepEqual(result, c.result) {
+				t.Errorf("Got %v, Want %v", result, c.result)
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	serveUsage(rec, httptest.NewRequest(http.MethodPost, introspectionPath, nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Got status %d, expecting %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestUsageAfterRelease(t *testing.T) {
+	now := time.Unix(1000, 0)
+	h := buildIntrospectionHandler(t, now)
+	defer func() { _ = h.Close() }()
+
+	// the rolling window releases everything after its duration.
+	h.common.getTime = func() time.Time {
+		return now.Add(10 * time.Second)
+	}
+
+	for _, ku := range h.usage("IntroRW") {
+		if ku.Used != 0 || ku.NextRelease != "" {
+			t.Errorf("Got %v, expecting no usage", ku)
+		}
+	}
+
+	// the token bucket refills one token per second.
+	h.common.getTime = func() time.Time {
+		return now.Add(2500 * time.Millisecond)
+	}
+
+	ku := h.usage("IntroTB")
+	if len(ku) != 1 || ku[0].Used != 2 || ku[0].NextRelease != "500ms" {
+		t.Errorf("Got %v, expecting 2 used and a release in 500ms", ku)
+	}
+}
+
+func TestUsageUnregister(t *testing.T) {
+	h := buildIntrospectionHandler(t, time.Unix(1000, 0))
+	_ = h.Close()
+
+	if result := collectUsage("IntroCell", ""); len(result) != 0 {
+		t.Errorf("Got %v, expecting no usage after Close", result)
+	}
+}
+
+func TestUsageCollector(t *testing.T) {
+	h := buildIntrospectionHandler(t, time.Unix(1000, 0))
+	defer func() { _ = h.Close() }()
+
+	r := prometheus.NewRegistry()
+	r.MustRegister(usageCollector{})
+	families, err := r.Gather()
+	if err != nil {
+		t.Fatalf("Expecting success, got %v", err)
+	}
+
+	got := make(map[string]float64)
+	for _, f := range families {
+		for _, m := range f.Metric {
+			labels := make(map[string]string)
+			for _, l := range m.Label {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if q := labels["quota"]; len(q) > 5 && q[:5] == "Intro" {
+				got[labels["key"]] = m.Gauge.GetValue()
+			}
+		}
+	}
+
+	// only the two keys with the highest usage are exported.
+	want := map[string]float64{
+		"IntroTB":          0.8,
+		"IntroRW;source=b": 0.6,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v, Want %v", got, want)
+	}
+}
+
+func TestRegisterCollector(t *testing.T) {
+	h := buildIntrospectionHandler(t, time.Unix(1000, 0))
+	defer func() { _ = h.Close() }()
+
+	// building another handler does not register the collector again.
+	if err := registerCollector(); err != nil {
+		t.Errorf("Expecting success, got %v", err)
+	}
+	if err := prometheus.Register(usageCollector{}); err == nil {
+		t.Error("Expecting the collector to be registered")
+	}
+}
+
+func TestTopKeys(t *testing.T) {
+	keys := []keyUsage{
+		{Key: "a", Used: 1, MaxAmount: 10},
+		{Key: "b", Used: 5, MaxAmount: 10},
+		{Key: "c", Used: 0, MaxAmount: 0},
+		{Key: "d", Used: 2, MaxAmount: 10},
+		{Key: "e", Used: 5, MaxAmount: 10},
+	}
+
+	cases := []struct {
+		n    int
+		want []string
+	}{
+		{0, []string{}},
+		{1, []string{"c"}},
+		{3, []string{"c", "b", "e"}},
+		{10, []string{"c", "b", "e", "d", "a"}},
+	}
+
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			top := &topKeys{n: c.n}
+			for _, ku := range keys {
+				top.add(ku)
+			}
+
+			got := []string{}
+			for _, ku := range top.sorted() {
+				got = append(got, ku.Key)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("Got %v, Want %v", got, c.want)
+			}
+		})
+	}
+}
@@ -17,13 +17,17 @@
 //
 // - Obviously, the data set must be able to fit in memory.
 //
-// - When Mixer crashes/restarts, all quota values are erased.
-// This means this isn't good for allocation quotas although
-// it works well enough for rate limits quotas.
+// - When Mixer crashes/restarts, all quota values are erased, unless
+// snapshotPath is configured. Even then, the allocations made since the
+// last snapshot are lost when Mixer crashes.
 //
 // - Since the data is all memory-resident and there isn't any cross-node
 // synchronization, this adapter can't be used in an Istio mixer where
 // a single service can be handled by different mixer instances.
+//
+// The current usage of the quotas is served as JSON at /debug/memquota on
+// Mixer's monitoring port. The quota and prefix query parameters restrict the
+// output to a quota and to the keys starting with a prefix.
 package memquota
 
 import (
@@ -33,6 +37,7 @@ import (
 
 	"istio.io/mixer/adapter/memquota/config"
 	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/adapter/quotas"
 	"istio.io/mixer/pkg/status"
 	"istio.io/mixer/template/quota"
 )
@@ -44,50 +49,57 @@ type handler struct {
 	// the counters we track for non-expiring quotas, protected by lock
 	cells map[string]int64
 
-	// the rolling windows we track for expiring quotas, protected by lock
-	windows map[string]*rollingWindow
+	// the windows and buckets we track for expiring quotas, protected by lock
+	windows map[string]window
+
+	// the limit which applies to each key of cells and windows, protected by lock
+	keyLimits map[string]Limit
 
 	// the limits we know about
 	limits map[string]*config.Params_Quota
 
 	// logger provided by the framework
 	logger adapter.Logger
+
+	// how long deduplication entries are kept at least, they are reaped after twice this duration at most
+	dedupDuration time.Duration
+
+	// the file where the state is saved, empty if the state is not saved
+	snapshotPath string
+
+	// used for saving the state periodically, nil if the state is only saved on Close
+	snapshotTicker *time.Ticker
+
+	// the number of keys whose usage is exported as a Prometheus gauge
+	maxExportedKeys int
 }
 
 // Limit is implemented by Quota and Override messages.
 type Limit interface {
 	GetMaxAmount() int64
 	GetValidDuration() time.Duration
+	GetAlgorithm() config.Params_Algorithm
+	GetBurstSize() int64
 }
 
-// matchDimensions matches configured dimensions with dimensions of the instance.
-func matchDimensions(cfg map[string]string, inst map[string]interface{}) bool {
-	for k, val := range cfg {
-		rval := inst[k]
-		if rval == val { // this dimension matches, on to next comparison.
-			continue
-		}
+// window is implemented by the algorithms tracking expiring quotas.
+type window interface {
+	alloc(amount int64, currentTick int64) bool
+	release(amount int64, currentTick int64) int64
+	available() int64
 
-		// if rval has a string representation then compare it with val
-		// For example net.ip has a useful string representation.
-		switch v := rval.(type) {
-		case fmt.Stringer:
-			if v.String() == val {
-				continue
-			}
-		}
-		// rval does not match val.
-		return false
-	}
-	return true
+	// nextRelease returns the tick at which some of the allocated amount is
+	// next released, or 0 if nothing is allocated.
+	nextRelease(currentTick int64) int64
 }
 
 // limit returns the limit associated with this particular request.
 // Check if the instance matches an override, else return the default limit.
+// The overrides are sorted most specific first when the handler is built.
 func limit(cfg *config.Params_Quota, instance *quota.Instance, l adapter.Logger) Limit {
 	for idx := range cfg.Overrides {
 		o := cfg.Overrides[idx]
-		if matchDimensions(o.Dimensions, instance.Dimensions) {
+		if quotas.MatchDimensions(o.Dimensions, instance.Dimensions) {
 			if l.VerbosityLevel(4) {
 				l.Infof("quota override: %v selected for %v", o, *instance)
 			}
@@ -102,56 +114,122 @@ func limit(cfg *config.Params_Quota, instance *quota.Instance, l adapter.Logger)
 	return cfg
 }
 
+// bucket is a key debited by an allocation, along with the limit which applies to it.
+type bucket struct {
+	key string
+	l   Limit
+	alg config.Params_Algorithm
+}
+
+// buckets returns the buckets debited by an allocation against the instance: the bucket of
+// the instance's quota, whose key is filled in by the caller, followed by the buckets of its
+// parent quotas.
+func (h *handler) buckets(cfg *config.Params_Quota, instance *quota.Instance) []bucket {
+	q := limit(cfg, instance, h.logger)
+	result := []bucket{{l: q, alg: algorithm(cfg, q)}}
+
+	dims := instance.Dimensions
+	for cfg.Parent != "" {
+		dims = parentDimensions(dims, cfg.ParentDimensions)
+		cfg = h.limits[cfg.Parent]
+
+		parent := &quota.Instance{Name: cfg.Name, Dimensions: dims}
+		q = limit(cfg, parent, h.logger)
+		result = append(result, bucket{key: quotas.MakeKey(cfg.Name, dims), l: q, alg: algorithm(cfg, q)})
+	}
+	return result
+}
+
+// parentDimensions returns the dimensions which select the bucket of the parent quota.
+func parentDimensions(dims map[string]interface{}, names []string) map[string]interface{} {
+	result := make(map[string]interface{}, len(names))
+	for _, n := range names {
+		if v, ok := dims[n]; ok {
+			result[n] = v
+		}
+	}
+	return result
+}
+
+// algorithm returns the algorithm used to enforce the limit. An override uses the
+// algorithm of its quota unless it specifies one.
+func algorithm(cfg *config.Params_Quota, l Limit) config.Params_Algorithm {
+	if a := l.GetAlgorithm(); a != config.UNSPECIFIED_ALGORITHM {
+		return a
+	}
+	if a := cfg.GetAlgorithm(); a != config.UNSPECIFIED_ALGORITHM {
+		return a
+	}
+	return config.ROLLING_WINDOW
+}
+
+// capacity returns the amount available in an unused window or bucket of the limit.
+func capacity(l Limit, alg config.Params_Algorithm) int64 {
+	if alg == config.TOKEN_BUCKET && l.GetBurstSize() > 0 {
+		return l.GetBurstSize()
+	}
+	return l.GetMaxAmount()
+}
+
+// durationTicks returns the number of ticks in the duration, rounded up.
+func durationTicks(d time.Duration) int64 {
+	return (int64(d) + nanosPerTick - 1) / nanosPerTick
+}
+
+// newWindow creates the window or bucket tracking the limit.
+func newWindow(l Limit, alg config.Params_Algorithm, currentTick int64) window {
+	switch alg {
+	case config.FIXED_WINDOW:
+		return newFixedWindow(l.GetMaxAmount(), durationTicks(l.GetValidDuration()))
+	case config.TOKEN_BUCKET:
+		return newTokenBucket(capacity(l, alg), l.GetMaxAmount(), durationTicks(l.GetValidDuration()), currentTick)
+	default:
+		seconds := int32((l.GetValidDuration() + time.Second - 1) / time.Second)
+		return newRollingWindow(l.GetMaxAmount(), int64(seconds)*ticksPerSecond)
+	}
+}
+
 func (h *handler) HandleQuota(context context.Context, instance *quota.Instance, args adapter.QuotaArgs) (adapter.QuotaResult, error) {
-	q := limit(h.limits[instance.Name], instance, h.logger)
+	buckets := h.buckets(h.limits[instance.Name], instance)
 	if args.QuotaAmount > 0 {
-		return h.alloc(instance, args, q)
+		return h.alloc(instance, args, buckets)
 	} else if args.QuotaAmount < 0 {
 		args.QuotaAmount = -args.QuotaAmount
-		return h.free(instance, args, q)
+		return h.free(instance, args, buckets)
 	}
 	return adapter.QuotaResult{}, nil
 }
 
-func (h *handler) alloc(instance *quota.Instance, args adapter.QuotaArgs, q Limit) (adapter.QuotaResult, error) {
+func (h *handler) alloc(instance *quota.Instance, args adapter.QuotaArgs, buckets []bucket) (adapter.QuotaResult, error) {
 	amount, exp, key, err := h.common.handleDedup(instance, args, func(key string, currentTime time.Time, currentTick int64) (int64, time.Time,
 		time.Duration) {
 		result := args.QuotaAmount
+		buckets[0].key = key
 
-		// we optimize storage for non-expiring quotas
-		if q.GetValidDuration() == 0 {
-			inUse := h.cells[key]
-
-			if result > q.GetMaxAmount()-inUse {
+		// the allocation is only granted if every bucket allows it.
+		for _, b := range buckets {
+			if avail := h.available(b, currentTick); result > avail {
 				if !args.BestEffort {
 					return 0, time.Time{}, 0
 				}
 
 				// grab as much as we can
-				result = q.GetMaxAmount() - inUse
+				result = avail
 			}
-			h.cells[key] = inUse + result
-			return result, time.Time{}, 0
-		}
-
-		window, ok := h.windows[key]
-		if !ok {
-			seconds := int32((q.GetValidDuration() + time.Second - 1) / time.Second)
-			window = newRollingWindow(q.GetMaxAmount(), int64(seconds)*ticksPerSecond)
-			h.windows[key] = window
 		}
 
-		if !window.alloc(result, currentTick) {
-			if !args.BestEffort {
-				return 0, time.Time{}, 0
+		// the allocation is valid until the first bucket releases it.
+		var exp time.Time
+		for _, b := range buckets {
+			if t := h.debit(b, result, currentTime, currentTick); !t.IsZero() && (exp.IsZero() || t.Before(exp)) {
+				exp = t
 			}
-
-			// grab as much as we can
-			result = window.available()
-			_ = window.alloc(result, currentTick)
 		}
 
-		return result, currentTime.Add(q.GetValidDuration()), q.GetValidDuration()
+		if exp.IsZero() {
+			return result, exp, 0
+		}
+		return result, exp, exp.Sub(currentTime)
 	})
 
 	if h.logger.VerbosityLevel(2) {
@@ -165,38 +243,59 @@ func (h *handler) alloc(instance *quota.Instance, args adapter.QuotaArgs, q Limi
 	}, err
 }
 
-func (h *handler) free(instance *quota.Instance, args adapter.QuotaArgs, q Limit) (adapter.QuotaResult, error) {
-	amount, _, _, err := h.common.handleDedup(instance, args, func(key string, currentTime time.Time, currentTick int64) (int64, time.Time,
-		time.Duration) {
-		result := args.QuotaAmount
+// available returns the amount which can be allocated in the bucket. The caller must hold the lock.
+func (h *handler) available(b bucket, currentTick int64) int64 {
+	// we optimize storage for non-expiring quotas
+	if b.l.GetValidDuration() == 0 {
+		if avail := b.l.GetMaxAmount() - h.cells[b.key]; avail > 0 {
+			return avail
+		}
+		return 0
+	}
 
-		if q.GetValidDuration() == 0 {
-			inUse := h.cells[key]
+	w, ok := h.windows[b.key]
+	if !ok {
+		return capacity(b.l, b.alg)
+	}
 
-			if result >= inUse {
-				// delete the cell since it contains no useful state
-				delete(h.cells, key)
-				return inUse, time.Time{}, 0
-			}
+	// releasing nothing brings the window up to date.
+	_ = w.release(0, currentTick)
+	return w.available()
+}
 
-			h.cells[key] = inUse - result
-			return result, time.Time{}, 0
-		}
+// debit allocates the amount in the bucket, which must be available, and returns the time at
+// which it's released, or the zero time for non-expiring quotas. The caller must hold the lock.
+func (h *handler) debit(b bucket, amount int64, currentTime time.Time, currentTick int64) time.Time {
+	if b.l.GetValidDuration() == 0 {
+		h.cells[b.key] += amount
+		h.keyLimits[b.key] = b.l
+		return time.Time{}
+	}
 
-		// WARNING: Releasing quota in the case of rate limits is
-		//          inherently racy. A release can easily end up
-		//          freeing quota in the wrong window.
+	w, ok := h.windows[b.key]
+	if !ok {
+		w = newWindow(b.l, b.alg, currentTick)
+		h.windows[b.key] = w
+		h.keyLimits[b.key] = b.l
+	}
+	_ = w.alloc(amount, currentTick)
 
-		window, ok := h.windows[key]
-		if !ok {
-			return 0, time.Time{}, 0
-		}
+	if fw, ok := w.(*fixedWindow); ok {
+		// the allocation is valid until the end of the current window.
+		return time.Unix(0, fw.end()*nanosPerTick)
+	}
+	return currentTime.Add(b.l.GetValidDuration())
+}
 
-		result = window.release(result, currentTick)
+func (h *handler) free(instance *quota.Instance, args adapter.QuotaArgs, buckets []bucket) (adapter.QuotaResult, error) {
+	amount, _, _, err := h.common.handleDedup(instance, args, func(key string, currentTime time.Time, currentTick int64) (int64, time.Time,
+		time.Duration) {
+		buckets[0].key = key
 
-		if window.available() == q.GetMaxAmount() {
-			// delete the cell since it contains no useful state
-			delete(h.windows, key)
+		// the parents get back what the quota itself released.
+		result := h.credit(buckets[0], args.QuotaAmount, currentTick)
+		for _, b := range buckets[1:] {
+			_ = h.credit(b, result, currentTick)
 		}
 
 		return result, time.Time{}, 0
@@ -208,9 +307,71 @@ func (h *handler) free(instance *quota.Instance, args adapter.QuotaArgs, q Limit
 	}, err
 }
 
+// credit releases the amount from the bucket and returns the amount actually released.
+// The caller must hold the lock.
+func (h *handler) credit(b bucket, amount int64, currentTick int64) int64 {
+	if b.l.GetValidDuration() == 0 {
+		inUse := h.cells[b.key]
+
+		if amount >= inUse {
+			// delete the cell since it contains no useful state
+			delete(h.cells, b.key)
+			delete(h.keyLimits, b.key)
+			return inUse
+		}
+
+		h.cells[b.key] = inUse - amount
+		return amount
+	}
+
+	// WARNING: Releasing quota in the case of rate limits is
+	//          inherently racy. A release can easily end up
+	//          freeing quota in the wrong window.
+
+	w, ok := h.windows[b.key]
+	if !ok {
+		return 0
+	}
+
+	amount = w.release(amount, currentTick)
+
+	if w.available() == capacity(b.l, b.alg) {
+		// delete the cell since it contains no useful state
+		delete(h.windows, b.key)
+		delete(h.keyLimits, b.key)
+	}
+
+	return amount
+}
+
+// reapWindows deletes the fixed windows which no longer hold any allocation, and the
+// token buckets which are full again.
+func (h *handler) reapWindows(currentTick int64) {
+	for k, w := range h.windows {
+		reap := false
+		switch w := w.(type) {
+		case *fixedWindow:
+			reap = w.expired(currentTick)
+		case *tokenBucket:
+			reap = w.full(currentTick)
+		}
+		if reap {
+			delete(h.windows, k)
+			delete(h.keyLimits, k)
+		}
+	}
+}
+
 func (h *handler) Close() error {
+	unregisterHandler(h)
 	h.common.ticker.Stop()
-	return nil
+	if h.snapshotTicker != nil {
+		h.snapshotTicker.Stop()
+	}
+	if h.snapshotPath == "" {
+		return nil
+	}
+	return h.releaseSnapshot()
 }
 
 ////////////////// Config //////////////////////////
@@ -226,6 +387,7 @@ func GetInfo() adapter.Info {
 		},
 		DefaultConfig: &config.Params{
 			MinDeduplicationDuration: 1 * time.Second,
+			MaxExportedKeys:          10,
 		},
 
 		NewBuilder: func() adapter.HandlerBuilder { return &builder{} },
@@ -246,9 +408,65 @@ func (b *builder) Validate() (ce *adapter.ConfigErrors) {
 	if ac.MinDeduplicationDuration <= 0 {
 		ce = ce.Appendf("minDeduplicationDuration", "deduplication window of %v is invalid, must be > 0", ac.MinDeduplicationDuration)
 	}
+
+	if ac.MaxExportedKeys < 0 {
+		ce = ce.Appendf("maxExportedKeys", "number of exported keys %d is invalid, must be >= 0", ac.MaxExportedKeys)
+	}
+
+	if ac.SnapshotInterval < 0 {
+		ce = ce.Appendf("snapshotInterval", "snapshot interval of %v is invalid, must be >= 0", ac.SnapshotInterval)
+	}
+
+	quotas := make(map[string]*config.Params_Quota, len(ac.Quotas))
+	for i := range ac.Quotas {
+		quotas[ac.Quotas[i].Name] = &ac.Quotas[i]
+	}
+
+	for i := range ac.Quotas {
+		q := &ac.Quotas[i]
+		ce = validateParent(ce, fmt.Sprintf("quotas[%d]", i), q, quotas)
+		ce = validateLimit(ce, fmt.Sprintf("quotas[%d]", i), q, algorithm(q, q))
+		for j := range q.Overrides {
+			o := &q.Overrides[j]
+			ce = validateLimit(ce, fmt.Sprintf("quotas[%d].overrides[%d]", i, j), o, algorithm(q, o))
+		}
+	}
 	return
 }
 
+// validateParent checks that the ancestors of the quota are defined, and that the quota isn't its own ancestor.
+func validateParent(ce *adapter.ConfigErrors, field string, q *config.Params_Quota, quotas map[string]*config.Params_Quota) *adapter.ConfigErrors {
+	if q.Parent == "" {
+		if len(q.ParentDimensions) > 0 {
+			ce = ce.Appendf(field+".parentDimensions", "parent dimensions are only meaningful for a quota with a parent")
+		}
+		return ce
+	}
+
+	seen := map[string]bool{q.Name: true}
+	for p := q.Parent; p != ""; p = quotas[p].Parent {
+		if seen[p] {
+			return ce.Appendf(field+".parent", "quota %s is its own ancestor", q.Name)
+		}
+		seen[p] = true
+
+		if quotas[p] == nil {
+			return ce.Appendf(field+".parent", "ancestor quota %s is not defined", p)
+		}
+	}
+	return ce
+}
+
+func validateLimit(ce *adapter.ConfigErrors, field string, l Limit, alg config.Params_Algorithm) *adapter.ConfigErrors {
+	if l.GetBurstSize() < 0 {
+		ce = ce.Appendf(field+".burstSize", "burst size of %d is invalid, must be >= 0", l.GetBurstSize())
+	}
+	if l.GetValidDuration() == 0 && l.GetAlgorithm() != config.UNSPECIFIED_ALGORITHM && alg != config.ROLLING_WINDOW {
+		ce = ce.Appendf(field+".algorithm", "algorithm %v is only meaningful for rate limit quotas, validDuration must be > 0", alg)
+	}
+	return ce
+}
+
 func (b *builder) Build(context context.Context, env adapter.Env) (adapter.Handler, error) {
 	ac := b.adapterConfig
 	return b.buildWithDedup(context, env, time.NewTicker(ac.MinDeduplicationDuration))
@@ -260,6 +478,7 @@ func (b *builder) buildWithDedup(_ context.Context, env adapter.Env, ticker *tim
 	limits := make(map[string]*config.Params_Quota, len(ac.Quotas))
 	for idx := range ac.Quotas {
 		l := ac.Quotas[idx]
+		l.Overrides = sortOverrides(l.Overrides)
 		limits[l.Name] = &l
 	}
 
@@ -277,16 +496,49 @@ func (b *builder) buildWithDedup(_ context.Context, env adapter.Env, ticker *tim
 			getTime:     time.Now,
 			logger:      env.Logger(),
 		},
-		cells:   make(map[string]int64),
-		windows: make(map[string]*rollingWindow),
-		limits:  limits,
-		logger:  env.Logger(),
+		cells:           make(map[string]int64),
+		windows:         make(map[string]window),
+		keyLimits:       make(map[string]Limit),
+		limits:          limits,
+		logger:          env.Logger(),
+		dedupDuration:   ac.MinDeduplicationDuration,
+		snapshotPath:    ac.SnapshotPath,
+		maxExportedKeys: int(ac.MaxExportedKeys),
+	}
+
+	if h.snapshotPath != "" {
+		s, err := h.claimSnapshot()
+		if err != nil {
+			// starting from scratch is better than not enforcing the quotas at all.
+			env.Logger().Warningf("Unable to restore the quota state from %s: %v", h.snapshotPath, err)
+		} else if s != nil {
+			h.restore(s, h.common.getTime())
+		}
+
+		if ac.SnapshotInterval > 0 {
+			h.snapshotTicker = time.NewTicker(ac.SnapshotInterval)
+			env.ScheduleDaemon(func() {
+				for range h.snapshotTicker.C {
+					if err := h.saveSnapshot(); err != nil {
+						_ = h.logger.Errorf("Unable to save the quota state to %s: %v", h.snapshotPath, err)
+					}
+				}
+			})
+		}
+	}
+
+	if h.maxExportedKeys > 0 {
+		if err := registerCollector(); err != nil {
+			env.Logger().Warningf("Unable to export the quota usage: %v", err)
+		}
 	}
+	registerHandler(h)
 
 	env.ScheduleDaemon(func() {
 		for range h.common.ticker.C {
 			h.common.Lock()
 			h.common.reapDedup()
+			h.reapWindows(h.common.getTime().UnixNano() / nanosPerTick)
 			h.common.Unlock()
 		}
 	})
@@ -84,7 +84,7 @@ func TestAllocAndRelease(t *testing.T) {
 
 	cfg := config.Params{
 		MinDeduplicationDuration: 3600 * time.Second,
-		Quotas: limits,
+		Quotas:                   limits,
 	}
 	info := GetInfo()
 	b := info.NewBuilder()
@@ -237,7 +237,7 @@ func TestReaper(t *testing.T) {
 
 	cfg := config.Params{
 		MinDeduplicationDuration: 3600 * time.Second,
-		Quotas: limits,
+		Quotas:                   limits,
 	}
 	info := GetInfo()
 	b := info.NewBuilder()
@@ -456,6 +456,56 @@ func TestHandler_Limit(t *testing.T) {
 			inst:  instIP,
 			limit: limit2,
 		},
+		{
+			desc: "override glob match",
+			cfg: config.Params_Quota{
+				MaxAmount: limit1,
+				Overrides: []config.Params_Override{
+					{
+						Dimensions: map[string]string{"destination": "dest*"},
+						MaxAmount:  limit2,
+					},
+				},
+			},
+			inst:  inst2,
+			limit: limit2,
+		},
+		{
+			desc: "override glob match ip",
+			cfg: config.Params_Quota{
+				MaxAmount: limit1,
+				Overrides: []config.Params_Override{
+					{
+						Dimensions: map[string]string{"source.ip": "192.10.*"},
+						MaxAmount:  limit2,
+					},
+				},
+			},
+			inst:  instIP,
+			limit: limit2,
+		},
+		{
+			desc: "most specific override",
+			cfg: config.Params_Quota{
+				MaxAmount: limit1,
+				Overrides: []config.Params_Override{
+					{
+						Dimensions: map[string]string{"destination": "*"},
+						MaxAmount:  1,
+					},
+					{
+						Dimensions: map[string]string{"destination": "dest*"},
+						MaxAmount:  2,
+					},
+					{
+						Dimensions: map[string]string{"destination": "*", "source": "src1"},
+						MaxAmount:  limit2,
+					},
+				},
+			},
+			inst:  inst1,
+			limit: limit2,
+		},
 		{
 			desc: "override no dim",
 			cfg: config.Params_Quota{
@@ -473,6 +523,7 @@ func TestHandler_Limit(t *testing.T) {
 	} {
 		t.Run(tc.desc, func(t *testing.T) {
 			env := test.NewEnv(t)
+			tc.cfg.Overrides = sortOverrides(tc.cfg.Overrides)
 			l := limit(&tc.cfg, &tc.inst, env.Logger())
 
 			if l.GetMaxAmount() != tc.limit {
@@ -481,3 +532,363 @@ func TestHandler_Limit(t *testing.T) {
 		})
 	}
 }
+
+func TestAlgorithms(t *testing.T) {
+	limits := []config.Params_Quota{
+		{
+			Name:          "FW",
+			MaxAmount:     10,
+			ValidDuration: time.Second,
+			Algorithm:     config.FIXED_WINDOW,
+		},
+		{
+			Name:          "TB",
+			MaxAmount:     10,
+			ValidDuration: time.Second,
+			Algorithm:     config.TOKEN_BUCKET,
+			BurstSize:     20,
+			Overrides: []config.Params_Override{
+				{
+					Dimensions:    map[string]string{"source": "rolling"},
+					MaxAmount:     5,
+					ValidDuration: time.Second,
+					Algorithm:     config.ROLLING_WINDOW,
+				},
+				{
+					Dimensions:    map[string]string{"source": "inherited"},
+					MaxAmount:     5,
+					ValidDuration: time.Second,
+				},
+			},
+		},
+	}
+
+	cfg := config.Params{
+		MinDeduplicationDuration: 3600 * time.Second,
+		Quotas:                   limits,
+	}
+	info := GetInfo()
+	b := info.NewBuilder()
+	b.SetAdapterConfig(&cfg)
+
+	hndlr, err := b.Build(context.Background(), test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+
+	h := hndlr.(*handler)
+
+	cases := []struct {
+		name            string
+		source          string
+		allocAmount     int64
+		allocResult     int64
+		allocBestEffort bool
+		exp             time.Duration
+		millis          int64
+		releaseAmount   int64
+		releaseResult   int64
+	}{
+		// the allocations are valid until the end of the fixed window
+		{"FW", "", 8, 8, false, 800 * time.Millisecond, 200, 0, 0},
+		{"FW", "", 4, 0, false, 0, 500, 0, 0},
+		{"FW", "", 4, 2, true, 100 * time.Millisecond, 900, 0, 0},
+		{"FW", "", 10, 10, false, time.Second, 1000, 5, 5},
+		{"FW", "", 6, 5, true, 500 * time.Millisecond, 1500, 0, 0},
+
+		// the bucket holds 20 units, and is refilled at 10 units per second
+		{"TB", "", 20, 20, false, time.Second, 0, 0, 0},
+		{"TB", "", 5, 0, false, 0, 400, 0, 0},
+		{"TB", "", 5, 5, false, time.Second, 500, 0, 0},
+		{"TB", "", 30, 20, true, time.Second, 3000, 0, 0},
+		{"TB", "", 0, 0, false, 0, 3000, 30, 20},
+
+		// overrides can use their own algorithm, or the one of the quota
+		{"TB", "rolling", 5, 5, false, time.Second, 0, 0, 0},
+		{"TB", "rolling", 5, 0, false, 0, 500, 0, 0},
+		{"TB", "rolling", 5, 5, false, time.Second, 1000, 0, 0},
+		{"TB", "inherited", 5, 5, false, time.Second, 0, 0, 0},
+		{"TB", "inherited", 5, 0, false, 0, 200, 0, 0},
+		{"TB", "inherited", 1, 1, false, time.Second, 200, 0, 0},
+	}
+
+	now := time.Unix(1000, 0)
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			qa := adapter.QuotaArgs{
+				DeduplicationID: "A" + strconv.Itoa(i),
+				QuotaAmount:     c.allocAmount,
+				BestEffort:      c.allocBestEffort,
+			}
+
+			instance := quota.Instance{
+				Name:       c.name,
+				Dimensions: map[string]interface{}{"source": c.source},
+			}
+
+			h.common.getTime = func() time.Time {
+				return now.Add(time.Duration(c.millis) * time.Millisecond)
+			}
+
+			qr, err := h.HandleQuota(context.Background(), &instance, qa)
+			if err != nil {
+				t.Errorf("Expecting success, got %v", err)
+			}
+
+			if qr.Amount != c.allocResult {
+				t.Errorf("Expecting %d, got %d", c.allocResult, qr.Amount)
+			}
+
+			if qr.ValidDuration != c.exp {
+				t.Errorf("Expecting %v, got %v", c.exp, qr.ValidDuration)
+			}
+
+			qa = adapter.QuotaArgs{
+				DeduplicationID: "R" + strconv.Itoa(i),
+				QuotaAmount:     -c.releaseAmount,
+			}
+
+			qr, err = h.HandleQuota(context.Background(), &instance, qa)
+			if err != nil {
+				t.Errorf("Expecting success, got %v", err)
+			}
+
+			if qr.Amount != c.releaseResult {
+				t.Errorf("Expecting %d, got %d", c.releaseResult, qr.Amount)
+			}
+		})
+	}
+
+	if err := h.Close(); err != nil {
+		t.Errorf("Unable to close handler: %v", err)
+	}
+}
+
+func TestReapWindows(t *testing.T) {
+	h := &handler{
+		windows: map[string]window{
+			"fixed":    newFixedWindow(10, 10),
+			"unused":   newFixedWindow(10, 10),
+			"current":  newFixedWindow(10, 10),
+			"rolling":  newRollingWindow(10, 10),
+			"refilled": newTokenBucket(10, 1, 1, 0),
+			"draining": newTokenBucket(10, 1, 10, 0),
+			"idle":     newTokenBucket(10, 1, 1, 0),
+		},
+	}
+	_ = h.windows["fixed"].alloc(1, 5)
+	_ = h.windows["current"].alloc(1, 15)
+	_ = h.windows["rolling"].alloc(1, 5)
+	_ = h.windows["refilled"].alloc(5, 5)
+	_ = h.windows["draining"].alloc(5, 5)
+
+	h.reapWindows(15)
+
+	for k, want := range map[string]bool{"fixed": false, "unused": false, "current": true, "rolling": true,
+		"refilled": false, "draining": true, "idle": false} {
+		if _, got := h.windows[k]; got != want {
+			t.Errorf("window %s: Got %v, Want %v", k, got, want)
+		}
+	}
+}
+
+func TestAlgorithm(t *testing.T) {
+	for _, tc := range []struct {
+		desc     string
+		quota    config.Params_Algorithm
+		override config.Params_Algorithm
+		want     config.Params_Algorithm
+	}{
+		{"default", config.UNSPECIFIED_ALGORITHM, config.UNSPECIFIED_ALGORITHM, config.ROLLING_WINDOW},
+		{"quota", config.TOKEN_BUCKET, config.UNSPECIFIED_ALGORITHM, config.TOKEN_BUCKET},
+		{"override", config.TOKEN_BUCKET, config.FIXED_WINDOW, config.FIXED_WINDOW},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			q := &config.Params_Quota{Algorithm: tc.quota}
+			o := &config.Params_Override{Algorithm: tc.override}
+			if got := algorithm(q, o); got != tc.want {
+				t.Errorf("Got %v, Want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBadAlgorithmConfig(t *testing.T) {
+	for _, tc := range []struct {
+		desc  string
+		quota config.Params_Quota
+		field string
+	}{
+		{
+			desc:  "non-expiring token bucket",
+			quota: config.Params_Quota{Name: "Q", MaxAmount: 10, Algorithm: config.TOKEN_BUCKET},
+			field: "quotas[0].algorithm",
+		},
+		{
+			desc:  "negative burst size",
+			quota: config.Params_Quota{Name: "Q", MaxAmount: 10, ValidDuration: time.Second, BurstSize: -1},
+			field: "quotas[0].burstSize",
+		},
+		{
+			desc: "non-expiring fixed window override",
+			quota: config.Params_Quota{Name: "Q", MaxAmount: 10, Overrides: []config.Params_Override{
+				{MaxAmount: 5, Algorithm: config.FIXED_WINDOW},
+			}},
+			field: "quotas[0].overrides[0].algorithm",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			info := GetInfo()
+			cfg := info.DefaultConfig.(*config.Params)
+			cfg.Quotas = []config.Params_Quota{tc.quota}
+			b := info.NewBuilder()
+			b.SetAdapterConfig(cfg)
+
+			ce := b.Validate()
+			if ce == nil {
+				t.Fatal("Expecting failure, got success")
+			}
+			if len(ce.Multi.Errors) != 1 || ce.Multi.Errors[0].(adapter.ConfigError).Field != tc.field {
+				t.Errorf("Got %v, Want an error for %s", ce, tc.field)
+			}
+		})
+	}
+}
+
+func TestHierarchy(t *testing.T) {
+	limits := []config.Params_Quota{
+		{
+			Name:             "User",
+			MaxAmount:        5,
+			Parent:           "Tenant",
+			ParentDimensions: []string{"tenant"},
+		},
+		{
+			Name:          "Tenant",
+			MaxAmount:     8,
+			ValidDuration: 10 * time.Second,
+			Overrides: []config.Params_Override{
+				{Dimensions: map[string]string{"tenant": "small*"}, MaxAmount: 3, ValidDuration: 10 * time.Second},
+			},
+		},
+	}
+
+	cases := []struct {
+		name       string
+		user       string
+		tenant     string
+		amount     int64
+		bestEffort bool
+		tick       int64
+		allocated  int64
+		duration   time.Duration
+	}{
+		{"User", "u1", "t1", 4, false, 0, 4, 10 * time.Second},
+		{"User", "u2", "t1", 4, false, 1, 4, 10 * time.Second},
+		{"User", "u3", "t1", 1, false, 2, 0, 0},                  // the tenant is exhausted
+		{"User", "u1", "t1", 3, true, 3, 0, 10 * time.Second},    // the user and the tenant are exhausted
+		{"User", "u2", "t2", 2, false, 4, 2, 10 * time.Second},   // another tenant
+		{"User", "u1", "t1", -2, false, 5, 2, 0},                 // released from the user and the tenant
+		{"User", "u3", "t1", 5, true, 6, 2, 10 * time.Second},    // what the tenant released
+		{"Tenant", "", "t1", 1, false, 7, 0, 0},                  // the same bucket as the parent
+		{"User", "u1", "t1", 1, false, 100, 1, 10 * time.Second}, // the tenant window rolled
+		{"User", "u4", "small1", 4, true, 101, 3, 10 * time.Second},
+	}
+
+	b := GetInfo().NewBuilder().(*builder)
+	b.SetAdapterConfig(&config.Params{
+		MinDeduplicationDuration: time.Second,
+		Quotas:                   limits,
+	})
+	if err := b.Validate(); err != nil {
+		t.Fatalf("Expecting success, got %v", err)
+	}
+
+	h, err := b.buildWithDedup(context.Background(), test.NewEnv(t), time.NewTicker(time.Second))
+	if err != nil {
+		t.Fatalf("Unable to create handler: %v", err)
+	}
+	defer func() { _ = h.Close() }()
+
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			h.common.getTime = func() time.Time {
+				return time.Unix(0, c.tick*nanosPerTick)
+			}
+
+			dims := map[string]interface{}{"tenant": c.tenant}
+			if c.user != "" {
+				dims["user"] = c.user
+			}
+
+			qa := adapter.QuotaArgs{DeduplicationID: strconv.Itoa(i), QuotaAmount: c.amount, BestEffort: c.bestEffort}
+			qr, err := h.HandleQuota(context.Background(), &quota.Instance{Name: c.name, Dimensions: dims}, qa)
+			if err != nil {
+				t.Fatalf("Expecting success, got %v", err)
+			}
+
+			if qr.Amount != c.allocated {
+				t.Errorf("Expecting %d, got %d", c.allocated, qr.Amount)
+			}
+			if qr.ValidDuration != c.duration {
+				t.Errorf("Expecting %v, got %v", c.duration, qr.ValidDuration)
+			}
+		})
+	}
+}
+
+func TestBadParentConfig(t *testing.T) {
+	for _, tc := range []struct {
+		desc   string
+		quotas []config.Params_Quota
+		field  string
+	}{
+		{
+			desc:   "undefined parent",
+			quotas: []config.Params_Quota{{Name: "Q", MaxAmount: 10, Parent: "P"}},
+			field:  "quotas[0].parent",
+		},
+		{
+			desc: "undefined ancestor",
+			quotas: []config.Params_Quota{
+				{Name: "P", MaxAmount: 10, Parent: "G"},
+				{Name: "Q", MaxAmount: 10, Parent: "P"},
+			},
+			field: "quotas[0].parent",
+		},
+		{
+			desc: "cycle",
+			quotas: []config.Params_Quota{
+				{Name: "Q", MaxAmount: 10, Parent: "P"},
+				{Name: "P", MaxAmount: 10, Parent: "Q"},
+			},
+			field: "quotas[0].parent",
+		},
+		{
+			desc:   "own parent",
+			quotas: []config.Params_Quota{{Name: "Q", MaxAmount: 10, Parent: "Q"}},
+			field:  "quotas[0].parent",
+		},
+		{
+			desc:   "parent dimensions without parent",
+			quotas: []config.Params_Quota{{Name: "Q", MaxAmount: 10, ParentDimensions: []string{"tenant"}}},
+			field:  "quotas[0].parentDimensions",
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			info := GetInfo()
+			cfg := info.DefaultConfig.(*config.Params)
+			cfg.Quotas = tc.quotas
+			b := info.NewBuilder()
+			b.SetAdapterConfig(cfg)
+
+			ce := b.Validate()
+			if ce == nil {
+				t.Fatal("Expecting failure, got success")
+			}
+			if ce.Multi.Errors[0].(adapter.ConfigError).Field != tc.field {
+				t.Errorf("Got %v, Want an error for %s", ce, tc.field)
+			}
+		})
+	}
+}
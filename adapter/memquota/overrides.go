@@ -0,0 +1,31 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memquota
+
+import (
+	"sort"
+
+	"istio.io/mixer/adapter/memquota/config"
+	"istio.io/mixer/pkg/adapter/quotas"
+)
+
+// sortOverrides returns a copy of the overrides, most specific first. The order of
+// equally specific overrides is preserved.
+func sortOverrides(overrides []config.Params_Override) []config.Params_Override {
+	sorted := make([]config.Params_Override, len(overrides))
+	copy(sorted, overrides)
+	sort.SliceStable(sorted, func(i, j int) bool { return quotas.MoreSpecific(sorted[i].Dimensions, sorted[j].Dimensions) })
+	return sorted
+}
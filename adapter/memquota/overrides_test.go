@@ -0,0 +1,49 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memquota
+
+import (
+	"reflect"
+	"testing"
+
+	"istio.io/mixer/adapter/memquota/config"
+)
+
+func TestSortOverrides(t *testing.T) {
+	overrides := []config.Params_Override{
+		{MaxAmount: 0},
+		{MaxAmount: 1, Dimensions: map[string]string{"destination": "*"}},
+		{MaxAmount: 2, Dimensions: map[string]string{"destination": "*.local"}},
+		{MaxAmount: 3, Dimensions: map[string]string{"destination": "a.local"}},
+		{MaxAmount: 4, Dimensions: map[string]string{"destination": "*", "source": "*"}},
+		{MaxAmount: 5, Dimensions: map[string]string{"destination": "b.local"}},
+		{MaxAmount: 6, Dimensions: map[string]string{"destination": "*.svc.local"}},
+	}
+
+	var order []int64
+	for _, o := range sortOverrides(overrides) {
+		order = append(order, o.MaxAmount)
+	}
+
+	want := []int64{4, 3, 5, 6, 2, 1, 0}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("Got %v, Want %v", order, want)
+	}
+
+	// the configuration itself is left alone.
+	if overrides[0].MaxAmount != 0 || overrides[6].MaxAmount != 6 {
+		t.Errorf("Got %v, expecting the overrides not to be reordered in place", overrides)
+	}
+}
@@ -112,3 +112,24 @@ func (w *rollingWindow) roll(currentTick int64) {
 func (w *rollingWindow) available() int64 {
 	return w.avail
 }
+
+// limit returns the maximum amount that can be allocated within the window.
+func (w *rollingWindow) limit() int64 {
+	total := w.avail
+	for _, s := range w.slots {
+		total += s
+	}
+	return total
+}
+
+func (w *rollingWindow) nextRelease(currentTick int64) int64 {
+	w.roll(currentTick)
+
+	// the oldest slot is the one after the current slot, it's reclaimed on the next tick.
+	for i := 1; i <= len(w.slots); i++ {
+		if w.slots[(w.currentSlot+i)%len(w.slots)] > 0 {
+			return w.currentSlotTick + int64(i)
+		}
+	}
+	return 0
+}
@@ -91,3 +91,28 @@ func TestRelease(t *testing.T) {
 		}
 	}
 }
+
+func TestNextRelease(t *testing.T) {
+	w := newRollingWindow(5, 3)
+	if tick := w.nextRelease(1); tick != 0 {
+		t.Errorf("Expecting no release, got %d", tick)
+	}
+
+	_ = w.alloc(1, 1)
+	_ = w.alloc(1, 2)
+	cases := []struct {
+		tick int64
+		next int64
+	}{
+		{2, 4},
+		{3, 4},
+		{4, 5},
+		{5, 0},
+	}
+
+	for i, c := range cases {
+		if tick := w.nextRelease(c.tick); tick != c.next {
+			t.Errorf("Expecting %d, got %d, case %d", c.next, tick, i)
+		}
+	}
+}
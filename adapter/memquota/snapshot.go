@@ -0,0 +1,360 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memquota
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"istio.io/mixer/adapter/memquota/config"
+)
+
+// snapshotVersion is the version of the snapshot format. It must be incremented
+// whenever the format changes in a way older versions can't read. Snapshots of
+// other versions are ignored.
+const snapshotVersion = 1
+
+type (
+	// snapshot is the saved state of a handler.
+	snapshot struct {
+		Version int                       `json:"version"`
+		Cells   map[string]int64          `json:"cells,omitempty"`
+		Windows map[string]windowSnapshot `json:"windows,omitempty"`
+		Dedup   map[string]dedupSnapshot  `json:"dedup,omitempty"`
+	}
+
+	// windowSnapshot is the saved state of a window, only one of the fields is set.
+	windowSnapshot struct {
+		Rolling     *rollingWindowSnapshot `json:"rolling,omitempty"`
+		Fixed       *fixedWindowSnapshot   `json:"fixed,omitempty"`
+		TokenBucket *tokenBucketSnapshot   `json:"tokenBucket,omitempty"`
+	}
+
+	rollingWindowSnapshot struct {
+		Slots           []int64 `json:"slots"`
+		CurrentSlot     int     `json:"currentSlot"`
+		CurrentSlotTick int64   `json:"currentSlotTick"`
+		Avail           int64   `json:"avail"`
+	}
+
+	fixedWindowSnapshot struct {
+		TicksInWindow int64 `json:"ticksInWindow"`
+		Window        int64 `json:"window"`
+		Limit         int64 `json:"limit"`
+		Used          int64 `json:"used"`
+	}
+
+	tokenBucketSnapshot struct {
+		Capacity       int64 `json:"capacity"`
+		Rate           int64 `json:"rate"`
+		TicksPerPeriod int64 `json:"ticksPerPeriod"`
+		Tokens         int64 `json:"tokens"`
+		Remainder      int64 `json:"remainder"`
+		LastTick       int64 `json:"lastTick"`
+	}
+
+	dedupSnapshot struct {
+		Amount     int64     `json:"amount"`
+		Expiration time.Time `json:"expiration"`
+
+		// the time after which the entry would have been reaped
+		Reaped time.Time `json:"reaped"`
+	}
+)
+
+// the handlers saving their state, by snapshot path. A handler built with the snapshot path of a live handler
+// takes over the state of that handler instead of reading the snapshot file, which the replaced handler may
+// not have saved yet, and the replaced handler stops saving its state so that it doesn't overwrite the newer
+// state. The allocations made by the replaced handler after it has been taken over are lost.
+var snapshotOwners = struct {
+	sync.Mutex
+	m map[string]*handler
+}{m: make(map[string]*handler)}
+
+// claimSnapshot makes the handler the owner of its snapshot path, and returns the state it starts from: the
+// current state of the previous owner if there is one, else the saved snapshot, or nil if there is none.
+func (h *handler) claimSnapshot() (*snapshot, error) {
+	snapshotOwners.Lock()
+	defer snapshotOwners.Unlock()
+
+	prev := snapshotOwners.m[h.snapshotPath]
+	snapshotOwners.m[h.snapshotPath] = h
+	if prev == nil {
+		return readSnapshot(h.snapshotPath)
+	}
+
+	prev.common.Lock()
+	defer prev.common.Unlock()
+	return prev.snapshot(prev.common.getTime()), nil
+}
+
+// releaseSnapshot saves the state of the handler one last time, and gives up its snapshot path.
+func (h *handler) releaseSnapshot() error {
+	err := h.saveSnapshot()
+
+	snapshotOwners.Lock()
+	if snapshotOwners.m[h.snapshotPath] == h {
+		delete(snapshotOwners.m, h.snapshotPath)
+	}
+	snapshotOwners.Unlock()
+
+	return err
+}
+
+// snapshot captures the state of the handler at the current time. The caller must hold the lock.
+func (h *handler) snapshot(currentTime time.Time) *snapshot {
+	s := &snapshot{
+		Version: snapshotVersion,
+		Cells:   make(map[string]int64, len(h.cells)),
+		Windows: make(map[string]windowSnapshot, len(h.windows)),
+		Dedup:   make(map[string]dedupSnapshot, len(h.common.recentDedup)+len(h.common.oldDedup)),
+	}
+
+	for k, v := range h.cells {
+		s.Cells[k] = v
+	}
+
+	for k, w := range h.windows {
+		switch w := w.(type) {
+		case *rollingWindow:
+			s.Windows[k] = windowSnapshot{Rolling: &rollingWindowSnapshot{
+				Slots:           append([]int64(nil), w.slots...),
+				CurrentSlot:     w.currentSlot,
+				CurrentSlotTick: w.currentSlotTick,
+				Avail:           w.avail,
+			}}
+		case *fixedWindow:
+			s.Windows[k] = windowSnapshot{Fixed: &fixedWindowSnapshot{
+				TicksInWindow: w.ticksInWindow,
+				Window:        w.window,
+				Limit:         w.limit,
+				Used:          w.used,
+			}}
+		case *tokenBucket:
+			s.Windows[k] = windowSnapshot{TokenBucket: &tokenBucketSnapshot{
+				Capacity:       w.capacity,
+				Rate:           w.rate,
+				TicksPerPeriod: w.ticksPerPeriod,
+				Tokens:         w.tokens,
+				Remainder:      w.remainder,
+				LastTick:       w.lastTick,
+			}}
+		}
+	}
+
+	// the old entries are saved first, so the recent ones win. The old entries are reaped by the next
+	// run of the reaper, the recent ones by the run after it.
+	for i, m := range []map[string]dedupState{h.common.oldDedup, h.common.recentDedup} {
+		reaped := currentTime.Add(time.Duration(i+1) * h.dedupDuration)
+		for id, d := range m {
+			s.Dedup[id] = dedupSnapshot{Amount: d.amount, Expiration: d.exp, Reaped: reaped}
+		}
+	}
+
+	return s
+}
+
+// window returns the window saved in the snapshot, or nil if the snapshot is invalid.
+func (ws windowSnapshot) window() window {
+	switch {
+	case ws.Rolling != nil && len(ws.Rolling.Slots) > 0 &&
+		ws.Rolling.CurrentSlot >= 0 && ws.Rolling.CurrentSlot < len(ws.Rolling.Slots):
+		return &rollingWindow{
+			slots:           ws.Rolling.Slots,
+			currentSlot:     ws.Rolling.CurrentSlot,
+			currentSlotTick: ws.Rolling.CurrentSlotTick,
+			avail:           ws.Rolling.Avail,
+		}
+	case ws.Fixed != nil && ws.Fixed.TicksInWindow > 0:
+		return &fixedWindow{
+			ticksInWindow: ws.Fixed.TicksInWindow,
+			window:        ws.Fixed.Window,
+			limit:         ws.Fixed.Limit,
+			used:          ws.Fixed.Used,
+		}
+	case ws.TokenBucket != nil && ws.TokenBucket.TicksPerPeriod > 0:
+		return &tokenBucket{
+			capacity:       ws.TokenBucket.Capacity,
+			rate:           ws.TokenBucket.Rate,
+			ticksPerPeriod: ws.TokenBucket.TicksPerPeriod,
+			tokens:         ws.TokenBucket.Tokens,
+			remainder:      ws.TokenBucket.Remainder,
+			lastTick:       ws.TokenBucket.LastTick,
+		}
+	}
+	return nil
+}
+
+// sameShape returns true if the windows are of the same kind and enforce the same limit.
+func sameShape(a, b window) bool {
+	switch a := a.(type) {
+	case *rollingWindow:
+		b, ok := b.(*rollingWindow)
+		return ok && len(a.slots) == len(b.slots) && a.limit() == b.limit()
+	case *fixedWindow:
+		b, ok := b.(*fixedWindow)
+		return ok && a.ticksInWindow == b.ticksInWindow && a.limit == b.limit
+	case *tokenBucket:
+		b, ok := b.(*tokenBucket)
+		return ok && a.capacity == b.capacity && a.rate == b.rate && a.ticksPerPeriod == b.ticksPerPeriod
+	}
+	return false
+}
+
+// restore loads the state saved in the snapshot into the handler. The state of the
+// quotas which are no longer configured, the windows which don't match the
+// current limits of their quota or hold no allocation anymore, and the
+// deduplication entries which would have been reaped, are discarded.
+// The caller must hold the lock.
+func (h *handler) restore(s *snapshot, currentTime time.Time) {
+	currentTick := currentTime.UnixNano() / nanosPerTick
+
+	for k, v := range s.Cells {
+		if cfg := h.limits[quotaName(k)]; cfg != nil && v > 0 {
+			h.cells[k] = v
+		}
+	}
+
+	for k, ws := range s.Windows {
+		cfg := h.limits[quotaName(k)]
+		w := ws.window()
+		if cfg == nil || w == nil {
+			continue
+		}
+
+		// bring the window up to date, which reclaims the expired allocations.
+		w.release(0, currentTick)
+
+		for _, l := range limitsOf(cfg) {
+			if l.GetValidDuration() == 0 {
+				continue
+			}
+			unused := newWindow(l, algorithm(cfg, l), currentTick)
+			if sameShape(w, unused) {
+				if w.available() != unused.available() {
+					h.windows[k] = w
+					h.keyLimits[k] = l
+				}
+				break
+			}
+		}
+	}
+
+	for id, d := range s.Dedup {
+		if !d.Reaped.After(currentTime) {
+			continue
+		}
+		// entries due before the next run of the reaper go to the old entries, so that they're not kept too long.
+		m := h.common.recentDedup
+		if d.Reaped.Sub(currentTime) <= h.dedupDuration {
+			m = h.common.oldDedup
+		}
+		m[id] = dedupState{amount: d.Amount, exp: d.Expiration}
+	}
+}
+
+// limitsOf returns the quota and its overrides.
+func limitsOf(cfg *config.Params_Quota) []Limit {
+	limits := make([]Limit, 0, len(cfg.Overrides)+1)
+	limits = append(limits, cfg)
+	for idx := range cfg.Overrides {
+		limits = append(limits, &cfg.Overrides[idx])
+	}
+	return limits
+}
+
+// quotaName returns the name of the quota of a key produced by quotas.MakeKey.
+func quotaName(key string) string {
+	if i := strings.IndexByte(key, ';'); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// saveSnapshot writes the state of the handler to its snapshot file, unless another handler has taken over
+// the file.
+func (h *handler) saveSnapshot() error {
+	// writing under the lock keeps a replaced handler from overwriting the state saved by its replacement.
+	snapshotOwners.Lock()
+	defer snapshotOwners.Unlock()
+	if snapshotOwners.m[h.snapshotPath] != h {
+		return nil
+	}
+
+	h.common.Lock()
+	s := h.snapshot(h.common.getTime())
+	h.common.Unlock()
+
+	return writeSnapshot(h.snapshotPath, s)
+}
+
+// writeSnapshot atomically replaces the file at path with the snapshot.
+func writeSnapshot(path string, s *snapshot) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+// readSnapshot reads the snapshot at path. It returns nil if there is no snapshot.
+func readSnapshot(path string) (*snapshot, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// check the version first, the rest of the format depends on it.
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err = json.Unmarshal(data, &header); err != nil {
+		return nil, err
+	}
+	if header.Version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d, want %d", header.Version, snapshotVersion)
+	}
+
+	s := &snapshot{}
+	if err = json.Unmarshal(data, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
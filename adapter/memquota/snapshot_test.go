@@ -0,0 +1,337 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memquota
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/mixer/adapter/memquota/config"
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/adapter/test"
+	"istio.io/mixer/template/quota"
+)
+
+func snapshotConfig(path string) *config.Params {
+	return &config.Params{
+		MinDeduplicationDuration: 3600 * time.Second,
+		SnapshotPath:             path,
+		Quotas: []config.Params_Quota{
+			{Name: "Q1", MaxAmount: 10},
+			{Name: "RW", MaxAmount: 10, ValidDuration: time.Hour},
+			{Name: "FW", MaxAmount: 10, ValidDuration: time.Hour, Algorithm: config.FIXED_WINDOW},
+			{Name: "TB", MaxAmount: 10, ValidDuration: time.Hour, Algorithm: config.TOKEN_BUCKET},
+		},
+	}
+}
+
+func buildSnapshotHandler(t *testing.T, cfg *config.Params) *handler {
+	b := GetInfo().NewBuilder()
+	b.SetAdapterConfig(cfg)
+	if err := b.Validate(); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	h, err := b.Build(context.Background(), test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	return h.(*handler)
+}
+
+func allocQuota(t *testing.T, h *handler, name string, dedup string, amount int64) int64 {
+	qa := adapter.QuotaArgs{DeduplicationID: dedup, QuotaAmount: amount, BestEffort: true}
+	qr, err := h.HandleQuota(context.Background(), &quota.Instance{Name: name}, qa)
+	if err != nil {
+		t.Fatalf("Expecting success, got %v", err)
+	}
+	return qr.Amount
+}
+
+func TestSnapshotRestart(t *testing.T) {
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	cfg := snapshotConfig(filepath.Join(dir, "memquota.json"))
+
+	h := buildSnapshotHandler(t, cfg)
+	for _, name := range []string{"Q1", "RW", "FW", "TB"} {
+		if got := allocQuota(t, h, name, name+"0", 8); got != 8 {
+			t.Errorf("%s: Expecting 8, got %d", name, got)
+		}
+	}
+	if err = h.Close(); err != nil {
+		t.Fatalf("Unable to close handler: %v", err)
+	}
+
+	h = buildSnapshotHandler(t, cfg)
+	for _, name := range []string{"Q1", "RW", "FW", "TB"} {
+		// replayed through deduplication
+		if got := allocQuota(t, h, name, name+"0", 8); got != 8 {
+			t.Errorf("%s: Expecting 8 through deduplication, got %d", name, got)
+		}
+		if got := allocQuota(t, h, name, name+"1", 8); got != 2 {
+			t.Errorf("%s: Expecting 2, got %d", name, got)
+		}
+	}
+	if err = h.Close(); err != nil {
+		t.Fatalf("Unable to close handler: %v", err)
+	}
+
+	// the state of the quotas which are no longer configured is discarded
+	cfg.Quotas = cfg.Quotas[:1]
+	cfg.Quotas[0].MaxAmount = 20
+	h = buildSnapshotHandler(t, cfg)
+	if len(h.cells) != 1 || len(h.windows) != 0 {
+		t.Errorf("Got cells %v and windows %v, Want a single cell", h.cells, h.windows)
+	}
+	if got := allocQuota(t, h, "Q1", "Q1-2", 20); got != 10 {
+		t.Errorf("Expecting 10, got %d", got)
+	}
+	if err = h.Close(); err != nil {
+		t.Fatalf("Unable to close handler: %v", err)
+	}
+}
+
+func TestSnapshotInterval(t *testing.T) {
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "memquota.json")
+	cfg := snapshotConfig(path)
+	cfg.SnapshotInterval = time.Millisecond
+
+	h := buildSnapshotHandler(t, cfg)
+	_ = allocQuota(t, h, "Q1", "0", 5)
+
+	for i := 0; ; i++ {
+		s, err := readSnapshot(path)
+		if err == nil && s != nil && s.Cells["Q1"] == 5 {
+			break
+		}
+		if i == 1000 {
+			t.Fatalf("Got snapshot %v, %v, Want the allocation to be saved", s, err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err = h.Close(); err != nil {
+		t.Errorf("Unable to close handler: %v", err)
+	}
+}
+
+func TestRestore(t *testing.T) {
+	cfg := &config.Params_Quota{
+		Name:          "RW",
+		MaxAmount:     5,
+		ValidDuration: time.Second,
+		Overrides: []config.Params_Override{
+			{
+				Dimensions:    map[string]string{"source": "bucket"},
+				MaxAmount:     5,
+				ValidDuration: time.Second,
+				Algorithm:     config.TOKEN_BUCKET,
+			},
+		},
+	}
+	h := &handler{
+		common:        dedupUtil{recentDedup: make(map[string]dedupState), oldDedup: make(map[string]dedupState)},
+		dedupDuration: time.Second,
+		cells:         make(map[string]int64),
+		windows:       make(map[string]window),
+		keyLimits:     make(map[string]Limit),
+		limits:        map[string]*config.Params_Quota{"RW": cfg},
+	}
+
+	current := newRollingWindow(5, 10)
+	_ = current.alloc(2, 95)
+	expired := newRollingWindow(5, 10)
+	_ = expired.alloc(2, 85)
+	otherLimit := newRollingWindow(6, 10)
+	_ = otherLimit.alloc(2, 95)
+	bucket := newTokenBucket(5, 5, 10, 95)
+	_ = bucket.alloc(5, 95)
+	refilled := newTokenBucket(5, 5, 10, 80)
+	_ = refilled.alloc(5, 80)
+	fixed := newFixedWindow(5, 10)
+	_ = fixed.alloc(2, 95)
+
+	src := &handler{
+		common: dedupUtil{
+			recentDedup: map[string]dedupState{"recent": {amount: 1}},
+			oldDedup:    map[string]dedupState{"old": {amount: 2}, "recent": {amount: 3}},
+		},
+		dedupDuration: time.Second,
+		cells:         map[string]int64{"RW": 1, "Q1;a=b": 1},
+		windows: map[string]window{
+			"RW;source=a":        current,
+			"RW;source=b":        expired,
+			"RW;source=c":        otherLimit,
+			"RW;source=bucket":   bucket,
+			"RW;source=refilled": refilled,
+			"RW;source=fixed":    fixed,
+			"Q2":                 newRollingWindow(5, 10),
+		},
+	}
+
+	saved := time.Unix(0, 100*nanosPerTick)
+	h.restore(src.snapshot(saved), saved)
+
+	if len(h.cells) != 1 || h.cells["RW"] != 1 {
+		t.Errorf("Got cells %v, Want RW only", h.cells)
+	}
+	for _, k := range []string{"RW;source=a", "RW;source=bucket"} {
+		if _, ok := h.windows[k]; !ok {
+			t.Errorf("Expecting window %s to be restored", k)
+		}
+	}
+	if len(h.windows) != 2 {
+		t.Errorf("Got windows %v, Want 2 windows", h.windows)
+	}
+	if a := h.windows["RW;source=bucket"].available(); a != 2 {
+		t.Errorf("Expecting 2 available in the bucket, got %d", a)
+	}
+	if h.common.recentDedup["recent"].amount != 1 || h.common.oldDedup["old"].amount != 2 {
+		t.Errorf("Got dedup state %v and %v, Want the recent entries to win", h.common.recentDedup, h.common.oldDedup)
+	}
+
+	// the dedup entries which would have been reaped are discarded
+	h.common.recentDedup = make(map[string]dedupState)
+	h.common.oldDedup = make(map[string]dedupState)
+	h.restore(src.snapshot(saved), saved.Add(1500*time.Millisecond))
+	if len(h.common.recentDedup) != 0 || len(h.common.oldDedup) != 1 || h.common.oldDedup["recent"].amount != 1 {
+		t.Errorf("Got dedup state %v and %v, Want the recent entry only", h.common.recentDedup, h.common.oldDedup)
+	}
+}
+
+func TestSnapshotHandoff(t *testing.T) {
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "memquota.json")
+	cfg := snapshotConfig(path)
+
+	old := buildSnapshotHandler(t, cfg)
+	_ = allocQuota(t, old, "Q1", "0", 5)
+
+	// the new handler takes over the live state, which the old handler hasn't saved yet
+	h := buildSnapshotHandler(t, cfg)
+	if got := allocQuota(t, h, "Q1", "1", 10); got != 5 {
+		t.Errorf("Expecting 5, got %d", got)
+	}
+
+	// the replaced handler doesn't overwrite the state of its replacement
+	if err = old.Close(); err != nil {
+		t.Fatalf("Unable to close handler: %v", err)
+	}
+	if s, err := readSnapshot(path); err != nil || s != nil {
+		t.Errorf("Got snapshot %v, %v, Want no snapshot", s, err)
+	}
+
+	if err = h.Close(); err != nil {
+		t.Fatalf("Unable to close handler: %v", err)
+	}
+	if s, err := readSnapshot(path); err != nil || s.Cells["Q1"] != 10 {
+		t.Errorf("Got snapshot %v, %v, Want the state of the new handler", s, err)
+	}
+}
+
+func TestReadSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	for _, c := range []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"missing", "", ""},
+		{"garbage", "{", "unexpected end of JSON input"},
+		{"version", `{"version": 2, "cells": [1]}`, "unsupported snapshot version 2"},
+		{"format", `{"version": 1, "cells": [1]}`, "cannot unmarshal"},
+		{"valid", `{"version": 1, "cells": {"Q1": 1}}`, ""},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			path := filepath.Join(dir, c.name)
+			if c.data != "" {
+				if err := ioutil.WriteFile(path, []byte(c.data), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			s, err := readSnapshot(path)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Errorf("Got %v, Want error containing %q", err, c.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Got error %v, expecting success", err)
+			}
+			if c.data == "" && s != nil {
+				t.Errorf("Got %v, Want no snapshot", s)
+			}
+			if c.data != "" && s.Cells["Q1"] != 1 {
+				t.Errorf("Got %v, Want the cells of the snapshot", s)
+			}
+		})
+	}
+}
+
+func TestBadSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	path := filepath.Join(dir, "memquota.json")
+	if err = ioutil.WriteFile(path, []byte(`{"version": 0}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// an unreadable snapshot is ignored
+	h := buildSnapshotHandler(t, snapshotConfig(path))
+	if got := allocQuota(t, h, "Q1", "0", 10); got != 10 {
+		t.Errorf("Expecting 10, got %d", got)
+	}
+
+	// and replaced when the handler is closed
+	if err = h.Close(); err != nil {
+		t.Fatalf("Unable to close handler: %v", err)
+	}
+	if s, err := readSnapshot(path); err != nil || s.Cells["Q1"] != 10 {
+		t.Errorf("Got %v, %v, Want the saved state", s, err)
+	}
+
+	// the snapshot can't be saved in a missing directory
+	h = buildSnapshotHandler(t, snapshotConfig(filepath.Join(dir, "missing", "memquota.json")))
+	if err = h.Close(); err == nil {
+		t.Error("Expecting failure, got success")
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memquota
+
+import (
+	"math"
+	"math/big"
+)
+
+// Implements a token bucket that holds up to N units, refilled at a rate of R units
+// per time interval. Time is abstracted in terms of ticks, provided by the caller.
+//
+// The refill is computed with integer arithmetic, the fraction of a unit accumulated
+// so far is carried over in remainder to avoid drifting.
+type tokenBucket struct {
+	// the maximum # of units in the bucket
+	capacity int64
+
+	// the # of units added to the bucket in ticksPerPeriod ticks
+	rate           int64
+	ticksPerPeriod int64
+
+	// the # of units currently in the bucket
+	tokens int64
+
+	// the fraction of a unit refilled so far, in units of 1/ticksPerPeriod
+	remainder int64
+
+	// the tick at which the bucket was last refilled
+	lastTick int64
+}
+
+// Creates a new token bucket used to implement rate limiting. The bucket starts full.
+//
+// The capacity parameter determines the largest burst allowed. The bucket is refilled
+// with rate units every ticksPerPeriod ticks, spread evenly over the ticks.
+func newTokenBucket(capacity int64, rate int64, ticksPerPeriod int64, currentTick int64) *tokenBucket {
+	return &tokenBucket{
+		capacity:       capacity,
+		rate:           rate,
+		ticksPerPeriod: ticksPerPeriod,
+		tokens:         capacity,
+		lastTick:       currentTick,
+	}
+}
+
+func (b *tokenBucket) alloc(amount int64, currentTick int64) bool {
+	b.refill(currentTick)
+
+	if amount > b.tokens {
+		// not enough tokens
+		return false
+	}
+
+	b.tokens -= amount
+	return true
+}
+
+// release puts the units back into the bucket, up to its capacity, and returns the
+// amount actually returned.
+func (b *tokenBucket) release(amount int64, currentTick int64) int64 {
+	b.refill(currentTick)
+
+	if amount > b.capacity-b.tokens {
+		amount = b.capacity - b.tokens
+	}
+	b.tokens += amount
+	return amount
+}
+
+func (b *tokenBucket) refill(currentTick int64) {
+	elapsed := currentTick - b.lastTick
+	if elapsed <= 0 {
+		return
+	}
+	b.lastTick = currentTick
+
+	if b.tokens >= b.capacity {
+		b.tokens = b.capacity
+		b.remainder = 0
+		return
+	}
+	if b.rate <= 0 {
+		// the bucket is never refilled.
+		return
+	}
+
+	added, remainder := refilled(elapsed, b.rate, b.remainder, b.ticksPerPeriod)
+	if added >= b.capacity-b.tokens {
+		b.tokens = b.capacity
+		b.remainder = 0
+		return
+	}
+	b.tokens += added
+	b.remainder = remainder
+}
+
+// refilled returns the units refilled in elapsed ticks at rate units per ticksPerPeriod ticks,
+// given the fraction of a unit refilled before, and the fraction of a unit left over. Products
+// which overflow int64, after a long idle period or with large rates, are computed with big
+// integers, and a number of units which doesn't fit is returned as math.MaxInt64.
+func refilled(elapsed, rate, remainder, ticksPerPeriod int64) (int64, int64) {
+	if elapsed <= (math.MaxInt64-remainder)/rate {
+		added := elapsed*rate + remainder
+		return added / ticksPerPeriod, added % ticksPerPeriod
+	}
+
+	added := new(big.Int).Mul(big.NewInt(elapsed), big.NewInt(rate))
+	added.Add(added, big.NewInt(remainder))
+	units, left := added.QuoRem(added, big.NewInt(ticksPerPeriod), new(big.Int))
+	if !units.IsInt64() {
+		return math.MaxInt64, 0
+	}
+	return units.Int64(), left.Int64()
+}
+
+// full returns true if the bucket is refilled to its capacity at the given tick, it then
+// holds no state a new bucket would not.
+func (b *tokenBucket) full(currentTick int64) bool {
+	b.refill(currentTick)
+	return b.tokens >= b.capacity
+}
+
+func (b *tokenBucket) available() int64 {
+	return b.tokens
+}
+
+func (b *tokenBucket) nextRelease(currentTick int64) int64 {
+	b.refill(currentTick)
+
+	if b.tokens >= b.capacity || b.rate <= 0 {
+		return 0
+	}
+
+	// the number of ticks until the fraction of a unit refilled so far reaches a unit, rounded up.
+	return b.lastTick + (b.ticksPerPeriod-b.remainder-1)/b.rate + 1
+}
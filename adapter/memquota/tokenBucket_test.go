@@ -0,0 +1,149 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memquota
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTokenBucketAlloc(t *testing.T) {
+	cases := []struct {
+		amount int64
+		tick   int64
+		avail  int64
+		result bool
+	}{
+		{11, 0, 10, false},
+		{10, 0, 0, true},
+		{1, 1, 0, false},  // 2/3 of a unit refilled
+		{1, 2, 0, true},   // 4/3 of a unit refilled
+		{2, 5, 0, true},   // 1/3 + 2 units refilled
+		{1, 6, 0, true},   // 1/3 + 2/3 of a unit refilled
+		{1, 6, 0, false},  // nothing left
+		{4, 15, 2, true},  // 6 units refilled
+		{5, 100, 5, true}, // refilled to the burst size
+		{6, 100, 5, false},
+	}
+
+	// 2 units every 3 ticks, bursts of up to 10 units
+	b := newTokenBucket(10, 2, 3, 0)
+
+	for i, c := range cases {
+		if ok := b.alloc(c.amount, c.tick); ok != c.result {
+			t.Errorf("Expecting %v got %v, case %d", c.result, ok, i)
+		}
+
+		if b.available() != c.avail {
+			t.Errorf("Expecting %d available, got %d, case %d", c.avail, b.available(), i)
+		}
+	}
+}
+
+func TestTokenBucketRelease(t *testing.T) {
+	cases := []struct {
+		allocAmount   int64
+		allocTick     int64
+		releaseAmount int64
+		releaseTick   int64
+		releaseResult int64
+		avail         int64
+	}{
+		{4, 0, 4, 0, 4, 10},
+		{4, 0, 2, 0, 2, 8},
+		{8, 0, 10, 3, 8, 10},
+		{6, 3, 6, 6, 4, 10},
+	}
+
+	b := newTokenBucket(10, 2, 3, 0)
+
+	for i, c := range cases {
+		if ok := b.alloc(c.allocAmount, c.allocTick); !ok {
+			t.Errorf("Expecting to succeed, case %d", i)
+		}
+
+		result := b.release(c.releaseAmount, c.releaseTick)
+		if result != c.releaseResult {
+			t.Errorf("Expecting %d, got %d, case %d", c.releaseResult, result, i)
+		}
+
+		if b.available() != c.avail {
+			t.Errorf("Expecting %d available, got %d, case %d", c.avail, b.available(), i)
+		}
+	}
+}
+
+func TestTokenBucketLongIdle(t *testing.T) {
+	b := newTokenBucket(1<<40, 1<<40, 1, 0)
+	if ok := b.alloc(1<<40, 0); !ok {
+		t.Fatal("Expecting to succeed")
+	}
+
+	// a long idle period must not overflow the refill computation
+	if ok := b.alloc(1<<40, 1<<40); !ok || b.available() != 0 {
+		t.Errorf("Expecting to succeed with 0 available, got %v with %d available", ok, b.available())
+	}
+
+	// neither must a large capacity or period
+	b = newTokenBucket(math.MaxInt64, 1<<40, 1<<40, 0)
+	if ok := b.alloc(math.MaxInt64, 0); !ok {
+		t.Fatal("Expecting to succeed")
+	}
+	if ok := b.alloc(3, 3); !ok || b.available() != 0 {
+		t.Errorf("Expecting to succeed with 0 available, got %v with %d available", ok, b.available())
+	}
+	if ok := b.alloc(1, math.MaxInt64/2); !ok || b.available() != math.MaxInt64/2-4 {
+		t.Errorf("Expecting to succeed with %d available, got %v with %d available", math.MaxInt64/2-4, ok, b.available())
+	}
+}
+
+func TestTokenBucketNextRelease(t *testing.T) {
+	// 2 units every 3 ticks, bursts of up to 10 units
+	b := newTokenBucket(10, 2, 3, 0)
+	if tick := b.nextRelease(0); tick != 0 {
+		t.Errorf("Expecting no release for a full bucket, got %d", tick)
+	}
+
+	_ = b.alloc(3, 0)
+	cases := []struct {
+		tick int64
+		next int64
+	}{
+		{0, 2}, // 2/3 of a unit per tick
+		{1, 2},
+		{2, 3}, // 1/3 of a unit left over
+		{5, 0}, // full again
+	}
+
+	for i, c := range cases {
+		if tick := b.nextRelease(c.tick); tick != c.next {
+			t.Errorf("Expecting %d, got %d, case %d", c.next, tick, i)
+		}
+	}
+}
+
+func TestTokenBucketNoRate(t *testing.T) {
+	// a bucket without a rate is never refilled.
+	b := newTokenBucket(10, 0, 10, 0)
+	if ok := b.alloc(4, 0); !ok {
+		t.Fatal("Expecting to succeed")
+	}
+	if ok := b.alloc(7, 100); ok || b.available() != 6 {
+		t.Errorf("Expecting to fail with 6 available, got %v with %d available", ok, b.available())
+	}
+	if b.full(1000) {
+		t.Error("Expecting the bucket not to be full")
+	}
+}
@@ -17,12 +17,16 @@
 package prometheus
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha1"
 	"fmt"
 	"math"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	multierror "github.com/hashicorp/go-multierror"
@@ -42,22 +46,52 @@ type (
 		c    prometheus.Collector
 		sha  [sha1.Size]byte
 		kind config.Params_MetricInfo_Kind
+		// sha of the kind, buckets and objectives. Metrics sharing a collector must agree on them.
+		shape [sha1.Size]byte
+		// unit of duration values.
+		unit config.Params_MetricInfo_DurationUnit
+		// names of the dimensions used as labels, in label order.
+		labelNames []string
+		// series not updated for longer than expiry are removed. 0 means never.
+		expiry time.Duration
+
+		lock   sync.Mutex // protects series
+		series map[string]*series
+	}
+
+	// series is a combination of label values of a metric,
+	// and the time it was last updated.
+	series struct {
+		labels  prometheus.Labels
+		updated time.Time
 	}
 
 	builder struct {
+		lock sync.Mutex // protects metrics, expiryRefs and expiryDone
 		// maps instance_name to collector.
-		metrics  map[string]*cinfo
-		registry *prometheus.Registry
-		srv      server
-		cfg      *config.Params
+		metrics     map[string]*cinfo
+		registry    *prometheus.Registry
+		srv         server
+		cfg         *config.Params
+		metricTypes map[string]*metric.Type
+		// number of live handlers with expiring metrics, the series are checked for expiry while there are any.
+		expiryRefs int
+		// closed to stop checking the series for expiry.
+		expiryDone chan struct{}
+		// how often series are checked for expiry.
+		expiryCheckInterval time.Duration
 	}
 
 	handler struct {
 		srv     server
 		metrics map[string]*cinfo
+		// stops checking the series for expiry once no other handler needs it, nil without expiring metrics.
+		releaseExpiry func()
 	}
 )
 
+const defaultExpiryCheckInterval = 30 * time.Second
+
 var (
 	charReplacer = strings.NewReplacer("/", "_", ".", "_", " ", "_", "-", "")
 
@@ -70,10 +104,7 @@ func GetInfo() adapter.Info {
 	// prometheus uses a singleton http port, so we make the
 	// builder itself a singleton, when defaultAddr become configurable
 	// srv will be a map[string]server
-	singletonBuilder := &builder{
-		srv: newServer(defaultAddr),
-	}
-	singletonBuilder.clearState()
+	singletonBuilder := newBuilder(newServer(defaultAddr))
 	return adapter.Info{
 		Name:        "prometheus",
 		Impl:        "istio.io/mixer/adapter/prometheus",
@@ -86,15 +117,123 @@ func GetInfo() adapter.Info {
 	}
 }
 
-func (b *builder) clearState() {
-	b.registry = prometheus.NewPedanticRegistry()
-	b.metrics = make(map[string]*cinfo)
+func newBuilder(s server) *builder {
+	return &builder{
+		srv:                 s,
+		registry:            prometheus.NewPedanticRegistry(),
+		metrics:             make(map[string]*cinfo),
+		expiryCheckInterval: defaultExpiryCheckInterval,
+	}
+}
+
+func (b *builder) SetMetricTypes(types map[string]*metric.Type) { b.metricTypes = types }
+func (b *builder) SetAdapterConfig(cfg adapter.Config)          { b.cfg = cfg.(*config.Params) }
+
+func (b *builder) Validate() (ce *adapter.ConfigErrors) {
+	names := make(map[string]int, len(b.cfg.Metrics))
+	for i, m := range b.cfg.Metrics {
+		if t, found := b.metricTypes[m.InstanceName]; found {
+			for _, l := range m.LabelNames {
+				if _, found := t.Dimensions[l]; !found {
+					ce = ce.Appendf(fmt.Sprintf("metrics[%d].labelNames", i), "label '%s' is not a dimension of metric %s", l, m.InstanceName)
+				}
+			}
+		}
+		for l := range m.ConstLabels {
+			for _, n := range labelNamesFor(m, b.metricTypes) {
+				if safeName(l) == safeName(n) {
+					ce = ce.Appendf(fmt.Sprintf("metrics[%d].constLabels", i), "const label '%s' is also a dimension of metric %s", l, m.InstanceName)
+				}
+			}
+		}
+		if m.Expiry < 0 {
+			ce = ce.Appendf(fmt.Sprintf("metrics[%d].expiry", i), "expiry must be >= 0, it is %v", m.Expiry)
+		}
+		switch m.Kind {
+		case config.DISTRIBUTION:
+			ce = validateBuckets(ce, fmt.Sprintf("metrics[%d].buckets", i), m.Buckets)
+		case config.SUMMARY:
+			ce = validateSummary(ce, fmt.Sprintf("metrics[%d].summary", i), m.Summary)
+		}
+
+		// metrics with the same name share a collector, so they must record the same way.
+		name := safeName(promName(m))
+		if j, found := names[name]; found {
+			o := b.cfg.Metrics[j]
+			if o.Kind != m.Kind || !reflect.DeepEqual(o.Buckets, m.Buckets) || !reflect.DeepEqual(o.Summary, m.Summary) {
+				ce = ce.Appendf(fmt.Sprintf("metrics[%d].name", i),
+					"metric %s reuses the name %s of metrics[%d] with a different kind, buckets or objectives", m.InstanceName, name, j)
+			}
+		} else {
+			names[name] = i
+		}
+	}
+	return
+}
+
+func validateBuckets(ce *adapter.ConfigErrors, field string, def *config.Params_MetricInfo_BucketsDefinition) *adapter.ConfigErrors {
+	switch d := def.GetDefinition().(type) {
+	case *config.Params_MetricInfo_BucketsDefinition_LinearBuckets:
+		if d.LinearBuckets.NumFiniteBuckets <= 0 {
+			ce = ce.Appendf(field+".linearBuckets.numFiniteBuckets", "must be > 0, it is %d", d.LinearBuckets.NumFiniteBuckets)
+		}
+		if d.LinearBuckets.Width <= 0 {
+			ce = ce.Appendf(field+".linearBuckets.width", "must be > 0, it is %v", d.LinearBuckets.Width)
+		}
+	case *config.Params_MetricInfo_BucketsDefinition_ExponentialBuckets:
+		if d.ExponentialBuckets.NumFiniteBuckets <= 0 {
+			ce = ce.Appendf(field+".exponentialBuckets.numFiniteBuckets", "must be > 0, it is %d", d.ExponentialBuckets.NumFiniteBuckets)
+		}
+		if d.ExponentialBuckets.GrowthFactor <= 1 {
+			ce = ce.Appendf(field+".exponentialBuckets.growthFactor", "must be > 1, it is %v", d.ExponentialBuckets.GrowthFactor)
+		}
+		if d.ExponentialBuckets.Scale <= 0 {
+			ce = ce.Appendf(field+".exponentialBuckets.scale", "must be > 0, it is %v", d.ExponentialBuckets.Scale)
+		}
+	case *config.Params_MetricInfo_BucketsDefinition_ExplicitBuckets:
+		bounds := d.ExplicitBuckets.Bounds
+		if len(bounds) == 0 {
+			ce = ce.Appendf(field+".explicitBuckets.bounds", "must contain at least one element")
+		}
+		for j := 1; j < len(bounds); j++ {
+			if bounds[j] <= bounds[j-1] {
+				ce = ce.Appendf(field+".explicitBuckets.bounds", "must be monotonically increasing, %v follows %v", bounds[j], bounds[j-1])
+				break
+			}
+		}
+	}
+	return ce
+}
+
+func validateSummary(ce *adapter.ConfigErrors, field string, def *config.Params_MetricInfo_SummaryDefinition) *adapter.ConfigErrors {
+	if def == nil {
+		return ce
+	}
+	for j, o := range def.Objectives {
+		if o.Quantile < 0 || o.Quantile > 1 {
+			ce = ce.Appendf(fmt.Sprintf("%s.objectives[%d].quantile", field, j), "must be between 0 and 1, it is %v", o.Quantile)
+		}
+		if o.Error < 0 || o.Error > 1 {
+			ce = ce.Appendf(fmt.Sprintf("%s.objectives[%d].error", field, j), "must be between 0 and 1, it is %v", o.Error)
+		}
+	}
+	if def.MaxAge < 0 {
+		ce = ce.Appendf(field+".maxAge", "must be >= 0, it is %v", def.MaxAge)
+	}
+	return ce
+}
+
+// promName returns the name of the prometheus metric of the given metric.
+func promName(m *config.Params_MetricInfo) string {
+	if len(m.Name) != 0 {
+		return m.Name
+	}
+	return m.InstanceName
 }
 
-func (b *builder) SetMetricTypes(map[string]*metric.Type) {}
-func (b *builder) SetAdapterConfig(cfg adapter.Config)    { b.cfg = cfg.(*config.Params) }
-func (b *builder) Validate() *adapter.ConfigErrors        { return nil }
 func (b *builder) Build(ctx context.Context, env adapter.Env) (adapter.Handler, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
 
 	cfg := b.cfg
 	var metricErr *multierror.Error
@@ -103,76 +242,171 @@ func (b *builder) Build(ctx context.Context, env adapter.Env) (adapter.Handler,
 	newMetrics := make([]*config.Params_MetricInfo, 0, len(cfg.Metrics))
 
 	// Check for metric redefinition.
-	// If a metric is redefined clear the metric registry and metric map.
-	// prometheus client panics on metric redefinition.
+	// prometheus registry remembers the labels of every name it has seen, even after
+	// a collector is unregistered, so a redefined metric is registered in a new registry.
+	// The collectors of the other metrics move to the new registry with their series.
 	// Addition and removal of metrics is ok.
-	var cl *cinfo
+	redefined := false
 	for _, m := range cfg.Metrics {
+		resolved := *m
+		resolved.LabelNames = labelNamesFor(m, b.metricTypes)
+
 		// metric is not found in the current metric table
 		// should be added.
-		if cl = b.metrics[m.InstanceName]; cl == nil {
-			newMetrics = append(newMetrics, m)
+		cl := b.metrics[m.InstanceName]
+		if cl == nil {
+			newMetrics = append(newMetrics, &resolved)
 			continue
 		}
 
 		// metric collector found and sha matches
 		// safe to reuse the existing collector.
-		if cl.sha == computeSha(m, env.Logger()) {
+		if cl.sha == computeSha(&resolved, env.Logger()) {
 			continue
 		}
 
 		// sha does not match.
-		env.Logger().Warningf("Metric %s redefined. Reloading adapter.", m.Name)
-		b.clearState()
-		// consider all configured metrics to be "new".
-		newMetrics = cfg.Metrics
-		break
+		env.Logger().Warningf("Metric %s redefined. Replacing its collector.", m.InstanceName)
+		delete(b.metrics, m.InstanceName)
+		newMetrics = append(newMetrics, &resolved)
+		redefined = true
+	}
+
+	if redefined {
+		b.registry = prometheus.NewPedanticRegistry()
+		for name, ci := range b.metrics {
+			if err := b.registry.Register(ci.c); err != nil {
+				metricErr = multierror.Append(metricErr, fmt.Errorf("could not register metric %s: %v", name, err))
+				delete(b.metrics, name)
+			}
+		}
 	}
 
 	if env.Logger().VerbosityLevel(4) {
 		env.Logger().Infof("%d new metrics defined", len(newMetrics))
 	}
 
-	var err error
 	for _, m := range newMetrics {
-		mname := m.InstanceName
-		if len(m.Name) != 0 {
-			mname = m.Name
+		mname := promName(m)
+		ci := &cinfo{
+			kind:       m.Kind,
+			sha:        computeSha(m, env.Logger()),
+			shape:      computeSha(&config.Params_MetricInfo{Kind: m.Kind, Buckets: m.Buckets, Summary: m.Summary}, env.Logger()),
+			labelNames: m.LabelNames,
+			expiry:     m.Expiry,
+			unit:       m.DurationUnit,
+			series:     make(map[string]*series),
 		}
-		ci := &cinfo{kind: m.Kind, sha: computeSha(m, env.Logger())}
+		var c prometheus.Collector
 		switch m.Kind {
 		case config.GAUGE:
-			// TODO: make prometheus use the keys of metric.Type.Dimensions as the label names and remove from config.
-			ci.c, err = registerOrGet(b.registry, newGaugeVec(mname, m.Description, m.LabelNames))
-			if err != nil {
-				metricErr = multierror.Append(metricErr, fmt.Errorf("could not register metric: %v", err))
-				continue
-			}
-			b.metrics[m.InstanceName] = ci
+			c = newGaugeVec(mname, m.Description, m.LabelNames, m.ConstLabels)
 		case config.COUNTER:
-			ci.c, err = registerOrGet(b.registry, newCounterVec(mname, m.Description, m.LabelNames))
-			if err != nil {
-				metricErr = multierror.Append(metricErr, fmt.Errorf("could not register metric: %v", err))
-				continue
-			}
-			b.metrics[m.InstanceName] = ci
+			c = newCounterVec(mname, m.Description, m.LabelNames, m.ConstLabels)
 		case config.DISTRIBUTION:
-			ci.c, err = registerOrGet(b.registry, newHistogramVec(mname, m.Description, m.LabelNames, m.Buckets))
-			if err != nil {
-				metricErr = multierror.Append(metricErr, fmt.Errorf("could not register metric: %v", err))
-				continue
-			}
-			b.metrics[m.InstanceName] = ci
+			c = newHistogramVec(mname, m.Description, m.LabelNames, m.ConstLabels, m.Buckets)
+		case config.SUMMARY:
+			c = newSummaryVec(mname, m.Description, m.LabelNames, m.ConstLabels, m.Summary)
 		default:
 			metricErr = multierror.Append(metricErr, fmt.Errorf("unknown metric kind (%d); could not register metric %v", m.Kind, m))
+			continue
 		}
+
+		var err error
+		if ci.c, err = registerOrGet(b.registry, c); err != nil {
+			metricErr = multierror.Append(metricErr, fmt.Errorf("could not register metric: %v", err))
+			continue
+		}
+		// a collector shared with another metric must record the same way,
+		// or the observations of one are put in the buckets of the other.
+		if ci.c != c {
+			if other := b.owner(ci.c); other != nil && other.shape != ci.shape {
+				metricErr = multierror.Append(metricErr, fmt.Errorf("could not register metric %s: "+
+					"prometheus metric %s is already defined with a different kind, buckets or objectives", m.InstanceName, mname))
+				continue
+			}
+		}
+		b.metrics[m.InstanceName] = ci
 	}
 
 	if err := b.srv.Start(env, promhttp.HandlerFor(b.registry, promhttp.HandlerOpts{})); err != nil {
 		return nil, err
 	}
 
-	return &handler{b.srv, b.metrics}, metricErr.ErrorOrNil()
+	// the handler gets its own copy, as the builder keeps updating
+	// its table when other handlers are built.
+	metrics := make(map[string]*cinfo, len(b.metrics))
+	expiring := false
+	for k, v := range b.metrics {
+		metrics[k] = v
+		expiring = expiring || v.expiry > 0
+	}
+
+	h := &handler{srv: b.srv, metrics: metrics}
+	if expiring {
+		h.releaseExpiry = b.retainExpiry(env)
+	}
+
+	return h, metricErr.ErrorOrNil()
+}
+
+// retainExpiry starts checking the series for expiry, unless it is already running, and returns the function
+// releasing it. The check stops once all the handlers which retained it released it. The caller must hold the lock.
+func (b *builder) retainExpiry(env adapter.Env) func() {
+	if b.expiryRefs == 0 {
+		done := make(chan struct{})
+		b.expiryDone = done
+		interval := b.expiryCheckInterval
+		env.ScheduleDaemon(func() {
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case now := <-ticker.C:
+					b.expire(now)
+				case <-done:
+					return
+				}
+			}
+		})
+	}
+	b.expiryRefs++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.lock.Lock()
+			defer b.lock.Unlock()
+			if b.expiryRefs--; b.expiryRefs == 0 {
+				close(b.expiryDone)
+				b.expiryDone = nil
+			}
+		})
+	}
+}
+
+// owner returns the metric info of the given collector.
+func (b *builder) owner(c prometheus.Collector) *cinfo {
+	for _, ci := range b.metrics {
+		if ci.c == c {
+			return ci
+		}
+	}
+	return nil
+}
+
+// expire removes the series of all metrics that were not updated within their expiry.
+func (b *builder) expire(now time.Time) {
+	b.lock.Lock()
+	metrics := make([]*cinfo, 0, len(b.metrics))
+	for _, ci := range b.metrics {
+		metrics = append(metrics, ci)
+	}
+	b.lock.Unlock()
+
+	for _, ci := range metrics {
+		ci.expire(now)
+	}
 }
 
 func (h *handler) HandleMetric(_ context.Context, vals []*metric.Instance) error {
@@ -184,83 +418,222 @@ func (h *handler) HandleMetric(_ context.Context, vals []*metric.Instance) error
 			result = multierror.Append(result, fmt.Errorf("could not find metric info from adapter config for %s", val.Name))
 			continue
 		}
-		collector := ci.c
-		switch ci.kind {
-		case config.GAUGE:
-			vec := collector.(*prometheus.GaugeVec)
-			amt, err := promValue(val.Value)
-			if err != nil {
-				result = multierror.Append(result, fmt.Errorf("could not get value for metric %s: %v", val.Name, err))
-				continue
-			}
-			vec.With(promLabels(val.Dimensions)).Set(amt)
-		case config.COUNTER:
-			vec := collector.(*prometheus.CounterVec)
-			amt, err := promValue(val.Value)
-			if err != nil {
-				result = multierror.Append(result, fmt.Errorf("could not get value for metric %s: %v", val.Name, err))
-				continue
-			}
-			vec.With(promLabels(val.Dimensions)).Add(amt)
-		case config.DISTRIBUTION:
-			vec := collector.(*prometheus.HistogramVec)
-			amt, err := promValue(val.Value)
-			if err != nil {
-				result = multierror.Append(result, fmt.Errorf("could not get value for metric %s: %v", val.Name, err))
-				continue
-			}
-			vec.With(promLabels(val.Dimensions)).Observe(amt)
+		amt, err := ci.value(val.Value)
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("could not get value for metric %s: %v", val.Name, err))
+			continue
+		}
+		if err = ci.record(ci.labels(val.Dimensions), amt, time.Now()); err != nil {
+			result = multierror.Append(result, fmt.Errorf("could not record metric %s: %v", val.Name, err))
 		}
 	}
 
 	return result.ErrorOrNil()
 }
 
-func (h *handler) Close() error { return h.srv.Close() }
+func (h *handler) Close() error {
+	if h.releaseExpiry != nil {
+		h.releaseExpiry()
+	}
+	return h.srv.Close()
+}
+
+// record updates the series with the given labels. The series of an expiring metric is updated under the
+// lock, so that it can't be removed between the update and recording the time of the update.
+func (ci *cinfo) record(labels prometheus.Labels, amt float64, now time.Time) error {
+	if ci.expiry <= 0 {
+		return ci.update(labels, amt)
+	}
+
+	ci.lock.Lock()
+	defer ci.lock.Unlock()
+	if err := ci.update(labels, amt); err != nil {
+		return err
+	}
+	ci.touch(labels, now)
+	return nil
+}
+
+// update records the value in the series with the given labels.
+func (ci *cinfo) update(labels prometheus.Labels, amt float64) (err error) {
+	switch ci.kind {
+	case config.GAUGE:
+		var g prometheus.Gauge
+		if g, err = ci.c.(*prometheus.GaugeVec).GetMetricWith(labels); err == nil {
+			g.Set(amt)
+		}
+	case config.COUNTER:
+		var c prometheus.Counter
+		if c, err = ci.c.(*prometheus.CounterVec).GetMetricWith(labels); err == nil {
+			c.Add(amt)
+		}
+	case config.DISTRIBUTION:
+		var hist prometheus.Histogram
+		if hist, err = ci.c.(*prometheus.HistogramVec).GetMetricWith(labels); err == nil {
+			hist.Observe(amt)
+		}
+	case config.SUMMARY:
+		var sum prometheus.Summary
+		if sum, err = ci.c.(*prometheus.SummaryVec).GetMetricWith(labels); err == nil {
+			sum.Observe(amt)
+		}
+	}
+	return err
+}
 
-func newCounterVec(name, desc string, labels []string) *prometheus.CounterVec {
+// value returns the value to record for the metric, durations in the unit of the metric.
+func (ci *cinfo) value(val interface{}) (float64, error) {
+	if d, ok := val.(time.Duration); ok && ci.unit == config.MILLISECONDS {
+		return float64(d) / float64(time.Millisecond), nil
+	}
+	return promValue(val)
+}
+
+// labels returns the labels of the metric for the given dimensions. Dimensions
+// without a label are ignored, labels without a dimension are left empty.
+func (ci *cinfo) labels(dims map[string]interface{}) prometheus.Labels {
+	labels := make(prometheus.Labels, len(ci.labelNames))
+	for _, n := range ci.labelNames {
+		v := ""
+		if d, found := dims[n]; found {
+			v = fmt.Sprintf("%v", d)
+		}
+		labels[safeName(n)] = v
+	}
+	return labels
+}
+
+// touch records that the series with the given labels was updated. The caller must hold the lock.
+func (ci *cinfo) touch(labels prometheus.Labels, now time.Time) {
+	key := seriesKey(ci.labelNames, labels)
+	if s, found := ci.series[key]; found {
+		s.updated = now
+	} else {
+		ci.series[key] = &series{labels: labels, updated: now}
+	}
+}
+
+// expire removes the series that were not updated within the expiry of the metric.
+func (ci *cinfo) expire(now time.Time) {
+	if ci.expiry <= 0 {
+		return
+	}
+	ci.lock.Lock()
+	defer ci.lock.Unlock()
+	for key, s := range ci.series {
+		if now.Sub(s.updated) < ci.expiry {
+			continue
+		}
+		switch vec := ci.c.(type) {
+		case *prometheus.GaugeVec:
+			vec.Delete(s.labels)
+		case *prometheus.CounterVec:
+			vec.Delete(s.labels)
+		case *prometheus.HistogramVec:
+			vec.Delete(s.labels)
+		case *prometheus.SummaryVec:
+			vec.Delete(s.labels)
+		}
+		delete(ci.series, key)
+	}
+}
+
+func seriesKey(names []string, labels prometheus.Labels) string {
+	var buf bytes.Buffer
+	for _, n := range names {
+		buf.WriteString(labels[safeName(n)])
+		buf.WriteByte(0)
+	}
+	return buf.String()
+}
+
+// labelNamesFor returns the configured label names of the metric or, when there are
+// none, the names of the dimensions of its type, sorted.
+func labelNamesFor(m *config.Params_MetricInfo, types map[string]*metric.Type) []string {
+	if len(m.LabelNames) > 0 {
+		return m.LabelNames
+	}
+	t, found := types[m.InstanceName]
+	if !found {
+		return []string{}
+	}
+	names := make([]string, 0, len(t.Dimensions))
+	for n := range t.Dimensions {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func newCounterVec(name, desc string, labels []string, constLabels map[string]string) *prometheus.CounterVec {
 	if desc == "" {
 		desc = name
 	}
 	c := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
-			Name: safeName(name),
-			Help: desc,
+			Name:        safeName(name),
+			Help:        desc,
+			ConstLabels: promConstLabels(constLabels),
 		},
 		labelNames(labels),
 	)
 	return c
 }
 
-func newGaugeVec(name, desc string, labels []string) *prometheus.GaugeVec {
+func newGaugeVec(name, desc string, labels []string, constLabels map[string]string) *prometheus.GaugeVec {
 	if desc == "" {
 		desc = name
 	}
 	c := prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
-			Name: safeName(name),
-			Help: desc,
+			Name:        safeName(name),
+			Help:        desc,
+			ConstLabels: promConstLabels(constLabels),
 		},
 		labelNames(labels),
 	)
 	return c
 }
 
-func newHistogramVec(name, desc string, labels []string, bucketDef *config.Params_MetricInfo_BucketsDefinition) *prometheus.HistogramVec {
+func newHistogramVec(name, desc string, labels []string, constLabels map[string]string,
+	bucketDef *config.Params_MetricInfo_BucketsDefinition) *prometheus.HistogramVec {
 	if desc == "" {
 		desc = name
 	}
 	c := prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
-			Name:    safeName(name),
-			Help:    desc,
-			Buckets: buckets(bucketDef),
+			Name:        safeName(name),
+			Help:        desc,
+			ConstLabels: promConstLabels(constLabels),
+			Buckets:     buckets(bucketDef),
 		},
 		labelNames(labels),
 	)
 	return c
 }
 
+func newSummaryVec(name, desc string, labels []string, constLabels map[string]string,
+	def *config.Params_MetricInfo_SummaryDefinition) *prometheus.SummaryVec {
+	if desc == "" {
+		desc = name
+	}
+	opts := prometheus.SummaryOpts{
+		Name:        safeName(name),
+		Help:        desc,
+		ConstLabels: promConstLabels(constLabels),
+	}
+	if def != nil {
+		if len(def.Objectives) > 0 {
+			opts.Objectives = make(map[float64]float64, len(def.Objectives))
+			for _, o := range def.Objectives {
+				opts.Objectives[o.Quantile] = o.Error
+			}
+		}
+		opts.MaxAge = def.MaxAge
+	}
+	return prometheus.NewSummaryVec(opts, labelNames(labels))
+}
+
 func buckets(def *config.Params_MetricInfo_BucketsDefinition) []float64 {
 	switch def.GetDefinition().(type) {
 	case *config.Params_MetricInfo_BucketsDefinition_ExplicitBuckets:
@@ -285,6 +658,17 @@ func labelNames(m []string) []string {
 	return out
 }
 
+func promConstLabels(m map[string]string) prometheus.Labels {
+	if len(m) == 0 {
+		return nil
+	}
+	out := make(prometheus.Labels, len(m))
+	for k, v := range m {
+		out[safeName(k)] = v
+	}
+	return out
+}
+
 // borrowed from prometheus.RegisterOrGet. However, that method is
 // targeted for removal soon(tm). So, we duplicate that functionality here
 // to maintain it long-term, as we have a use case for the convenience.
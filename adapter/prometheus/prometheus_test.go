@@ -19,12 +19,15 @@ import (
 	"errors"
 	"net/http"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
 
+	pb "istio.io/api/mixer/v1/config/descriptor"
+
 	"istio.io/mixer/adapter/prometheus/config"
 	"istio.io/mixer/pkg/adapter"
 	"istio.io/mixer/pkg/adapter/test"
@@ -46,14 +49,6 @@ func (t testServer) Start(adapter.Env, http.Handler) error {
 
 func (testServer) Close() error { return nil }
 
-func newBuilder(s server) *builder {
-	return &builder{
-		srv:      s,
-		registry: prometheus.NewPedanticRegistry(),
-		metrics:  make(map[string]*cinfo),
-	}
-}
-
 var (
 	gaugeNoLabels = &config.Params_MetricInfo{
 		InstanceName: "/funky::gauge",
@@ -118,6 +113,24 @@ var (
 		LabelNames: []string{"bool", "string", "email"},
 	}
 
+	summary = &config.Params_MetricInfo{
+		InstanceName: "latency_summary",
+		Description:  "quantiles of latency",
+		Kind:         config.SUMMARY,
+		LabelNames:   []string{},
+		Summary: &config.Params_MetricInfo_SummaryDefinition{
+			Objectives: []*config.Params_MetricInfo_SummaryDefinition_Objective{{Quantile: 0.5, Error: 0.05}, {Quantile: 0.99, Error: 0.001}},
+			MaxAge:     time.Minute,
+		},
+	}
+
+	durationMillis = &config.Params_MetricInfo{
+		InstanceName: "latency_millis",
+		Kind:         config.GAUGE,
+		LabelNames:   []string{},
+		DurationUnit: config.MILLISECONDS,
+	}
+
 	unknown = &config.Params_MetricInfo{
 		InstanceName: "unknown",
 		Description:  "unknown",
@@ -294,6 +307,270 @@ func TestFactory_Build_MetricDefinitionConflicts(t *testing.T) {
 	}
 }
 
+func TestBuild_SharedCollectorConflicts(t *testing.T) {
+	f := newBuilder(&testServer{})
+	f.SetAdapterConfig(makeConfig(histogramNoLabels))
+	if _, err := f.Build(context.Background(), test.NewEnv(t)); err != nil {
+		t.Fatalf("Build() => unexpected error: %v", err)
+	}
+
+	// another handler defines a metric of the same name and labels, but other buckets.
+	otherBuckets := *histogramNoLabels
+	otherBuckets.InstanceName = "other_histogram"
+	otherBuckets.Name = histogramNoLabels.InstanceName
+	otherBuckets.Buckets = histogramNoLabelsNoDesc.Buckets
+	f.SetAdapterConfig(makeConfig(&otherBuckets))
+	if _, err := f.Build(context.Background(), test.NewEnv(t)); err == nil {
+		t.Error("Build() => expected error for a shared collector with different buckets")
+	}
+
+	sameBuckets := *histogramNoLabels
+	sameBuckets.InstanceName = "same_histogram"
+	sameBuckets.Name = histogramNoLabels.InstanceName
+	f.SetAdapterConfig(makeConfig(&sameBuckets))
+	if _, err := f.Build(context.Background(), test.NewEnv(t)); err != nil {
+		t.Errorf("Build() => unexpected error sharing a collector: %v", err)
+	}
+}
+
+func TestProm_DurationUnit(t *testing.T) {
+	f := newBuilder(&testServer{})
+	f.SetAdapterConfig(makeConfig(gaugeNoLabels, durationMillis))
+	a, err := f.Build(context.Background(), test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Build() => unexpected error: %v", err)
+	}
+	vals := []*metric.Instance{
+		newGaugeVal(gaugeNoLabels.InstanceName, 1500*time.Millisecond),
+		newGaugeVal(durationMillis.InstanceName, 1500*time.Millisecond),
+	}
+	if err := a.(metric.Handler).HandleMetric(context.Background(), vals); err != nil {
+		t.Fatalf("HandleMetric() => unexpected error: %v", err)
+	}
+
+	for name, want := range map[string]float64{gaugeNoLabels.InstanceName: 1.5, durationMillis.InstanceName: 1500} {
+		m := new(dto.Metric)
+		if err := f.metrics[name].c.(*prometheus.GaugeVec).With(prometheus.Labels{}).Write(m); err != nil {
+			t.Fatalf("Error writing metric value to proto: %v", err)
+		}
+		if got := metricValue(m); got != want {
+			t.Errorf("HandleMetric(%s) => %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestBuild_Redefinition(t *testing.T) {
+	f := newBuilder(&testServer{})
+	f.SetAdapterConfig(makeConfig(counter, gaugeNoLabels))
+	if _, err := f.Build(context.Background(), test.NewEnv(t)); err != nil {
+		t.Fatalf("Build() => unexpected error: %v", err)
+	}
+	gauge := f.metrics[gaugeNoLabels.InstanceName]
+
+	altCounter := *counter
+	altCounter.LabelNames = []string{"email"}
+	f.SetAdapterConfig(makeConfig(&altCounter, gaugeNoLabels))
+	a, err := f.Build(context.Background(), test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Build() => unexpected error redefining a metric: %v", err)
+	}
+	if f.metrics[gaugeNoLabels.InstanceName] != gauge {
+		t.Error("Build() => unchanged metric was replaced")
+	}
+	if got := f.metrics[counter.InstanceName].labelNames; !reflect.DeepEqual(got, altCounter.LabelNames) {
+		t.Errorf("Build() => redefined metric has labels %v, want %v", got, altCounter.LabelNames)
+	}
+
+	vals := []*metric.Instance{{
+		Name:       counter.InstanceName,
+		Value:      int64(1),
+		Dimensions: map[string]interface{}{"email": "test@istio.io"},
+	}}
+	if err := a.(metric.Handler).HandleMetric(context.Background(), vals); err != nil {
+		t.Errorf("HandleMetric() => unexpected error: %v", err)
+	}
+}
+
+func TestBuild_LabelNamesFromType(t *testing.T) {
+	f := newBuilder(&testServer{})
+	noLabels := &config.Params_MetricInfo{
+		InstanceName: "typed_counter",
+		Kind:         config.COUNTER,
+		ConstLabels:  map[string]string{"mesh": "test"},
+	}
+	f.SetMetricTypes(map[string]*metric.Type{
+		noLabels.InstanceName: {Dimensions: map[string]pb.ValueType{
+			"source.service": pb.STRING,
+			"response_code":  pb.INT64,
+		}},
+	})
+	f.SetAdapterConfig(makeConfig(noLabels))
+	a, err := f.Build(context.Background(), test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Build() => unexpected error: %v", err)
+	}
+
+	ci := a.(*handler).metrics[noLabels.InstanceName]
+	if want := []string{"response_code", "source.service"}; !reflect.DeepEqual(ci.labelNames, want) {
+		t.Errorf("Build() => label names %v, want %v", ci.labelNames, want)
+	}
+
+	vals := []*metric.Instance{{
+		Name:       noLabels.InstanceName,
+		Value:      int64(3),
+		Dimensions: map[string]interface{}{"source.service": "a.svc", "response_code": int64(200)},
+	}}
+	if err := a.(metric.Handler).HandleMetric(context.Background(), vals); err != nil {
+		t.Fatalf("HandleMetric() => unexpected error: %v", err)
+	}
+
+	mfs, err := f.registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() => unexpected error: %v", err)
+	}
+	if len(mfs) != 1 || len(mfs[0].Metric) != 1 {
+		t.Fatalf("Gather() => got %v, want a single series", mfs)
+	}
+	got := make(map[string]string)
+	for _, l := range mfs[0].Metric[0].Label {
+		got[l.GetName()] = l.GetValue()
+	}
+	want := map[string]string{"mesh": "test", "response_code": "200", "source_service": "a.svc"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Gather() => labels %v, want %v", got, want)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	f := newBuilder(&testServer{})
+	expiring := &config.Params_MetricInfo{
+		InstanceName: "expiring_gauge",
+		Kind:         config.GAUGE,
+		LabelNames:   []string{"pod"},
+		Expiry:       time.Minute,
+	}
+	f.SetAdapterConfig(makeConfig(expiring))
+	a, err := f.Build(context.Background(), test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Build() => unexpected error: %v", err)
+	}
+	if f.expiryRefs != 1 {
+		t.Error("Build() => expiry was not started")
+	}
+
+	record := func(pod string) {
+		vals := []*metric.Instance{{Name: expiring.InstanceName, Value: int64(1), Dimensions: map[string]interface{}{"pod": pod}}}
+		if err := a.(metric.Handler).HandleMetric(context.Background(), vals); err != nil {
+			t.Fatalf("HandleMetric() => unexpected error: %v", err)
+		}
+	}
+	series := func() int {
+		mfs, err := f.registry.Gather()
+		if err != nil {
+			t.Fatalf("Gather() => unexpected error: %v", err)
+		}
+		if len(mfs) == 0 {
+			return 0
+		}
+		return len(mfs[0].Metric)
+	}
+
+	record("a")
+	record("b")
+	f.expire(time.Now())
+	if got := series(); got != 2 {
+		t.Errorf("expire() => %d series, want 2", got)
+	}
+
+	f.metrics[expiring.InstanceName].series[seriesKey([]string{"pod"}, prometheus.Labels{"pod": "a"})].updated = time.Now().Add(-2 * time.Minute)
+	f.expire(time.Now())
+	if got := series(); got != 1 {
+		t.Errorf("expire() => %d series, want 1", got)
+	}
+
+	if err = a.Close(); err != nil {
+		t.Fatalf("Close() => unexpected error: %v", err)
+	}
+	if f.expiryRefs != 0 || f.expiryDone != nil {
+		t.Error("Close() => expiry was not stopped")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	types := map[string]*metric.Type{
+		counter.InstanceName: {Dimensions: map[string]pb.ValueType{
+			"bool":   pb.BOOL,
+			"string": pb.STRING,
+			"email":  pb.EMAIL_ADDRESS,
+		}},
+	}
+
+	unknownLabel := *counter
+	unknownLabel.LabelNames = []string{"bool", "nope"}
+	constClash := *counter
+	constClash.ConstLabels = map[string]string{"email": "x"}
+	negative := *counter
+	negative.Expiry = -time.Second
+	badLinear := *histogramNoLabels
+	badLinear.Buckets = &config.Params_MetricInfo_BucketsDefinition{
+		Definition: &config.Params_MetricInfo_BucketsDefinition_LinearBuckets{
+			LinearBuckets: &config.Params_MetricInfo_BucketsDefinition_Linear{NumFiniteBuckets: 3},
+		},
+	}
+	badExponential := *histogramNoLabels
+	badExponential.Buckets = &config.Params_MetricInfo_BucketsDefinition{
+		Definition: &config.Params_MetricInfo_BucketsDefinition_ExponentialBuckets{
+			ExponentialBuckets: &config.Params_MetricInfo_BucketsDefinition_Exponential{NumFiniteBuckets: 3, GrowthFactor: 1, Scale: 1},
+		},
+	}
+	badExplicit := *histogramNoLabels
+	badExplicit.Buckets = &config.Params_MetricInfo_BucketsDefinition{
+		Definition: &config.Params_MetricInfo_BucketsDefinition_ExplicitBuckets{
+			ExplicitBuckets: &config.Params_MetricInfo_BucketsDefinition_Explicit{Bounds: []float64{1, 5, 2}},
+		},
+	}
+	badQuantile := *summary
+	badQuantile.Summary = &config.Params_MetricInfo_SummaryDefinition{
+		Objectives: []*config.Params_MetricInfo_SummaryDefinition_Objective{{Quantile: 1.5, Error: 0.01}},
+	}
+	reusedName := *histogramNoLabelsNoDesc
+	reusedName.Name = histogramNoLabels.InstanceName
+
+	tests := []struct {
+		name    string
+		metrics []*config.Params_MetricInfo
+		wantErr string
+	}{
+		{"valid", []*config.Params_MetricInfo{counter, histogram, summary}, ""},
+		{"unknown label", []*config.Params_MetricInfo{&unknownLabel}, "metrics[0].labelNames"},
+		{"const label clash", []*config.Params_MetricInfo{&constClash}, "metrics[0].constLabels"},
+		{"negative expiry", []*config.Params_MetricInfo{&negative}, "metrics[0].expiry"},
+		{"linear width", []*config.Params_MetricInfo{&badLinear}, "metrics[0].buckets.linearBuckets.width"},
+		{"exponential growth", []*config.Params_MetricInfo{&badExponential}, "metrics[0].buckets.exponentialBuckets.growthFactor"},
+		{"explicit order", []*config.Params_MetricInfo{&badExplicit}, "metrics[0].buckets.explicitBuckets.bounds"},
+		{"quantile range", []*config.Params_MetricInfo{&badQuantile}, "metrics[0].summary.objectives[0].quantile"},
+		{"name reuse", []*config.Params_MetricInfo{histogramNoLabels, &reusedName}, "metrics[1].name"},
+	}
+
+	for _, v := range tests {
+		t.Run(v.name, func(t *testing.T) {
+			f := newBuilder(&testServer{})
+			f.SetMetricTypes(types)
+			f.SetAdapterConfig(makeConfig(v.metrics...))
+			ce := f.Validate()
+			if v.wantErr == "" {
+				if ce != nil {
+					t.Errorf("Validate() => unexpected error: %v", ce)
+				}
+				return
+			}
+			if ce == nil || !strings.Contains(ce.Error(), v.wantErr) {
+				t.Errorf("Validate() => got %v, want an error for %s", ce, v.wantErr)
+			}
+		})
+	}
+}
+
 func TestProm_Close(t *testing.T) {
 	f := newBuilder(&testServer{})
 	f.SetAdapterConfig(&config.Params{})
@@ -321,6 +598,7 @@ func TestProm_Record(t *testing.T) {
 		{"Int64", []*config.Params_MetricInfo{gaugeNoLabels}, []*metric.Instance{newGaugeVal(gaugeVal.Name, int64(8))}},
 		{"Duration", []*config.Params_MetricInfo{gaugeNoLabels}, []*metric.Instance{newGaugeVal(gaugeVal.Name, duration)}},
 		{"String", []*config.Params_MetricInfo{gaugeNoLabels}, []*metric.Instance{newGaugeVal(gaugeVal.Name, "8.243543")}},
+		{"Summary", []*config.Params_MetricInfo{summary}, []*metric.Instance{newGaugeVal(summary.InstanceName, duration)}},
 	}
 
 	for _, v := range tests {
@@ -362,6 +640,11 @@ func TestProm_Record(t *testing.T) {
 						t.Errorf("Error writing metric value to proto: %v", err)
 						continue
 					}
+				case *prometheus.SummaryVec:
+					if err := c.(*prometheus.SummaryVec).With(promLabels(adapterVal.Dimensions)).Write(m); err != nil {
+						t.Errorf("Error writing metric value to proto: %v", err)
+						continue
+					}
 				}
 
 				got := metricValue(m)
@@ -417,6 +700,9 @@ func metricValue(m *dto.Metric) float64 {
 	if c := m.GetHistogram(); c != nil {
 		return *c.SampleSum
 	}
+	if c := m.GetSummary(); c != nil {
+		return *c.SampleSum
+	}
 	if c := m.GetUntyped(); c != nil {
 		return *c.Value
 	}
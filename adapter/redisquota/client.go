@@ -0,0 +1,89 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redisquota
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix.v2/pool"
+	"github.com/mediocregopher/radix.v2/redis"
+)
+
+// reconnectInterval is how long the client waits after failing to connect before trying again.
+const reconnectInterval = time.Second
+
+// client connects to the Redis server the first time it is used, so that the handler can be
+// built, and applies its failure mode, while the server can't be reached.
+type client struct {
+	network  string
+	address  string
+	poolSize int
+
+	// indirection to support fast deterministic tests
+	getTime func() time.Time
+
+	lock        sync.Mutex // protects the fields below
+	pool        *pool.Pool
+	lastErr     error     // the error of the last attempt to connect
+	lastAttempt time.Time // when the last attempt to connect failed
+}
+
+// Cmd runs the command against the Redis server, connecting to it first if needed.
+func (c *client) Cmd(cmd string, args ...interface{}) *redis.Resp {
+	p, err := c.connect()
+	if err != nil {
+		return &redis.Resp{Err: err}
+	}
+	return p.Cmd(cmd, args...)
+}
+
+// connect returns the connection pool, creating it if needed. After a failure, it returns
+// the same error without trying again until reconnectInterval has passed.
+func (c *client) connect() (*pool.Pool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.pool != nil {
+		return c.pool, nil
+	}
+
+	now := c.getTime()
+	if c.lastErr != nil && now.Sub(c.lastAttempt) < reconnectInterval {
+		return nil, c.lastErr
+	}
+
+	p, err := pool.New(c.network, c.address, c.poolSize)
+	if err != nil {
+		c.lastErr = fmt.Errorf("could not connect to redis server %s: %v", c.address, err)
+		c.lastAttempt = now
+		return nil, c.lastErr
+	}
+	c.pool = p
+	c.lastErr = nil
+	return p, nil
+}
+
+// Close releases the connections to the Redis server.
+func (c *client) Close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.pool != nil {
+		c.pool.Empty()
+		c.pool = nil
+	}
+}
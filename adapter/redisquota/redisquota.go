@@ -0,0 +1,354 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package redisquota provides a quota implementation backed by Redis. Unlike memquota,
+// the quotas are shared by all the Mixer instances using the same Redis server.
+//
+// The rate limit algorithms are implemented as Lua scripts, which Redis runs atomically.
+// The deduplication entries are also kept in Redis, so a retried request is deduplicated
+// even when it reaches a different Mixer instance.
+package redisquota
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"time"
+
+	rpc "github.com/googleapis/googleapis/google/rpc"
+	"github.com/mediocregopher/radix.v2/redis"
+	"github.com/mediocregopher/radix.v2/util"
+
+	"istio.io/mixer/adapter/redisquota/config"
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/adapter/quotas"
+	"istio.io/mixer/pkg/status"
+	"istio.io/mixer/template/quota"
+)
+
+type handler struct {
+	// the connections to the Redis server
+	client util.Cmder
+
+	// releases the connections to the Redis server
+	closeClient func()
+
+	// the limits we know about
+	limits map[string]*config.Params_Quota
+
+	// how long the deduplication entries are kept
+	dedupDuration time.Duration
+
+	// the prefix of the keys of the deduplication entries
+	dedupPrefix string
+
+	// what to do when Redis can't be reached
+	failureMode config.Params_FailureMode
+
+	// logger provided by the framework
+	logger adapter.Logger
+}
+
+// Limit is implemented by Quota and Override messages.
+type Limit interface {
+	GetMaxAmount() int64
+	GetValidDuration() time.Duration
+}
+
+// limit returns the limit associated with this particular request.
+// Check if the instance matches an override, else return the default limit.
+// The overrides are sorted most specific first when the handler is built.
+func limit(cfg *config.Params_Quota, instance *quota.Instance, l adapter.Logger) Limit {
+	for idx := range cfg.Overrides {
+		o := cfg.Overrides[idx]
+		if quotas.MatchDimensions(o.Dimensions, instance.Dimensions) {
+			if l.VerbosityLevel(4) {
+				l.Infof("quota override: %v selected for %v", o, *instance)
+			}
+			// all dimensions matched, we found the override.
+			return &o
+		}
+	}
+	if l.VerbosityLevel(4) {
+		l.Infof("quota default: %v selected for %v", cfg.MaxAmount, *instance)
+	}
+	// no overrides, use default limit.
+	return cfg
+}
+
+func millis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+func (h *handler) HandleQuota(context context.Context, instance *quota.Instance, args adapter.QuotaArgs) (adapter.QuotaResult, error) {
+	if args.QuotaAmount == 0 {
+		return adapter.QuotaResult{}, nil
+	}
+
+	cfg := h.limits[instance.Name]
+	l := limit(cfg, instance, h.logger)
+	key := quotas.MakeKey(instance.Name, instance.Dimensions)
+	window := millis(l.GetValidDuration())
+
+	var script, stateKey, extra string
+	switch {
+	case window == 0:
+		script = cellScript
+		stateKey = "cell;" + key
+	case cfg.RateLimitAlgorithm == config.ROLLING_WINDOW:
+		script = rollingWindowScript
+		stateKey = "rolling;" + key
+		bucket := millis(cfg.BucketDuration)
+		if bucket <= 0 {
+			// not allowed by Validate, track the whole window in a single bucket.
+			bucket = window
+		}
+		extra = strconv.FormatInt(bucket, 10)
+	default:
+		script = fixedWindowScript
+		stateKey = "fixed;" + key
+	}
+
+	keys := []string{stateKey}
+	if args.DeduplicationID != "" {
+		keys = append(keys, h.dedupPrefix+instance.Name+";"+args.DeduplicationID)
+	}
+
+	bestEffort := "0"
+	if args.BestEffort {
+		bestEffort = "1"
+	}
+
+	resp := util.LuaEval(h.client, script, len(keys), keys,
+		l.GetMaxAmount(), args.QuotaAmount, bestEffort, window, extra, millis(h.dedupDuration))
+	amount, validity, err := parseResult(resp.Array())
+	if err != nil {
+		return h.degrade(key, args, l, err), nil
+	}
+
+	if h.logger.VerbosityLevel(2) {
+		h.logger.Infof(" AccessLog %d/%d %s", amount, args.QuotaAmount, key)
+	}
+
+	return adapter.QuotaResult{
+		Status:        status.OK,
+		Amount:        amount,
+		ValidDuration: time.Duration(validity) * time.Millisecond,
+	}, nil
+}
+
+// parseResult parses the amount and validity returned by the scripts.
+func parseResult(values []*redis.Resp, err error) (amount int64, validity int64, _ error) {
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(values) != 2 {
+		return 0, 0, fmt.Errorf("unexpected result from redis: %v", values)
+	}
+	if amount, err = values[0].Int64(); err != nil {
+		return 0, 0, err
+	}
+	if validity, err = values[1].Int64(); err != nil {
+		return 0, 0, err
+	}
+	return amount, validity, nil
+}
+
+// degrade returns the result of a request which couldn't be processed by Redis.
+func (h *handler) degrade(key string, args adapter.QuotaArgs, l Limit, err error) adapter.QuotaResult {
+	_ = h.logger.Errorf("Unable to process quota %s with redis: %v", key, err)
+
+	if h.failureMode != config.FAIL_OPEN {
+		return adapter.QuotaResult{
+			Status: status.WithMessage(rpc.UNAVAILABLE, fmt.Sprintf("quota %s is unavailable: %v", key, err)),
+		}
+	}
+
+	if args.QuotaAmount < 0 {
+		// nothing was released.
+		return adapter.QuotaResult{Status: status.OK}
+	}
+	return adapter.QuotaResult{
+		Status:        status.OK,
+		Amount:        args.QuotaAmount,
+		ValidDuration: l.GetValidDuration(),
+	}
+}
+
+func (h *handler) Close() error {
+	h.closeClient()
+	return nil
+}
+
+////////////////// Config //////////////////////////
+
+// GetInfo returns the Info associated with this adapter implementation.
+func GetInfo() adapter.Info {
+	return adapter.Info{
+		Name:        "redisquota",
+		Impl:        "istio.io/mixer/adapter/redisquota",
+		Description: "Redis-based quotas shared by Mixer instances",
+		SupportedTemplates: []string{
+			quota.TemplateName,
+		},
+		DefaultConfig: &config.Params{
+			ConnectionPoolSize:       10,
+			MinDeduplicationDuration: 1 * time.Second,
+		},
+
+		NewBuilder: func() adapter.HandlerBuilder { return &builder{} },
+	}
+}
+
+type builder struct {
+	adapterConfig *config.Params
+	quotaTypes    map[string]*quota.Type
+}
+
+func (b *builder) SetQuotaTypes(types map[string]*quota.Type) { b.quotaTypes = types }
+func (b *builder) SetAdapterConfig(cfg adapter.Config)        { b.adapterConfig = cfg.(*config.Params) }
+
+func (b *builder) Validate() (ce *adapter.ConfigErrors) {
+	ac := b.adapterConfig
+
+	if ac.RedisServerUrl == "" {
+		ce = ce.Appendf("redisServerUrl", "redis server address must be specified")
+	}
+	if ac.ConnectionPoolSize <= 0 {
+		ce = ce.Appendf("connectionPoolSize", "connection pool size of %d is invalid, must be > 0", ac.ConnectionPoolSize)
+	}
+	if ac.MinDeduplicationDuration < time.Millisecond {
+		ce = ce.Appendf("minDeduplicationDuration", "deduplication window of %v is invalid, must be >= 1ms", ac.MinDeduplicationDuration)
+	}
+
+	for i := range ac.Quotas {
+		q := &ac.Quotas[i]
+		field := fmt.Sprintf("quotas[%d]", i)
+		ce = validateLimit(ce, field, q)
+
+		if q.RateLimitAlgorithm == config.ROLLING_WINDOW && expires(q) {
+			if q.BucketDuration < time.Millisecond || q.BucketDuration%time.Millisecond != 0 {
+				ce = ce.Appendf(field+".bucketDuration", "bucket duration of %v is invalid, must be a whole number of milliseconds",
+					q.BucketDuration)
+			} else {
+				for j, l := range limitsOf(q) {
+					if l.GetValidDuration()%q.BucketDuration != 0 {
+						ce = ce.Appendf(limitField(field, j)+".validDuration", "valid duration of %v is not a multiple of the bucket duration %v",
+							l.GetValidDuration(), q.BucketDuration)
+					}
+				}
+			}
+		}
+
+		for j := range q.Overrides {
+			ce = validateLimit(ce, limitField(field, j+1), &q.Overrides[j])
+		}
+	}
+	return
+}
+
+// limitsOf returns the quota and its overrides.
+func limitsOf(cfg *config.Params_Quota) []Limit {
+	limits := make([]Limit, 0, len(cfg.Overrides)+1)
+	limits = append(limits, cfg)
+	for idx := range cfg.Overrides {
+		limits = append(limits, &cfg.Overrides[idx])
+	}
+	return limits
+}
+
+// expires returns true if the quota or any of its overrides has a valid duration.
+func expires(cfg *config.Params_Quota) bool {
+	for _, l := range limitsOf(cfg) {
+		if l.GetValidDuration() != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// limitField returns the name of the field of the limit at index i of limitsOf.
+func limitField(quotaField string, i int) string {
+	if i == 0 {
+		return quotaField
+	}
+	return fmt.Sprintf("%s.overrides[%d]", quotaField, i-1)
+}
+
+func validateLimit(ce *adapter.ConfigErrors, field string, l Limit) *adapter.ConfigErrors {
+	if l.GetMaxAmount() < 0 {
+		ce = ce.Appendf(field+".maxAmount", "max amount of %d is invalid, must be >= 0", l.GetMaxAmount())
+	}
+	if d := l.GetValidDuration(); d < 0 || d%time.Millisecond != 0 {
+		ce = ce.Appendf(field+".validDuration", "valid duration of %v is invalid, must be a whole number of milliseconds", d)
+	}
+	return ce
+}
+
+func (b *builder) Build(context context.Context, env adapter.Env) (adapter.Handler, error) {
+	ac := b.adapterConfig
+
+	limits := make(map[string]*config.Params_Quota, len(ac.Quotas))
+	for idx := range ac.Quotas {
+		l := ac.Quotas[idx]
+		l.Overrides = sortOverrides(l.Overrides)
+		limits[l.Name] = &l
+	}
+
+	for k := range b.quotaTypes {
+		if _, ok := limits[k]; !ok {
+			return nil, fmt.Errorf("did not find limit defined for quota %s", k)
+		}
+	}
+
+	c := &client{
+		network:  "tcp",
+		address:  ac.RedisServerUrl,
+		poolSize: int(ac.ConnectionPoolSize),
+		getTime:  time.Now,
+	}
+	if _, err := c.connect(); err != nil {
+		env.Logger().Warningf("%v, retrying when quota is allocated", err)
+	}
+
+	return &handler{
+		client:        c,
+		closeClient:   c.Close,
+		limits:        limits,
+		dedupDuration: ac.MinDeduplicationDuration,
+		dedupPrefix:   dedupPrefix(ac.Quotas),
+		failureMode:   ac.FailureMode,
+		logger:        env.Logger(),
+	}, nil
+}
+
+// sortOverrides returns a copy of the overrides, most specific first. The order of
+// equally specific overrides is preserved.
+func sortOverrides(overrides []config.Params_Override) []config.Params_Override {
+	sorted := make([]config.Params_Override, len(overrides))
+	copy(sorted, overrides)
+	sort.SliceStable(sorted, func(i, j int) bool { return quotas.MoreSpecific(sorted[i].Dimensions, sorted[j].Dimensions) })
+	return sorted
+}
+
+// dedupPrefix returns the prefix of the deduplication keys of a handler with the given quotas, which
+// keeps them apart from those of the other handlers using the Redis server. The handlers of the Mixer
+// instances sharing the server are configured the same, so they share their deduplication entries.
+func dedupPrefix(cfg []config.Params_Quota) string {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%v", cfg)
+	return "dedup;" + strconv.FormatUint(h.Sum64(), 16) + ";"
+}
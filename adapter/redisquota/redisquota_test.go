@@ -0,0 +1,452 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redisquota
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis"
+	rpc "github.com/googleapis/googleapis/google/rpc"
+
+	"istio.io/mixer/adapter/redisquota/config"
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/adapter/test"
+	"istio.io/mixer/template/quota"
+)
+
+func newServer(t *testing.T) *miniredis.Miniredis {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Unable to start the redis server: %v", err)
+	}
+	return s
+}
+
+func newHandler(t *testing.T, cfg *config.Params) *handler {
+	info := GetInfo()
+	b := info.NewBuilder()
+	b.SetAdapterConfig(cfg)
+	if err := b.Validate(); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	h, err := b.Build(context.Background(), test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	return h.(*handler)
+}
+
+func TestBasic(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	info := GetInfo()
+	if len(info.SupportedTemplates) != 1 || info.SupportedTemplates[0] != quota.TemplateName {
+		t.Errorf("Got %v, Want the quota template", info.SupportedTemplates)
+	}
+
+	cfg := info.DefaultConfig.(*config.Params)
+	cfg.RedisServerUrl = s.Addr()
+	h := newHandler(t, cfg)
+
+	if err := h.Close(); err != nil {
+		t.Errorf("Got error %v, expecting success", err)
+	}
+}
+
+func TestAllocAndRelease(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	cfg := &config.Params{
+		RedisServerUrl:           s.Addr(),
+		ConnectionPoolSize:       2,
+		MinDeduplicationDuration: time.Hour,
+		Quotas: []config.Params_Quota{
+			{
+				Name:      "Q1",
+				MaxAmount: 10,
+			},
+			{
+				Name:          "FW",
+				MaxAmount:     10,
+				ValidDuration: time.Second,
+			},
+			{
+				Name:               "RW",
+				MaxAmount:          10,
+				ValidDuration:      time.Second,
+				BucketDuration:     100 * time.Millisecond,
+				RateLimitAlgorithm: config.ROLLING_WINDOW,
+			},
+		},
+	}
+	h := newHandler(t, cfg)
+
+	cases := []struct {
+		name            string
+		dedup           string
+		allocAmount     int64
+		allocResult     int64
+		allocBestEffort bool
+		exp             time.Duration
+		millis          int64
+		releaseAmount   int64
+		releaseResult   int64
+	}{
+		{"Q1", "0", 2, 2, false, 0, 0, 0, 0},
+		{"Q1", "0", 2, 2, false, 0, 0, 0, 0}, // should be a nop due to dedup
+		{"Q1", "1", 6, 6, false, 0, 0, 0, 0},
+		{"Q1", "2", 2, 2, false, 0, 0, 0, 0},
+		{"Q1", "3", 2, 0, false, 0, 0, 0, 0},
+		{"Q1", "4", 1, 0, true, 0, 0, 0, 0},
+		{"Q1", "5b", 0, 0, false, 0, 0, 5, 5},
+		{"Q1", "5b", 0, 0, false, 0, 0, 5, 5},
+		{"Q1", "5c", 0, 0, false, 0, 0, 15, 5},
+
+		{"FW", "6", 8, 8, false, 800 * time.Millisecond, 200, 0, 0},
+		{"FW", "7", 4, 0, false, 0, 500, 0, 0},
+		{"FW", "8", 4, 2, true, 100 * time.Millisecond, 900, 0, 0},
+		{"FW", "9", 10, 10, false, time.Second, 1000, 5, 5},
+		{"FW", "10", 6, 5, true, 500 * time.Millisecond, 1500, 0, 0},
+		{"FW", "11", 0, 0, false, 0, 1500, 20, 10},
+
+		{"RW", "12", 4, 4, false, time.Second, 0, 0, 0},
+		{"RW", "13", 4, 4, false, time.Second, 500, 0, 0},
+		{"RW", "14", 4, 2, true, time.Second, 900, 0, 0},
+		{"RW", "15", 1, 0, false, 0, 950, 0, 0},
+		{"RW", "16", 4, 4, false, time.Second, 1000, 0, 0}, // the first allocation left the window
+		{"RW", "17", 0, 0, false, 0, 1000, 5, 5},           // released from the newest buckets
+		{"RW", "18", 5, 5, false, time.Second, 1050, 0, 0},
+		{"RW", "19", 0, 0, false, 0, 3000, 10, 0},
+	}
+
+	now := time.Unix(1000, 0)
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			s.SetTime(now.Add(time.Duration(c.millis) * time.Millisecond))
+
+			instance := quota.Instance{
+				Name:       c.name,
+				Dimensions: map[string]interface{}{"source": "a", "ip": net.ParseIP("10.0.0.1")},
+			}
+
+			qa := adapter.QuotaArgs{
+				DeduplicationID: "A" + c.dedup,
+				QuotaAmount:     c.allocAmount,
+				BestEffort:      c.allocBestEffort,
+			}
+			qr, err := h.HandleQuota(context.Background(), &instance, qa)
+			if err != nil {
+				t.Errorf("Expecting success, got %v", err)
+			}
+			if qr.Status.Code != int32(rpc.OK) {
+				t.Errorf("Expecting success, got %v", qr.Status)
+			}
+			if qr.Amount != c.allocResult {
+				t.Errorf("Expecting %d, got %d", c.allocResult, qr.Amount)
+			}
+			if qr.ValidDuration != c.exp {
+				t.Errorf("Expecting %v, got %v", c.exp, qr.ValidDuration)
+			}
+
+			qa = adapter.QuotaArgs{
+				DeduplicationID: "R" + c.dedup,
+				QuotaAmount:     -c.releaseAmount,
+			}
+			qr, err = h.HandleQuota(context.Background(), &instance, qa)
+			if err != nil {
+				t.Errorf("Expecting success, got %v", err)
+			}
+			if qr.Amount != c.releaseResult {
+				t.Errorf("Expecting %d, got %d", c.releaseResult, qr.Amount)
+			}
+		})
+	}
+
+	if err := h.Close(); err != nil {
+		t.Errorf("Unable to close handler: %v", err)
+	}
+}
+
+func TestSharedLimits(t *testing.T) {
+	s := newServer(t)
+	defer s.Close()
+
+	cfg := &config.Params{
+		RedisServerUrl:           s.Addr(),
+		ConnectionPoolSize:       1,
+		MinDeduplicationDuration: time.Second,
+		Quotas: []config.Params_Quota{
+			{
+				Name:          "Q1",
+				MaxAmount:     10,
+				ValidDuration: time.Minute,
+				Overrides: []config.Params_Override{
+					{
+						Dimensions:    map[string]string{"source": "ratings*"},
+						MaxAmount:     5,
+						ValidDuration: time.Minute,
+					},
+					{
+						Dimensions:    map[string]string{"source": "ratings"},
+						MaxAmount:     2,
+						ValidDuration: time.Minute,
+					},
+				},
+			},
+			{
+				Name:          "Q2",
+				MaxAmount:     10,
+				ValidDuration: time.Minute,
+			},
+		},
+	}
+
+	// two Mixer instances sharing the same server
+	h1 := newHandler(t, cfg)
+	h2 := newHandler(t, cfg)
+	s.SetTime(time.Unix(60000, 0))
+
+	allocQuota := func(h *handler, name string, dedup string, source string, amount int64) int64 {
+		instance := quota.Instance{Name: name, Dimensions: map[string]interface{}{"source": source}}
+		qr, err := h.HandleQuota(context.Background(), &instance, adapter.QuotaArgs{DeduplicationID: dedup, QuotaAmount: amount})
+		if err != nil {
+			t.Fatalf("Expecting success, got %v", err)
+		}
+		return qr.Amount
+	}
+	alloc := func(h *handler, dedup string, source string, amount int64) int64 {
+		return allocQuota(h, "Q1", dedup, source, amount)
+	}
+
+	if got := alloc(h1, "0", "reviews", 6); got != 6 {
+		t.Errorf("Expecting 6, got %d", got)
+	}
+	// deduplicated by the other instance
+	if got := alloc(h2, "0", "reviews", 6); got != 6 {
+		t.Errorf("Expecting 6 through deduplication, got %d", got)
+	}
+	if got := alloc(h2, "1", "reviews", 6); got != 0 {
+		t.Errorf("Expecting 0, got %d", got)
+	}
+	if got := alloc(h2, "2", "reviews", 4); got != 4 {
+		t.Errorf("Expecting 4, got %d", got)
+	}
+
+	// overrides are tracked separately
+	if got := alloc(h1, "3", "ratings", 3); got != 0 {
+		t.Errorf("Expecting 0, got %d", got)
+	}
+	if got := alloc(h2, "4", "ratings", 2); got != 2 {
+		t.Errorf("Expecting 2, got %d", got)
+	}
+
+	// the most specific override applies
+	if got := alloc(h1, "5", "ratings-v2", 5); got != 5 {
+		t.Errorf("Expecting 5, got %d", got)
+	}
+
+	// deduplication entries are kept per quota
+	if got := allocQuota(h1, "Q2", "0", "reviews", 3); got != 3 {
+		t.Errorf("Expecting 3, got %d", got)
+	}
+
+	// and per handler
+	other := *cfg
+	other.Quotas = []config.Params_Quota{{Name: "Q1", MaxAmount: 20, ValidDuration: time.Minute}}
+	h3 := newHandler(t, &other)
+	defer func() { _ = h3.Close() }()
+	if got := alloc(h3, "0", "reviews", 3); got != 3 {
+		t.Errorf("Expecting 3, got %d", got)
+	}
+
+	// deduplication entries expire
+	s.FastForward(2 * time.Second)
+	if got := alloc(h2, "0", "reviews", 6); got != 0 {
+		t.Errorf("Expecting 0 after the deduplication entry expired, got %d", got)
+	}
+
+	for _, h := range []*handler{h1, h2} {
+		if err := h.Close(); err != nil {
+			t.Errorf("Unable to close handler: %v", err)
+		}
+	}
+}
+
+func TestFailureMode(t *testing.T) {
+	for _, c := range []struct {
+		mode          config.Params_FailureMode
+		amount        int64
+		code          rpc.Code
+		allocResult   int64
+		exp           time.Duration
+		releaseResult int64
+	}{
+		{config.FAIL_CLOSE, 5, rpc.UNAVAILABLE, 0, 0, 0},
+		{config.FAIL_OPEN, 5, rpc.OK, 5, time.Second, 0},
+	} {
+		t.Run(c.mode.String(), func(t *testing.T) {
+			s := newServer(t)
+			cfg := &config.Params{
+				RedisServerUrl:           s.Addr(),
+				ConnectionPoolSize:       1,
+				MinDeduplicationDuration: time.Second,
+				FailureMode:              c.mode,
+				Quotas:                   []config.Params_Quota{{Name: "Q1", MaxAmount: 10, ValidDuration: time.Second}},
+			}
+			h := newHandler(t, cfg)
+			instance := quota.Instance{Name: "Q1"}
+
+			// the server goes away
+			s.Close()
+
+			qr, err := h.HandleQuota(context.Background(), &instance, adapter.QuotaArgs{DeduplicationID: "0", QuotaAmount: c.amount})
+			if err != nil {
+				t.Errorf("Expecting success, got %v", err)
+			}
+			if qr.Status.Code != int32(c.code) || qr.Amount != c.allocResult || qr.ValidDuration != c.exp {
+				t.Errorf("Got %v, Want code %v, amount %d and validity %v", qr, c.code, c.allocResult, c.exp)
+			}
+
+			qr, _ = h.HandleQuota(context.Background(), &instance, adapter.QuotaArgs{DeduplicationID: "1", QuotaAmount: -c.amount})
+			if qr.Status.Code != int32(c.code) || qr.Amount != c.releaseResult {
+				t.Errorf("Got %v, Want code %v and amount %d", qr, c.code, c.releaseResult)
+			}
+
+			if err = h.Close(); err != nil {
+				t.Errorf("Unable to close handler: %v", err)
+			}
+		})
+	}
+}
+
+func TestLazyConnect(t *testing.T) {
+	s := newServer(t)
+	addr := s.Addr()
+	s.Close()
+
+	cfg := &config.Params{
+		RedisServerUrl:           addr,
+		ConnectionPoolSize:       1,
+		MinDeduplicationDuration: time.Second,
+		Quotas:                   []config.Params_Quota{{Name: "Q1", MaxAmount: 10}},
+	}
+
+	// the handler is built while the server can't be reached.
+	h := newHandler(t, cfg)
+	c := h.client.(*client)
+	now := c.lastAttempt
+	c.getTime = func() time.Time { return now }
+
+	instance := quota.Instance{Name: "Q1"}
+	qr, _ := h.HandleQuota(context.Background(), &instance, adapter.QuotaArgs{QuotaAmount: 5})
+	if qr.Status.Code != int32(rpc.UNAVAILABLE) || !strings.Contains(qr.Status.Message, addr) {
+		t.Errorf("Got %v, Want an error connecting to %s", qr.Status, addr)
+	}
+
+	s = miniredis.NewMiniRedis()
+	if err := s.StartAddr(addr); err != nil {
+		t.Fatalf("Unable to restart the redis server: %v", err)
+	}
+	defer s.Close()
+
+	// the client waits before connecting again.
+	if qr, _ = h.HandleQuota(context.Background(), &instance, adapter.QuotaArgs{QuotaAmount: 5}); qr.Status.Code != int32(rpc.UNAVAILABLE) {
+		t.Errorf("Got %v, Want the previous error", qr.Status)
+	}
+
+	now = now.Add(reconnectInterval)
+	if qr, _ = h.HandleQuota(context.Background(), &instance, adapter.QuotaArgs{QuotaAmount: 5}); qr.Status.Code != int32(rpc.OK) || qr.Amount != 5 {
+		t.Errorf("Got %v, Want 5 allocated once connected", qr)
+	}
+
+	if err := h.Close(); err != nil {
+		t.Errorf("Unable to close handler: %v", err)
+	}
+}
+
+func TestBadConfig(t *testing.T) {
+	for _, c := range []struct {
+		desc   string
+		modify func(*config.Params)
+		field  string
+	}{
+		{"no server", func(p *config.Params) { p.RedisServerUrl = "" }, "redisServerUrl"},
+		{"pool size", func(p *config.Params) { p.ConnectionPoolSize = 0 }, "connectionPoolSize"},
+		{"dedup", func(p *config.Params) { p.MinDeduplicationDuration = 0 }, "minDeduplicationDuration"},
+		{"max amount", func(p *config.Params) { p.Quotas[0].MaxAmount = -1 }, "quotas[0].maxAmount"},
+		{"valid duration", func(p *config.Params) { p.Quotas[0].ValidDuration = -time.Second }, "quotas[0].validDuration"},
+		{"bucket duration", func(p *config.Params) { p.Quotas[0].BucketDuration = 0 }, "quotas[0].bucketDuration"},
+		{"window", func(p *config.Params) { p.Quotas[0].BucketDuration = 400 * time.Millisecond }, "quotas[0].validDuration"},
+		{"override bucket duration", func(p *config.Params) {
+			p.Quotas[0].ValidDuration = 0
+			p.Quotas[0].BucketDuration = 0
+		}, "quotas[0].bucketDuration"},
+		{"override window", func(p *config.Params) { p.Quotas[0].Overrides[0].ValidDuration = 1550 * time.Millisecond },
+			"quotas[0].overrides[0].validDuration"},
+		{"override max amount", func(p *config.Params) { p.Quotas[0].Overrides[0].MaxAmount = -1 }, "quotas[0].overrides[0].maxAmount"},
+	} {
+		t.Run(c.desc, func(t *testing.T) {
+			cfg := &config.Params{
+				RedisServerUrl:           "localhost:6379",
+				ConnectionPoolSize:       1,
+				MinDeduplicationDuration: time.Second,
+				Quotas: []config.Params_Quota{
+					{
+						Name:               "Q1",
+						MaxAmount:          10,
+						ValidDuration:      time.Second,
+						BucketDuration:     100 * time.Millisecond,
+						RateLimitAlgorithm: config.ROLLING_WINDOW,
+						Overrides:          []config.Params_Override{{MaxAmount: 5, ValidDuration: 2 * time.Second}},
+					},
+				},
+			}
+			b := GetInfo().NewBuilder()
+			b.SetAdapterConfig(cfg)
+			if err := b.Validate(); err != nil {
+				t.Fatalf("Got error %v, expecting success", err)
+			}
+
+			c.modify(cfg)
+			ce := b.Validate()
+			if ce == nil {
+				t.Fatal("Expecting failure, got success")
+			}
+			if len(ce.Multi.Errors) != 1 || ce.Multi.Errors[0].(adapter.ConfigError).Field != c.field {
+				t.Errorf("Got %v, Want an error for %s", ce, c.field)
+			}
+		})
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	info := GetInfo()
+	cfg := info.DefaultConfig.(*config.Params)
+	cfg.RedisServerUrl = "localhost:6379"
+	b := info.NewBuilder().(*builder)
+	b.SetAdapterConfig(cfg)
+
+	b.SetQuotaTypes(map[string]*quota.Type{"Foo": {}})
+	if _, err := b.Build(context.Background(), test.NewEnv(t)); err == nil || !strings.Contains(err.Error(), "Foo") {
+		t.Errorf("Got %v, Want an error for the missing limit", err)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package redisquota
+
+// The Lua scripts implementing the quota algorithms. Redis runs a script atomically,
+// so concurrent requests from several Mixer instances can't grant more than the limit.
+//
+// Each script takes the key of the quota state in KEYS[1], and the key of the
+// deduplication entry in KEYS[2] when there is a deduplication ID. The arguments are:
+//
+//   ARGV[1]: the maximum amount of the limit
+//   ARGV[2]: the requested amount, negative to release quota
+//   ARGV[3]: "1" to grant as much as possible, instead of nothing, when the amount isn't available
+//   ARGV[4]: the length of the window in milliseconds, 0 for non-expiring quotas
+//   ARGV[5]: algorithm specific, see the scripts
+//   ARGV[6]: how long the deduplication entry is kept, in milliseconds
+//
+// The scripts return the granted (or released) amount and the number of milliseconds
+// it remains valid. A deduplicated request returns the result of the original request.
+//
+// The windows are tracked with the clock of the Redis server, so the Mixer instances
+// sharing the server agree on them even when their clocks differ.
+
+// dedupPrologue reads the current time, returns the result of the original request of a
+// duplicate request, and parses the arguments.
+const dedupPrologue = `
+-- reading the time makes the script non-deterministic, so its effects are replicated
+-- instead of the script itself.
+redis.replicate_commands()
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+if #KEYS > 1 then
+	local dedup = redis.call("GET", KEYS[2])
+	if dedup then
+		local sep = string.find(dedup, ":", 1, true)
+		local amount = tonumber(string.sub(dedup, 1, sep - 1))
+		local validity = tonumber(string.sub(dedup, sep + 1)) - now
+		if validity < 0 then
+			validity = 0
+		end
+		return {amount, validity}
+	end
+end
+
+local max = tonumber(ARGV[1])
+local amount = tonumber(ARGV[2])
+local bestEffort = ARGV[3] == "1"
+local window = tonumber(ARGV[4])
+
+local function result(granted, validity)
+	if #KEYS > 1 then
+		redis.call("SET", KEYS[2], granted .. ":" .. (now + validity), "PX", ARGV[6])
+	end
+	return {granted, validity}
+end
+
+local function grant(used)
+	if used + amount <= max then
+		return amount
+	end
+	if bestEffort and used < max then
+		return max - used
+	end
+	return 0
+end
+`
+
+// cellScript keeps the counter of a non-expiring quota.
+const cellScript = dedupPrologue + `
+local used = tonumber(redis.call("GET", KEYS[1]) or "0")
+
+if amount < 0 then
+	local released = math.min(-amount, used)
+	if released == used then
+		redis.call("DEL", KEYS[1])
+	elseif released > 0 then
+		redis.call("DECRBY", KEYS[1], released)
+	end
+	return result(released, 0)
+end
+
+local granted = grant(used)
+if granted == 0 then
+	return result(0, 0)
+end
+
+redis.call("INCRBY", KEYS[1], granted)
+return result(granted, 0)
+`
+
+// fixedWindowScript keeps a hash of the index of the current window, counted from the
+// epoch, and of the amount allocated in it. The amount allocated in a previous window
+// is discarded, and the hash expires at the end of the window. ARGV[5] isn't used.
+const fixedWindowScript = dedupPrologue + `
+local current = math.floor(now / window)
+local remaining = window - now % window
+local state = redis.call("HMGET", KEYS[1], "window", "used")
+local used = 0
+if tonumber(state[1]) == current then
+	used = tonumber(state[2])
+end
+
+if amount < 0 then
+	local released = math.min(-amount, used)
+	if released == used then
+		redis.call("DEL", KEYS[1])
+	elseif released > 0 then
+		redis.call("HINCRBY", KEYS[1], "used", -released)
+	end
+	return result(released, 0)
+end
+
+local granted = grant(used)
+if granted == 0 then
+	return result(0, 0)
+end
+
+if used > 0 then
+	redis.call("HINCRBY", KEYS[1], "used", granted)
+else
+	redis.call("HMSET", KEYS[1], "window", string.format("%d", current), "used", granted)
+end
+redis.call("PEXPIRE", KEYS[1], remaining)
+return result(granted, remaining)
+`
+
+// rollingWindowScript keeps a hash of the amount allocated in each bucket of the window,
+// keyed by the start time of the bucket. The caller passes the length of the buckets in
+// milliseconds in ARGV[5]. The buckets which left the window are deleted, and the hash
+// expires when no allocation is made for a whole window.
+const rollingWindowScript = dedupPrologue + `
+local bucket = tonumber(ARGV[5])
+local current = now - now % bucket
+local field = string.format("%d", current)
+local buckets = redis.call("HGETALL", KEYS[1])
+local used = 0
+local live = {}
+for i = 1, #buckets, 2 do
+	local start = tonumber(buckets[i])
+	local allocated = tonumber(buckets[i + 1])
+	if current - start >= window then
+		redis.call("HDEL", KEYS[1], buckets[i])
+	else
+		used = used + allocated
+		table.insert(live, {field = buckets[i], start = start, allocated = allocated})
+	end
+end
+
+if amount < 0 then
+	-- release from the leading edge of the window moving back in time.
+	table.sort(live, function(a, b) return a.start > b.start end)
+	local remaining = -amount
+	for _, b in ipairs(live) do
+		if remaining == 0 then
+			break
+		end
+		if b.allocated <= remaining then
+			redis.call("HDEL", KEYS[1], b.field)
+			remaining = remaining - b.allocated
+		else
+			redis.call("HINCRBY", KEYS[1], b.field, -remaining)
+			remaining = 0
+		end
+	end
+	return result(-amount - remaining, 0)
+end
+
+local granted = grant(used)
+if granted == 0 then
+	return result(0, 0)
+end
+
+redis.call("HINCRBY", KEYS[1], field, granted)
+redis.call("PEXPIRE", KEYS[1], ARGV[4])
+return result(granted, window)
+`
@@ -23,6 +23,7 @@ import (
 	"istio.io/mixer/pkg/adapter/test"
 	"istio.io/mixer/template/logentry"
 	"istio.io/mixer/template/metric"
+	"istio.io/mixer/template/tracespan"
 )
 
 type (
@@ -52,6 +53,10 @@ func (f *fakeBuilder) SetMetricTypes(metrics map[string]*metric.Type) {
 func (f *fakeBuilder) SetLogEntryTypes(entries map[string]*logentry.Type) {
 	f.calledConfigure = true
 }
+
+func (f *fakeBuilder) SetTraceSpanTypes(spans map[string]*tracespan.Type) {
+	f.calledConfigure = true
+}
 func (f *fakeBuilder) Validate() *adapter.ConfigErrors {
 	f.calledValidate = true
 	return nil
@@ -76,10 +81,16 @@ func (f *fakeAspect) HandleLogEntry(context.Context, []*logentry.Instance) error
 	return nil
 }
 
+func (f *fakeAspect) HandleTraceSpan(context.Context, []*tracespan.Instance) error {
+	f.calledHandle = true
+	return nil
+}
+
 func TestDispatchConfigureAndBuild(t *testing.T) {
 	m := &fakeBuilder{}
 	l := &fakeBuilder{}
-	b := &builder{m, l}
+	tr := &fakeBuilder{}
+	b := &builder{m, l, tr}
 	b.SetMetricTypes(make(map[string]*metric.Type))
 
 	if !m.calledConfigure {
@@ -89,6 +100,10 @@ func TestDispatchConfigureAndBuild(t *testing.T) {
 	if !l.calledConfigure {
 		t.Error("Expected l.SetLogEntryTypes to be called, wasn't.")
 	}
+	b.SetTraceSpanTypes(make(map[string]*tracespan.Type))
+	if !tr.calledConfigure {
+		t.Error("Expected tr.SetTraceSpanTypes to be called, wasn't.")
+	}
 
 	b.SetAdapterConfig(&config.Params{})
 	if !l.calledAdptCfg {
@@ -97,6 +112,9 @@ func TestDispatchConfigureAndBuild(t *testing.T) {
 	if !m.calledAdptCfg {
 		t.Error("Expected m.calledAdptCfg to be called, wasn't.")
 	}
+	if !tr.calledAdptCfg {
+		t.Error("Expected tr.calledAdptCfg to be called, wasn't.")
+	}
 
 	_ = b.Validate()
 	if !l.calledValidate {
@@ -105,8 +123,11 @@ func TestDispatchConfigureAndBuild(t *testing.T) {
 	if !m.calledValidate {
 		t.Error("Expected m.calledValidate to be called, wasn't.")
 	}
+	if !tr.calledValidate {
+		t.Error("Expected tr.calledValidate to be called, wasn't.")
+	}
 
-	if l.calledBuild || m.calledBuild {
+	if l.calledBuild || m.calledBuild || tr.calledBuild {
 		t.Fatalf("Build called on builders before calling b.Build")
 	}
 	if _, err := b.Build(context.Background(), test.NewEnv(t)); err != nil {
@@ -118,6 +139,9 @@ func TestDispatchConfigureAndBuild(t *testing.T) {
 	if !l.calledBuild {
 		t.Errorf("b.Build but l.Build not called")
 	}
+	if !tr.calledBuild {
+		t.Errorf("b.Build but tr.Build not called")
+	}
 }
 
 func TestDispatchHandleAndClose(t *testing.T) {
@@ -125,7 +149,9 @@ func TestDispatchHandleAndClose(t *testing.T) {
 	lb := &fakeBuilder{instance: la}
 	ma := &fakeAspect{}
 	mb := &fakeBuilder{instance: ma}
-	b := &builder{mb, lb}
+	ta := &fakeAspect{}
+	tb := &fakeBuilder{instance: ta}
+	b := &builder{mb, lb, tb}
 
 	superHandler, err := b.Build(context.Background(), test.NewEnv(t))
 	if err != nil {
@@ -148,6 +174,14 @@ func TestDispatchHandleAndClose(t *testing.T) {
 		t.Error("Called handler.HandleLogEntry, but call not forwarded to log aspect")
 	}
 
+	ts, _ := superHandler.(tracespan.Handler)
+	if err := ts.HandleTraceSpan(context.Background(), []*tracespan.Instance{}); err != nil {
+		t.Errorf("HandleTraceSpan returned unexpected err: %v", err)
+	}
+	if !ta.calledHandle {
+		t.Error("Called handler.HandleTraceSpan, but call not forwarded to trace aspect")
+	}
+
 	if err := ms.Close(); err != nil {
 		t.Errorf("Unexpected error when calling close: %v", err)
 	}
@@ -157,5 +191,8 @@ func TestDispatchHandleAndClose(t *testing.T) {
 	if !la.calledClose {
 		t.Error("Called handler.Close, but call not forwarded to log aspect")
 	}
+	if !ta.calledClose {
+		t.Error("Called handler.Close, but call not forwarded to trace aspect")
+	}
 
 }
@@ -0,0 +1,360 @@
+// Copyright 2017 the Istio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	traceapi "cloud.google.com/go/trace/apiv1"
+	"github.com/golang/protobuf/ptypes"
+	gax "github.com/googleapis/gax-go"
+	multierror "github.com/hashicorp/go-multierror"
+	xcontext "golang.org/x/net/context"
+	cloudtracepb "google.golang.org/genproto/googleapis/devtools/cloudtrace/v1"
+
+	"istio.io/mixer/adapter/stackdriver/config"
+	"istio.io/mixer/adapter/stackdriver/helper"
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/template/tracespan"
+)
+
+type (
+	// traceClient abstracts over the Stackdriver Trace client to enable network-less testing.
+	traceClient interface {
+		io.Closer
+
+		PatchTraces(ctx xcontext.Context, req *cloudtracepb.PatchTracesRequest, opts ...gax.CallOption) error
+	}
+
+	// createClientFunc abstracts over the creation of the stackdriver client to enable network-less testing.
+	createClientFunc func(*config.Params) (traceClient, error)
+
+	builder struct {
+		createClient createClientFunc
+		types        map[string]*tracespan.Type
+		cfg          *config.Params
+	}
+
+	// noopHandler is built when the adapter is not configured to handle tracespan instances.
+	noopHandler struct{}
+
+	handler struct {
+		l   adapter.Logger
+		now func() time.Time // used to control time in tests
+
+		projectID string
+		client    traceClient
+		maxSpans  int
+		ticker    *time.Ticker
+		wake      chan struct{} // signaled when maxSpans spans are buffered
+		done      chan struct{}
+		closeOnce sync.Once
+
+		// Guards traces, retry and spans
+		m      sync.Mutex
+		traces map[string]*cloudtracepb.Trace // trace ID -> spans of the trace reported since the last push
+		retry  []*cloudtracepb.Trace          // the traces of the last push, which failed
+		spans  int                            // the number of spans in traces and retry
+	}
+)
+
+const (
+	// Stackdriver trace IDs are 32 hex characters; shorter (64 bit) IDs are zero padded.
+	traceIDLength = 32
+
+	defaultPushInterval     = 1 * time.Minute
+	defaultMaxBufferedSpans = 10000
+)
+
+var (
+	_ tracespan.HandlerBuilder = &builder{}
+	_ tracespan.Handler        = &handler{}
+	_ tracespan.Handler        = noopHandler{}
+)
+
+// NewBuilder returns a builder implementing the tracespan.HandlerBuilder interface.
+func NewBuilder() tracespan.HandlerBuilder {
+	return &builder{createClient: createClient}
+}
+
+func createClient(cfg *config.Params) (traceClient, error) {
+	client, err := traceapi.NewClient(context.Background(), helper.ToOpts(cfg)...)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
+
+func (b *builder) SetTraceSpanTypes(types map[string]*tracespan.Type) {
+	b.types = types
+}
+
+func (b *builder) SetAdapterConfig(cfg adapter.Config) {
+	b.cfg = cfg.(*config.Params)
+}
+
+func (b *builder) Validate() (ce *adapter.ConfigErrors) {
+	// Traces are only pushed when the adapter is configured to handle tracespan instances.
+	if len(b.types) == 0 {
+		return nil
+	}
+	if b.cfg.ProjectId == "" {
+		ce = ce.Appendf("projectId", "project ID must be specified to push traces")
+	}
+	if b.cfg.PushInterval < 0 {
+		ce = ce.Appendf("pushInterval", "push interval must be >= 0")
+	}
+	if b.cfg.MaxBufferedSpans < 0 {
+		ce = ce.Appendf("maxBufferedSpans", "max buffered spans must be >= 0")
+	}
+	return
+}
+
+func (b *builder) Build(ctx context.Context, env adapter.Env) (adapter.Handler, error) {
+	// Without tracespan instances there is nothing to push, so no client is created.
+	if len(b.types) == 0 {
+		return noopHandler{}, nil
+	}
+
+	cfg := b.cfg
+	client, err := b.createClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stackdriver trace client: %v", err)
+	}
+
+	// Per the documentation on config.proto, if push_interval is zero we'll default to a 1 minute push interval
+	interval := cfg.PushInterval
+	if interval == 0 {
+		interval = defaultPushInterval
+	}
+
+	maxSpans := int(cfg.MaxBufferedSpans)
+	if maxSpans == 0 {
+		maxSpans = defaultMaxBufferedSpans
+	}
+
+	h := &handler{
+		l:         env.Logger(),
+		now:       time.Now,
+		projectID: cfg.ProjectId,
+		client:    client,
+		maxSpans:  maxSpans,
+		ticker:    time.NewTicker(interval),
+		wake:      make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		traces:    make(map[string]*cloudtracepb.Trace),
+	}
+	env.ScheduleDaemon(h.pushLoop)
+	return h, nil
+}
+
+func (noopHandler) HandleTraceSpan(context.Context, []*tracespan.Instance) error { return nil }
+func (noopHandler) Close() error                                                 { return nil }
+
+// HandleTraceSpan buffers the spans, grouped by trace, until the next push. The spans are pushed early once
+// maxSpans are buffered, and dropped until the push completes.
+func (h *handler) HandleTraceSpan(_ context.Context, values []*tracespan.Instance) error {
+	var result *multierror.Error
+	dropped := 0
+	for _, v := range values {
+		span, traceID, err := h.toSpan(v)
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("skipping span '%s' of trace '%s': %v", v.SpanName, v.TraceId, err))
+			continue
+		}
+
+		h.m.Lock()
+		if h.spans >= h.maxSpans {
+			h.m.Unlock()
+			dropped++
+			continue
+		}
+		t, found := h.traces[traceID]
+		if !found {
+			t = &cloudtracepb.Trace{ProjectId: h.projectID, TraceId: traceID}
+			h.traces[traceID] = t
+		}
+		t.Spans = append(t.Spans, span)
+		h.spans++
+		full := h.spans >= h.maxSpans
+		h.m.Unlock()
+
+		if full {
+			select {
+			case h.wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+	if dropped > 0 {
+		result = multierror.Append(result, fmt.Errorf("span buffer is full, dropped %d spans", dropped))
+	}
+	return result.ErrorOrNil()
+}
+
+func (h *handler) toSpan(v *tracespan.Instance) (*cloudtracepb.TraceSpan, string, error) {
+	traceID, err := toTraceID(v.TraceId)
+	if err != nil {
+		return nil, "", err
+	}
+	spanID, err := toSpanID(v.SpanId)
+	if err != nil {
+		return nil, "", err
+	}
+	var parentID uint64
+	if v.ParentSpanId != "" {
+		if parentID, err = toSpanID(v.ParentSpanId); err != nil {
+			return nil, "", err
+		}
+	}
+
+	end := v.EndTime
+	if end.IsZero() {
+		end = h.now()
+	}
+	start := v.StartTime
+	if start.IsZero() {
+		start = end
+	}
+	startpb, err := ptypes.TimestampProto(start)
+	if err != nil {
+		return nil, "", err
+	}
+	endpb, err := ptypes.TimestampProto(end)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &cloudtracepb.TraceSpan{
+		SpanId:       spanID,
+		ParentSpanId: parentID,
+		Name:         v.SpanName,
+		StartTime:    startpb,
+		EndTime:      endpb,
+		Labels:       helper.ToStringMap(v.SpanTags),
+	}, traceID, nil
+}
+
+func (h *handler) pushLoop() {
+	for {
+		select {
+		case <-h.ticker.C:
+			h.push()
+		case <-h.wake:
+			h.push()
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// push sends the buffered traces to Stackdriver, along with the traces of the last push if it failed.
+// When the push fails, the traces reported since the last push are retried at the next push, as long
+// as they fit in the buffer, and the traces already retried are dropped.
+func (h *handler) push() {
+	h.m.Lock()
+	if len(h.traces) == 0 && len(h.retry) == 0 {
+		h.m.Unlock()
+		h.l.Infof("No traces to send to Stackdriver.")
+		return
+	}
+	buffered, retry := h.traces, h.retry
+	h.traces = make(map[string]*cloudtracepb.Trace, len(buffered))
+	h.retry = nil
+	h.spans = 0
+	h.m.Unlock()
+
+	ids := make([]string, 0, len(buffered))
+	for id := range buffered {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	traces := make([]*cloudtracepb.Trace, 0, len(ids))
+	for _, id := range ids {
+		traces = append(traces, buffered[id])
+	}
+
+	all := append(retry, traces...)
+	err := h.client.PatchTraces(context.Background(), &cloudtracepb.PatchTracesRequest{
+		ProjectId: h.projectID,
+		Traces:    &cloudtracepb.Traces{Traces: all},
+	})
+
+	// This is executed in a daemon, so we can't get out info about errors other than logging.
+	if err != nil {
+		h.m.Lock()
+		kept := 0
+		for _, t := range traces {
+			if h.spans+len(t.Spans) > h.maxSpans {
+				break
+			}
+			h.retry = append(h.retry, t)
+			h.spans += len(t.Spans)
+			kept++
+		}
+		h.m.Unlock()
+		_ = h.l.Errorf("Stackdriver returned: %v\nGiven traces: %v\nRetrying %d traces at the next push, dropping %d.",
+			err, all, kept, len(all)-kept)
+	} else {
+		h.l.Infof("Successfully sent %d traces to Stackdriver.", len(all))
+	}
+}
+
+func (h *handler) Close() error {
+	var err error
+	h.closeOnce.Do(func() {
+		h.ticker.Stop()
+		close(h.done)
+		h.l.Infof("Sending last traces before shutting down")
+		h.push()
+		err = h.client.Close()
+	})
+	return err
+}
+
+// toTraceID converts a hex trace ID of up to 128 bits to the 32 hex character form Stackdriver requires.
+func toTraceID(id string) (string, error) {
+	if id == "" {
+		return "", fmt.Errorf("missing trace ID")
+	}
+	if len(id) > traceIDLength {
+		return "", fmt.Errorf("trace ID '%s' is longer than %d characters", id, traceIDLength)
+	}
+	for _, c := range id {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return "", fmt.Errorf("trace ID '%s' is not a hex string", id)
+		}
+	}
+	return strings.Repeat("0", traceIDLength-len(id)) + strings.ToLower(id), nil
+}
+
+// toSpanID converts a hex span ID to the non-zero 64 bit integer Stackdriver requires.
+func toSpanID(id string) (uint64, error) {
+	v, err := strconv.ParseUint(id, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("span ID '%s' is not a 64 bit hex number", id)
+	}
+	if v == 0 {
+		return 0, fmt.Errorf("span ID must not be zero")
+	}
+	return v, nil
+}
@@ -0,0 +1,344 @@
+// Copyright 2017 the Istio Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package trace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	gax "github.com/googleapis/gax-go"
+	xcontext "golang.org/x/net/context"
+	cloudtracepb "google.golang.org/genproto/googleapis/devtools/cloudtrace/v1"
+
+	"istio.io/mixer/adapter/stackdriver/config"
+	"istio.io/mixer/pkg/adapter/test"
+	"istio.io/mixer/template/tracespan"
+)
+
+type fakeClient struct {
+	m      sync.Mutex
+	err    error
+	reqs   []*cloudtracepb.PatchTracesRequest
+	closed bool
+}
+
+func (f *fakeClient) PatchTraces(_ xcontext.Context, req *cloudtracepb.PatchTracesRequest, _ ...gax.CallOption) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.reqs = append(f.reqs, req)
+	return f.err
+}
+
+func (f *fakeClient) Close() error {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.closed = true
+	return nil
+}
+
+var clientFunc = func(c traceClient, err error) createClientFunc {
+	return func(cfg *config.Params) (traceClient, error) {
+		return c, err
+	}
+}
+
+func newHandler(t *testing.T, client *fakeClient) (*handler, *test.Env) {
+	env := test.NewEnv(t)
+	b := &builder{createClient: clientFunc(client, nil)}
+	b.SetTraceSpanTypes(map[string]*tracespan.Type{"span": {}})
+	b.SetAdapterConfig(&config.Params{ProjectId: "pid", PushInterval: time.Hour})
+	if err := b.Validate(); err != nil {
+		t.Fatalf("Validate() = %v, wanted no err", err)
+	}
+	h, err := b.Build(context.Background(), env)
+	if err != nil {
+		t.Fatalf("Build() = %v, wanted no err", err)
+	}
+	return h.(*handler), env
+}
+
+func TestBuild_Errs(t *testing.T) {
+	err := fmt.Errorf("expected")
+	b := &builder{createClient: clientFunc(nil, err)}
+	b.SetTraceSpanTypes(map[string]*tracespan.Type{"span": {}})
+	b.SetAdapterConfig(&config.Params{})
+	if _, e := b.Build(context.Background(), test.NewEnv(t)); e == nil || !strings.Contains(e.Error(), err.Error()) {
+		t.Fatalf("Expected error from createClient to be propagated, got %v", e)
+	}
+}
+
+func TestBuild_NoTypes(t *testing.T) {
+	// no client is created when there are no spans to push.
+	b := &builder{createClient: clientFunc(nil, fmt.Errorf("unexpected"))}
+	b.SetAdapterConfig(&config.Params{})
+	h, err := b.Build(context.Background(), test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Build() = %v, wanted no err", err)
+	}
+	if err := h.(tracespan.Handler).HandleTraceSpan(context.Background(), []*tracespan.Instance{{}}); err != nil {
+		t.Errorf("HandleTraceSpan() = %v, wanted no err", err)
+	}
+	if err := h.Close(); err != nil {
+		t.Errorf("Close() = %v, wanted no err", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		types map[string]*tracespan.Type
+		cfg   *config.Params
+		field string
+	}{
+		{"no spans", nil, &config.Params{}, ""},
+		{"ok", map[string]*tracespan.Type{"span": {}}, &config.Params{ProjectId: "pid"}, ""},
+		{"no project", map[string]*tracespan.Type{"span": {}}, &config.Params{}, "projectId"},
+		{"negative interval", map[string]*tracespan.Type{"span": {}}, &config.Params{ProjectId: "pid", PushInterval: -time.Second}, "pushInterval"},
+		{"negative max spans", map[string]*tracespan.Type{"span": {}}, &config.Params{ProjectId: "pid", MaxBufferedSpans: -1}, "maxBufferedSpans"},
+	}
+
+	for idx, tt := range tests {
+		t.Run(fmt.Sprintf("[%d] %s", idx, tt.name), func(t *testing.T) {
+			b := &builder{}
+			b.SetTraceSpanTypes(tt.types)
+			b.SetAdapterConfig(tt.cfg)
+			err := b.Validate()
+			if tt.field == "" {
+				if err != nil {
+					t.Errorf("Validate() = %v, wanted no err", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.field) {
+				t.Errorf("Validate() = %v, wanted an error for %s", err, tt.field)
+			}
+		})
+	}
+}
+
+func TestHandleTraceSpan(t *testing.T) {
+	client := &fakeClient{}
+	h, _ := newHandler(t, client)
+
+	start := time.Date(2017, 9, 1, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Second)
+	spans := []*tracespan.Instance{
+		{
+			Name:      "span",
+			TraceId:   "463ac35c9f6413ad",
+			SpanId:    "a2fb4a1d1a96d312",
+			SpanName:  "/index",
+			StartTime: start,
+			EndTime:   end,
+			SpanTags:  map[string]interface{}{"http.status_code": int64(200)},
+		},
+		{
+			Name:         "span",
+			TraceId:      "463AC35C9F6413AD",
+			SpanId:       "2",
+			ParentSpanId: "a2fb4a1d1a96d312",
+			SpanName:     "/db",
+			StartTime:    start,
+			EndTime:      end,
+		},
+		{
+			Name:     "span",
+			TraceId:  "0af7651916cd43dd8448eb211c80319c",
+			SpanId:   "1",
+			SpanName: "/other",
+		},
+	}
+	if err := h.HandleTraceSpan(context.Background(), spans); err != nil {
+		t.Fatalf("HandleTraceSpan() = %v, wanted no err", err)
+	}
+
+	if err := h.Close(); err != nil {
+		t.Fatalf("Close() = %v, wanted no err", err)
+	}
+
+	client.m.Lock()
+	defer client.m.Unlock()
+	if !client.closed {
+		t.Error("Close() did not close the client")
+	}
+	if len(client.reqs) != 1 {
+		t.Fatalf("Got %d requests, wanted 1", len(client.reqs))
+	}
+	req := client.reqs[0]
+	if req.ProjectId != "pid" {
+		t.Errorf("Got project %s, wanted pid", req.ProjectId)
+	}
+
+	traces := req.Traces.Traces
+	if len(traces) != 2 {
+		t.Fatalf("Got %d traces, wanted the spans batched in 2 traces: %v", len(traces), traces)
+	}
+	if traces[1].TraceId != "0af7651916cd43dd8448eb211c80319c" || len(traces[1].Spans) != 1 {
+		t.Errorf("Got trace %v, wanted a single span in trace 0af7651916cd43dd8448eb211c80319c", traces[1])
+	}
+	tr := traces[0]
+	if tr.TraceId != "0000000000000000463ac35c9f6413ad" || tr.ProjectId != "pid" {
+		t.Errorf("Got trace %s in project %s, wanted 0000000000000000463ac35c9f6413ad in pid", tr.TraceId, tr.ProjectId)
+	}
+	if len(tr.Spans) != 2 {
+		t.Fatalf("Got %d spans, wanted 2", len(tr.Spans))
+	}
+
+	root, child := tr.Spans[0], tr.Spans[1]
+	if root.SpanId != 0xa2fb4a1d1a96d312 || root.ParentSpanId != 0 || root.Name != "/index" {
+		t.Errorf("Got root span %v, wanted span a2fb4a1d1a96d312 named /index", root)
+	}
+	if root.Labels["http.status_code"] != "200" {
+		t.Errorf("Got labels %v, wanted http.status_code 200", root.Labels)
+	}
+	if s, _ := ptypes.Timestamp(root.StartTime); !s.Equal(start) {
+		t.Errorf("Got start time %v, wanted %v", s, start)
+	}
+	if e, _ := ptypes.Timestamp(root.EndTime); !e.Equal(end) {
+		t.Errorf("Got end time %v, wanted %v", e, end)
+	}
+	if child.SpanId != 2 || child.ParentSpanId != root.SpanId {
+		t.Errorf("Got child span %d with parent %d, wanted 2 with parent %d", child.SpanId, child.ParentSpanId, root.SpanId)
+	}
+}
+
+func TestHandleTraceSpan_Errs(t *testing.T) {
+	tests := []struct {
+		name string
+		span *tracespan.Instance
+		err  string
+	}{
+		{"no trace", &tracespan.Instance{SpanId: "1"}, "missing trace ID"},
+		{"long trace", &tracespan.Instance{TraceId: strings.Repeat("a", 33), SpanId: "1"}, "longer than"},
+		{"bad trace", &tracespan.Instance{TraceId: "xyz", SpanId: "1"}, "not a hex string"},
+		{"bad span", &tracespan.Instance{TraceId: "1", SpanId: "xyz"}, "not a 64 bit hex number"},
+		{"zero span", &tracespan.Instance{TraceId: "1", SpanId: "0"}, "must not be zero"},
+		{"bad parent", &tracespan.Instance{TraceId: "1", SpanId: "1", ParentSpanId: "xyz"}, "not a 64 bit hex number"},
+	}
+
+	for idx, tt := range tests {
+		t.Run(fmt.Sprintf("[%d] %s", idx, tt.name), func(t *testing.T) {
+			client := &fakeClient{}
+			h, _ := newHandler(t, client)
+			defer func() { _ = h.Close() }()
+
+			err := h.HandleTraceSpan(context.Background(), []*tracespan.Instance{tt.span})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("HandleTraceSpan() = %v, wanted error containing '%s'", err, tt.err)
+			}
+			if len(h.traces) != 0 {
+				t.Errorf("Got buffered traces %v, wanted the span to be skipped", h.traces)
+			}
+		})
+	}
+}
+
+func TestPush(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		out  string
+	}{
+		{"error", errors.New("expected"), "Stackdriver returned: expected"},
+		{"happy", nil, "Successfully sent 1 traces to Stackdriver."},
+	}
+
+	for idx, tt := range tests {
+		t.Run(fmt.Sprintf("[%d] %s", idx, tt.name), func(t *testing.T) {
+			client := &fakeClient{err: tt.err}
+			h, env := newHandler(t, client)
+			defer func() { _ = h.Close() }()
+
+			h.push()
+			if len(client.reqs) != 0 {
+				t.Errorf("Got %d requests, wanted none with nothing buffered", len(client.reqs))
+			}
+
+			if err := h.HandleTraceSpan(context.Background(), []*tracespan.Instance{{TraceId: "1", SpanId: "1"}}); err != nil {
+				t.Fatalf("HandleTraceSpan() = %v, wanted no err", err)
+			}
+			h.push()
+			found := false
+			for _, l := range env.GetLogs() {
+				found = found || strings.Contains(l, tt.out)
+			}
+			if !found {
+				t.Errorf("push() didn't log '%s'; got logs: %v", tt.out, env.GetLogs())
+			}
+		})
+	}
+}
+
+func TestHandleTraceSpan_Full(t *testing.T) {
+	client := &fakeClient{}
+	h, _ := newHandler(t, client)
+	defer func() { _ = h.Close() }()
+	h.maxSpans = 2
+
+	spans := []*tracespan.Instance{{TraceId: "1", SpanId: "1"}, {TraceId: "1", SpanId: "2"}, {TraceId: "1", SpanId: "3"}}
+	err := h.HandleTraceSpan(context.Background(), spans)
+	if err == nil || !strings.Contains(err.Error(), "dropped 1 spans") {
+		t.Errorf("HandleTraceSpan() = %v, wanted the last span to be dropped", err)
+	}
+
+	// the buffered spans are pushed without waiting for the push interval.
+	for i := 0; ; i++ {
+		client.m.Lock()
+		n := len(client.reqs)
+		client.m.Unlock()
+		if n == 1 {
+			break
+		}
+		if i == 1000 {
+			t.Fatal("Got no request, wanted the spans to be pushed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := len(client.reqs[0].Traces.Traces[0].Spans); got != 2 {
+		t.Errorf("Got %d spans, wanted 2", got)
+	}
+}
+
+func TestPush_Retry(t *testing.T) {
+	client := &fakeClient{err: errors.New("expected")}
+	h, _ := newHandler(t, client)
+	defer func() { _ = h.Close() }()
+
+	for _, id := range []string{"1", "2"} {
+		if err := h.HandleTraceSpan(context.Background(), []*tracespan.Instance{{TraceId: id, SpanId: "1"}}); err != nil {
+			t.Fatalf("HandleTraceSpan() = %v, wanted no err", err)
+		}
+		h.push()
+	}
+
+	// the first trace was retried once, the second one is retried at the next push.
+	client.err = nil
+	h.push()
+	if len(client.reqs) != 3 {
+		t.Fatalf("Got %d requests, wanted 3", len(client.reqs))
+	}
+	if got := client.reqs[1].Traces.Traces; len(got) != 2 {
+		t.Errorf("Got traces %v, wanted the failed trace to be retried", got)
+	}
+	if got := client.reqs[2].Traces.Traces; len(got) != 1 || got[0].TraceId != strings.Repeat("0", 31)+"2" {
+		t.Errorf("Got traces %v, wanted the second trace only", got)
+	}
+}
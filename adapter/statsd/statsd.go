@@ -18,6 +18,9 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
 	"text/template"
 	"time"
 
@@ -44,6 +47,7 @@ type (
 		rate      float32
 		client    statsd.Statter
 		templates map[string]info // metric name -> template
+		dogstatsd bool            // send dimensions as DogStatsD tags
 	}
 )
 
@@ -73,11 +77,19 @@ func (h *handler) record(value *metric.Instance) error {
 		pool.PutBuffer(buf)
 	}
 
+	var tags string
+	if h.dogstatsd {
+		tags = dogstatsdTags(value.Dimensions)
+	}
+
 	switch t.mtype {
 	case config.GAUGE:
 		v, ok := value.Value.(int64)
 		if !ok {
-			return fmt.Errorf("could not record counter '%s' expected int value, got %v", mname, value.Value)
+			return fmt.Errorf("could not record gauge '%s' expected int value, got %v", mname, value.Value)
+		}
+		if h.dogstatsd {
+			return h.raw(mname, strconv.FormatInt(v, 10), "g", tags)
 		}
 		return h.client.Gauge(mname, v, h.rate)
 	case config.COUNTER:
@@ -85,25 +97,84 @@ func (h *handler) record(value *metric.Instance) error {
 		if !ok {
 			return fmt.Errorf("could not record counter '%s' expected int value, got %v", mname, value.Value)
 		}
+		if h.dogstatsd {
+			return h.raw(mname, strconv.FormatInt(v, 10), "c", tags)
+		}
 		return h.client.Inc(mname, v, h.rate)
 	case config.DISTRIBUTION:
-		// TODO: figure out how to program histograms via config.*
-		// updates
-		v, ok := value.Value.(time.Duration)
-		if ok {
-			return h.client.TimingDuration(mname, v, h.rate)
+		v, err := sampleValue(value.Value)
+		if err != nil {
+			return fmt.Errorf("could not record distribution '%s'; %v", mname, err)
+		}
+		if h.dogstatsd {
+			return h.raw(mname, v, "d", tags)
 		}
-		// TODO: figure out support for non-duration distributions.
-		vint, ok := value.Value.(int64)
-		if ok {
-			return h.client.Inc(mname, vint, h.rate)
+		return h.raw(mname, v, "ms", "")
+	case config.HISTOGRAM:
+		v, err := sampleValue(value.Value)
+		if err != nil {
+			return fmt.Errorf("could not record histogram '%s'; %v", mname, err)
 		}
-		return fmt.Errorf("could not record distribution '%s'; expected int or duration, got %v", mname, value.Value)
+		return h.raw(mname, v, "h", tags)
+	case config.SET:
+		return h.raw(mname, tagReplacer.Replace(fmt.Sprintf("%v", value.Value)), "s", tags)
 	default:
 		return fmt.Errorf("unknown metric type '%v' for metric: %s", t.mtype, value.Name)
 	}
 }
 
+// raw sends a value of the given statsd type, with the given DogStatsD tags, if any.
+func (h *handler) raw(name, value, mtype, tags string) error {
+	v := value + "|" + mtype
+	if tags != "" {
+		v += "|#" + tags
+	}
+	return h.client.Raw(name, v, h.rate)
+}
+
+// sampleValue formats a value of a distribution or histogram; durations are in milliseconds.
+func sampleValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case time.Duration:
+		return strconv.FormatFloat(float64(v)/float64(time.Millisecond), 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("expected int, double or duration, got %v", value)
+	}
+}
+
+// tagReplacer replaces the characters that delimit the fields of a DogStatsD line, in tags and
+// in the values of sets.
+var tagReplacer = strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_")
+
+// dogstatsdTags formats dimensions as DogStatsD tags, sorted by name.
+func dogstatsdTags(dims map[string]interface{}) string {
+	if len(dims) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(dims))
+	for n := range dims {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	buf := pool.GetBuffer()
+	for i, n := range names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(tagReplacer.Replace(n))
+		buf.WriteByte(':')
+		buf.WriteString(tagReplacer.Replace(fmt.Sprintf("%v", dims[n])))
+	}
+	tags := buf.String()
+	pool.PutBuffer(buf)
+	return tags
+}
+
 func (h *handler) Close() error { return h.client.Close() }
 
 ////////////////// Config //////////////////////////
@@ -188,5 +259,5 @@ func (b *builder) Build(context context.Context, env adapter.Env) (adapter.Handl
 		}
 		templates[metricName] = info{mtype: s.Type, tmpl: t}
 	}
-	return &handler{ac.SamplingRate, client, templates}, nil
+	return &handler{ac.SamplingRate, client, templates, ac.Protocol == config.DOGSTATSD}, nil
 }
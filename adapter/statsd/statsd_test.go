@@ -17,6 +17,8 @@ package statsd
 import (
 	"context"
 	"fmt"
+	"net"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -165,6 +167,8 @@ func TestRecord(t *testing.T) {
 			"counter":          {Type: config.COUNTER},
 			"distribution":     {Type: config.DISTRIBUTION},
 			"gauge":            {Type: config.GAUGE},
+			"histogram":        {Type: config.HISTOGRAM},
+			"set":              {Type: config.SET},
 		},
 	}
 	metrics := map[string]*metric.Type{
@@ -175,6 +179,8 @@ func TestRecord(t *testing.T) {
 		"counter":      {},
 		"distribution": {},
 		"gauge":        {},
+		"histogram":    {},
+		"set":          {},
 	}
 
 	validGauge := metric.Instance{
@@ -205,6 +211,18 @@ func TestRecord(t *testing.T) {
 		Name:  "distribution",
 		Value: int64(3459),
 	}
+	doubleHistogram := &metric.Instance{
+		Name:  "histogram",
+		Value: 3.14,
+	}
+	invalidHistogram := &metric.Instance{
+		Name:  "histogram",
+		Value: true,
+	}
+	set := &metric.Instance{
+		Name:  "set",
+		Value: "user-1",
+	}
 
 	templateMetric := metric.Instance{
 		Name:       templateMetricName,
@@ -223,11 +241,14 @@ func TestRecord(t *testing.T) {
 		{[]*metric.Instance{&templateMetric}, ""},
 		{[]*metric.Instance{requestDuration}, ""},
 		{[]*metric.Instance{int64Distribution}, ""},
+		{[]*metric.Instance{doubleHistogram}, ""},
+		{[]*metric.Instance{set}, ""},
 		{[]*metric.Instance{&validCounter, &validGauge}, ""},
 		{[]*metric.Instance{&validCounter, &validGauge, &templateMetric}, ""},
 		{[]*metric.Instance{&invalidCounter}, "could not record"},
 		{[]*metric.Instance{&invalidGauge}, "could not record"},
 		{[]*metric.Instance{invalidDistribution}, "could not record"},
+		{[]*metric.Instance{invalidHistogram}, "could not record"},
 		{[]*metric.Instance{&validGauge, &invalidGauge}, "could not record"},
 		{[]*metric.Instance{&templateMetric, &invalidCounter}, "could not record"},
 	}
@@ -281,3 +302,90 @@ func TestRecord(t *testing.T) {
 		})
 	}
 }
+
+func TestRecord_UDP(t *testing.T) {
+	dims := map[string]interface{}{"source": "a|b", "code": int64(200)}
+	vals := []*metric.Instance{
+		{Name: "counter", Value: int64(2), Dimensions: dims},
+		{Name: "gauge", Value: int64(7), Dimensions: dims},
+		{Name: "distribution", Value: 1500 * time.Microsecond, Dimensions: dims},
+		{Name: "histogram", Value: 0.25, Dimensions: dims},
+		{Name: "set", Value: "user|1", Dimensions: dims},
+	}
+	metrics := map[string]*config.Params_MetricInfo{
+		"counter":      {Type: config.COUNTER},
+		"gauge":        {Type: config.GAUGE},
+		"distribution": {Type: config.DISTRIBUTION},
+		"histogram":    {Type: config.HISTOGRAM},
+		"set":          {Type: config.SET},
+	}
+	types := map[string]*metric.Type{"counter": {}, "gauge": {}, "distribution": {}, "histogram": {}, "set": {}}
+
+	cases := []struct {
+		name     string
+		protocol config.Params_Protocol
+		want     []string
+	}{
+		{"statsd", config.STATSD, []string{
+			"istio.counter:2|c",
+			"istio.gauge:7|g",
+			"istio.distribution:1.5|ms",
+			"istio.histogram:0.25|h",
+			"istio.set:user_1|s",
+		}},
+		{"dogstatsd", config.DOGSTATSD, []string{
+			"istio.counter:2|c|#code:200,source:a_b",
+			"istio.gauge:7|g|#code:200,source:a_b",
+			"istio.distribution:1.5|d|#code:200,source:a_b",
+			"istio.histogram:0.25|h|#code:200,source:a_b",
+			"istio.set:user_1|s|#code:200,source:a_b",
+		}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("net.ListenPacket() = %v; wanted no err", err)
+			}
+			defer func() { _ = conn.Close() }()
+
+			b := GetInfo().NewBuilder().(*builder)
+			b.SetAdapterConfig(&config.Params{
+				Address:       conn.LocalAddr().String(),
+				Prefix:        "istio",
+				FlushDuration: time.Hour,
+				FlushBytes:    1432,
+				SamplingRate:  1.0,
+				Metrics:       metrics,
+				Protocol:      c.protocol,
+			})
+			b.SetMetricTypes(types)
+			h, err := b.Build(context.Background(), test.NewEnv(t))
+			if err != nil {
+				t.Fatalf("Build() = %v; wanted no err", err)
+			}
+			if err := h.(metric.Handler).HandleMetric(context.Background(), vals); err != nil {
+				t.Errorf("HandleMetric() = %v; wanted no err", err)
+			}
+			// closing the client flushes the buffered metrics.
+			if err := h.Close(); err != nil {
+				t.Errorf("Close() = %v; wanted no err", err)
+			}
+
+			var got []string
+			buf := make([]byte, 2048)
+			for len(got) < len(c.want) {
+				_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+				n, _, err := conn.ReadFrom(buf)
+				if err != nil {
+					t.Fatalf("ReadFrom() = %v; got %v so far, want %v", err, got, c.want)
+				}
+				got = append(got, strings.Split(strings.TrimSpace(string(buf[:n])), "\n")...)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("got lines %v, want %v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,329 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stdio
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+// backupTimeFormat is appended to the name of rotated files. It sorts in time order.
+const backupTimeFormat = "2006-01-02T15-04-05.000000000"
+
+// rotatingFile is a file that is rotated when it grows larger than maxBytes or older than maxAge.
+// Rotated files are renamed after the file with the time of the rotation appended. They are
+// compressed, if configured, and the oldest are removed to keep at most maxBackups of them, in the
+// background so that writes don't wait for it.
+type rotatingFile struct {
+	path   string
+	now    func() time.Time
+	logger adapter.Logger
+
+	rotated chan struct{} // signals the file was rotated
+	done    chan struct{} // closed when the file is closed
+	stopped chan struct{} // closed once the rotated files are cleaned up after closing
+
+	// refs is the number of handlers sharing the file, protected by the lock of rotatingFiles.
+	refs int
+
+	lock       sync.Mutex    // protects the fields below
+	maxBytes   int64         // 0 means no size based rotation
+	maxAge     time.Duration // 0 means no time based rotation
+	maxBackups int           // 0 means keep all
+	compress   bool
+	file       *os.File
+	size       int64
+	opened     time.Time
+	closed     bool
+}
+
+// rotatingFiles are the rotating files in use, by path. The handlers writing to the same path, like
+// the ones replacing each other when the config changes, share the file, so that it is rotated, and
+// its rotated files are cleaned up, in one place.
+var rotatingFiles = struct {
+	sync.Mutex
+	files map[string]*rotatingFile
+}{files: make(map[string]*rotatingFile)}
+
+// openRotatingFile returns the rotating file of the path, opening it if no handler uses it yet. The
+// settings of a file in use are replaced by the given ones. Each call must be matched by a call to Close.
+func openRotatingFile(path string, maxBytes int64, maxAge time.Duration, maxBackups int, compress bool,
+	env adapter.Env) (*rotatingFile, error) {
+	rotatingFiles.Lock()
+	defer rotatingFiles.Unlock()
+
+	if r := rotatingFiles.files[path]; r != nil {
+		r.lock.Lock()
+		r.maxBytes = maxBytes
+		r.maxAge = maxAge
+		r.maxBackups = maxBackups
+		r.compress = compress
+		r.lock.Unlock()
+		r.refs++
+		return r, nil
+	}
+
+	r, err := newRotatingFile(path, maxBytes, maxAge, maxBackups, compress, env)
+	if err != nil {
+		return nil, err
+	}
+	rotatingFiles.files[path] = r
+	return r, nil
+}
+
+func newRotatingFile(path string, maxBytes int64, maxAge time.Duration, maxBackups int, compress bool,
+	env adapter.Env) (*rotatingFile, error) {
+	r := &rotatingFile{
+		path:       path,
+		maxBytes:   maxBytes,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+		compress:   compress,
+		now:        time.Now,
+		logger:     env.Logger(),
+		rotated:    make(chan struct{}, 1),
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+		refs:       1,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	env.ScheduleDaemon(r.cleanup)
+	return r, nil
+}
+
+// open opens the file for appending.
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open %s: %v", r.path, err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("could not stat %s: %v", r.path, err)
+	}
+	r.file = f
+	r.size = fi.Size()
+	r.opened = r.now()
+	return nil
+}
+
+// Write writes p to the file, rotating it first when p does not fit or the file is too old.
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.file == nil {
+		return 0, fmt.Errorf("%s is closed", r.path)
+	}
+
+	tooLarge := r.maxBytes > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxBytes
+	tooOld := r.maxAge > 0 && r.now().Sub(r.opened) >= r.maxAge
+	if tooLarge || tooOld {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Sync commits the content of the file to storage.
+func (r *rotatingFile) Sync() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	return r.file.Sync()
+}
+
+// Close releases the file. The last handler sharing the file closes it, once the rotated files are
+// cleaned up. Writes fail after the file is closed.
+func (r *rotatingFile) Close() error {
+	rotatingFiles.Lock()
+	r.refs--
+	if r.refs > 0 {
+		rotatingFiles.Unlock()
+		return nil
+	}
+	if rotatingFiles.files[r.path] == r {
+		delete(rotatingFiles.files, r.path)
+	}
+	rotatingFiles.Unlock()
+
+	r.lock.Lock()
+	if r.closed {
+		r.lock.Unlock()
+		return nil
+	}
+	r.closed = true
+	var err error
+	if r.file != nil {
+		err = r.file.Close()
+		r.file = nil
+	}
+	r.lock.Unlock()
+
+	close(r.done)
+	<-r.stopped
+	return err
+}
+
+// rotate moves the file aside and opens a new one. When either fails, the current file is kept
+// open, so that the following writes go on and try to rotate it again. Called with the lock held.
+func (r *rotatingFile) rotate() error {
+	backup := r.path + "." + r.now().UTC().Format(backupTimeFormat)
+	if err := os.Rename(r.path, backup); err != nil {
+		return fmt.Errorf("could not rotate %s: %v", r.path, err)
+	}
+	old := r.file
+	if err := r.open(); err != nil {
+		// move the file back, so that it is rotated with the next write.
+		if rerr := os.Rename(backup, r.path); rerr != nil {
+			_ = r.logger.Errorf("Unable to restore %s after failing to rotate it: %v", r.path, rerr)
+		}
+		return err
+	}
+	if err := old.Close(); err != nil {
+		_ = r.logger.Errorf("Unable to close the file rotated from %s: %v", r.path, err)
+	}
+
+	select {
+	case r.rotated <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// cleanup cleans up the rotated files each time the file is rotated, until it is closed.
+func (r *rotatingFile) cleanup() {
+	defer close(r.stopped)
+
+	for {
+		select {
+		case <-r.rotated:
+			r.cleanupBackups()
+		case <-r.done:
+			select {
+			case <-r.rotated:
+				r.cleanupBackups()
+			default:
+			}
+			return
+		}
+	}
+}
+
+// cleanupBackups compresses the rotated files, if configured, and removes the oldest ones, keeping
+// maxBackups of them.
+func (r *rotatingFile) cleanupBackups() {
+	r.lock.Lock()
+	compress, maxBackups := r.compress, r.maxBackups
+	r.lock.Unlock()
+
+	backups, err := r.backups()
+	if err != nil {
+		_ = r.logger.Errorf("Unable to list the files rotated from %s: %v", r.path, err)
+		return
+	}
+
+	if compress {
+		for i, b := range backups {
+			if strings.HasSuffix(b, ".gz") {
+				continue
+			}
+			if err := compressFile(b); err != nil {
+				_ = r.logger.Errorf("%v", err)
+				continue
+			}
+			backups[i] = b + ".gz"
+		}
+	}
+
+	if maxBackups <= 0 {
+		return
+	}
+	for len(backups) > maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			_ = r.logger.Errorf("Unable to remove rotated file %s: %v", backups[0], err)
+		}
+		backups = backups[1:]
+	}
+}
+
+// backups returns the rotated files, oldest first. Only the files named after the file with the
+// time of a rotation appended, compressed or not, are returned.
+func (r *rotatingFile) backups() ([]string, error) {
+	files, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := r.path + "."
+	backups := files[:0]
+	for _, f := range files {
+		ts := strings.TrimSuffix(strings.TrimPrefix(f, prefix), ".gz")
+		if _, err := time.Parse(backupTimeFormat, ts); err == nil {
+			backups = append(backups, f)
+		}
+	}
+	sort.Strings(backups)
+	return backups, nil
+}
+
+// compressFile replaces the file at path with a gzip compressed copy, named path.gz.
+func compressFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not compress %s: %v", path, err)
+	}
+	defer func() { _ = in.Close() }()
+
+	gzPath := path + ".gz"
+	out, err := os.OpenFile(gzPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("could not compress %s: %v", path, err)
+	}
+
+	gz := gzip.NewWriter(out)
+	gz.Name = filepath.Base(path)
+	_, err = io.Copy(gz, in)
+	if err == nil {
+		err = gz.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(gzPath)
+		return fmt.Errorf("could not compress %s: %v", path, err)
+	}
+	return os.Remove(path)
+}
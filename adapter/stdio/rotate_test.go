@@ -0,0 +1,282 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stdio
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/mixer/pkg/adapter/test"
+)
+
+func newTestRotatingFile(t *testing.T, maxBytes int64, maxAge time.Duration, maxBackups int, compress bool) (*rotatingFile, *time.Time, func()) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	r, err := newRotatingFile(filepath.Join(dir, "out.log"), maxBytes, maxAge, maxBackups, compress, test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+
+	now := time.Date(2017, time.August, 21, 10, 4, 00, 0, time.UTC)
+	r.now = func() time.Time { return now }
+	r.opened = now
+	return r, &now, func() {
+		_ = r.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func write(t *testing.T, r *rotatingFile, s string) {
+	if _, err := r.Write([]byte(s)); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+}
+
+func backups(t *testing.T, r *rotatingFile) []string {
+	files, err := filepath.Glob(r.path + ".*")
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+func read(t *testing.T, path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Unable to read %s: %v", path, err)
+	}
+	return string(b)
+}
+
+func TestRotatingFile_Size(t *testing.T) {
+	r, now, cleanup := newTestRotatingFile(t, 10, 0, 0, false)
+	defer cleanup()
+
+	write(t, r, "12345\n")
+	write(t, r, "123\n")
+	if got := backups(t, r); len(got) != 0 {
+		t.Errorf("Got rotated files %v, expecting none", got)
+	}
+
+	*now = now.Add(time.Second)
+	write(t, r, "abc\n")
+	got := backups(t, r)
+	if len(got) != 1 {
+		t.Fatalf("Got rotated files %v, expecting one", got)
+	}
+	if !strings.HasSuffix(got[0], ".2017-08-21T10-04-01.000000000") {
+		t.Errorf("Got rotated file %s, expecting it named after the rotation time", got[0])
+	}
+	if s := read(t, got[0]); s != "12345\n123\n" {
+		t.Errorf("Got rotated content %q, expecting %q", s, "12345\n123\n")
+	}
+	if s := read(t, r.path); s != "abc\n" {
+		t.Errorf("Got content %q, expecting %q", s, "abc\n")
+	}
+}
+
+func TestRotatingFile_Age(t *testing.T) {
+	r, now, cleanup := newTestRotatingFile(t, 0, time.Hour, 0, false)
+	defer cleanup()
+
+	write(t, r, "first\n")
+	*now = now.Add(59 * time.Minute)
+	write(t, r, "second\n")
+	if got := backups(t, r); len(got) != 0 {
+		t.Errorf("Got rotated files %v, expecting none", got)
+	}
+
+	*now = now.Add(time.Minute)
+	write(t, r, "third\n")
+	if got := backups(t, r); len(got) != 1 {
+		t.Errorf("Got rotated files %v, expecting one", got)
+	}
+	if s := read(t, r.path); s != "third\n" {
+		t.Errorf("Got content %q, expecting %q", s, "third\n")
+	}
+}
+
+func TestRotatingFile_RotateError(t *testing.T) {
+	r, now, cleanup := newTestRotatingFile(t, 10, 0, 0, false)
+	defer cleanup()
+
+	write(t, r, "12345\n")
+	// a directory in the way of the rotated file makes the rotation fail.
+	*now = now.Add(time.Second)
+	blocker := r.path + "." + now.UTC().Format(backupTimeFormat)
+	if err := os.MkdirAll(filepath.Join(blocker, "x"), 0755); err != nil {
+		t.Fatalf("Unable to create directory: %v", err)
+	}
+	if _, err := r.Write([]byte("abcdef\n")); err == nil {
+		t.Error("Got success, expecting the rotation to fail")
+	}
+
+	// the next write rotates the file.
+	*now = now.Add(time.Second)
+	write(t, r, "abcdef\n")
+	if s := read(t, r.path); s != "abcdef\n" {
+		t.Errorf("Got content %q, expecting %q", s, "abcdef\n")
+	}
+	if s := read(t, r.path+"."+now.UTC().Format(backupTimeFormat)); s != "12345\n" {
+		t.Errorf("Got rotated content %q, expecting %q", s, "12345\n")
+	}
+}
+
+func TestOpenRotatingFile_Shared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	path := filepath.Join(dir, "out.log")
+	r1, err := openRotatingFile(path, 10, 0, 1, false, test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	r2, err := openRotatingFile(path, 20, 0, 2, false, test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	if r1 != r2 {
+		t.Fatal("Got two files for the same path, expecting them to be shared")
+	}
+	if r2.maxBytes != 20 || r2.maxBackups != 2 {
+		t.Errorf("Got maxBytes %d and maxBackups %d, expecting the latest settings, 20 and 2", r2.maxBytes, r2.maxBackups)
+	}
+
+	// the file stays open until every handler closes it.
+	if err = r1.Close(); err != nil {
+		t.Errorf("Got error %v, expecting success", err)
+	}
+	write(t, r2, "abc\n")
+	if err = r2.Close(); err != nil {
+		t.Errorf("Got error %v, expecting success", err)
+	}
+	if _, err = r2.Write([]byte("def\n")); err == nil {
+		t.Error("Got success writing to the closed file, expecting an error")
+	}
+
+	// the path is opened again once closed.
+	r3, err := openRotatingFile(path, 10, 0, 1, false, test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	defer func() { _ = r3.Close() }()
+	if r3 == r1 {
+		t.Error("Got the closed file, expecting a new one")
+	}
+}
+
+func TestRotatingFile_MaxBackups(t *testing.T) {
+	r, now, cleanup := newTestRotatingFile(t, 1, 0, 2, false)
+	defer cleanup()
+
+	for _, s := range []string{"a", "b", "c", "d", "e"} {
+		*now = now.Add(time.Second)
+		write(t, r, s)
+	}
+
+	// the rotated files are removed in the background, until the file is closed.
+	_ = r.Close()
+	got := backups(t, r)
+	if len(got) != 2 {
+		t.Fatalf("Got rotated files %v, expecting two", got)
+	}
+	if a, b := read(t, got[0]), read(t, got[1]); a != "c" || b != "d" {
+		t.Errorf("Got rotated content %q and %q, expecting the newest, \"c\" and \"d\"", a, b)
+	}
+}
+
+func TestRotatingFile_Compress(t *testing.T) {
+	r, _, cleanup := newTestRotatingFile(t, 1, 0, 0, true)
+	defer cleanup()
+
+	write(t, r, "compress me")
+	write(t, r, "next")
+
+	// the rotated files are compressed in the background, until the file is closed.
+	_ = r.Close()
+	got := backups(t, r)
+	if len(got) != 1 || !strings.HasSuffix(got[0], ".gz") {
+		t.Fatalf("Got rotated files %v, expecting a single gzip file", got)
+	}
+
+	f, err := os.Open(got[0])
+	if err != nil {
+		t.Fatalf("Unable to open %s: %v", got[0], err)
+	}
+	defer func() { _ = f.Close() }()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("Got error %v, expecting a gzip file", err)
+	}
+	b, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("Got error %v, expecting a gzip file", err)
+	}
+	if string(b) != "compress me" {
+		t.Errorf("Got rotated content %q, expecting %q", b, "compress me")
+	}
+}
+
+func TestRotatingFile_OtherFiles(t *testing.T) {
+	r, now, cleanup := newTestRotatingFile(t, 1, 0, 1, true)
+	defer cleanup()
+
+	old := r.path + ".2017-08-20T10-04-00.000000000.gz"
+	other := r.path + ".keep"
+	for _, f := range []string{old, other} {
+		if err := ioutil.WriteFile(f, []byte("x"), 0644); err != nil {
+			t.Fatalf("Unable to write %s: %v", f, err)
+		}
+	}
+
+	write(t, r, "a")
+	*now = now.Add(time.Second)
+	write(t, r, "b")
+	_ = r.Close()
+
+	// only the rotated files are compressed and removed.
+	want := []string{r.path + ".2017-08-21T10-04-01.000000000.gz", other}
+	if got := backups(t, r); !reflect.DeepEqual(got, want) {
+		t.Errorf("Got files %v, expecting %v", got, want)
+	}
+}
+
+func TestRotatingFile_Closed(t *testing.T) {
+	r, _, cleanup := newTestRotatingFile(t, 0, 0, 0, false)
+	defer cleanup()
+
+	if err := r.Close(); err != nil {
+		t.Errorf("Got error %v, expecting success", err)
+	}
+	if _, err := r.Write([]byte("x")); err == nil {
+		t.Error("Got success writing a closed file, expecting failure")
+	}
+	if err := r.Sync(); err != nil {
+		t.Errorf("Got error %v, expecting success", err)
+	}
+}
@@ -17,8 +17,11 @@ package stdio // import "istio.io/mixer/adapter/stdio"
 import (
 	"context"
 	"fmt"
+	"io"
 	"net"
+	"os"
 	"sort"
+	"text/template"
 	"time"
 
 	multierror "github.com/hashicorp/go-multierror"
@@ -27,12 +30,13 @@ import (
 
 	"istio.io/mixer/adapter/stdio/config"
 	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/pool"
 	"istio.io/mixer/template/logentry"
 	"istio.io/mixer/template/metric"
 )
 
 type (
-	zapBuilderFn func(outputPath string, encoding string) (*zap.Logger, error)
+	zapBuilderFn func(sink zapcore.WriteSyncer, encoding string) (*zap.Logger, error)
 	getTimeFn    func() time.Time
 
 	handler struct {
@@ -42,6 +46,9 @@ type (
 		getTime        getTimeFn
 		logEntryVars   map[string][]string
 		metricDims     map[string][]string
+		logEntryTmpls  map[string]*template.Template
+		sink           zapcore.WriteSyncer
+		closer         io.Closer // closes the sink, nil for the standard streams
 	}
 )
 
@@ -50,6 +57,13 @@ func (h *handler) HandleLogEntry(_ context.Context, instances []*logentry.Instan
 
 	fields := make([]zapcore.Field, 0, 6)
 	for _, instance := range instances {
+		if tmpl, found := h.logEntryTmpls[instance.Name]; found {
+			if err := h.writeTemplated(tmpl, instance); err != nil {
+				errors = multierror.Append(errors, err)
+			}
+			continue
+		}
+
 		entry := zapcore.Entry{
 			LoggerName: instance.Name,
 			Level:      h.mapSeverityLevel(instance.Severity),
@@ -97,6 +111,21 @@ func (h *handler) HandleMetric(_ context.Context, instances []*metric.Instance)
 	return errors.ErrorOrNil()
 }
 
+// writeTemplated writes the instance to the sink, formatted by its template.
+func (h *handler) writeTemplated(tmpl *template.Template, instance *logentry.Instance) error {
+	buf := pool.GetBuffer()
+	defer pool.PutBuffer(buf)
+
+	if err := tmpl.Execute(buf, instance); err != nil {
+		return fmt.Errorf("could not format log entry %s: %v", instance.Name, err)
+	}
+	if b := buf.Bytes(); len(b) == 0 || b[len(b)-1] != '\n' {
+		buf.WriteByte('\n')
+	}
+	_, err := h.sink.Write(buf.Bytes())
+	return err
+}
+
 func field(varName string, value interface{}) zapcore.Field {
 	// TODO: remove when IP_ADDRESS is properly handled by Mixer
 	switch value.(type) {
@@ -111,7 +140,12 @@ func field(varName string, value interface{}) zapcore.Field {
 	}
 }
 
-func (h *handler) Close() error { return nil }
+func (h *handler) Close() error {
+	if h.closer != nil {
+		return h.closer.Close()
+	}
+	return nil
+}
 
 func (h *handler) mapSeverityLevel(severity string) zapcore.Level {
 	level, ok := h.severityLevels[severity]
@@ -153,13 +187,36 @@ type builder struct {
 func (b *builder) SetLogEntryTypes(types map[string]*logentry.Type) { b.logEntryTypes = types }
 func (b *builder) SetMetricTypes(types map[string]*metric.Type)     { b.metricTypes = types }
 func (b *builder) SetAdapterConfig(cfg adapter.Config)              { b.adapterConfig = cfg.(*config.Params) }
-func (*builder) Validate() (ce *adapter.ConfigErrors)               { return }
+
+func (b *builder) Validate() (ce *adapter.ConfigErrors) {
+	ac := b.adapterConfig
+	if ac.LogStream == config.FILE || ac.LogStream == config.ROTATED_FILE {
+		if ac.OutputPath == "" {
+			ce = ce.Appendf("outputPath", "output path must be specified when logging to a file")
+		}
+	}
+	if ac.MaxMegabytesBeforeRotation < 0 {
+		ce = ce.Appendf("maxMegabytesBeforeRotation", "must be >= 0")
+	}
+	if ac.MaxDaysBeforeRotation < 0 {
+		ce = ce.Appendf("maxDaysBeforeRotation", "must be >= 0")
+	}
+	if ac.MaxRotatedFiles < 0 {
+		ce = ce.Appendf("maxRotatedFiles", "must be >= 0")
+	}
+	for name, t := range ac.LogEntryTemplates {
+		if _, err := template.New(name).Parse(t); err != nil {
+			ce = ce.Appendf("logEntryTemplates", "failed to parse template '%s' for log entry '%s': %v", t, name, err)
+		}
+	}
+	return
+}
 
 func (b *builder) Build(context context.Context, env adapter.Env) (adapter.Handler, error) {
 	return b.buildWithZapBuilder(context, env, newZapLogger)
 }
 
-func (b *builder) buildWithZapBuilder(_ context.Context, _ adapter.Env, zb zapBuilderFn) (adapter.Handler, error) {
+func (b *builder) buildWithZapBuilder(_ context.Context, env adapter.Env, zb zapBuilderFn) (adapter.Handler, error) {
 	// We produce sorted tables of the variables we'll receive such that
 	// we send output to the zap logger in a consistent order at runtime
 	varLists := make(map[string][]string, len(b.logEntryTypes))
@@ -188,9 +245,18 @@ func (b *builder) buildWithZapBuilder(_ context.Context, _ adapter.Env, zb zapBu
 
 	ac := b.adapterConfig
 
-	outputPath := "stdout"
-	if ac.LogStream == config.STDERR {
-		outputPath = "stderr"
+	tmpls := make(map[string]*template.Template, len(ac.LogEntryTemplates))
+	for name, t := range ac.LogEntryTemplates {
+		tmpl, err := template.New(name).Parse(t)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse template for log entry %s: %v", name, err)
+		}
+		tmpls[name] = tmpl
+	}
+
+	sink, closer, err := newSink(ac, env)
+	if err != nil {
+		return nil, fmt.Errorf("could not open output: %v", err)
 	}
 
 	encoding := "console"
@@ -198,8 +264,11 @@ func (b *builder) buildWithZapBuilder(_ context.Context, _ adapter.Env, zb zapBu
 		encoding = "json"
 	}
 
-	zapLogger, err := zb(outputPath, encoding)
+	zapLogger, err := zb(sink, encoding)
 	if err != nil {
+		if closer != nil {
+			_ = closer.Close()
+		}
 		return nil, fmt.Errorf("could not build logger: %v", err)
 	}
 
@@ -215,9 +284,39 @@ func (b *builder) buildWithZapBuilder(_ context.Context, _ adapter.Env, zb zapBu
 		getTime:        time.Now,
 		logEntryVars:   varLists,
 		metricDims:     dimLists,
+		logEntryTmpls:  tmpls,
+		sink:           sink,
+		closer:         closer,
 	}, nil
 }
 
+// newSink opens the output selected by the configuration, and returns what closes it, if anything.
+func newSink(ac *config.Params, env adapter.Env) (zapcore.WriteSyncer, io.Closer, error) {
+	switch ac.LogStream {
+	case config.STDERR:
+		return os.Stderr, nil, nil
+	case config.FILE:
+		f, err := os.OpenFile(ac.OutputPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		return f, f, nil
+	case config.ROTATED_FILE:
+		r, err := openRotatingFile(ac.OutputPath,
+			int64(ac.MaxMegabytesBeforeRotation)*1024*1024,
+			time.Duration(ac.MaxDaysBeforeRotation)*24*time.Hour,
+			int(ac.MaxRotatedFiles),
+			ac.CompressRotatedFiles,
+			env)
+		if err != nil {
+			return nil, nil, err
+		}
+		return r, r, nil
+	default:
+		return os.Stdout, nil, nil
+	}
+}
+
 func mapConfigLevel(l config.Params_Level) zapcore.Level {
 	if l == config.WARNING {
 		return zapcore.WarnLevel
@@ -237,13 +336,16 @@ func newZapEncoderConfig() zapcore.EncoderConfig {
 	return encConfig
 }
 
-func newZapLogger(outputPath string, encoding string) (*zap.Logger, error) {
-	zapConfig := zap.NewProductionConfig()
-	zapConfig.DisableCaller = true
-	zapConfig.DisableStacktrace = true
-	zapConfig.OutputPaths = []string{outputPath}
-	zapConfig.EncoderConfig = newZapEncoderConfig()
-	zapConfig.Encoding = encoding
+func newZapLogger(sink zapcore.WriteSyncer, encoding string) (*zap.Logger, error) {
+	var enc zapcore.Encoder
+	switch encoding {
+	case "console":
+		enc = zapcore.NewConsoleEncoder(newZapEncoderConfig())
+	case "json":
+		enc = zapcore.NewJSONEncoder(newZapEncoderConfig())
+	default:
+		return nil, fmt.Errorf("unknown encoding %s", encoding)
+	}
 
-	return zapConfig.Build()
+	return zap.New(zapcore.NewCore(enc, zapcore.Lock(sink), zap.InfoLevel)), nil
 }
@@ -17,7 +17,10 @@ package stdio
 import (
 	"context"
 	"errors"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
@@ -85,7 +88,7 @@ func TestBuilder(t *testing.T) {
 
 	cases := []struct {
 		config      config.Params
-		outputPath  string
+		sink        zapcore.WriteSyncer
 		encoding    string
 		metricLevel zapcore.Level
 		induceError bool
@@ -93,39 +96,39 @@ func TestBuilder(t *testing.T) {
 	}{
 		{config.Params{
 			LogStream: config.STDOUT,
-		}, "stdout", "console", zapcore.InfoLevel, false, true},
+		}, os.Stdout, "console", zapcore.InfoLevel, false, true},
 
 		{config.Params{
 			LogStream: config.STDERR,
-		}, "stderr", "console", zapcore.InfoLevel, false, true},
+		}, os.Stderr, "console", zapcore.InfoLevel, false, true},
 
 		{config.Params{
 			MetricLevel: config.INFO,
-		}, "stdout", "console", zapcore.InfoLevel, false, true},
+		}, os.Stdout, "console", zapcore.InfoLevel, false, true},
 
 		{config.Params{
 			MetricLevel: config.WARNING,
-		}, "stdout", "console", zapcore.WarnLevel, false, true},
+		}, os.Stdout, "console", zapcore.WarnLevel, false, true},
 
 		{config.Params{
 			MetricLevel: config.ERROR,
-		}, "stdout", "console", zapcore.ErrorLevel, false, true},
+		}, os.Stdout, "console", zapcore.ErrorLevel, false, true},
 
 		{config.Params{
 			MetricLevel: config.ERROR,
-		}, "stdout", "console", zapcore.ErrorLevel, true, false},
+		}, os.Stdout, "console", zapcore.ErrorLevel, true, false},
 
 		{config.Params{
 			SeverityLevels: map[string]config.Params_Level{"WARNING": config.WARNING},
 			OutputAsJson:   true,
-		}, "stdout", "json", zapcore.InfoLevel, false, true},
+		}, os.Stdout, "json", zapcore.InfoLevel, false, true},
 	}
 
 	for i, c := range cases {
 		t.Run(strconv.Itoa(i), func(t *testing.T) {
-			zb := func(outputPath string, encoding string) (*zap.Logger, error) {
-				if outputPath != c.outputPath {
-					t.Errorf("Got output path %s, expecting %s", outputPath, c.outputPath)
+			zb := func(sink zapcore.WriteSyncer, encoding string) (*zap.Logger, error) {
+				if sink != c.sink {
+					t.Errorf("Got sink %v, expecting %v", sink, c.sink)
 				}
 
 				if encoding != c.encoding {
@@ -136,7 +139,7 @@ func TestBuilder(t *testing.T) {
 					return nil, errors.New("expected")
 				}
 
-				return newZapLogger(outputPath, encoding)
+				return newZapLogger(sink, encoding)
 			}
 
 			info := GetInfo()
@@ -403,3 +406,112 @@ func TestMetricEntry(t *testing.T) {
 		t.Errorf("Got error %v, expecting success", err)
 	}
 }
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		config    config.Params
+		errString string
+	}{
+		{config.Params{}, ""},
+		{config.Params{LogStream: config.FILE, OutputPath: "/tmp/out.log"}, ""},
+		{config.Params{LogStream: config.FILE}, "outputPath"},
+		{config.Params{LogStream: config.ROTATED_FILE}, "outputPath"},
+		{config.Params{MaxMegabytesBeforeRotation: -1}, "maxMegabytesBeforeRotation"},
+		{config.Params{MaxDaysBeforeRotation: -1}, "maxDaysBeforeRotation"},
+		{config.Params{MaxRotatedFiles: -1}, "maxRotatedFiles"},
+		{config.Params{LogEntryTemplates: map[string]string{"Foo": "{{.Variables.a}}"}}, ""},
+		{config.Params{LogEntryTemplates: map[string]string{"Foo": "{{if 1}}"}}, "logEntryTemplates"},
+	}
+
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			b := GetInfo().NewBuilder()
+			b.SetAdapterConfig(&c.config)
+			errString := ""
+			if err := b.Validate(); err != nil {
+				errString = err.Error()
+			}
+			if c.errString == "" && errString != "" {
+				t.Errorf("Got %s, expecting success", errString)
+			} else if !strings.Contains(errString, c.errString) {
+				t.Errorf("Got '%s', expecting error containing '%s'", errString, c.errString)
+			}
+		})
+	}
+}
+
+func TestFileOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stdio")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	for _, stream := range []config.Params_Stream{config.FILE, config.ROTATED_FILE} {
+		t.Run(stream.String(), func(t *testing.T) {
+			path := filepath.Join(dir, stream.String()+".log")
+			cfg := &config.Params{
+				LogStream:    stream,
+				OutputPath:   path,
+				OutputAsJson: true,
+				LogEntryTemplates: map[string]string{
+					"access": `{{.Variables.sourceIp}} - - [{{.Timestamp.Format "02/Jan/2006:15:04:05 -0700"}}] "{{.Variables.method}} {{.Variables.url}}" {{.Variables.code}}`,
+				},
+			}
+
+			b := GetInfo().NewBuilder().(*builder)
+			b.SetAdapterConfig(cfg)
+			b.SetLogEntryTypes(map[string]*logentry.Type{
+				"Foo": {Variables: map[string]descriptor.ValueType{"String": descriptor.STRING}},
+			})
+			h, err := b.Build(context.Background(), test.NewEnv(t))
+			if err != nil {
+				t.Fatalf("Got error %v, expecting success", err)
+			}
+
+			tm := time.Date(2017, time.August, 21, 10, 4, 00, 0, time.UTC)
+			instances := []*logentry.Instance{
+				{
+					Name:      "access",
+					Timestamp: tm,
+					Variables: map[string]interface{}{"sourceIp": "10.0.0.1", "method": "GET", "url": "/index.html", "code": int64(200)},
+				},
+				{
+					Name:      "Foo",
+					Timestamp: tm,
+					Variables: map[string]interface{}{"String": "a string"},
+				},
+			}
+			if err := h.(logentry.Handler).HandleLogEntry(context.Background(), instances); err != nil {
+				t.Errorf("Got error %v, expecting success", err)
+			}
+			if err := h.Close(); err != nil {
+				t.Errorf("Got error %v, expecting success", err)
+			}
+
+			out, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Unable to read output: %v", err)
+			}
+			want := `10.0.0.1 - - [21/Aug/2017:10:04:00 +0000] "GET /index.html" 200` + "\n" +
+				`{"level":"info","ts":"2017-08-21T10:04:00.000Z","instance":"Foo","String":"a string"}` + "\n"
+			if string(out) != want {
+				t.Errorf("Got output\n%s\nexpecting\n%s", out, want)
+			}
+		})
+	}
+}
+
+func TestTemplateError(t *testing.T) {
+	b := GetInfo().NewBuilder().(*builder)
+	b.SetAdapterConfig(&config.Params{LogEntryTemplates: map[string]string{"Foo": "{{.Variables.a.b}}"}})
+	h, err := b.Build(context.Background(), test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+
+	instances := []*logentry.Instance{{Name: "Foo", Variables: map[string]interface{}{"a": "not a map"}}}
+	if err := h.(logentry.Handler).HandleLogEntry(context.Background(), instances); err == nil {
+		t.Error("Got success, expecting failure")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svcctrl
+
+import (
+	"context"
+	"fmt"
+
+	rpc "github.com/googleapis/googleapis/google/rpc"
+	"github.com/pborman/uuid"
+	sc "google.golang.org/api/servicecontrol/v1"
+
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/status"
+	"istio.io/mixer/template/checknothing"
+	"istio.io/mixer/template/quota"
+)
+
+// Quota modes of Service Control quota operations.
+const (
+	quotaModeNormal     = "NORMAL"
+	quotaModeBestEffort = "BEST_EFFORT"
+
+	// quotaUsedMetric is the metric of allocation responses with the amount allocated per quota group.
+	quotaUsedMetric = "serviceruntime.googleapis.com/api/consumer/quota_used_count"
+)
+
+// HandleCheckNothing checks the consumer against the service.
+func (h *handler) HandleCheckNothing(ctx context.Context, instance *checknothing.Instance) (adapter.CheckResult, error) {
+	now := formatTime(h.now())
+	op := &sc.Operation{
+		OperationId:   uuid.New(),
+		OperationName: instance.Name,
+		ConsumerId:    h.consumerID,
+		StartTime:     now,
+		EndTime:       now,
+	}
+
+	resp, err := h.client.Services.Check(h.serviceName, &sc.CheckRequest{Operation: op}).Context(ctx).Do()
+	if err != nil {
+		return adapter.CheckResult{}, fmt.Errorf("could not check %s: %v", instance.Name, err)
+	}
+
+	result := h.checkResult
+	if len(resp.CheckErrors) > 0 {
+		e := resp.CheckErrors[0]
+		result.Status = status.WithMessage(errorCode(e.Code), fmt.Sprintf("%s: %s", e.Code, e.Detail))
+	}
+	return result, nil
+}
+
+// HandleQuota allocates quota of the metric the instance is mapped to. Instances that
+// are not mapped, and releases, are granted without calling Service Control.
+func (h *handler) HandleQuota(ctx context.Context, instance *quota.Instance, args adapter.QuotaArgs) (adapter.QuotaResult, error) {
+	metricName, found := h.quotas[instance.Name]
+	if !found || args.QuotaAmount <= 0 {
+		return adapter.QuotaResult{Amount: args.QuotaAmount}, nil
+	}
+
+	opID := args.DeduplicationID
+	if opID == "" {
+		opID = uuid.New()
+	}
+	mode := quotaModeNormal
+	if args.BestEffort {
+		mode = quotaModeBestEffort
+	}
+	amount := args.QuotaAmount
+	op := &sc.QuotaOperation{
+		OperationId: opID,
+		MethodName:  instance.Name,
+		ConsumerId:  h.consumerID,
+		QuotaMode:   mode,
+		Labels:      metricLabels(instance.Dimensions),
+		QuotaMetrics: []*sc.MetricValueSet{
+			{
+				MetricName:   metricName,
+				MetricValues: []*sc.MetricValue{{Int64Value: &amount}},
+			},
+		},
+	}
+
+	resp, err := h.client.Services.AllocateQuota(h.serviceName, &sc.AllocateQuotaRequest{AllocateOperation: op}).Context(ctx).Do()
+	if err != nil {
+		return adapter.QuotaResult{}, fmt.Errorf("could not allocate quota %s: %v", instance.Name, err)
+	}
+
+	if len(resp.AllocateErrors) > 0 {
+		e := resp.AllocateErrors[0]
+		return adapter.QuotaResult{
+			Status: status.WithMessage(errorCode(e.Code), fmt.Sprintf("%s: %s", e.Code, e.Description)),
+		}, nil
+	}
+
+	// best effort allocations may be granted less, reported as the usage of each quota group the metric
+	// counts against. The smallest is what was granted.
+	if args.BestEffort {
+		for _, set := range resp.QuotaMetrics {
+			if set.MetricName != quotaUsedMetric {
+				continue
+			}
+			for _, mv := range set.MetricValues {
+				if mv.Int64Value != nil && *mv.Int64Value < amount {
+					amount = *mv.Int64Value
+				}
+			}
+		}
+	}
+	return adapter.QuotaResult{Amount: amount}, nil
+}
+
+// errorCode maps the code of a Service Control check or quota error to a status code.
+func errorCode(code string) rpc.Code {
+	switch code {
+	case "NOT_FOUND", "PROJECT_DELETED":
+		return rpc.NOT_FOUND
+	case "RESOURCE_EXHAUSTED":
+		return rpc.RESOURCE_EXHAUSTED
+	case "PROJECT_INVALID", "API_KEY_INVALID", "API_KEY_EXPIRED", "API_KEY_NOT_FOUND":
+		return rpc.INVALID_ARGUMENT
+	case "NAMESPACE_LOOKUP_UNAVAILABLE", "SERVICE_STATUS_UNAVAILABLE", "BILLING_STATUS_UNAVAILABLE", "QUOTA_SYSTEM_UNAVAILABLE":
+		return rpc.UNAVAILABLE
+	default:
+		return rpc.PERMISSION_DENIED
+	}
+}
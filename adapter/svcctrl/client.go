@@ -16,6 +16,8 @@ package svcctrl
 
 import (
 	"net/http"
+	"strings"
+	"time"
 
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
@@ -25,8 +27,12 @@ import (
 	"istio.io/mixer/pkg/adapter"
 )
 
-// Creates a service control client. The client is authenticated with service control with Oauth2.
-func createClient(logger adapter.Logger) (*sc.Service, error) {
+// clientTimeout bounds the calls to Service Control, including the ones made without a deadline.
+const clientTimeout = 10 * time.Second
+
+type createClientFn func(logger adapter.Logger, endpoint string) (*sc.Service, error)
+
+func createClient(logger adapter.Logger, endpoint string) (*sc.Service, error) {
 	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{
 		Transport: http.DefaultTransport})
 
@@ -34,11 +40,24 @@ func createClient(logger adapter.Logger) (*sc.Service, error) {
 	if err != nil {
 		return nil, logger.Errorf("unable to create http client %v", err.Error())
 	}
+	client.Timeout = clientTimeout
 
 	serviceControl, err := sc.New(client)
 	if err != nil {
 		return nil, logger.Errorf("unable to create service control client %v", err.Error())
 	}
+	setEndpoint(serviceControl, endpoint)
 	logger.Infof("created service control client\n")
 	return serviceControl, nil
 }
+
+// setEndpoint points the client at the given endpoint, if any.
+func setEndpoint(client *sc.Service, endpoint string) {
+	if endpoint == "" {
+		return
+	}
+	if !strings.HasSuffix(endpoint, "/") {
+		endpoint += "/"
+	}
+	client.BasePath = endpoint
+}
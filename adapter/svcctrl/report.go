@@ -0,0 +1,189 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package svcctrl
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	sc "google.golang.org/api/servicecontrol/v1"
+
+	"istio.io/mixer/adapter/svcctrl/config"
+)
+
+type (
+	// aggregator accumulates metric values between reports.
+	aggregator struct {
+		lock   sync.Mutex // protects the fields below
+		start  time.Time  // start of the current reporting interval
+		values map[string]*aggregate
+	}
+
+	// aggregate is the accumulated value of a metric, for a set of labels.
+	aggregate struct {
+		metricName string
+		kind       config.Params_MetricInfo_Kind
+		labels     map[string]string
+		isDouble   bool
+		int64Value int64
+		double     float64
+	}
+)
+
+func newAggregator(now time.Time) *aggregator {
+	return &aggregator{
+		start:  now,
+		values: make(map[string]*aggregate),
+	}
+}
+
+// add accumulates a value of the metric: summed for DELTA metrics, replaced for GAUGE metrics.
+func (a *aggregator) add(info *config.Params_MetricInfo, labels map[string]string, value interface{}) error {
+	var i int64
+	var d float64
+	isDouble := false
+	switch v := value.(type) {
+	case int64:
+		i = v
+	case float64:
+		d, isDouble = v, true
+	case time.Duration:
+		d, isDouble = v.Seconds(), true
+	default:
+		return fmt.Errorf("could not report metric %s: expected int64, double or duration value, got %v", info.Name, value)
+	}
+
+	key := aggregateKey(info.Name, labels)
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	agg, found := a.values[key]
+	if !found || info.Kind == config.GAUGE {
+		a.values[key] = &aggregate{
+			metricName: info.Name,
+			kind:       info.Kind,
+			labels:     labels,
+			isDouble:   isDouble,
+			int64Value: i,
+			double:     d,
+		}
+		return nil
+	}
+
+	// mixed int64 and double values are summed as doubles.
+	if isDouble && !agg.isDouble {
+		agg.isDouble = true
+		agg.double = float64(agg.int64Value)
+	}
+	if agg.isDouble {
+		if !isDouble {
+			d = float64(i)
+		}
+		agg.double += d
+	} else {
+		agg.int64Value += i
+	}
+	return nil
+}
+
+// flush returns the accumulated values as metric value sets, sorted by metric name, and starts a new interval.
+// It returns nil when there is nothing to report.
+func (a *aggregator) flush(now time.Time) []*sc.MetricValueSet {
+	a.lock.Lock()
+	values, start := a.values, a.start
+	a.values = make(map[string]*aggregate)
+	a.start = now
+	a.lock.Unlock()
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	startTime, endTime := formatTime(start), formatTime(now)
+	sets := make([]*sc.MetricValueSet, 0, len(values))
+	var set *sc.MetricValueSet
+	for _, k := range keys {
+		agg := values[k]
+		if set == nil || set.MetricName != agg.metricName {
+			set = &sc.MetricValueSet{MetricName: agg.metricName}
+			sets = append(sets, set)
+		}
+
+		mv := &sc.MetricValue{
+			StartTime: startTime,
+			EndTime:   endTime,
+			Labels:    agg.labels,
+		}
+		if agg.kind == config.GAUGE {
+			// gauges are a point in time.
+			mv.StartTime = endTime
+		}
+		if agg.isDouble {
+			v := agg.double
+			mv.DoubleValue = &v
+		} else {
+			v := agg.int64Value
+			mv.Int64Value = &v
+		}
+		set.MetricValues = append(set.MetricValues, mv)
+	}
+	return sets
+}
+
+// aggregateKey identifies the aggregate of a metric for a set of labels.
+// Keys of the same metric sort together.
+func aggregateKey(metricName string, labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for n := range labels {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	buf.WriteString(metricName)
+	for _, n := range names {
+		buf.WriteByte(0)
+		buf.WriteString(n)
+		buf.WriteByte('=')
+		buf.WriteString(labels[n])
+	}
+	return buf.String()
+}
+
+// metricLabels converts the dimensions of an instance to Service Control labels.
+func metricLabels(dims map[string]interface{}) map[string]string {
+	if len(dims) == 0 {
+		return nil
+	}
+	labels := make(map[string]string, len(dims))
+	for k, v := range dims {
+		labels[k] = fmt.Sprintf("%v", v)
+	}
+	return labels
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -15,74 +15,115 @@
 package svcctrl // import "istio.io/mixer/adapter/svcctrl"
 
 import (
-	"bytes"
 	"context"
+	"fmt"
+	"sync"
 	"time"
 
+	multierror "github.com/hashicorp/go-multierror"
 	"github.com/pborman/uuid"
 	sc "google.golang.org/api/servicecontrol/v1"
 
+	descriptor "istio.io/api/mixer/v1/config/descriptor"
 	"istio.io/mixer/adapter/svcctrl/config"
 	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/template/checknothing"
 	"istio.io/mixer/template/metric"
+	"istio.io/mixer/template/quota"
 )
 
+const defaultReportInterval = 10 * time.Second
+
 type handler struct {
-	serviceControlClient *sc.Service
-	env                  adapter.Env
-	configParams         *config.Params
+	client      *sc.Service
+	env         adapter.Env
+	serviceName string
+	consumerID  string
+	metrics     map[string]*config.Params_MetricInfo // metric instance name -> Service Control metric
+	quotas      map[string]string                    // quota instance name -> Service Control quota metric name
+	checkResult adapter.CheckResult
+	agg         *aggregator
+	now         func() time.Time
+
+	closeOnce sync.Once
+	done      chan struct{}
 }
 
-func (h *handler) HandleMetric(ctx context.Context, instances []*metric.Instance) error {
-	buf := bytes.NewBufferString("mixer-metric-report-id-")
-	_, err := buf.WriteString(uuid.New())
-	if err != nil {
-		return err
-	}
+var (
+	_ checknothing.Handler = &handler{}
+	_ metric.Handler       = &handler{}
+	_ quota.Handler        = &handler{}
+)
 
-	opID := buf.String()
-	reportReq, err := handleMetric(time.Now().Format(time.RFC3339Nano), opID)
-	if err != nil {
-		return err
+// HandleMetric accumulates the values of the instances, until they are reported.
+func (h *handler) HandleMetric(_ context.Context, instances []*metric.Instance) error {
+	var result *multierror.Error
+	for _, inst := range instances {
+		info, found := h.metrics[inst.Name]
+		if !found {
+			continue
+		}
+		if err := h.agg.add(info, metricLabels(inst.Dimensions), inst.Value); err != nil {
+			result = multierror.Append(result, err)
+		}
 	}
-	_, err = h.serviceControlClient.Services.Report(h.configParams.ServiceName, reportReq).Do()
-	return err
+	return result.ErrorOrNil()
 }
 
-func handleMetric(timeNow, opID string) (*sc.ReportRequest, error) {
+// report sends the values accumulated since the last report.
+func (h *handler) report() error {
+	sets := h.agg.flush(h.now())
+	if len(sets) == 0 {
+		return nil
+	}
+
 	op := &sc.Operation{
-		OperationId:   opID,
-		OperationName: "reportMetrics",
-		StartTime:     timeNow,
-		EndTime:       timeNow,
+		OperationId:     "mixer-metric-report-id-" + uuid.New(),
+		OperationName:   "reportMetrics",
+		ConsumerId:      h.consumerID,
+		StartTime:       sets[0].MetricValues[0].StartTime,
+		EndTime:         sets[0].MetricValues[0].EndTime,
+		MetricValueSets: sets,
 		Labels: map[string]string{
 			"cloud.googleapis.com/location": "global",
 		},
 	}
-
-	value := int64(1)
-	metricValue := sc.MetricValue{
-		StartTime:  timeNow,
-		EndTime:    timeNow,
-		Int64Value: &value,
+	resp, err := h.client.Services.Report(h.serviceName, &sc.ReportRequest{Operations: []*sc.Operation{op}}).Do()
+	if err != nil {
+		return fmt.Errorf("could not report metrics: %v", err)
 	}
-
-	op.MetricValueSets = []*sc.MetricValueSet{
-		{
-			MetricName:   "serviceruntime.googleapis.com/api/producer/request_count",
-			MetricValues: []*sc.MetricValue{&metricValue},
-		},
+	for _, e := range resp.ReportErrors {
+		if e.Status != nil {
+			return fmt.Errorf("could not report metrics: %s", e.Status.Message)
+		}
 	}
+	return nil
+}
 
-	reportReq := &sc.ReportRequest{
-		Operations: []*sc.Operation{op},
+// reportLoop reports on every tick, until the handler is closed.
+func (h *handler) reportLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := h.report(); err != nil {
+				_ = h.env.Logger().Errorf("%v", err)
+			}
+		case <-h.done:
+			return
+		}
 	}
-	return reportReq, nil
 }
 
+// Close stops the periodic reports, and reports what is left.
 func (h *handler) Close() error {
-	h.serviceControlClient = nil
-	return nil
+	var err error
+	h.closeOnce.Do(func() {
+		close(h.done)
+		err = h.report()
+	})
+	return err
 }
 
 ////////////////// Config //////////////////////////
@@ -94,10 +135,14 @@ func GetInfo() adapter.Info {
 		Impl:        "istio.io/mixer/adapter/svcctrl",
 		Description: "Interface to Google Service Control",
 		SupportedTemplates: []string{
+			checknothing.TemplateName,
 			metric.TemplateName,
+			quota.TemplateName,
 		},
 		DefaultConfig: &config.Params{
-			ServiceName: "library-example.sandbox.googleapis.com",
+			ServiceName:              "library-example.sandbox.googleapis.com",
+			ReportInterval:           defaultReportInterval,
+			CheckResultValidUseCount: 1000,
 		},
 
 		NewBuilder: func() adapter.HandlerBuilder { return &builder{} },
@@ -105,22 +150,84 @@ func GetInfo() adapter.Info {
 }
 
 type builder struct {
-	adapterConfig *config.Params
+	adapterConfig     *config.Params
+	metricTypes       map[string]*metric.Type
+	checkNothingTypes map[string]*checknothing.Type
 }
 
-func (*builder) SetMetricTypes(map[string]*metric.Type) {}
-func (b *builder) SetAdapterConfig(cfg adapter.Config)  { b.adapterConfig = cfg.(*config.Params) }
-func (*builder) Validate() (ce *adapter.ConfigErrors)   { return }
+func (b *builder) SetCheckNothingTypes(t map[string]*checknothing.Type) { b.checkNothingTypes = t }
+func (b *builder) SetMetricTypes(t map[string]*metric.Type)             { b.metricTypes = t }
+func (*builder) SetQuotaTypes(map[string]*quota.Type)                   {}
+func (b *builder) SetAdapterConfig(cfg adapter.Config)                  { b.adapterConfig = cfg.(*config.Params) }
 
-func (b *builder) Build(context context.Context, env adapter.Env) (adapter.Handler, error) {
-	client, err := createClient(env.Logger())
+func (b *builder) Validate() (ce *adapter.ConfigErrors) {
+	ac := b.adapterConfig
+	if ac.ServiceName == "" {
+		ce = ce.Appendf("serviceName", "service name must be specified")
+	}
+	if ac.ReportInterval < 0 {
+		ce = ce.Appendf("reportInterval", "report interval must be >= 0")
+	}
+	if ac.CheckResultValidDuration < 0 {
+		ce = ce.Appendf("checkResultValidDuration", "check result valid duration must be >= 0")
+	}
+	if ac.CheckResultValidUseCount < 0 {
+		ce = ce.Appendf("checkResultValidUseCount", "check result valid use count must be >= 0")
+	}
+	if ac.ConsumerId == "" && (len(b.checkNothingTypes) > 0 || len(ac.Quotas) > 0) {
+		ce = ce.Appendf("consumerId", "consumer id must be specified for checks and quotas")
+	}
+	for name, info := range ac.Metrics {
+		if info.Name == "" {
+			ce = ce.Appendf("metrics", "Service Control metric name must be specified for metric '%s'", name)
+		}
+		if t, found := b.metricTypes[name]; found {
+			switch t.Value {
+			case descriptor.INT64, descriptor.DOUBLE, descriptor.DURATION:
+			default:
+				ce = ce.Appendf("metrics", "metric '%s' has a %v value, it must be an int64, double or duration", name, t.Value)
+			}
+		}
+	}
+	for name, metricName := range ac.Quotas {
+		if metricName == "" {
+			ce = ce.Appendf("quotas", "Service Control quota metric name must be specified for quota '%s'", name)
+		}
+	}
+	return
+}
+
+func (b *builder) Build(ctx context.Context, env adapter.Env) (adapter.Handler, error) {
+	return b.buildWithClient(ctx, env, createClient)
+}
+
+func (b *builder) buildWithClient(_ context.Context, env adapter.Env, cc createClientFn) (adapter.Handler, error) {
+	ac := b.adapterConfig
+	client, err := cc(env.Logger(), ac.ServiceControlEndpoint)
 	if err != nil {
 		return nil, err
 	}
 
-	return &handler{
-		serviceControlClient: client,
-		env:                  env,
-		configParams:         b.adapterConfig,
-	}, nil
+	h := &handler{
+		client:      client,
+		env:         env,
+		serviceName: ac.ServiceName,
+		consumerID:  ac.ConsumerId,
+		metrics:     ac.Metrics,
+		quotas:      ac.Quotas,
+		checkResult: adapter.CheckResult{
+			ValidDuration: ac.CheckResultValidDuration,
+			ValidUseCount: ac.CheckResultValidUseCount,
+		},
+		agg:  newAggregator(time.Now()),
+		now:  time.Now,
+		done: make(chan struct{}),
+	}
+
+	interval := ac.ReportInterval
+	if interval == 0 {
+		interval = defaultReportInterval
+	}
+	env.ScheduleDaemon(func() { h.reportLoop(interval) })
+	return h, nil
 }
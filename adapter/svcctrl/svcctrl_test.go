@@ -15,51 +15,448 @@
 package svcctrl
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
-	"github.com/davecgh/go-spew/spew"
+	rpc "github.com/googleapis/googleapis/google/rpc"
 	sc "google.golang.org/api/servicecontrol/v1"
+
+	descriptor "istio.io/api/mixer/v1/config/descriptor"
+	"istio.io/mixer/adapter/svcctrl/config"
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/adapter/test"
+	"istio.io/mixer/template/checknothing"
+	"istio.io/mixer/template/metric"
+	"istio.io/mixer/template/quota"
 )
 
-func TestHandleMetric(t *testing.T) {
-	timeNow := time.Now().Format(time.RFC3339Nano)
-	request, err := handleMetric(timeNow, "")
+// fakeServiceControl stands in for the Service Control REST API, recording the requests it gets.
+type fakeServiceControl struct {
+	lock     sync.Mutex
+	reports  []*sc.ReportRequest
+	checks   []*sc.CheckRequest
+	allocs   []*sc.AllocateQuotaRequest
+	paths    []string
+	checkRsp *sc.CheckResponse
+	quotaRsp *sc.AllocateQuotaResponse
+}
+
+func (f *fakeServiceControl) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.paths = append(f.paths, r.URL.Path)
+	var rsp interface{}
+	var req interface{}
+	switch {
+	case strings.HasSuffix(r.URL.Path, ":report"):
+		rr := &sc.ReportRequest{}
+		f.reports = append(f.reports, rr)
+		req, rsp = rr, &sc.ReportResponse{}
+	case strings.HasSuffix(r.URL.Path, ":check"):
+		cr := &sc.CheckRequest{}
+		f.checks = append(f.checks, cr)
+		req, rsp = cr, f.checkRsp
+	case strings.HasSuffix(r.URL.Path, ":allocateQuota"):
+		ar := &sc.AllocateQuotaRequest{}
+		f.allocs = append(f.allocs, ar)
+		req, rsp = ar, f.quotaRsp
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rsp == nil {
+		rsp = struct{}{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(rsp)
+}
+
+// newTestHandler builds a handler talking to fake. The returned function closes the handler and the fake.
+func newTestHandler(t *testing.T, cfg *config.Params, fake *fakeServiceControl) (*handler, func()) {
+	srv := httptest.NewServer(fake)
 
+	b := GetInfo().NewBuilder().(*builder)
+	b.SetAdapterConfig(cfg)
+	h, err := b.buildWithClient(context.Background(), test.NewEnv(t), func(_ adapter.Logger, endpoint string) (*sc.Service, error) {
+		client, err := sc.New(http.DefaultClient)
+		if err != nil {
+			return nil, err
+		}
+		setEndpoint(client, srv.URL)
+		return client, nil
+	})
 	if err != nil {
-		t.Fatalf("handleMetric() failed with:%v", err)
-	}
-
-	metricValue := int64(1)
-	expected := &sc.ReportRequest{
-		Operations: []*sc.Operation{
-			{
-				OperationName: "reportMetrics",
-				StartTime:     timeNow,
-				EndTime:       timeNow,
-				Labels: map[string]string{
-					"cloud.googleapis.com/location": "global",
-				},
-				MetricValueSets: []*sc.MetricValueSet{
-					{
-						MetricName: "serviceruntime.googleapis.com/api/producer/request_count",
-						MetricValues: []*sc.MetricValue{
-							{
-								StartTime:  timeNow,
-								EndTime:    timeNow,
-								Int64Value: &metricValue,
-							},
-						},
-					},
-				},
+		srv.Close()
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	return h.(*handler), func() {
+		_ = h.Close()
+		srv.Close()
+	}
+}
+
+func TestReport(t *testing.T) {
+	cfg := &config.Params{
+		ServiceName: "example.googleapis.com",
+		ConsumerId:  "project:example",
+		Metrics: map[string]*config.Params_MetricInfo{
+			"requestcount": {Name: "serviceruntime.googleapis.com/api/producer/request_count", Kind: config.DELTA},
+			"latency":      {Name: "example.googleapis.com/latency", Kind: config.DELTA},
+			"connections":  {Name: "example.googleapis.com/connections", Kind: config.GAUGE},
+		},
+	}
+	fake := &fakeServiceControl{}
+	h, done := newTestHandler(t, cfg, fake)
+	defer done()
+
+	instances := []*metric.Instance{
+		{Name: "requestcount", Value: int64(1), Dimensions: map[string]interface{}{"code": 200}},
+		{Name: "requestcount", Value: int64(2), Dimensions: map[string]interface{}{"code": 200}},
+		{Name: "requestcount", Value: int64(1), Dimensions: map[string]interface{}{"code": 404}},
+		{Name: "latency", Value: 500 * time.Millisecond},
+		{Name: "latency", Value: time.Second},
+		{Name: "connections", Value: int64(10)},
+		{Name: "connections", Value: int64(7)},
+		{Name: "unmapped", Value: int64(1)},
+	}
+	if err := h.HandleMetric(context.Background(), instances); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+
+	if err := h.Close(); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	if err := h.Close(); err != nil {
+		t.Errorf("Got error %v, expecting the second close to be a no-op", err)
+	}
+
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+
+	if len(fake.reports) != 1 {
+		t.Fatalf("Got %d reports, expecting 1", len(fake.reports))
+	}
+	if want := "/v1/services/example.googleapis.com:report"; fake.paths[0] != want {
+		t.Errorf("Got path %s, expecting %s", fake.paths[0], want)
+	}
+	ops := fake.reports[0].Operations
+	if len(ops) != 1 {
+		t.Fatalf("Got %d operations, expecting 1", len(ops))
+	}
+	op := ops[0]
+	if op.ConsumerId != "project:example" || !strings.HasPrefix(op.OperationId, "mixer-metric-report-id-") {
+		t.Errorf("Got operation %s for %s, expecting a report for project:example", op.OperationId, op.ConsumerId)
+	}
+
+	got := make(map[string][]*sc.MetricValue)
+	for _, set := range op.MetricValueSets {
+		got[set.MetricName] = set.MetricValues
+	}
+	if len(got) != 3 {
+		t.Fatalf("Got %d metrics, expecting 3: %v", len(got), got)
+	}
+
+	counts := got["serviceruntime.googleapis.com/api/producer/request_count"]
+	if len(counts) != 2 {
+		t.Fatalf("Got %d request counts, expecting one per code", len(counts))
+	}
+	for _, mv := range counts {
+		want := int64(3)
+		if mv.Labels["code"] == "404" {
+			want = 1
+		}
+		if mv.Int64Value == nil || *mv.Int64Value != want {
+			t.Errorf("Got request count %v for %v, expecting %d", mv.Int64Value, mv.Labels, want)
+		}
+	}
+
+	latency := got["example.googleapis.com/latency"]
+	if len(latency) != 1 || latency[0].DoubleValue == nil || *latency[0].DoubleValue != 1.5 {
+		t.Errorf("Got latency %v, expecting a single 1.5s value", latency)
+	}
+
+	conns := got["example.googleapis.com/connections"]
+	if len(conns) != 1 || conns[0].Int64Value == nil || *conns[0].Int64Value != 7 {
+		t.Errorf("Got connections %v, expecting the last value 7", conns)
+	} else if conns[0].StartTime != conns[0].EndTime {
+		t.Errorf("Got gauge interval %s - %s, expecting a point in time", conns[0].StartTime, conns[0].EndTime)
+	}
+}
+
+func TestReport_Nothing(t *testing.T) {
+	fake := &fakeServiceControl{}
+	h, done := newTestHandler(t, &config.Params{ServiceName: "example.googleapis.com"}, fake)
+	defer done()
+
+	if err := h.Close(); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+
+	fake.lock.Lock()
+	defer fake.lock.Unlock()
+	if len(fake.paths) != 0 {
+		t.Errorf("Got requests %v, expecting none when there is nothing to report", fake.paths)
+	}
+}
+
+func TestHandleMetric_BadValue(t *testing.T) {
+	cfg := &config.Params{
+		ServiceName: "example.googleapis.com",
+		Metrics:     map[string]*config.Params_MetricInfo{"m": {Name: "example.googleapis.com/m"}},
+	}
+	h, done := newTestHandler(t, cfg, &fakeServiceControl{})
+	defer done()
+
+	if err := h.HandleMetric(context.Background(), []*metric.Instance{{Name: "m", Value: "string"}}); err == nil {
+		t.Error("Got success, expecting an error for a string value")
+	}
+}
+
+func TestHandleCheckNothing(t *testing.T) {
+	cases := []struct {
+		name string
+		rsp  *sc.CheckResponse
+		code rpc.Code
+	}{
+		{"ok", &sc.CheckResponse{}, rpc.OK},
+		{"not found", &sc.CheckResponse{CheckErrors: []*sc.CheckError{{Code: "PROJECT_DELETED"}}}, rpc.NOT_FOUND},
+		{"invalid key", &sc.CheckResponse{CheckErrors: []*sc.CheckError{{Code: "API_KEY_INVALID"}}}, rpc.INVALID_ARGUMENT},
+		{"unavailable", &sc.CheckResponse{CheckErrors: []*sc.CheckError{{Code: "SERVICE_STATUS_UNAVAILABLE"}}}, rpc.UNAVAILABLE},
+		{"denied", &sc.CheckResponse{CheckErrors: []*sc.CheckError{{Code: "SERVICE_NOT_ACTIVATED", Detail: "not activated"}}}, rpc.PERMISSION_DENIED},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := &config.Params{
+				ServiceName:              "example.googleapis.com",
+				ConsumerId:               "project:example",
+				CheckResultValidDuration: 5 * time.Second,
+				CheckResultValidUseCount: 100,
+			}
+			fake := &fakeServiceControl{checkRsp: c.rsp}
+			h, done := newTestHandler(t, cfg, fake)
+			defer done()
+
+			result, err := h.HandleCheckNothing(context.Background(), &checknothing.Instance{Name: "check"})
+			if err != nil {
+				t.Fatalf("Got error %v, expecting success", err)
+			}
+			if result.Status.Code != int32(c.code) {
+				t.Errorf("Got code %v, expecting %v", rpc.Code(result.Status.Code), c.code)
+			}
+			if result.ValidDuration != 5*time.Second || result.ValidUseCount != 100 {
+				t.Errorf("Got result valid for %v, %d uses, expecting 5s, 100 uses", result.ValidDuration, result.ValidUseCount)
+			}
+
+			fake.lock.Lock()
+			defer fake.lock.Unlock()
+			if len(fake.checks) != 1 || fake.checks[0].Operation.ConsumerId != "project:example" {
+				t.Errorf("Got checks %v, expecting one for project:example", fake.checks)
+			}
+		})
+	}
+}
+
+func TestHandleQuota(t *testing.T) {
+	used := func(v int64) *sc.AllocateQuotaResponse {
+		return &sc.AllocateQuotaResponse{
+			QuotaMetrics: []*sc.MetricValueSet{
+				{MetricName: quotaUsedMetric, MetricValues: []*sc.MetricValue{{Int64Value: &v}}},
+			},
+		}
+	}
+
+	cases := []struct {
+		name     string
+		instance string
+		args     adapter.QuotaArgs
+		rsp      *sc.AllocateQuotaResponse
+		amount   int64
+		code     rpc.Code
+		requests int
+	}{
+		{"granted", "requests", adapter.QuotaArgs{QuotaAmount: 5}, &sc.AllocateQuotaResponse{}, 5, rpc.OK, 1},
+		{"best effort", "requests", adapter.QuotaArgs{QuotaAmount: 5, BestEffort: true}, used(3), 3, rpc.OK, 1},
+		{"exhausted", "requests", adapter.QuotaArgs{QuotaAmount: 5},
+			&sc.AllocateQuotaResponse{AllocateErrors: []*sc.QuotaError{{Code: "RESOURCE_EXHAUSTED"}}}, 0, rpc.RESOURCE_EXHAUSTED, 1},
+		{"unmapped", "other", adapter.QuotaArgs{QuotaAmount: 5}, nil, 5, rpc.OK, 0},
+		{"release", "requests", adapter.QuotaArgs{QuotaAmount: -5}, nil, -5, rpc.OK, 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg := &config.Params{
+				ServiceName: "example.googleapis.com",
+				ConsumerId:  "project:example",
+				Quotas:      map[string]string{"requests": "example.googleapis.com/requests"},
+			}
+			fake := &fakeServiceControl{quotaRsp: c.rsp}
+			h, done := newTestHandler(t, cfg, fake)
+			defer done()
+
+			args := c.args
+			args.DeduplicationID = "dedup"
+			result, err := h.HandleQuota(context.Background(), &quota.Instance{Name: c.instance}, args)
+			if err != nil {
+				t.Fatalf("Got error %v, expecting success", err)
+			}
+			if result.Amount != c.amount {
+				t.Errorf("Got amount %d, expecting %d", result.Amount, c.amount)
+			}
+			if result.Status.Code != int32(c.code) {
+				t.Errorf("Got code %v, expecting %v", rpc.Code(result.Status.Code), c.code)
+			}
+
+			fake.lock.Lock()
+			defer fake.lock.Unlock()
+			if len(fake.allocs) != c.requests {
+				t.Fatalf("Got %d allocations, expecting %d", len(fake.allocs), c.requests)
+			}
+			if c.requests == 0 {
+				return
+			}
+
+			op := fake.allocs[0].AllocateOperation
+			mode := quotaModeNormal
+			if c.args.BestEffort {
+				mode = quotaModeBestEffort
+			}
+			if op.OperationId != "dedup" || op.QuotaMode != mode {
+				t.Errorf("Got operation %s in mode %s, expecting dedup in mode %s", op.OperationId, op.QuotaMode, mode)
+			}
+			if len(op.QuotaMetrics) != 1 || op.QuotaMetrics[0].MetricName != "example.googleapis.com/requests" {
+				t.Errorf("Got quota metrics %v, expecting example.googleapis.com/requests", op.QuotaMetrics)
+			}
+		})
+	}
+}
+
+func TestCanceledContext(t *testing.T) {
+	cfg := &config.Params{
+		ServiceName: "example.googleapis.com",
+		ConsumerId:  "project:example",
+		Quotas:      map[string]string{"requests": "example.googleapis.com/requests"},
+	}
+	h, done := newTestHandler(t, cfg, &fakeServiceControl{})
+	defer done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := h.HandleCheckNothing(ctx, &checknothing.Instance{Name: "check"}); err == nil {
+		t.Error("Got success checking with a canceled context, expecting failure")
+	}
+	if _, err := h.HandleQuota(ctx, &quota.Instance{Name: "requests"}, adapter.QuotaArgs{QuotaAmount: 1}); err == nil {
+		t.Error("Got success allocating with a canceled context, expecting failure")
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	start := time.Date(2017, 9, 1, 10, 0, 0, 0, time.UTC)
+	a := newAggregator(start)
+
+	delta := &config.Params_MetricInfo{Name: "delta", Kind: config.DELTA}
+	gauge := &config.Params_MetricInfo{Name: "gauge", Kind: config.GAUGE}
+
+	for _, v := range []interface{}{int64(1), 0.5, int64(2)} {
+		if err := a.add(delta, nil, v); err != nil {
+			t.Fatalf("Got error %v, expecting success", err)
+		}
+	}
+	for _, v := range []interface{}{2.5, int64(4)} {
+		if err := a.add(gauge, map[string]string{"a": "b"}, v); err != nil {
+			t.Fatalf("Got error %v, expecting success", err)
+		}
+	}
+
+	end := start.Add(time.Minute)
+	sets := a.flush(end)
+
+	d, i := 3.5, int64(4)
+	want := []*sc.MetricValueSet{
+		{
+			MetricName: "delta",
+			MetricValues: []*sc.MetricValue{
+				{StartTime: formatTime(start), EndTime: formatTime(end), DoubleValue: &d},
+			},
+		},
+		{
+			MetricName: "gauge",
+			MetricValues: []*sc.MetricValue{
+				{StartTime: formatTime(end), EndTime: formatTime(end), Labels: map[string]string{"a": "b"}, Int64Value: &i},
 			},
 		},
 	}
+	if !reflect.DeepEqual(sets, want) {
+		gotJSON, _ := json.Marshal(sets)
+		wantJSON, _ := json.Marshal(want)
+		t.Errorf("Got %s, expecting %s", gotJSON, wantJSON)
+	}
+
+	if sets = a.flush(end.Add(time.Minute)); sets != nil {
+		t.Errorf("Got %v, expecting nothing after a flush", sets)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name         string
+		cfg          config.Params
+		metricTypes  map[string]*metric.Type
+		checkNothing map[string]*checknothing.Type
+		field        string
+	}{
+		{"default", *GetInfo().DefaultConfig.(*config.Params), nil, nil, ""},
+		{"no service", config.Params{}, nil, nil, "serviceName"},
+		{"negative interval", config.Params{ServiceName: "s", ReportInterval: -time.Second}, nil, nil, "reportInterval"},
+		{"negative duration", config.Params{ServiceName: "s", CheckResultValidDuration: -time.Second}, nil, nil, "checkResultValidDuration"},
+		{"negative count", config.Params{ServiceName: "s", CheckResultValidUseCount: -1}, nil, nil, "checkResultValidUseCount"},
+		{"check without consumer", config.Params{ServiceName: "s"}, nil, map[string]*checknothing.Type{"c": {}}, "consumerId"},
+		{"quota without consumer", config.Params{ServiceName: "s", Quotas: map[string]string{"q": "m"}}, nil, nil, "consumerId"},
+		{"quota without metric", config.Params{ServiceName: "s", ConsumerId: "c", Quotas: map[string]string{"q": ""}}, nil, nil, "quotas"},
+		{"metric without name", config.Params{ServiceName: "s",
+			Metrics: map[string]*config.Params_MetricInfo{"m": {}}}, nil, nil, "metrics"},
+		{"string metric", config.Params{ServiceName: "s",
+			Metrics: map[string]*config.Params_MetricInfo{"m": {Name: "n"}}},
+			map[string]*metric.Type{"m": {Value: descriptor.STRING}}, nil, "metrics"},
+		{"duration metric", config.Params{ServiceName: "s",
+			Metrics: map[string]*config.Params_MetricInfo{"m": {Name: "n"}}},
+			map[string]*metric.Type{"m": {Value: descriptor.DURATION}}, nil, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			b := GetInfo().NewBuilder().(*builder)
+			cfg := c.cfg
+			b.SetAdapterConfig(&cfg)
+			b.SetMetricTypes(c.metricTypes)
+			b.SetCheckNothingTypes(c.checkNothing)
 
-	cfg := spew.NewDefaultConfig()
-	cfg.DisablePointerAddresses = true
-	if !reflect.DeepEqual(*expected, *request) {
-		t.Errorf("expect op1 == op2, but op1 = %v, op2 = %v", cfg.Sdump(*expected), cfg.Sdump(*request))
+			ce := b.Validate()
+			if c.field == "" {
+				if ce != nil {
+					t.Errorf("Got error %v, expecting success", ce)
+				}
+				return
+			}
+			if ce == nil {
+				t.Fatalf("Got success, expecting an error for %s", c.field)
+			}
+			if !strings.Contains(ce.Error(), c.field) {
+				t.Errorf("Got error %v, expecting one for %s", ce, c.field)
+			}
+		})
 	}
 }
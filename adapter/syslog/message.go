@@ -0,0 +1,198 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syslog
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// nilValue stands for a header field or structured data without a value.
+	nilValue = "-"
+
+	// timestampFormat is the RFC 3339 format of RFC 5424 timestamps, which have at most 6 fractional digits.
+	timestampFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+	maxHostnameLen  = 255
+	maxAppNameLen   = 48
+	maxProcIDLen    = 128
+	maxMsgIDLen     = 32
+	maxSDNameLen    = 32
+	sdNameForbidden = "= ]\""
+)
+
+// header holds the parts of a message that come before its structured data.
+type header struct {
+	priority  int
+	timestamp time.Time
+	hostname  string
+	appName   string
+	procID    string
+	msgID     string
+}
+
+// format builds an RFC 5424 message:
+//
+//	<PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG]
+func format(h header, sdID string, params map[string]interface{}, msg string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %s %s ",
+		h.priority,
+		h.timestamp.Format(timestampFormat),
+		toHeaderField(h.hostname, maxHostnameLen),
+		toHeaderField(h.appName, maxAppNameLen),
+		toHeaderField(h.procID, maxProcIDLen),
+		toHeaderField(h.msgID, maxMsgIDLen))
+
+	writeStructuredData(&b, sdID, params)
+
+	if msg != "" {
+		b.WriteByte(' ')
+		b.WriteString(msg)
+	}
+	return b.Bytes()
+}
+
+// writeStructuredData writes the params, sorted by name, as a single structured data element.
+func writeStructuredData(b *bytes.Buffer, sdID string, params map[string]interface{}) {
+	if len(params) == 0 {
+		b.WriteString(nilValue)
+		return
+	}
+
+	names := make([]string, 0, len(params))
+	for k := range params {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	b.WriteByte('[')
+	b.WriteString(sdID)
+	for _, k := range names {
+		b.WriteByte(' ')
+		b.WriteString(toSDName(k))
+		b.WriteString(`="`)
+		writeParamValue(b, toString(params[k]))
+		b.WriteByte('"')
+	}
+	b.WriteByte(']')
+}
+
+// writeParamValue writes the value with the characters structured data reserves escaped.
+func writeParamValue(b *bytes.Buffer, v string) {
+	for _, c := range v {
+		if c == '"' || c == '\\' || c == ']' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(c)
+	}
+}
+
+// toHeaderField returns the value truncated to the length of its header field, with the characters header
+// fields forbid dropped, or the nil value when nothing is left.
+func toHeaderField(v string, maxLen int) string {
+	v = strings.Map(func(c rune) rune {
+		if isPrintASCII(c) {
+			return c
+		}
+		return -1
+	}, v)
+	if len(v) > maxLen {
+		v = v[:maxLen]
+	}
+	if v == "" {
+		return nilValue
+	}
+	return v
+}
+
+// validHeaderField returns whether the value can be sent as is in a header field of the given length.
+func validHeaderField(v string, maxLen int) bool {
+	if len(v) > maxLen {
+		return false
+	}
+	for _, c := range v {
+		if !isPrintASCII(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// toSDName returns the name truncated to the length of SD-IDs and PARAM-NAMEs, with the characters they
+// forbid replaced by underscores.
+func toSDName(name string) string {
+	name = strings.Map(func(c rune) rune {
+		if !isPrintASCII(c) || strings.ContainsRune(sdNameForbidden, c) {
+			return '_'
+		}
+		return c
+	}, name)
+	if len(name) > maxSDNameLen {
+		name = name[:maxSDNameLen]
+	}
+	if name == "" {
+		return "_"
+	}
+	return name
+}
+
+// validSDID returns an error when the id is not a valid SD-ID for a custom structured data element.
+func validSDID(id string) error {
+	if id == "" {
+		return fmt.Errorf("missing SD-ID")
+	}
+	if len(id) > maxSDNameLen {
+		return fmt.Errorf("'%s' is longer than %d characters", id, maxSDNameLen)
+	}
+	if toSDName(id) != id {
+		return fmt.Errorf("'%s' contains a space, '=', ']', '\"' or a non printable character", id)
+	}
+	if at := strings.IndexByte(id, '@'); at <= 0 || at == len(id)-1 {
+		return fmt.Errorf("'%s' is not of the form name@<private enterprise number>", id)
+	}
+	return nil
+}
+
+func isPrintASCII(c rune) bool {
+	return c >= 33 && c <= 126
+}
+
+// toString converts a variable to the value of a structured data param.
+func toString(v interface{}) string {
+	switch vt := v.(type) {
+	case string:
+		return vt
+	case int64:
+		return strconv.FormatInt(vt, 10)
+	case time.Time:
+		return vt.Format(time.RFC3339Nano)
+	case []byte:
+		// 4 and 16 byte values are IP addresses, other bytes are encoded as base64.
+		if len(vt) == net.IPv4len || len(vt) == net.IPv6len {
+			return net.IP(vt).String()
+		}
+		return base64.StdEncoding.EncodeToString(vt)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
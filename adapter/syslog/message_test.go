@@ -0,0 +1,104 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syslog
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func mustParse(t *testing.T, text string) *template.Template {
+	tmpl, err := template.New("message").Parse(text)
+	if err != nil {
+		t.Fatalf("Unable to parse template '%s': %v", text, err)
+	}
+	return tmpl
+}
+
+func TestFormat(t *testing.T) {
+	ts := time.Date(2017, time.October, 1, 12, 0, 0, 123456789, time.FixedZone("PDT", -7*60*60))
+
+	tests := []struct {
+		name   string
+		hdr    header
+		params map[string]interface{}
+		msg    string
+		want   string
+	}{
+		{"nil values", header{priority: 134, timestamp: ts}, nil, "",
+			"<134>1 2017-10-01T12:00:00.123456-07:00 - - - - -"},
+		{"header", header{priority: 0, timestamp: ts, hostname: "host", appName: "app", procID: "12", msgID: "id"}, nil, "msg",
+			"<0>1 2017-10-01T12:00:00.123456-07:00 host app 12 id - msg"},
+		{"sanitized header", header{priority: 191, timestamp: ts, hostname: "my host", msgID: strings.Repeat("m", 40)}, nil, "",
+			"<191>1 2017-10-01T12:00:00.123456-07:00 myhost - - " + strings.Repeat("m", 32) + " -"},
+		{"params", header{timestamp: ts}, map[string]interface{}{
+			"b":                     `q"uote\`,
+			"a":                     1.5,
+			"x=y":                   true,
+			strings.Repeat("n", 40): time.Duration(0),
+			"t":                     time.Date(2017, time.October, 1, 0, 0, 0, 0, time.UTC),
+		}, "",
+			`<0>1 2017-10-01T12:00:00.123456-07:00 - - - - [id@1 a="1.5" b="q\"uote\\" ` + strings.Repeat("n", 32) +
+				`="0s" t="2017-10-01T00:00:00Z" x_y="true"]`},
+		{"bytes", header{timestamp: ts}, map[string]interface{}{
+			"ip":     []byte{10, 0, 0, 1},
+			"ipv6":   []byte(net.ParseIP("::1")),
+			"digest": []byte{1, 2, 3},
+		}, "",
+			`<0>1 2017-10-01T12:00:00.123456-07:00 - - - - [id@1 digest="AQID" ip="10.0.0.1" ipv6="::1"]`},
+	}
+
+	for idx, tt := range tests {
+		t.Run(fmt.Sprintf("[%d] %s", idx, tt.name), func(t *testing.T) {
+			if got := string(format(tt.hdr, "id@1", tt.params, tt.msg)); got != tt.want {
+				t.Errorf("Got\n%s\nexpecting\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidSDID(t *testing.T) {
+	tests := []struct {
+		id  string
+		err string
+	}{
+		{"mixer@32473", ""},
+		{"", "missing"},
+		{strings.Repeat("a", 31) + "@1", "longer than 32"},
+		{"mi xer@1", "contains a space"},
+		{"mixer", "not of the form"},
+		{"@1", "not of the form"},
+		{"mixer@", "not of the form"},
+	}
+
+	for idx, tt := range tests {
+		t.Run(fmt.Sprintf("[%d] %s", idx, tt.id), func(t *testing.T) {
+			err := validSDID(tt.id)
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("Got error %v, expecting success", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Got error %v, expecting one containing '%s'", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,296 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package syslog provides an adapter that sends logentry instances to a syslog server as RFC 5424 messages.
+package syslog // import "istio.io/mixer/adapter/syslog"
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/mixer/adapter/syslog/config"
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/pool"
+	"istio.io/mixer/template/logentry"
+)
+
+const (
+	defaultTimeout   = 5 * time.Second
+	defaultQueueSize = 1000
+)
+
+type handler struct {
+	facility       config.Params_Facility
+	severityLevels map[string]config.Params_Severity
+	hostname       string
+	appName        string
+	procID         string
+	sdID           string
+	msgTmpl        *template.Template
+	now            func() time.Time // used to control time in tests
+	w              *writer
+	closeOnce      sync.Once
+}
+
+var _ logentry.Handler = &handler{}
+
+// HandleLogEntry queues a message for each log entry, to be sent to the syslog server.
+func (h *handler) HandleLogEntry(_ context.Context, instances []*logentry.Instance) error {
+	var result *multierror.Error
+	dropped := 0
+	for _, instance := range instances {
+		msg, err := h.toMessage(instance)
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("could not send log entry %s: %v", instance.Name, err))
+			continue
+		}
+		if !h.w.enqueue(msg) {
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		result = multierror.Append(result, fmt.Errorf("syslog queue is full, dropped %d log entries", dropped))
+	}
+	return result.ErrorOrNil()
+}
+
+func (h *handler) toMessage(instance *logentry.Instance) ([]byte, error) {
+	var msg string
+	if h.msgTmpl != nil {
+		buf := pool.GetBuffer()
+		err := h.msgTmpl.Execute(buf, instance)
+		msg = buf.String()
+		pool.PutBuffer(buf)
+		if err != nil {
+			return nil, fmt.Errorf("could not compute the message: %v", err)
+		}
+	}
+
+	ts := instance.Timestamp
+	if ts.IsZero() {
+		ts = h.now()
+	}
+
+	hdr := header{
+		priority:  int(h.facility)*8 + int(h.mapSeverityLevel(instance.Severity)),
+		timestamp: ts,
+		hostname:  h.hostname,
+		appName:   h.appName,
+		procID:    h.procID,
+		msgID:     instance.Name,
+	}
+	return format(hdr, h.sdID, instance.Variables, msg), nil
+}
+
+func (h *handler) mapSeverityLevel(severity string) config.Params_Severity {
+	level, ok := h.severityLevels[severity]
+	if !ok {
+		level = config.INFO
+	}
+
+	return level
+}
+
+func (h *handler) Close() error {
+	var err error
+	h.closeOnce.Do(func() {
+		h.w.stop()
+		err = h.w.Close()
+	})
+	return err
+}
+
+////////////////// Config //////////////////////////
+
+// GetInfo returns the Info associated with this adapter implementation.
+func GetInfo() adapter.Info {
+	return adapter.Info{
+		Name:        "syslog",
+		Impl:        "istio.io/mixer/adapter/syslog",
+		Description: "Sends logs to a syslog server as RFC 5424 messages",
+		SupportedTemplates: []string{
+			logentry.TemplateName,
+		},
+		DefaultConfig: &config.Params{
+			Network:  config.UDP,
+			Address:  "localhost:514",
+			Facility: config.LOCAL0,
+			SeverityLevels: map[string]config.Params_Severity{
+				"EMERGENCY": config.EMERGENCY,
+				"ALERT":     config.ALERT,
+				"CRITICAL":  config.CRITICAL,
+				"ERROR":     config.ERROR,
+				"WARNING":   config.WARNING,
+				"NOTICE":    config.NOTICE,
+				"INFO":      config.INFO,
+				"DEBUG":     config.DEBUG,
+			},
+			AppName: "istio-mixer",
+			// 32473 is the enterprise number RFC 5612 reserves for documentation, deployments should use their own.
+			StructuredDataId: "mixer@32473",
+			Timeout:          defaultTimeout,
+			QueueSize:        defaultQueueSize,
+		},
+
+		NewBuilder: func() adapter.HandlerBuilder { return &builder{} },
+	}
+}
+
+type builder struct {
+	adapterConfig *config.Params
+}
+
+func (*builder) SetLogEntryTypes(map[string]*logentry.Type) {}
+func (b *builder) SetAdapterConfig(cfg adapter.Config)      { b.adapterConfig = cfg.(*config.Params) }
+
+func (b *builder) Validate() (ce *adapter.ConfigErrors) {
+	ac := b.adapterConfig
+	if ac.Address == "" {
+		ce = ce.Appendf("address", "address must be specified")
+	} else if ac.Network != config.UNIX {
+		if _, _, err := net.SplitHostPort(ac.Address); err != nil {
+			ce = ce.Appendf("address", "invalid address '%s': %v", ac.Address, err)
+		}
+	}
+	if _, ok := config.Params_Facility_name[int32(ac.Facility)]; !ok {
+		ce = ce.Appendf("facility", "unknown facility %d", ac.Facility)
+	}
+	for k, v := range ac.SeverityLevels {
+		if _, ok := config.Params_Severity_name[int32(v)]; !ok {
+			ce = ce.Appendf("severityLevels", "unknown severity %d for '%s'", v, k)
+		}
+	}
+	if !validHeaderField(ac.AppName, maxAppNameLen) {
+		ce = ce.Appendf("appName", "app name must be at most %d printable ASCII characters", maxAppNameLen)
+	}
+	if !validHeaderField(ac.Hostname, maxHostnameLen) {
+		ce = ce.Appendf("hostname", "hostname must be at most %d printable ASCII characters", maxHostnameLen)
+	}
+	if err := validSDID(ac.StructuredDataId); err != nil {
+		ce = ce.Appendf("structuredDataId", "invalid structured data ID: %v", err)
+	}
+	if _, err := template.New("message").Parse(ac.MessageTemplate); err != nil {
+		ce = ce.Appendf("messageTemplate", "failed to parse message template '%s': %v", ac.MessageTemplate, err)
+	}
+	if ac.CaFile != "" && ac.Network != config.TLS {
+		ce = ce.Appendf("caFile", "certificate authorities can only be specified for TLS")
+	}
+	if ac.Timeout < 0 {
+		ce = ce.Appendf("timeout", "timeout must be >= 0")
+	}
+	if ac.QueueSize < 0 {
+		ce = ce.Appendf("queueSize", "queue size must be >= 0")
+	}
+	return
+}
+
+func (b *builder) Build(_ context.Context, env adapter.Env) (adapter.Handler, error) {
+	ac := b.adapterConfig
+
+	w := &writer{
+		address: ac.Address,
+		timeout: ac.Timeout,
+		l:       env.Logger(),
+		getTime: time.Now,
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if w.timeout == 0 {
+		w.timeout = defaultTimeout
+	}
+	queueSize := int(ac.QueueSize)
+	if queueSize == 0 {
+		queueSize = defaultQueueSize
+	}
+	w.queue = make(chan []byte, queueSize)
+	switch ac.Network {
+	case config.TCP:
+		w.network = "tcp"
+	case config.TLS:
+		w.network = "tcp"
+		tc, err := newTLSConfig(ac)
+		if err != nil {
+			return nil, err
+		}
+		w.tls = tc
+	case config.UNIX:
+		w.network = "unixgram"
+	default:
+		w.network = "udp"
+	}
+
+	var msgTmpl *template.Template
+	if ac.MessageTemplate != "" {
+		var err error
+		if msgTmpl, err = template.New("message").Parse(ac.MessageTemplate); err != nil {
+			return nil, fmt.Errorf("failed to parse message template '%s': %v", ac.MessageTemplate, err)
+		}
+	}
+
+	hostname := ac.Hostname
+	if hostname == "" {
+		var err error
+		if hostname, err = os.Hostname(); err != nil {
+			env.Logger().Warningf("could not get the hostname, sending messages without one: %v", err)
+		}
+	}
+
+	env.ScheduleDaemon(w.run)
+
+	return &handler{
+		facility:       ac.Facility,
+		severityLevels: ac.SeverityLevels,
+		hostname:       hostname,
+		appName:        ac.AppName,
+		procID:         strconv.Itoa(os.Getpid()),
+		sdID:           ac.StructuredDataId,
+		msgTmpl:        msgTmpl,
+		now:            time.Now,
+		w:              w,
+	}, nil
+}
+
+// newTLSConfig returns the configuration of TLS connections, verifying the server with the configured certificate
+// authorities, if any.
+func newTLSConfig(ac *config.Params) (*tls.Config, error) {
+	host, _, err := net.SplitHostPort(ac.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address '%s': %v", ac.Address, err)
+	}
+	tc := &tls.Config{ServerName: host}
+	if ac.CaFile == "" {
+		return tc, nil
+	}
+
+	pem, err := ioutil.ReadFile(ac.CaFile)
+	if err != nil {
+		return nil, fmt.Errorf("could not read certificate authorities: %v", err)
+	}
+	tc.RootCAs = x509.NewCertPool()
+	if !tc.RootCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate authority found in %s", ac.CaFile)
+	}
+	return tc, nil
+}
@@ -0,0 +1,404 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"istio.io/mixer/adapter/syslog/config"
+	"istio.io/mixer/pkg/adapter/test"
+	"istio.io/mixer/template/logentry"
+)
+
+// listener is a local syslog server, delivering the messages it receives on a channel.
+type listener struct {
+	addr  string
+	msgs  chan string
+	close func()
+}
+
+func (l *listener) next(t *testing.T) string {
+	select {
+	case m := <-l.msgs:
+		return m
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the listener to receive a message")
+		return ""
+	}
+}
+
+// listenPacket receives a message per datagram.
+func listenPacket(t *testing.T, network, address string) *listener {
+	c, err := net.ListenPacket(network, address)
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	l := &listener{addr: c.LocalAddr().String(), msgs: make(chan string, 10), close: func() { _ = c.Close() }}
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, _, err := c.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			l.msgs <- string(buf[:n])
+		}
+	}()
+	return l
+}
+
+// listenStream receives messages framed by octet counting, over TLS when tc is set.
+func listenStream(t *testing.T, tc *tls.Config) *listener {
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	if tc != nil {
+		nl = tls.NewListener(nl, tc)
+	}
+	l := &listener{addr: nl.Addr().String(), msgs: make(chan string, 10), close: func() { _ = nl.Close() }}
+	go func() {
+		for {
+			conn, err := nl.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				r := bufio.NewReader(conn)
+				for {
+					length, err := r.ReadString(' ')
+					if err != nil {
+						return
+					}
+					n, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+					if err != nil {
+						l.msgs <- "bad frame: " + length
+						return
+					}
+					msg := make([]byte, n)
+					if _, err := io.ReadFull(r, msg); err != nil {
+						return
+					}
+					l.msgs <- string(msg)
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+// newCertificate returns a self-signed certificate for 127.0.0.1, and the file its PEM encoding is written to.
+func newCertificate(t *testing.T, dir string) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{Organization: []string{"syslog test"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unable to create certificate: %v", err)
+	}
+
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatalf("Unable to write certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, caFile
+}
+
+func newHandler(t *testing.T, cfg *config.Params) *handler {
+	b := GetInfo().NewBuilder().(*builder)
+	b.SetAdapterConfig(cfg)
+	b.SetLogEntryTypes(map[string]*logentry.Type{"access": {}})
+	if err := b.Validate(); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	h, err := b.Build(context.Background(), test.NewEnv(t))
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	return h.(*handler)
+}
+
+func TestBasic(t *testing.T) {
+	info := GetInfo()
+	if !reflect.DeepEqual(info.SupportedTemplates, []string{logentry.TemplateName}) {
+		t.Errorf("Got templates %v, expecting %s", info.SupportedTemplates, logentry.TemplateName)
+	}
+
+	h := newHandler(t, info.DefaultConfig.(*config.Params))
+	if h.hostname == "" {
+		t.Error("Got no hostname, expecting the name of the host")
+	}
+	if err := h.Close(); err != nil {
+		t.Errorf("Got error %v, expecting success", err)
+	}
+}
+
+func TestHandleLogEntry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syslog")
+	if err != nil {
+		t.Fatalf("Unable to create a temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	cert, caFile := newCertificate(t, dir)
+
+	tests := []struct {
+		network config.Params_Network
+		listen  func() *listener
+		caFile  string
+	}{
+		{config.UDP, func() *listener { return listenPacket(t, "udp", "127.0.0.1:0") }, ""},
+		{config.TCP, func() *listener { return listenStream(t, nil) }, ""},
+		{config.TLS, func() *listener { return listenStream(t, &tls.Config{Certificates: []tls.Certificate{cert}}) }, caFile},
+		{config.UNIX, func() *listener { return listenPacket(t, "unixgram", filepath.Join(dir, "log.sock")) }, ""},
+	}
+
+	ts := time.Date(2017, time.October, 1, 12, 0, 0, 0, time.UTC)
+	entries := []*logentry.Instance{
+		{Name: "access", Severity: "WARNING", Timestamp: ts, Variables: map[string]interface{}{
+			"method":    "GET",
+			"url":       "/a]b",
+			"code":      int64(200),
+			"source ip": []byte{10, 0, 0, 1},
+		}},
+		{Name: "audit", Severity: "unknown", Timestamp: ts, Variables: map[string]interface{}{
+			"method": "DELETE",
+			"url":    "/x",
+		}},
+	}
+	pid := os.Getpid()
+	want := []string{
+		fmt.Sprintf(`<84>1 2017-10-01T12:00:00.000000Z host istio-mixer %d access `+
+			`[mixer@32473 code="200" method="GET" source_ip="10.0.0.1" url="/a\]b"] GET /a]b`, pid),
+		fmt.Sprintf(`<86>1 2017-10-01T12:00:00.000000Z host istio-mixer %d audit `+
+			`[mixer@32473 method="DELETE" url="/x"] DELETE /x`, pid),
+	}
+
+	for idx, tt := range tests {
+		t.Run(fmt.Sprintf("[%d] %v", idx, tt.network), func(t *testing.T) {
+			l := tt.listen()
+			defer l.close()
+
+			cfg := *GetInfo().DefaultConfig.(*config.Params)
+			cfg.Network = tt.network
+			cfg.Address = l.addr
+			cfg.Facility = config.AUTHPRIV
+			cfg.Hostname = "host"
+			cfg.MessageTemplate = "{{.Variables.method}} {{.Variables.url}}"
+			cfg.CaFile = tt.caFile
+			h := newHandler(t, &cfg)
+			defer func() { _ = h.Close() }()
+
+			if err := h.HandleLogEntry(context.Background(), entries); err != nil {
+				t.Fatalf("Got error %v, expecting success", err)
+			}
+			for _, w := range want {
+				if got := l.next(t); got != w {
+					t.Errorf("Got message\n%s\nexpecting\n%s", got, w)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleLogEntry_Errs(t *testing.T) {
+	// nothing listens on the port once the listener is closed.
+	nl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Unable to listen: %v", err)
+	}
+	addr := nl.Addr().String()
+	_ = nl.Close()
+
+	env := test.NewEnv(t)
+	b := GetInfo().NewBuilder().(*builder)
+	b.SetAdapterConfig(&config.Params{Network: config.TCP, Address: addr, StructuredDataId: "mixer@32473"})
+	hh, err := b.Build(context.Background(), env)
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	h := hh.(*handler)
+	if err = h.HandleLogEntry(context.Background(), []*logentry.Instance{{Name: "access"}}); err != nil {
+		t.Errorf("Got error %v, expecting the log entry to be queued", err)
+	}
+	if err = h.Close(); err != nil {
+		t.Errorf("Got error %v, expecting success", err)
+	}
+	if logs := env.GetLogs(); len(logs) != 1 || !strings.Contains(logs[0], "could not connect to "+addr) {
+		t.Errorf("Got logs %v, expecting the connection to fail", logs)
+	}
+
+	// the writer isn't running, the queue fills up.
+	h = &handler{now: time.Now, w: &writer{queue: make(chan []byte, 1)}}
+	err = h.HandleLogEntry(context.Background(), []*logentry.Instance{{Name: "access"}, {Name: "audit"}})
+	if err == nil || !strings.Contains(err.Error(), "dropped 1 log entries") {
+		t.Errorf("Got error %v, expecting the queue to be full", err)
+	}
+
+	h = &handler{msgTmpl: mustParse(t, "{{.Name.Missing}}"), now: time.Now, w: &writer{}}
+	err = h.HandleLogEntry(context.Background(), []*logentry.Instance{{Name: "access"}})
+	if err == nil || !strings.Contains(err.Error(), "could not compute the message") {
+		t.Errorf("Got error %v, expecting the message template to fail", err)
+	}
+}
+
+func TestWriter_Backoff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "syslog")
+	if err != nil {
+		t.Fatalf("Unable to create a temporary directory: %v", err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	now := time.Now()
+	sock := filepath.Join(dir, "log.sock")
+	w := &writer{network: "unixgram", address: sock, timeout: time.Second, getTime: func() time.Time { return now }}
+	defer func() { _ = w.Close() }()
+
+	// nothing listens on the socket yet.
+	if err = w.write([]byte("first")); err == nil {
+		t.Fatal("Got success, expecting the connection to fail")
+	}
+	now = now.Add(minReconnectInterval)
+	if err = w.write([]byte("second")); err == nil {
+		t.Fatal("Got success, expecting the connection to fail")
+	}
+
+	l := listenPacket(t, "unixgram", sock)
+	defer l.close()
+
+	// the interval doubled after the second failure, writes fail fast until it has passed.
+	now = now.Add(minReconnectInterval)
+	if got := w.write([]byte("third")); got == nil || got.Error() != err.Error() {
+		t.Errorf("Got error %v, expecting the last connection error %v", got, err)
+	}
+	now = now.Add(minReconnectInterval)
+	if err = w.write([]byte("fourth")); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	if got := l.next(t); got != "fourth" {
+		t.Errorf("Got message %s, expecting fourth", got)
+	}
+}
+
+func TestBuild_Errs(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.Params
+		err  string
+	}{
+		{"missing ca", config.Params{Network: config.TLS, Address: "syslog:6514", CaFile: "/no/such/file"},
+			"could not read certificate authorities"},
+		{"bad ca", config.Params{Network: config.TLS, Address: "syslog:6514", CaFile: os.Args[0]},
+			"no certificate authority found"},
+	}
+
+	for idx, tt := range tests {
+		t.Run(fmt.Sprintf("[%d] %s", idx, tt.name), func(t *testing.T) {
+			b := GetInfo().NewBuilder().(*builder)
+			cfg := tt.cfg
+			b.SetAdapterConfig(&cfg)
+
+			_, err := b.Build(context.Background(), test.NewEnv(t))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Got error %v, expecting one containing '%s'", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func(f func(*config.Params)) config.Params {
+		cfg := config.Params{Address: "syslog:514", StructuredDataId: "mixer@32473"}
+		f(&cfg)
+		return cfg
+	}
+
+	tests := []struct {
+		name  string
+		cfg   config.Params
+		field string
+	}{
+		{"default", *GetInfo().DefaultConfig.(*config.Params), ""},
+		{"unix", valid(func(c *config.Params) { c.Network = config.UNIX; c.Address = "/dev/log" }), ""},
+		{"tls", valid(func(c *config.Params) { c.Network = config.TLS; c.CaFile = "ca.pem" }), ""},
+		{"no address", valid(func(c *config.Params) { c.Address = "" }), "address"},
+		{"no port", valid(func(c *config.Params) { c.Address = "syslog" }), "address"},
+		{"facility", valid(func(c *config.Params) { c.Facility = 24 }), "facility"},
+		{"severity", valid(func(c *config.Params) {
+			c.SeverityLevels = map[string]config.Params_Severity{"INFO": 8}
+		}), "severityLevels"},
+		{"app name", valid(func(c *config.Params) { c.AppName = "istio mixer" }), "appName"},
+		{"long app name", valid(func(c *config.Params) { c.AppName = strings.Repeat("a", 49) }), "appName"},
+		{"hostname", valid(func(c *config.Params) { c.Hostname = "höst" }), "hostname"},
+		{"no sd id", valid(func(c *config.Params) { c.StructuredDataId = "" }), "structuredDataId"},
+		{"sd id", valid(func(c *config.Params) { c.StructuredDataId = "mixer" }), "structuredDataId"},
+		{"message", valid(func(c *config.Params) { c.MessageTemplate = "{{.Name" }), "messageTemplate"},
+		{"ca file", valid(func(c *config.Params) { c.CaFile = "ca.pem" }), "caFile"},
+		{"timeout", valid(func(c *config.Params) { c.Timeout = -time.Second }), "timeout"},
+		{"queue size", valid(func(c *config.Params) { c.QueueSize = -1 }), "queueSize"},
+	}
+
+	for idx, tt := range tests {
+		t.Run(fmt.Sprintf("[%d] %s", idx, tt.name), func(t *testing.T) {
+			b := GetInfo().NewBuilder().(*builder)
+			cfg := tt.cfg
+			b.SetAdapterConfig(&cfg)
+
+			ce := b.Validate()
+			if tt.field == "" {
+				if ce != nil {
+					t.Errorf("Got error %v, expecting success", ce)
+				}
+				return
+			}
+			if ce == nil || !strings.Contains(ce.Error(), tt.field) {
+				t.Errorf("Got error %v, expecting one for %s", ce, tt.field)
+			}
+		})
+	}
+}
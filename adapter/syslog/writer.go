@@ -0,0 +1,220 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syslog
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+const (
+	// minReconnectInterval is how long the writer waits after failing to connect before trying again, the wait
+	// doubles after each consecutive failure up to maxReconnectInterval.
+	minReconnectInterval = time.Second
+	maxReconnectInterval = 30 * time.Second
+)
+
+// writer sends messages to the syslog server, connecting when the first message is sent and again after
+// a failed write. After failing to connect, writes fail fast until the reconnect interval has passed.
+//
+// The messages are queued, and sent by run, so that reporting log entries doesn't wait for the server.
+// Messages which can't be sent are dropped.
+type writer struct {
+	network string // as understood by net.Dial
+	address string
+	tls     *tls.Config // only set for TLS connections
+	timeout time.Duration
+	l       adapter.Logger
+
+	// indirection to support fast deterministic tests
+	getTime func() time.Time
+
+	queue   chan []byte
+	done    chan struct{} // closed to stop the writer
+	stopped chan struct{} // closed once the writer has sent the last queued messages
+	dropped int           // the number of messages dropped since the last one was sent, only used by run
+
+	lock      sync.Mutex // protects the fields below
+	conn      net.Conn
+	dialErr   error         // the error of the last attempt to connect
+	backoff   time.Duration // how long to wait after the last attempt to connect failed
+	nextRetry time.Time     // when to try to connect again
+}
+
+// enqueue queues the message without blocking, it returns false when the queue is full.
+func (w *writer) enqueue(msg []byte) bool {
+	select {
+	case w.queue <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+// run sends the queued messages until the writer is stopped.
+func (w *writer) run() {
+	defer close(w.stopped)
+
+	for {
+		select {
+		case msg := <-w.queue:
+			w.send(msg)
+		case <-w.done:
+			// send what is left in the queue before stopping. Once a write fails, the server is unlikely
+			// to take the rest, which is dropped.
+			for {
+				select {
+				case msg := <-w.queue:
+					if !w.send(msg) {
+						w.drop()
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// send writes the message, it returns false when the message is dropped. Only the first of consecutive
+// failures is logged.
+func (w *writer) send(msg []byte) bool {
+	if err := w.write(msg); err != nil {
+		if w.dropped == 0 {
+			_ = w.l.Errorf("dropping messages until they can be sent: %v", err)
+		}
+		w.dropped++
+		return false
+	}
+	if w.dropped > 0 {
+		w.l.Warningf("sending messages to %s again, %d were dropped", w.address, w.dropped)
+		w.dropped = 0
+	}
+	return true
+}
+
+// drop drops the messages left in the queue.
+func (w *writer) drop() {
+	n := 0
+	for {
+		select {
+		case <-w.queue:
+			n++
+		default:
+			if n > 0 {
+				_ = w.l.Errorf("dropping %d queued messages, the writer is stopped", n)
+			}
+			return
+		}
+	}
+}
+
+// stop stops the writer once the queued messages are sent.
+func (w *writer) stop() {
+	close(w.done)
+	<-w.stopped
+}
+
+// write sends the message, reconnecting once when writing to an existing connection fails.
+func (w *writer) write(msg []byte) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	// datagrams carry a single message, stream transports frame messages by octet counting.
+	if w.network == "tcp" {
+		msg = append([]byte(strconv.Itoa(len(msg))+" "), msg...)
+	}
+
+	reused := w.conn != nil
+	err := w.writeOnce(msg)
+	if err != nil && reused {
+		// the server may have closed an idle connection, try again with a new one.
+		err = w.writeOnce(msg)
+	}
+	return err
+}
+
+// writeOnce writes the message, it closes the connection when the write fails.
+func (w *writer) writeOnce(msg []byte) (err error) {
+	if w.conn == nil {
+		if w.conn, err = w.connect(); err != nil {
+			return err
+		}
+	}
+	defer func() {
+		if err != nil {
+			_ = w.conn.Close()
+			w.conn = nil
+		}
+	}()
+
+	if err = w.conn.SetWriteDeadline(time.Now().Add(w.timeout)); err != nil {
+		return err
+	}
+	_, err = w.conn.Write(msg)
+	return err
+}
+
+// connect dials the server. After a failure, it returns the same error without dialing again until the reconnect
+// interval has passed.
+func (w *writer) connect() (net.Conn, error) {
+	now := w.getTime()
+	if w.dialErr != nil && now.Before(w.nextRetry) {
+		return nil, w.dialErr
+	}
+
+	conn, err := w.dial()
+	if err != nil {
+		w.backoff *= 2
+		if w.backoff < minReconnectInterval {
+			w.backoff = minReconnectInterval
+		} else if w.backoff > maxReconnectInterval {
+			w.backoff = maxReconnectInterval
+		}
+		w.dialErr = fmt.Errorf("could not connect to %s: %v", w.address, err)
+		w.nextRetry = now.Add(w.backoff)
+		return nil, w.dialErr
+	}
+	w.dialErr = nil
+	w.backoff = 0
+	return conn, nil
+}
+
+func (w *writer) dial() (net.Conn, error) {
+	d := &net.Dialer{Timeout: w.timeout}
+	if w.tls != nil {
+		return tls.DialWithDialer(d, w.network, w.address, w.tls)
+	}
+	return d.Dial(w.network, w.address)
+}
+
+func (w *writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
@@ -0,0 +1,216 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"istio.io/mixer/pkg/adapter"
+)
+
+type (
+	// span is a span in the Zipkin v2 JSON format.
+	span struct {
+		TraceID       string            `json:"traceId"`
+		ID            string            `json:"id"`
+		ParentID      string            `json:"parentId,omitempty"`
+		Name          string            `json:"name,omitempty"`
+		Timestamp     int64             `json:"timestamp,omitempty"` // microseconds since the epoch
+		Duration      int64             `json:"duration,omitempty"`  // microseconds
+		LocalEndpoint *endpoint         `json:"localEndpoint,omitempty"`
+		Tags          map[string]string `json:"tags,omitempty"`
+	}
+
+	endpoint struct {
+		ServiceName string `json:"serviceName,omitempty"`
+	}
+
+	// sender queues spans in memory and posts them to the collector in batches.
+	sender struct {
+		client        *http.Client
+		url           string
+		batchSize     int
+		batchInterval time.Duration
+		maxRetries    int
+		retryInterval time.Duration
+		l             adapter.Logger
+
+		queue   chan *span
+		done    chan struct{} // closed to stop the sender
+		stopped chan struct{} // closed once the sender has posted the last batch
+	}
+)
+
+// enqueue queues the span without blocking, it returns false when the queue is full.
+func (s *sender) enqueue(sp *span) bool {
+	select {
+	case s.queue <- sp:
+		return true
+	default:
+		return false
+	}
+}
+
+// run posts the queued spans until the sender is closed.
+func (s *sender) run() {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(s.batchInterval)
+	defer ticker.Stop()
+
+	batch := make([]*span, 0, s.batchSize)
+	for {
+		select {
+		case sp := <-s.queue:
+			if batch = append(batch, sp); len(batch) >= s.batchSize {
+				if !s.send(batch) && s.closing() {
+					s.drop()
+					return
+				}
+				batch = make([]*span, 0, s.batchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				if !s.send(batch) && s.closing() {
+					s.drop()
+					return
+				}
+				batch = make([]*span, 0, s.batchSize)
+			}
+		case <-s.done:
+			// post what is left in the queue before stopping, without retrying. Once a post fails,
+			// the collector is unlikely to take the rest, which is dropped.
+			for {
+				select {
+				case sp := <-s.queue:
+					if batch = append(batch, sp); len(batch) >= s.batchSize {
+						if !s.send(batch) {
+							s.drop()
+							return
+						}
+						batch = make([]*span, 0, s.batchSize)
+					}
+				default:
+					if len(batch) > 0 {
+						s.send(batch)
+					}
+					return
+				}
+			}
+		}
+	}
+}
+
+// send posts the batch, retrying with an exponential backoff when the collector is unavailable.
+// The batch is dropped when all attempts fail, or when the sender is closed, in which case send
+// returns false. Once the sender is closed, the batch is posted at most once.
+func (s *sender) send(batch []*span) bool {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		_ = s.l.Errorf("could not encode %d spans: %v", len(batch), err)
+		return false
+	}
+
+	backoff := s.retryInterval
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(body)
+		if err == nil {
+			return true
+		}
+		if !retry || attempt >= s.maxRetries {
+			_ = s.l.Errorf("dropping %d spans after %d attempts: %v", len(batch), attempt+1, err)
+			return false
+		}
+
+		if s.closing() {
+			_ = s.l.Errorf("dropping %d spans after %d attempts, the sender is closed: %v", len(batch), attempt+1, err)
+			return false
+		}
+		s.l.Warningf("could not post %d spans, retrying in %v: %v", len(batch), backoff, err)
+
+		select {
+		case <-time.After(backoff):
+		case <-s.done:
+			_ = s.l.Errorf("dropping %d spans after %d attempts, the sender is closed: %v", len(batch), attempt+1, err)
+			return false
+		}
+		backoff *= 2
+	}
+}
+
+// closing returns true once the sender is closed.
+func (s *sender) closing() bool {
+	select {
+	case <-s.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// drop drops the spans left in the queue.
+func (s *sender) drop() {
+	n := 0
+	for {
+		select {
+		case <-s.queue:
+			n++
+		default:
+			if n > 0 {
+				_ = s.l.Errorf("dropping %d queued spans, the sender is closed", n)
+			}
+			return
+		}
+	}
+}
+
+// post sends a single request to the collector. It returns whether a failed request may be retried.
+func (s *sender) post(body []byte) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	// drain the body so the connection can be reused.
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	_ = resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("collector returned %s", resp.Status)
+	default:
+		return false, fmt.Errorf("collector returned %s", resp.Status)
+	}
+}
+
+// Close stops the sender once the queued spans are posted.
+func (s *sender) Close() error {
+	close(s.done)
+	<-s.stopped
+	return nil
+}
@@ -0,0 +1,242 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package zipkin provides an adapter that posts tracespan instances to a Zipkin (or Jaeger) collector.
+package zipkin // import "istio.io/mixer/adapter/zipkin"
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+
+	"istio.io/mixer/adapter/zipkin/config"
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/template/tracespan"
+)
+
+const (
+	defaultBatchSize      = 100
+	defaultBatchInterval  = 1 * time.Second
+	defaultQueueSize      = 1000
+	defaultRetryInterval  = 100 * time.Millisecond
+	defaultRequestTimeout = 5 * time.Second
+)
+
+type handler struct {
+	serviceName string
+	now         func() time.Time // used to control time in tests
+	s           *sender
+	closeOnce   sync.Once
+}
+
+var _ tracespan.Handler = &handler{}
+
+// HandleTraceSpan queues the spans to be posted to the collector.
+func (h *handler) HandleTraceSpan(_ context.Context, values []*tracespan.Instance) error {
+	var result *multierror.Error
+	dropped := 0
+	for _, v := range values {
+		sp, err := h.toSpan(v)
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("skipping span '%s' of trace '%s': %v", v.SpanName, v.TraceId, err))
+			continue
+		}
+		if !h.s.enqueue(sp) {
+			dropped++
+		}
+	}
+	if dropped > 0 {
+		result = multierror.Append(result, fmt.Errorf("span queue is full, dropped %d spans", dropped))
+	}
+	return result.ErrorOrNil()
+}
+
+func (h *handler) toSpan(v *tracespan.Instance) (*span, error) {
+	traceID, err := toID(v.TraceId, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trace ID: %v", err)
+	}
+	spanID, err := toID(v.SpanId, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid span ID: %v", err)
+	}
+	var parentID string
+	if v.ParentSpanId != "" {
+		if parentID, err = toID(v.ParentSpanId, 16); err != nil {
+			return nil, fmt.Errorf("invalid parent span ID: %v", err)
+		}
+	}
+
+	end := v.EndTime
+	if end.IsZero() {
+		end = h.now()
+	}
+	start := v.StartTime
+	if start.IsZero() {
+		start = end
+	}
+
+	sp := &span{
+		TraceID:   traceID,
+		ID:        spanID,
+		ParentID:  parentID,
+		Name:      v.SpanName,
+		Timestamp: start.UnixNano() / int64(time.Microsecond),
+		Duration:  int64(end.Sub(start) / time.Microsecond),
+		Tags:      toTags(v.SpanTags),
+	}
+	if h.serviceName != "" {
+		sp.LocalEndpoint = &endpoint{ServiceName: h.serviceName}
+	}
+	return sp, nil
+}
+
+func (h *handler) Close() error {
+	var err error
+	h.closeOnce.Do(func() { err = h.s.Close() })
+	return err
+}
+
+// toID converts a hex ID to the lower case form Zipkin requires: 16 characters, or 32 for 128 bit IDs.
+func toID(id string, maxLen int) (string, error) {
+	if id == "" {
+		return "", fmt.Errorf("missing ID")
+	}
+	if len(id) > maxLen {
+		return "", fmt.Errorf("'%s' is longer than %d characters", id, maxLen)
+	}
+	for _, c := range id {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return "", fmt.Errorf("'%s' is not a hex string", id)
+		}
+	}
+	width := 16
+	if len(id) > 16 {
+		width = 32
+	}
+	return strings.Repeat("0", width-len(id)) + strings.ToLower(id), nil
+}
+
+// toTags converts span tags to strings, the only tag values Zipkin accepts.
+func toTags(tags map[string]interface{}) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	out := make(map[string]string, len(tags))
+	for k, v := range tags {
+		out[k] = fmt.Sprintf("%v", v)
+	}
+	return out
+}
+
+////////////////// Config //////////////////////////
+
+// GetInfo returns the Info associated with this adapter implementation.
+func GetInfo() adapter.Info {
+	return adapter.Info{
+		Name:        "zipkin",
+		Impl:        "istio.io/mixer/adapter/zipkin",
+		Description: "Posts trace spans to a Zipkin or Jaeger collector",
+		SupportedTemplates: []string{
+			tracespan.TemplateName,
+		},
+		DefaultConfig: &config.Params{
+			CollectorUrl:   "http://localhost:9411/api/v2/spans",
+			ServiceName:    "istio-mesh",
+			BatchSize:      defaultBatchSize,
+			BatchInterval:  defaultBatchInterval,
+			QueueSize:      defaultQueueSize,
+			MaxRetries:     3,
+			RetryInterval:  defaultRetryInterval,
+			RequestTimeout: defaultRequestTimeout,
+		},
+
+		NewBuilder: func() adapter.HandlerBuilder { return &builder{} },
+	}
+}
+
+type builder struct {
+	adapterConfig *config.Params
+}
+
+func (*builder) SetTraceSpanTypes(map[string]*tracespan.Type) {}
+func (b *builder) SetAdapterConfig(cfg adapter.Config)        { b.adapterConfig = cfg.(*config.Params) }
+
+func (b *builder) Validate() (ce *adapter.ConfigErrors) {
+	ac := b.adapterConfig
+	if u, err := url.Parse(ac.CollectorUrl); err != nil {
+		ce = ce.Appendf("collectorUrl", "invalid collector URL '%s': %v", ac.CollectorUrl, err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		ce = ce.Appendf("collectorUrl", "collector URL '%s' must be an http or https URL", ac.CollectorUrl)
+	}
+	if ac.BatchSize < 0 {
+		ce = ce.Appendf("batchSize", "batch size must be >= 0")
+	}
+	if ac.BatchInterval < 0 {
+		ce = ce.Appendf("batchInterval", "batch interval must be >= 0")
+	}
+	if ac.QueueSize < 0 {
+		ce = ce.Appendf("queueSize", "queue size must be >= 0")
+	}
+	if ac.MaxRetries < 0 {
+		ce = ce.Appendf("maxRetries", "max retries must be >= 0")
+	}
+	if ac.RetryInterval < 0 {
+		ce = ce.Appendf("retryInterval", "retry interval must be >= 0")
+	}
+	if ac.RequestTimeout < 0 {
+		ce = ce.Appendf("requestTimeout", "request timeout must be >= 0")
+	}
+	return
+}
+
+func (b *builder) Build(_ context.Context, env adapter.Env) (adapter.Handler, error) {
+	ac := b.adapterConfig
+
+	s := &sender{
+		client:        &http.Client{Timeout: orDefault(ac.RequestTimeout, defaultRequestTimeout)},
+		url:           ac.CollectorUrl,
+		batchSize:     int(ac.BatchSize),
+		batchInterval: orDefault(ac.BatchInterval, defaultBatchInterval),
+		maxRetries:    int(ac.MaxRetries),
+		retryInterval: orDefault(ac.RetryInterval, defaultRetryInterval),
+		l:             env.Logger(),
+		done:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+	if s.batchSize == 0 {
+		s.batchSize = defaultBatchSize
+	}
+	queueSize := int(ac.QueueSize)
+	if queueSize == 0 {
+		queueSize = defaultQueueSize
+	}
+	s.queue = make(chan *span, queueSize)
+	env.ScheduleDaemon(s.run)
+
+	return &handler{serviceName: ac.ServiceName, now: time.Now, s: s}, nil
+}
+
+func orDefault(d, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return d
+}
@@ -0,0 +1,397 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipkin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"istio.io/mixer/adapter/zipkin/config"
+	"istio.io/mixer/pkg/adapter/test"
+	"istio.io/mixer/template/tracespan"
+)
+
+// collector is a fake Zipkin collector, answering requests with the given status codes in turn (200 once they
+// are used up) and delivering the batches it accepts on a channel.
+type collector struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	statuses []int
+	requests int
+	batches  chan []span
+}
+
+func newCollector(statuses ...int) *collector {
+	c := &collector{statuses: statuses, batches: make(chan []span, 10)}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.lock.Lock()
+		c.requests++
+		status := http.StatusAccepted
+		if len(c.statuses) > 0 {
+			status, c.statuses = c.statuses[0], c.statuses[1:]
+		}
+		c.lock.Unlock()
+
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "expected a JSON post", http.StatusBadRequest)
+			return
+		}
+		if status != http.StatusAccepted {
+			w.WriteHeader(status)
+			return
+		}
+
+		var batch []span
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c.batches <- batch
+		w.WriteHeader(status)
+	}))
+	return c
+}
+
+func (c *collector) requestCount() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.requests
+}
+
+func (c *collector) nextBatch(t *testing.T) []span {
+	select {
+	case b := <-c.batches:
+		return b
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the collector to receive a batch")
+		return nil
+	}
+}
+
+func newHandler(t *testing.T, cfg *config.Params) (*handler, *test.Env) {
+	env := test.NewEnv(t)
+	b := GetInfo().NewBuilder().(*builder)
+	b.SetAdapterConfig(cfg)
+	b.SetTraceSpanTypes(map[string]*tracespan.Type{"span": {}})
+	if err := b.Validate(); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	h, err := b.Build(context.Background(), env)
+	if err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	return h.(*handler), env
+}
+
+func TestBasic(t *testing.T) {
+	info := GetInfo()
+	if !reflect.DeepEqual(info.SupportedTemplates, []string{tracespan.TemplateName}) {
+		t.Errorf("Got templates %v, expecting %s", info.SupportedTemplates, tracespan.TemplateName)
+	}
+
+	h, _ := newHandler(t, info.DefaultConfig.(*config.Params))
+	if err := h.Close(); err != nil {
+		t.Errorf("Got error %v, expecting success", err)
+	}
+	if err := h.Close(); err != nil {
+		t.Errorf("Got error %v, expecting the second close to be a no-op", err)
+	}
+}
+
+func TestHandleTraceSpan(t *testing.T) {
+	c := newCollector()
+	defer c.Close()
+
+	h, _ := newHandler(t, &config.Params{
+		CollectorUrl:  c.URL,
+		ServiceName:   "mesh",
+		BatchSize:     2,
+		BatchInterval: time.Hour,
+	})
+
+	start := time.Date(2017, 9, 1, 10, 0, 0, 0, time.UTC)
+	spans := []*tracespan.Instance{
+		{
+			Name:      "span",
+			TraceId:   "463AC35C9F6413AD",
+			SpanId:    "a2fb4a1d1a96d312",
+			SpanName:  "/index",
+			StartTime: start,
+			EndTime:   start.Add(150 * time.Millisecond),
+			SpanTags:  map[string]interface{}{"http.status_code": int64(200), "http.method": "GET"},
+		},
+		{
+			Name:         "span",
+			TraceId:      "463ac35c9f6413ad",
+			SpanId:       "2",
+			ParentSpanId: "a2fb4a1d1a96d312",
+			SpanName:     "/db",
+			StartTime:    start,
+			EndTime:      start.Add(time.Millisecond),
+		},
+		{
+			Name:      "span",
+			TraceId:   "0af7651916cd43dd8448eb211c80319c",
+			SpanId:    "b7ad6b7169203331",
+			StartTime: start,
+			EndTime:   start,
+		},
+	}
+	if err := h.HandleTraceSpan(context.Background(), spans); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+
+	// the first two spans fill a batch.
+	batch := c.nextBatch(t)
+	ts := start.UnixNano() / int64(time.Microsecond)
+	want := []span{
+		{
+			TraceID:       "463ac35c9f6413ad",
+			ID:            "a2fb4a1d1a96d312",
+			Name:          "/index",
+			Timestamp:     ts,
+			Duration:      150000,
+			LocalEndpoint: &endpoint{ServiceName: "mesh"},
+			Tags:          map[string]string{"http.status_code": "200", "http.method": "GET"},
+		},
+		{
+			TraceID:       "463ac35c9f6413ad",
+			ID:            "0000000000000002",
+			ParentID:      "a2fb4a1d1a96d312",
+			Name:          "/db",
+			Timestamp:     ts,
+			Duration:      1000,
+			LocalEndpoint: &endpoint{ServiceName: "mesh"},
+		},
+	}
+	if !reflect.DeepEqual(batch, want) {
+		t.Errorf("Got batch %+v, expecting %+v", batch, want)
+	}
+
+	// the last one is posted when the handler is closed.
+	if err := h.Close(); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	batch = c.nextBatch(t)
+	if len(batch) != 1 || batch[0].TraceID != "0af7651916cd43dd8448eb211c80319c" || batch[0].Duration != 0 {
+		t.Errorf("Got batch %+v, expecting the 128 bit trace span", batch)
+	}
+}
+
+func TestBatchInterval(t *testing.T) {
+	c := newCollector()
+	defer c.Close()
+
+	h, _ := newHandler(t, &config.Params{CollectorUrl: c.URL, BatchInterval: 10 * time.Millisecond})
+	defer func() { _ = h.Close() }()
+
+	if err := h.HandleTraceSpan(context.Background(), []*tracespan.Instance{{TraceId: "1", SpanId: "1"}}); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	if batch := c.nextBatch(t); len(batch) != 1 {
+		t.Errorf("Got %d spans, expecting 1", len(batch))
+	}
+}
+
+func TestRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int
+		posted   bool
+		log      string
+	}{
+		{"unavailable", []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}, 3, true, "retrying"},
+		{"exhausted", []int{500, 500, 500, 500}, 3, false, "dropping 1 spans after 3 attempts"},
+		{"bad request", []int{http.StatusBadRequest}, 1, false, "dropping 1 spans after 1 attempts"},
+	}
+
+	for idx, tt := range tests {
+		t.Run(fmt.Sprintf("[%d] %s", idx, tt.name), func(t *testing.T) {
+			c := newCollector(tt.statuses...)
+			defer c.Close()
+
+			h, env := newHandler(t, &config.Params{
+				CollectorUrl:  c.URL,
+				BatchSize:     1,
+				BatchInterval: time.Hour,
+				MaxRetries:    2,
+				RetryInterval: time.Millisecond,
+			})
+			if err := h.HandleTraceSpan(context.Background(), []*tracespan.Instance{{TraceId: "1", SpanId: "1"}}); err != nil {
+				t.Fatalf("Got error %v, expecting success", err)
+			}
+
+			// spans are not retried once the handler is closed.
+			for deadline := time.Now().Add(5 * time.Second); c.requestCount() < tt.requests; {
+				if time.Now().After(deadline) {
+					t.Fatalf("Timed out waiting for %d requests, got %d", tt.requests, c.requestCount())
+				}
+				time.Sleep(time.Millisecond)
+			}
+			if err := h.Close(); err != nil {
+				t.Fatalf("Got error %v, expecting success", err)
+			}
+
+			if n := c.requestCount(); n != tt.requests {
+				t.Errorf("Got %d requests, expecting %d", n, tt.requests)
+			}
+			if posted := len(c.batches) == 1; posted != tt.posted {
+				t.Errorf("Got posted %t, expecting %t", posted, tt.posted)
+			}
+			found := false
+			for _, l := range env.GetLogs() {
+				found = found || strings.Contains(l, tt.log)
+			}
+			if !found {
+				t.Errorf("Expected a log containing '%s', got logs: %v", tt.log, env.GetLogs())
+			}
+		})
+	}
+}
+
+func TestCloseWhileRetrying(t *testing.T) {
+	c := newCollector(http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable)
+	defer c.Close()
+
+	h, env := newHandler(t, &config.Params{
+		CollectorUrl:  c.URL,
+		BatchSize:     1,
+		BatchInterval: time.Hour,
+		MaxRetries:    5,
+		RetryInterval: time.Hour,
+	})
+	spans := []*tracespan.Instance{{TraceId: "1", SpanId: "1"}, {TraceId: "1", SpanId: "2"}, {TraceId: "1", SpanId: "3"}}
+	if err := h.HandleTraceSpan(context.Background(), spans); err != nil {
+		t.Fatalf("Got error %v, expecting success", err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); c.requestCount() < 1; {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the first request")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// closing does not wait for the retry, and drops the queued spans.
+	closed := make(chan error)
+	go func() { closed <- h.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("Got error %v, expecting success", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the handler to close")
+	}
+
+	if n := c.requestCount(); n != 1 {
+		t.Errorf("Got %d requests, expecting 1", n)
+	}
+	found := false
+	for _, l := range env.GetLogs() {
+		found = found || strings.Contains(l, "dropping 2 queued spans")
+	}
+	if !found {
+		t.Errorf("Expected a log about the queued spans being dropped, got logs: %v", env.GetLogs())
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	// the sender isn't running, so nothing is taken off the queue.
+	h := &handler{now: time.Now, s: &sender{queue: make(chan *span, 1)}}
+
+	spans := []*tracespan.Instance{{TraceId: "1", SpanId: "1"}, {TraceId: "1", SpanId: "2"}, {TraceId: "1", SpanId: "3"}}
+	err := h.HandleTraceSpan(context.Background(), spans)
+	if err == nil || !strings.Contains(err.Error(), "dropped 2 spans") {
+		t.Errorf("Got error %v, expecting 2 spans to be dropped", err)
+	}
+	if len(h.s.queue) != 1 {
+		t.Errorf("Got %d queued spans, expecting 1", len(h.s.queue))
+	}
+}
+
+func TestHandleTraceSpan_Errs(t *testing.T) {
+	tests := []struct {
+		name string
+		span *tracespan.Instance
+		err  string
+	}{
+		{"no trace", &tracespan.Instance{SpanId: "1"}, "invalid trace ID: missing ID"},
+		{"long trace", &tracespan.Instance{TraceId: strings.Repeat("a", 33), SpanId: "1"}, "longer than 32"},
+		{"bad trace", &tracespan.Instance{TraceId: "xyz", SpanId: "1"}, "not a hex string"},
+		{"long span", &tracespan.Instance{TraceId: "1", SpanId: strings.Repeat("a", 17)}, "invalid span ID"},
+		{"bad parent", &tracespan.Instance{TraceId: "1", SpanId: "1", ParentSpanId: "xyz"}, "invalid parent span ID"},
+	}
+
+	for idx, tt := range tests {
+		t.Run(fmt.Sprintf("[%d] %s", idx, tt.name), func(t *testing.T) {
+			h := &handler{now: time.Now, s: &sender{queue: make(chan *span, 1)}}
+			err := h.HandleTraceSpan(context.Background(), []*tracespan.Instance{tt.span})
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Got error %v, expecting one containing '%s'", err, tt.err)
+			}
+			if len(h.s.queue) != 0 {
+				t.Error("Got a queued span, expecting it to be skipped")
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		cfg   config.Params
+		field string
+	}{
+		{"default", *GetInfo().DefaultConfig.(*config.Params), ""},
+		{"no url", config.Params{}, "collectorUrl"},
+		{"bad url", config.Params{CollectorUrl: "%zz"}, "collectorUrl"},
+		{"udp url", config.Params{CollectorUrl: "udp://localhost:9411"}, "collectorUrl"},
+		{"batch size", config.Params{CollectorUrl: "http://zipkin", BatchSize: -1}, "batchSize"},
+		{"batch interval", config.Params{CollectorUrl: "http://zipkin", BatchInterval: -time.Second}, "batchInterval"},
+		{"queue size", config.Params{CollectorUrl: "http://zipkin", QueueSize: -1}, "queueSize"},
+		{"retries", config.Params{CollectorUrl: "http://zipkin", MaxRetries: -1}, "maxRetries"},
+		{"retry interval", config.Params{CollectorUrl: "http://zipkin", RetryInterval: -time.Second}, "retryInterval"},
+		{"timeout", config.Params{CollectorUrl: "https://zipkin", RequestTimeout: -time.Second}, "requestTimeout"},
+	}
+
+	for idx, tt := range tests {
+		t.Run(fmt.Sprintf("[%d] %s", idx, tt.name), func(t *testing.T) {
+			b := GetInfo().NewBuilder().(*builder)
+			cfg := tt.cfg
+			b.SetAdapterConfig(&cfg)
+
+			ce := b.Validate()
+			if tt.field == "" {
+				if ce != nil {
+					t.Errorf("Got error %v, expecting success", ce)
+				}
+				return
+			}
+			if ce == nil || !strings.Contains(ce.Error(), tt.field) {
+				t.Errorf("Got error %v, expecting one for %s", ce, tt.field)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/mixer/cmd/shared"
+	adptr "istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/config"
+	"istio.io/mixer/pkg/config/store"
+	"istio.io/mixer/pkg/expr"
+	mixerRuntime "istio.io/mixer/pkg/runtime"
+	"istio.io/mixer/pkg/template"
+)
+
+// output formats of the validate command.
+const (
+	lintOutputText = "text"
+	lintOutputJSON = "json"
+)
+
+// lintDiagnostic is a problem found in a config file.
+type lintDiagnostic struct {
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+	Severity string `json:"severity"`
+	Resource string `json:"resource,omitempty"`
+	Message  string `json:"message"`
+}
+
+// String formats the diagnostic as "file:line: severity: resource: message".
+func (d lintDiagnostic) String() string {
+	msg := strings.Replace(d.Message, "\n", " ", -1)
+	if d.Resource == "" {
+		return fmt.Sprintf("%s:%d: %s: %s", d.File, d.Line, d.Severity, msg)
+	}
+	return fmt.Sprintf("%s:%d: %s: %s: %s", d.File, d.Line, d.Severity, d.Resource, msg)
+}
+
+func validateCmd(info map[string]template.Info, adapters []adptr.InfoFn, printf, fatalf shared.FormatFn) *cobra.Command {
+	var configDir, output string
+	validateCmd := cobra.Command{
+		Use:   "validate",
+		Short: "Validates the Mixer config files in a directory",
+		Long: "Validates all of the Mixer config resources in the YAML files under a directory, the same way\n" +
+			"Mixer does. Expressions are type checked against the attribute manifests, handler params are\n" +
+			"validated by the adapters, and the rules referring to missing handlers or instances, and the\n" +
+			"unused instances are reported. Each problem is printed as 'file:line: severity: resource: message'.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if configDir == "" {
+				return fmt.Errorf("config_dir is required")
+			}
+			if output != lintOutputText && output != lintOutputJSON {
+				return fmt.Errorf("unknown output format %q, must be %s or %s", output, lintOutputText, lintOutputJSON)
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			diags, err := lintConfigDir(configDir, info, adapters)
+			if err != nil {
+				fatalf("%v", err)
+			}
+			if output == lintOutputJSON {
+				var out []byte
+				if out, err = json.MarshalIndent(diags, "", "  "); err != nil {
+					fatalf("Unable to encode the diagnostics: %v", err)
+				}
+				printf("%s", out)
+			} else {
+				for _, d := range diags {
+					printf("%s", d)
+				}
+			}
+			if n := countErrors(diags); n > 0 {
+				fatalf("%d error(s) found in %s", n, configDir)
+			}
+		},
+	}
+	validateCmd.PersistentFlags().StringVarP(&configDir, "config_dir", "", "", "Directory of the config files to validate")
+	validateCmd.PersistentFlags().StringVarP(&output, "output", "o", lintOutputText,
+		"Output format of the diagnostics, text or json")
+	return &validateCmd
+}
+
+// lintConfigDir validates the config resources in the directory and returns the problems,
+// ordered by the location in the files.
+func lintConfigDir(dir string, info map[string]template.Info, adapters []adptr.InfoFn) ([]lintDiagnostic, error) {
+	tc, err := expr.NewCEXLEvaluator(expr.DefaultCacheSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the expression type checker: %v", err)
+	}
+
+	resources, fileErrs := store.ReadConfigDir(dir)
+	var diags []lintDiagnostic
+	for _, fe := range fileErrs {
+		diags = append(diags, lintDiagnostic{
+			File:     fe.Path,
+			Line:     fe.Line,
+			Severity: string(mixerRuntime.SeverityError),
+			Message:  fe.Err.Error(),
+		})
+	}
+
+	locations := make(map[store.Key]store.Location, len(resources))
+	specs := make(map[store.Key]map[string]interface{}, len(resources))
+	for _, r := range resources {
+		// resources of the other API groups may share the directory.
+		if r.APIVersion != "" && !strings.HasPrefix(r.APIVersion, Group+"/") {
+			continue
+		}
+		if loc, found := locations[r.Key]; found {
+			diags = append(diags, lintDiagnostic{
+				File:     r.Path,
+				Line:     r.Line,
+				Severity: string(mixerRuntime.SeverityError),
+				Resource: r.Key.String(),
+				Message:  fmt.Sprintf("duplicate resource, already defined at %s", loc),
+			})
+			continue
+		}
+		locations[r.Key] = r.Location
+		spec := r.Spec
+		if spec == nil {
+			spec = map[string]interface{}{}
+		}
+		specs[r.Key] = spec
+	}
+
+	for _, d := range mixerRuntime.Lint(specs, config.InventoryMap(adapters), info, tc) {
+		loc := locations[d.Key]
+		diags = append(diags, lintDiagnostic{
+			File:     loc.Path,
+			Line:     loc.Line,
+			Severity: string(d.Severity),
+			Resource: d.Key.String(),
+			Message:  d.Message,
+		})
+	}
+
+	sortLintDiagnostics(diags)
+	return diags, nil
+}
+
+func sortLintDiagnostics(diags []lintDiagnostic) {
+	sort.SliceStable(diags, func(i, j int) bool {
+		if diags[i].File != diags[j].File {
+			return diags[i].File < diags[j].File
+		}
+		return diags[i].Line < diags[j].Line
+	})
+}
+
+func countErrors(diags []lintDiagnostic) int {
+	n := 0
+	for _, d := range diags {
+		if d.Severity == string(mixerRuntime.SeverityError) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,142 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"istio.io/mixer/adapter"
+	generatedTemplate "istio.io/mixer/template"
+)
+
+const lintAttributes = `apiVersion: "config.istio.io/v1alpha2"
+kind: attributemanifest
+metadata:
+  name: attrs
+  namespace: istio-system
+spec:
+  attributes:
+    request.headers:
+      valueType: STRING_MAP
+`
+
+const lintConfig = `apiVersion: "config.istio.io/v1alpha2"
+kind: denier
+metadata:
+  name: denyall
+  namespace: istio-system
+spec:
+  status:
+    code: 7
+---
+apiVersion: "config.istio.io/v1alpha2"
+kind: checknothing
+metadata:
+  name: denyrequest
+  namespace: istio-system
+spec:
+---
+apiVersion: "config.istio.io/v1alpha2"
+kind: checknothing
+metadata:
+  name: unused
+  namespace: istio-system
+spec:
+---
+apiVersion: "config.istio.io/v1alpha2"
+kind: rule
+metadata:
+  name: denysome
+  namespace: istio-system
+spec:
+  match: request.headers["clnt"] == "abc"
+  actions:
+  - handler: denyall.denier
+    instances:
+    - denyrequest.checknothing
+---
+apiVersion: "config.istio.io/v1alpha2"
+kind: rule
+metadata:
+  name: dangling
+  namespace: istio-system
+spec:
+  match: request.size == 10
+  actions:
+  - handler: missing.denier
+    instances:
+    - denyrequest.checknothing
+---
+apiVersion: "networking.istio.io/v1alpha3"
+kind: VirtualService
+metadata:
+  name: other
+  namespace: default
+spec:
+  hosts: ["*"]
+---
+apiVersion: "config.istio.io/v1alpha2"
+kind: denier
+metadata:
+  name: denyall
+  namespace: istio-system
+spec:
+`
+
+func TestLintConfigDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	attrs := filepath.Join(dir, "attributes.yaml")
+	cfg := filepath.Join(dir, "config.yaml")
+	if err = ioutil.WriteFile(attrs, []byte(lintAttributes), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(cfg, []byte(lintConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	diags, err := lintConfigDir(dir, generatedTemplate.SupportedTmplInfo, adapter.Inventory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		prefix  string
+		message string
+	}{
+		{cfg + ":17: warning: unused.checknothing.istio-system: ", "instance is not used by any rule"},
+		{cfg + ":36: error: dangling.rule.istio-system: ", "unknown attribute request.size"},
+		{cfg + ":36: error: dangling.rule.istio-system: ", "unknown handler: missing.denier.istio-system"},
+		{cfg + ":56: error: denyall.denier.istio-system: ", "duplicate resource, already defined at " + cfg + ":1"},
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("Got %v, Want %d diagnostics", diags, len(want))
+	}
+	for i, w := range want {
+		got := diags[i].String()
+		if !strings.HasPrefix(got, w.prefix) || !strings.Contains(got, w.message) {
+			t.Errorf("Got %s, Want %s...%s", got, w.prefix, w.message)
+		}
+	}
+	if n := countErrors(diags); n != 3 {
+		t.Errorf("Got %d errors, Want 3", n)
+	}
+}
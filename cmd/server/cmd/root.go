@@ -55,6 +55,8 @@ func GetRootCmd(args []string, info map[string]template.Info, adapters []adapter
 	rootCmd.AddCommand(adapterCmd(legacyAdapters, printf))
 	rootCmd.AddCommand(serverCmd(info, adapters, legacyAdapters, printf, fatalf))
 	rootCmd.AddCommand(crdCmd(info, adapters, printf, fatalf))
+	rootCmd.AddCommand(webhookCmd(info, adapters, printf, fatalf))
+	rootCmd.AddCommand(validateCmd(info, adapters, printf, fatalf))
 	rootCmd.AddCommand(shared.VersionCmd(printf))
 
 	return rootCmd
@@ -180,7 +180,10 @@ func serverCmd(info map[string]template.Info, adapters []adptr.InfoFn, legacyAda
 		"URL of the config store. May be fs:// for file system, or redis:// for redis url")
 
 	serverCmd.PersistentFlags().StringVarP(&sa.configStore2URL, "configStore2URL", "", "",
-		"URL of the config store. Use k8s://path_to_kubeconfig or fs:// for file system. If path_to_kubeconfig is empty, in-cluster kubeconfig is used.")
+		"URL of the config store. Use k8s://path_to_kubeconfig or fs:// for file system. If path_to_kubeconfig is empty, in-cluster kubeconfig is used. "+
+			"Multiple URLs separated by commas are merged into a single store, later URLs taking precedence, e.g. fs:///etc/mixer/base,k8s://. "+
+			"Add ?status=true to a k8s:// URL to record why each config resource does or does not take effect in its "+
+			"config.istio.io/status annotation, which requires the patch permission on the Mixer config resources.")
 
 	serverCmd.PersistentFlags().StringVarP(&sa.configDefaultNamespace, "configDefaultNamespace", "", mixerRuntime.DefaultConfigNamespace,
 		"Namespace used to store mesh wide configuration.")
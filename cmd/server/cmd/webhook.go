@@ -0,0 +1,100 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/spf13/cobra"
+
+	"istio.io/mixer/cmd/shared"
+	adptr "istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/config"
+	"istio.io/mixer/pkg/config/crd"
+	"istio.io/mixer/pkg/config/store"
+	"istio.io/mixer/pkg/expr"
+	mixerRuntime "istio.io/mixer/pkg/runtime"
+	"istio.io/mixer/pkg/template"
+)
+
+const admissionPath = "/admitmixer"
+
+type webhookArgs struct {
+	port            uint16
+	certFile        string
+	keyFile         string
+	configStore2URL string
+}
+
+func webhookCmd(info map[string]template.Info, adapters []adptr.InfoFn, printf, fatalf shared.FormatFn) *cobra.Command {
+	wa := &webhookArgs{}
+	webhookCmd := cobra.Command{
+		Use:   "admission-webhook",
+		Short: "Runs an HTTPS admission webhook which validates Mixer config resources",
+		Long: "Runs an HTTPS validating admission webhook for the Kubernetes API server. Changes to rules,\n" +
+			"handlers, instances and attribute manifests are checked against the current config before\n" +
+			"they are accepted, and invalid resources are rejected.",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			if wa.certFile == "" || wa.keyFile == "" {
+				return fmt.Errorf("both certFile and keyFile are required")
+			}
+			return nil
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			runWebhook(wa, info, adapters, printf, fatalf)
+		},
+	}
+
+	webhookCmd.PersistentFlags().Uint16VarP(&wa.port, "port", "p", 9443, "HTTPS port to serve the admission webhook on")
+
+	webhookCmd.PersistentFlags().StringVarP(&wa.certFile, "certFile", "", "", "The TLS cert file")
+	_ = webhookCmd.MarkPersistentFlagFilename("certFile")
+
+	webhookCmd.PersistentFlags().StringVarP(&wa.keyFile, "keyFile", "", "", "The TLS key file")
+	_ = webhookCmd.MarkPersistentFlagFilename("keyFile")
+
+	webhookCmd.PersistentFlags().StringVarP(&wa.configStore2URL, "configStore2URL", "", "k8s://",
+		"URL of the config store to validate the changes against. Use k8s://path_to_kubeconfig or fs:// for file system. "+
+			"If path_to_kubeconfig is empty, in-cluster kubeconfig is used.")
+
+	return &webhookCmd
+}
+
+func runWebhook(wa *webhookArgs, info map[string]template.Info, adapters []adptr.InfoFn, printf, fatalf shared.FormatFn) {
+	s, err := store.NewRegistry2(config.Store2Inventory()...).NewStore2(wa.configStore2URL)
+	if err != nil {
+		fatalf("Failed to connect to the configuration server. %v", err)
+	}
+	tc, err := expr.NewCEXLEvaluator(expr.DefaultCacheSize)
+	if err != nil {
+		fatalf("Failed to create the expression type checker: %v", err)
+	}
+	v, err := mixerRuntime.NewValidator(s, config.InventoryMap(adapters), info, tc)
+	if err != nil {
+		fatalf("Failed to create the config validator: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(admissionPath, crd.NewAdmissionHandler(v))
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", wa.port),
+		Handler: mux,
+	}
+	printf("Starting the admission webhook on port %d, path %s", wa.port, admissionPath)
+	if err = server.ListenAndServeTLS(wa.certFile, wa.keyFile); err != nil {
+		fatalf("Admission webhook terminated: %v", err)
+	}
+}
@@ -12,7 +12,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package memquota
+package quotas
 
 import (
 	"fmt"
@@ -23,7 +23,7 @@ import (
 	"istio.io/mixer/pkg/pool"
 )
 
-// we maintain a pool of these for use by the makeKey function
+// we maintain a pool of these for use by the MakeKey function
 type keyWorkspace struct {
 	keys []string
 }
@@ -31,8 +31,8 @@ type keyWorkspace struct {
 // pool of reusable keyWorkspace structs
 var keyWorkspacePool = sync.Pool{New: func() interface{} { return &keyWorkspace{} }}
 
-// makeKey produces a unique key representing the given labels.
-func makeKey(name string, labels map[string]interface{}) string {
+// MakeKey produces a unique key representing the given labels.
+func MakeKey(name string, labels map[string]interface{}) string {
 	ws := keyWorkspacePool.Get().(*keyWorkspace)
 	keys := ws.keys
 	buf := pool.GetBuffer()
@@ -54,13 +54,13 @@ func makeKey(name string, labels map[string]interface{}) string {
 			buf.WriteString(v) // nolint: gas
 		case int64:
 			var bytes [32]byte
-			buf.Write(strconv.AppendInt(bytes[:], v, 16)) // nolint: gas
+			buf.Write(strconv.AppendInt(bytes[:0], v, 16)) // nolint: gas
 		case float64:
 			var bytes [32]byte
-			buf.Write(strconv.AppendFloat(bytes[:], v, 'b', -1, 64)) // nolint: gas
+			buf.Write(strconv.AppendFloat(bytes[:0], v, 'b', -1, 64)) // nolint: gas
 		case bool:
 			var bytes [32]byte
-			buf.Write(strconv.AppendBool(bytes[:], v)) // nolint: gas
+			buf.Write(strconv.AppendBool(bytes[:0], v)) // nolint: gas
 		case []byte:
 			buf.Write(v) // nolint: gas
 		case map[string]string:
@@ -78,10 +78,12 @@ func makeKey(name string, labels map[string]interface{}) string {
 				buf.WriteString(v[k2]) // nolint: gas
 			}
 
-			ws.keys = keys[:0]
+			ws.keys = mk[:0]
 			keyWorkspacePool.Put(ws)
+		case fmt.Stringer:
+			buf.WriteString(v.String()) // nolint: gas
 		default:
-			buf.WriteString(v.(fmt.Stringer).String()) // nolint: gas
+			fmt.Fprint(buf, v) // nolint: gas
 		}
 	}
 
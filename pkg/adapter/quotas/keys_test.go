@@ -0,0 +1,39 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quotas
+
+import (
+	"net"
+	"testing"
+)
+
+func TestMakeKey(t *testing.T) {
+	got := MakeKey("Q1", map[string]interface{}{
+		"b": []byte("bytes"),
+		"a": "string",
+		"c": int64(16),
+		"d": net.ParseIP("10.0.0.1"),
+		"e": true,
+		"f": map[string]string{"y": "2", "x": "1"},
+		"g": 3,
+	})
+	if want := "Q1;a=string;b=bytes;c=10;d=10.0.0.1;e=true;f=x1y2;g=3"; got != want {
+		t.Errorf("Got %s, Want %s", got, want)
+	}
+
+	if got = MakeKey("Q1", nil); got != "Q1" {
+		t.Errorf("Got %s, Want Q1", got)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package quotas provides the helpers shared by the quota adapters to select the override
+// which applies to an instance, and to key the state kept for it.
+package quotas // import "istio.io/mixer/pkg/adapter/quotas"
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Wildcard matches any sequence of characters in the values of override dimensions.
+const Wildcard = "*"
+
+// MatchDimensions matches configured dimensions, whose values may contain wildcards, with
+// dimensions of the instance.
+func MatchDimensions(cfg map[string]string, inst map[string]interface{}) bool {
+	for k, val := range cfg {
+		rval := inst[k]
+		if rval == val { // this dimension matches, on to next comparison.
+			continue
+		}
+
+		// if rval has a string representation then compare it with val
+		// For example net.ip has a useful string representation.
+		switch v := rval.(type) {
+		case string:
+			if MatchValue(val, v) {
+				continue
+			}
+		case fmt.Stringer:
+			if MatchValue(val, v.String()) {
+				continue
+			}
+		}
+		// rval does not match val.
+		return false
+	}
+	return true
+}
+
+// MatchValue matches a configured dimension value, which may contain wildcards,
+// with the string representation of an instance dimension.
+func MatchValue(pattern string, s string) bool {
+	if !strings.Contains(pattern, Wildcard) {
+		return pattern == s
+	}
+
+	parts := strings.Split(pattern, Wildcard)
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+
+	// the leftmost occurrence of each part leaves the most room for the next ones.
+	for _, p := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, p)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(p):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// MoreSpecific returns true if an override with dimensions a is more specific than one
+// with dimensions b: it has more dimensions, or fewer wildcard values, or longer values.
+// Adapters try the most specific overrides first.
+func MoreSpecific(a map[string]string, b map[string]string) bool {
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+
+	wa, la := wildcardsAndLength(a)
+	wb, lb := wildcardsAndLength(b)
+	if wa != wb {
+		return wa < wb
+	}
+	return la > lb
+}
+
+// wildcardsAndLength returns the number of wildcard values, and the number of
+// characters matched literally by the values.
+func wildcardsAndLength(dims map[string]string) (int, int) {
+	var wildcards, length int
+	for _, v := range dims {
+		if n := strings.Count(v, Wildcard); n > 0 {
+			wildcards++
+			length += len(v) - n
+		} else {
+			length += len(v)
+		}
+	}
+	return wildcards, length
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package quotas
+
+import (
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestMatchValue(t *testing.T) {
+	cases := []struct {
+		pattern string
+		s       string
+		result  bool
+	}{
+		{"abc", "abc", true},
+		{"abc", "abcd", false},
+		{"*", "", true},
+		{"*", "abc", true},
+		{"*.prod.svc.cluster.local", "reviews.prod.svc.cluster.local", true},
+		{"*.prod.svc.cluster.local", "reviews.dev.svc.cluster.local", false},
+		{"reviews.*", "reviews.prod", true},
+		{"reviews.*", "ratings.prod", false},
+		{"a*b*c", "abc", true},
+		{"a*b*c", "axxbyybc", true},
+		{"a*b*c", "axxcyyb", false},
+		{"a*bc", "abcbc", true},
+		{"ab*ba", "aba", false},
+		{"**", "abc", true},
+	}
+
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if result := MatchValue(c.pattern, c.s); result != c.result {
+				t.Errorf("Got %v, Want %v for %s and %s", result, c.result, c.pattern, c.s)
+			}
+		})
+	}
+}
+
+func TestMatchDimensions(t *testing.T) {
+	inst := map[string]interface{}{
+		"destination": "reviews.prod.svc.cluster.local",
+		"source":      net.ParseIP("10.0.0.1"),
+		"code":        int64(200),
+	}
+
+	cases := []struct {
+		cfg    map[string]string
+		result bool
+	}{
+		{nil, true},
+		{map[string]string{"destination": "reviews.prod.svc.cluster.local"}, true},
+		{map[string]string{"destination": "*.prod.svc.cluster.local", "source": "10.0.0.*"}, true},
+		{map[string]string{"destination": "*.prod.svc.cluster.local", "source": "10.1.0.*"}, false},
+		{map[string]string{"destination": "*", "missing": "*"}, false},
+		{map[string]string{"code": "200"}, false},
+	}
+
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if result := MatchDimensions(c.cfg, inst); result != c.result {
+				t.Errorf("Got %v, Want %v for %v", result, c.result, c.cfg)
+			}
+		})
+	}
+}
+
+func TestMoreSpecific(t *testing.T) {
+	cases := []struct {
+		a      map[string]string
+		b      map[string]string
+		result bool
+	}{
+		{map[string]string{"d": "*", "s": "*"}, map[string]string{"d": "a.local"}, true},
+		{map[string]string{"d": "a.local"}, map[string]string{"d": "*.local"}, true},
+		{map[string]string{"d": "*.svc.local"}, map[string]string{"d": "*.local"}, true},
+		{map[string]string{"d": "*.local"}, map[string]string{"d": "*.svc.local"}, false},
+		{map[string]string{"d": "a.local"}, map[string]string{"d": "b.local"}, false},
+		{nil, map[string]string{"d": "*"}, false},
+	}
+
+	for i, c := range cases {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if result := MoreSpecific(c.a, c.b); result != c.result {
+				t.Errorf("Got %v, Want %v for %v and %v", result, c.result, c.a, c.b)
+			}
+		})
+	}
+}
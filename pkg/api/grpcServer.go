@@ -135,7 +135,7 @@ func (s *grpcServer) Check(legacyCtx legacyContext.Context, req *mixerpb.CheckRe
 	preprocResponseBag := attribute.GetMutableBag(nil)
 
 	glog.V(1).Info("Dispatching Preprocess Check")
-	out := s.aspectDispatcher.Preprocess(legacyCtx, compatReqBag, preprocResponseBag)
+	out := s.preprocess(legacyCtx, compatReqBag, preprocResponseBag)
 
 	mutableBag := attribute.GetMutableBag(requestBag)
 	if err := mutableBag.PreserveMerge(preprocResponseBag); err != nil {
@@ -238,6 +238,18 @@ func (s *grpcServer) Check(legacyCtx legacyContext.Context, req *mixerpb.CheckRe
 	return resp, nil
 }
 
+// preprocess runs the preprocessing aspects, followed by the attribute generating adapters.
+func (s *grpcServer) preprocess(ctx legacyContext.Context, requestBag attribute.Bag, responseBag *attribute.MutableBag) rpc.Status {
+	out := s.aspectDispatcher.Preprocess(ctx, requestBag, responseBag)
+	if !status.IsOK(out) {
+		return out
+	}
+	if err := s.dispatcher.Preprocess(ctx, requestBag, responseBag); err != nil {
+		return status.WithError(err)
+	}
+	return out
+}
+
 func quota(legacyCtx legacyContext.Context, d runtime.Dispatcher, bag attribute.Bag,
 	qma *aspect.QuotaMethodArgs) (*mixerpb.CheckResponse_QuotaResult, error) {
 	if d == nil {
@@ -303,7 +315,7 @@ func (s *grpcServer) Report(legacyCtx legacyContext.Context, req *mixerpb.Report
 		}
 
 		glog.V(1).Info("Dispatching Preprocess")
-		out := s.aspectDispatcher.Preprocess(newctx, compatReqBag, preprocResponseBag)
+		out := s.preprocess(newctx, compatReqBag, preprocResponseBag)
 		mutableBag := attribute.GetMutableBag(requestBag)
 		if err := mutableBag.PreserveMerge(preprocResponseBag); err != nil {
 			out = status.WithError(fmt.Errorf("could not merge preprocess attributes into request attributes: %v", err))
@@ -464,6 +464,50 @@ func TestFailingPreproc(t *testing.T) {
 	}
 }
 
+func TestPreproc(t *testing.T) {
+	ts, err := prepTestState()
+	if err != nil {
+		t.Fatalf("Unable to prep test state: %v", err)
+	}
+	defer ts.cleanupTestState()
+
+	ts.preproc = func(ctx context.Context, requestBag attribute.Bag, responseBag *attribute.MutableBag) error {
+		responseBag.Set("source.labels", map[string]string{"app": "reviews"})
+		return nil
+	}
+
+	ts.check = func(ctx context.Context, requestBag attribute.Bag) (*adapter.CheckResult, error) {
+		if _, found := requestBag.Get("source.labels"); !found {
+			return nil, errors.New("generated attribute missing in Check")
+		}
+		return &adapter.CheckResult{Status: status.OK}, nil
+	}
+
+	{
+		request := mixerpb.CheckRequest{}
+		if _, err := ts.client.Check(context.Background(), &request); err != nil {
+			t.Errorf("Got unexpected error: %v", err)
+		}
+	}
+
+	ts.preproc = func(ctx context.Context, requestBag attribute.Bag, responseBag *attribute.MutableBag) error {
+		return errors.New("DEADBEEF!")
+	}
+
+	{
+		request := mixerpb.CheckRequest{}
+		resp, err := ts.client.Check(context.Background(), &request)
+		if resp != nil {
+			t.Error("Expecting no response, got one")
+		}
+		if err == nil {
+			t.Error("Got success, expected failure")
+		} else if !strings.Contains(err.Error(), "DEADBEEF!") {
+			t.Errorf("Got '%s', expected DEADBEEF!", err.Error())
+		}
+	}
+}
+
 func init() {
 	// bump up the log level so log-only logic runs during the tests, for correctness and coverage.
 	_ = flag.Lookup("v").Value.Set("99")
@@ -0,0 +1,157 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"istio.io/mixer/pkg/config/store"
+)
+
+// maxAdmissionReviewSize is the limit of the size of an AdmissionReview request body.
+const maxAdmissionReviewSize = 1 << 22
+
+// Validator validates a change of a config resource. spec is nil for deletions.
+type Validator interface {
+	Validate(t store.ChangeType, key store.Key, spec map[string]interface{}) error
+}
+
+// The types below mirror the JSON encoding of admission.k8s.io/v1beta1 AdmissionReview.
+// Only the fields used by mixer are declared.
+type (
+	admissionReview struct {
+		APIVersion string             `json:"apiVersion,omitempty"`
+		Kind       string             `json:"kind,omitempty"`
+		Request    *admissionRequest  `json:"request,omitempty"`
+		Response   *admissionResponse `json:"response,omitempty"`
+	}
+
+	admissionRequest struct {
+		UID       string                  `json:"uid"`
+		Kind      metav1.GroupVersionKind `json:"kind"`
+		Namespace string                  `json:"namespace,omitempty"`
+		Name      string                  `json:"name,omitempty"`
+		Operation string                  `json:"operation"`
+		Object    json.RawMessage         `json:"object,omitempty"`
+	}
+
+	admissionResponse struct {
+		UID     string         `json:"uid"`
+		Allowed bool           `json:"allowed"`
+		Result  *metav1.Status `json:"status,omitempty"`
+	}
+)
+
+// admission operations.
+const (
+	opCreate = "CREATE"
+	opUpdate = "UPDATE"
+	opDelete = "DELETE"
+)
+
+// admissionHandler serves AdmissionReview requests of a validating admission webhook.
+type admissionHandler struct {
+	validator Validator
+}
+
+// NewAdmissionHandler creates an http.Handler which serves the validating admission
+// webhook for the mixer config kinds. Requests for resources outside of the mixer
+// API group are always allowed.
+func NewAdmissionHandler(v Validator) http.Handler {
+	return &admissionHandler{validator: v}
+}
+
+// ServeHTTP implements http.Handler interface.
+func (h *admissionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	review := &admissionReview{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxAdmissionReviewSize)).Decode(review); err != nil {
+		http.Error(w, fmt.Sprintf("unable to decode AdmissionReview: %v", err), http.StatusBadRequest)
+		return
+	}
+	if review.Request == nil {
+		http.Error(w, "AdmissionReview has no request", http.StatusBadRequest)
+		return
+	}
+	review.Response = h.admit(review.Request)
+	review.Request = nil
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(review); err != nil {
+		glog.Warningf("Unable to write AdmissionReview response: %v", err)
+	}
+}
+
+// admit decides whether the change in the request is allowed.
+func (h *admissionHandler) admit(req *admissionRequest) *admissionResponse {
+	resp := &admissionResponse{UID: req.UID, Allowed: true}
+	if req.Kind.Group != apiGroup {
+		return resp
+	}
+	key := store.Key{Kind: req.Kind.Kind, Namespace: req.Namespace, Name: req.Name}
+	var t store.ChangeType
+	var spec map[string]interface{}
+	switch req.Operation {
+	case opCreate, opUpdate:
+		t = store.Update
+		uns := &unstructured.Unstructured{}
+		if err := json.Unmarshal(req.Object, &uns.Object); err != nil {
+			return deny(resp, key, fmt.Errorf("unable to decode the object: %v", err))
+		}
+		// the name may be generated, and the namespace defaulted by the API server.
+		if name := uns.GetName(); name != "" {
+			key.Name = name
+		}
+		if ns := uns.GetNamespace(); ns != "" {
+			key.Namespace = ns
+		}
+		spec, _ = uns.UnstructuredContent()["spec"].(map[string]interface{})
+		if spec == nil {
+			spec = map[string]interface{}{}
+		}
+	case opDelete:
+		t = store.Delete
+	default:
+		return resp
+	}
+
+	if err := h.validator.Validate(t, key, spec); err != nil {
+		return deny(resp, key, err)
+	}
+	return resp
+}
+
+// deny marks the response as rejected because of err.
+func deny(resp *admissionResponse, key store.Key, err error) *admissionResponse {
+	glog.Infof("Rejecting the change of %s: %v", key, err)
+	resp.Allowed = false
+	resp.Result = &metav1.Status{
+		Status:  metav1.StatusFailure,
+		Reason:  metav1.StatusReasonInvalid,
+		Code:    http.StatusUnprocessableEntity,
+		Message: fmt.Sprintf("%s is invalid: %v", key, err),
+	}
+	return resp
+}
@@ -0,0 +1,167 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package crd
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"istio.io/mixer/pkg/config/store"
+)
+
+type fakeValidator struct {
+	t    store.ChangeType
+	key  store.Key
+	spec map[string]interface{}
+	err  error
+}
+
+func (v *fakeValidator) Validate(t store.ChangeType, key store.Key, spec map[string]interface{}) error {
+	v.t, v.key, v.spec = t, key, spec
+	return v.err
+}
+
+const handlerReview = `{
+  "apiVersion": "admission.k8s.io/v1beta1",
+  "kind": "AdmissionReview",
+  "request": {
+    "uid": "0001",
+    "kind": {"group": "config.istio.io", "version": "v1alpha2", "kind": "denier"},
+    "namespace": "istio-system",
+    "name": "handler",
+    "operation": "%s",
+    "object": {
+      "apiVersion": "config.istio.io/v1alpha2",
+      "kind": "denier",
+      "metadata": {"name": "handler", "namespace": "istio-system"},
+      "spec": {"status": {"code": 7}}
+    }
+  }
+}`
+
+func postReview(t *testing.T, url, body string) *admissionReview {
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Got status %d, Want %d", resp.StatusCode, http.StatusOK)
+	}
+	review := &admissionReview{}
+	if err = json.NewDecoder(resp.Body).Decode(review); err != nil {
+		t.Fatal(err)
+	}
+	if review.Response == nil {
+		t.Fatal("Got no response in the AdmissionReview")
+	}
+	return review
+}
+
+func TestAdmissionHandler(t *testing.T) {
+	key := store.Key{Kind: "denier", Namespace: "istio-system", Name: "handler"}
+	spec := map[string]interface{}{"status": map[string]interface{}{"code": float64(7)}}
+	for _, tt := range []struct {
+		name      string
+		operation string
+		err       error
+		allowed   bool
+		wantType  store.ChangeType
+		wantSpec  map[string]interface{}
+	}{
+		{"create", opCreate, nil, true, store.Update, spec},
+		{"update", opUpdate, nil, true, store.Update, spec},
+		{"delete", opDelete, nil, true, store.Delete, nil},
+		{"invalid", opCreate, errors.New("bad config"), false, store.Update, spec},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &fakeValidator{err: tt.err}
+			server := httptest.NewServer(NewAdmissionHandler(v))
+			defer server.Close()
+
+			review := postReview(t, server.URL, strings.Replace(handlerReview, "%s", tt.operation, 1))
+			if review.Response.UID != "0001" || review.Response.Allowed != tt.allowed {
+				t.Errorf("Got %+v, Want uid 0001 and allowed=%v", review.Response, tt.allowed)
+			}
+			if review.Request != nil {
+				t.Errorf("Got request %+v, Want it dropped from the response", review.Request)
+			}
+			if v.t != tt.wantType || v.key != key || !reflect.DeepEqual(v.spec, tt.wantSpec) {
+				t.Errorf("Got %v %v %v, Want %v %v %v", v.t, v.key, v.spec, tt.wantType, key, tt.wantSpec)
+			}
+			if tt.allowed {
+				return
+			}
+			if review.Response.Result == nil || !strings.Contains(review.Response.Result.Message, "bad config") ||
+				review.Response.Result.Code != http.StatusUnprocessableEntity {
+				t.Errorf("Got %+v, Want the validation error in the status", review.Response.Result)
+			}
+		})
+	}
+}
+
+func TestAdmissionHandlerIgnored(t *testing.T) {
+	v := &fakeValidator{err: errors.New("should not be called")}
+	server := httptest.NewServer(NewAdmissionHandler(v))
+	defer server.Close()
+
+	for _, body := range []string{
+		`{"request": {"uid": "1", "kind": {"group": "apps", "version": "v1", "kind": "Deployment"}, "operation": "CREATE"}}`,
+		`{"request": {"uid": "2", "kind": {"group": "config.istio.io", "version": "v1alpha2", "kind": "rule"}, "operation": "CONNECT"}}`,
+	} {
+		if review := postReview(t, server.URL, body); !review.Response.Allowed {
+			t.Errorf("%s: Got denied, Want allowed", body)
+		}
+	}
+	if v.key != (store.Key{}) {
+		t.Errorf("Got the validator called for %v", v.key)
+	}
+}
+
+func TestAdmissionHandlerBadRequest(t *testing.T) {
+	server := httptest.NewServer(NewAdmissionHandler(&fakeValidator{}))
+	defer server.Close()
+
+	for _, body := range []string{"not json", "{}"} {
+		resp, err := http.Post(server.URL, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s: Got status %d, Want %d", body, resp.StatusCode, http.StatusBadRequest)
+		}
+	}
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("Got status %d, Want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+
+	// malformed objects are rejected by the review itself.
+	review := postReview(t, server.URL, `{"request": {"uid": "3", "kind": {"group": "config.istio.io", "kind": "rule"},
+		"operation": "CREATE", "object": "not an object"}}`)
+	if review.Response.Allowed {
+		t.Error("Got allowed, Want denied for a malformed object")
+	}
+}
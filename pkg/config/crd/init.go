@@ -21,7 +21,9 @@ import (
 
 	"github.com/golang/glog"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/rest"
@@ -59,11 +61,19 @@ func (b *dynamicListerWatcherBuilder) build(res metav1.APIResource) cache.Lister
 	return b.client.Resource(&res, "")
 }
 
+func (b *dynamicListerWatcherBuilder) patch(res metav1.APIResource, namespace, name string, data []byte) (*unstructured.Unstructured, error) {
+	return b.client.Resource(&res, namespace).Patch(name, types.MergePatchType, data)
+}
+
 // NewStore creates a new Store instance.
 func NewStore(u *url.URL) (store.Store2Backend, error) {
 	kubeconfig := u.Path
 	namespaces := u.Query().Get("ns")
 	retryTimeout := crdRetryTimeout
+	// The status of the resources is written back only when it is enabled
+	// through "status" query parameter, like k8s://?status=true. Mixer then
+	// needs the patch permission on its config resources.
+	writeStatus := u.Query().Get("status") == "true"
 	retryTimeoutParam := u.Query().Get("retry-timeout")
 	if retryTimeoutParam != "" {
 		if timeout, err := time.ParseDuration(retryTimeoutParam); err == nil {
@@ -81,6 +91,7 @@ func NewStore(u *url.URL) (store.Store2Backend, error) {
 	s := &Store{
 		conf:                 conf,
 		retryTimeout:         retryTimeout,
+		writeStatus:          writeStatus,
 		discoveryBuilder:     defaultDiscoveryBuilder,
 		listerWatcherBuilder: newDynamicListenerWatcherBuilder,
 	}
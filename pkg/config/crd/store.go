@@ -18,6 +18,8 @@ package crd
 
 import (
 	"context"
+	"encoding/json"
+	"reflect"
 	"sync"
 	"time"
 
@@ -56,8 +58,14 @@ var retryInterval = time.Second / 2
 
 type listerWatcherBuilderInterface interface {
 	build(res metav1.APIResource) cache.ListerWatcher
+
+	// patch applies a JSON merge patch to the object of the resource type in kubernetes.
+	patch(res metav1.APIResource, namespace, name string, data []byte) (*unstructured.Unstructured, error)
 }
 
+// statusAnnotation is the annotation of the custom resources recording their status in mixer.
+const statusAnnotation = apiGroup + "/status"
+
 func waitForSynced(ctx context.Context, informers map[string]cache.SharedInformer) <-chan struct{} {
 	out := make(chan struct{})
 	go func() {
@@ -87,8 +95,14 @@ type Store struct {
 	ns           map[string]bool
 	retryTimeout time.Duration
 
+	// writeStatus is true if the status of the resources should be written back
+	// to kubernetes.
+	writeStatus bool
+
 	cacheMutex sync.Mutex
 	caches     map[string]cache.Store
+	apiRes     map[string]metav1.APIResource
+	lwBuilder  listerWatcherBuilderInterface
 
 	watchMutex sync.RWMutex
 	watchCtx   context.Context
@@ -100,6 +114,7 @@ type Store struct {
 }
 
 var _ store.Store2Backend = &Store{}
+var _ store.StatusWriter = &Store{}
 
 // checkAndCreateCaches checks the presence of custom resource definitions through the discovery API,
 // and then create caches through lwBUilder which is in kinds. It retries within the timeout duration.
@@ -145,6 +160,7 @@ func (s *Store) checkAndCreateCaches(
 				cl := lwBuilder.build(res)
 				informer := cache.NewSharedInformer(cl, &unstructured.Unstructured{}, 0)
 				s.caches[res.Kind] = informer.GetStore()
+				s.apiRes[res.Kind] = res
 				informers[res.Kind] = informer
 				delete(kindsSet, res.Kind)
 				informer.AddEventHandler(s)
@@ -173,6 +189,8 @@ func (s *Store) Init(ctx context.Context, kinds []string) error {
 		return err
 	}
 	s.caches = make(map[string]cache.Store, len(kinds))
+	s.apiRes = make(map[string]metav1.APIResource, len(kinds))
+	s.lwBuilder = lwBuilder
 	informers, remainingKinds := s.checkAndCreateCaches(ctx, s.retryTimeout, d, lwBuilder, kinds)
 	if len(remainingKinds) > 0 {
 		// Wait asynchronously for other kinds.
@@ -279,8 +297,12 @@ func (s *Store) OnAdd(obj interface{}) {
 	}
 }
 
-// OnUpdate implements cache.ResourceEventHandler interface.
+// OnUpdate implements cache.ResourceEventHandler interface. The updates which
+// do not change the config, like the ones by WriteStatus, are not dispatched.
 func (s *Store) OnUpdate(oldObj, newObj interface{}) {
+	if !configChanged(oldObj.(*unstructured.Unstructured), newObj.(*unstructured.Unstructured)) {
+		return
+	}
 	ev := toEvent(store.Update, newObj)
 	if s.ns == nil || s.ns[ev.Key.Namespace] {
 		s.dispatch(ev)
@@ -295,3 +317,81 @@ func (s *Store) OnDelete(obj interface{}) {
 		s.dispatch(ev)
 	}
 }
+
+// configChanged returns true if the update changes what mixer reads from the resource.
+func configChanged(oldObj, newObj *unstructured.Unstructured) bool {
+	return !reflect.DeepEqual(oldObj.UnstructuredContent()["spec"], newObj.UnstructuredContent()["spec"]) ||
+		!reflect.DeepEqual(oldObj.GetLabels(), newObj.GetLabels()) ||
+		!reflect.DeepEqual(configAnnotations(oldObj), configAnnotations(newObj))
+}
+
+// configAnnotations returns the annotations of the resource, except the status written by mixer.
+func configAnnotations(obj *unstructured.Unstructured) map[string]string {
+	annotations := obj.GetAnnotations()
+	delete(annotations, statusAnnotation)
+	if len(annotations) == 0 {
+		return nil
+	}
+	return annotations
+}
+
+// statusContent converts the status into the content of the status annotation.
+func statusContent(status *store.ResourceStatus) map[string]interface{} {
+	content := map[string]interface{}{
+		"validated": status.Validated,
+	}
+	if status.Error != "" {
+		content["error"] = status.Error
+	}
+	if status.Active {
+		content["active"] = true
+	}
+	if status.HandlerError != "" {
+		content["handlerError"] = status.HandlerError
+	}
+	return content
+}
+
+// WriteStatus implements store.StatusWriter interface. The status is recorded as JSON in the
+// config.istio.io/status annotation of the custom resource, so it is visible through kubectl.
+// The annotation is set through a merge patch, which requires the patch permission on the
+// resources, and only when the status changes.
+func (s *Store) WriteStatus(key store.Key, status *store.ResourceStatus) error {
+	if !s.writeStatus {
+		return nil
+	}
+	s.cacheMutex.Lock()
+	c, ok := s.caches[key.Kind]
+	res := s.apiRes[key.Kind]
+	s.cacheMutex.Unlock()
+	if !ok {
+		return store.ErrNotFound
+	}
+	req := &unstructured.Unstructured{}
+	req.SetName(key.Name)
+	req.SetNamespace(key.Namespace)
+	obj, exists, err := c.Get(req)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return store.ErrNotFound
+	}
+	value, err := json.Marshal(statusContent(status))
+	if err != nil {
+		return err
+	}
+	if obj.(*unstructured.Unstructured).GetAnnotations()[statusAnnotation] == string(value) {
+		return nil
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{statusAnnotation: string(value)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = s.lwBuilder.patch(res, key.Namespace, key.Name, patch)
+	return err
+}
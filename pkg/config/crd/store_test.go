@@ -16,6 +16,7 @@ package crd
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"reflect"
 	"sync"
@@ -62,6 +63,7 @@ type dummyListerWatcherBuilder struct {
 	mu       sync.RWMutex
 	data     map[store.Key]*unstructured.Unstructured
 	watchers map[string]*watch.RaceFreeFakeWatcher
+	patches  int
 }
 
 func (d *dummyListerWatcherBuilder) build(res metav1.APIResource) cache.ListerWatcher {
@@ -88,6 +90,45 @@ func (d *dummyListerWatcherBuilder) build(res metav1.APIResource) cache.ListerWa
 	}
 }
 
+func (d *dummyListerWatcherBuilder) patch(res metav1.APIResource, namespace, name string, data []byte) (*unstructured.Unstructured, error) {
+	var p struct {
+		Metadata struct {
+			Annotations map[string]string `json:"annotations"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	key := store.Key{Kind: res.Kind, Namespace: namespace, Name: name}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	obj, ok := d.data[key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	d.patches++
+	// the cached objects must not be modified, the patched object is a new one.
+	patched := &unstructured.Unstructured{}
+	patched.SetKind(obj.GetKind())
+	patched.SetAPIVersion(obj.GetAPIVersion())
+	patched.SetName(name)
+	patched.SetNamespace(namespace)
+	patched.Object["spec"] = obj.Object["spec"]
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	for k, v := range p.Metadata.Annotations {
+		annotations[k] = v
+	}
+	patched.SetAnnotations(annotations)
+	d.data[key] = patched
+	if w, ok := d.watchers[key.Kind]; ok {
+		w.Modify(patched)
+	}
+	return patched, nil
+}
+
 func (d *dummyListerWatcherBuilder) put(key store.Key, spec map[string]interface{}) error {
 	res := &unstructured.Unstructured{}
 	res.SetKind(key.Kind)
@@ -137,6 +178,7 @@ func getTempClient() (*Store, string, *dummyListerWatcherBuilder) {
 	client := &Store{
 		conf:             &rest.Config{},
 		retryTimeout:     testingRetryTimeout,
+		writeStatus:      true,
 		discoveryBuilder: createFakeDiscovery,
 		listerWatcherBuilder: func(*rest.Config) (listerWatcherBuilderInterface, error) {
 			return lw, nil
@@ -434,3 +476,65 @@ loop:
 		t.Errorf("Got %v, Want nil", err)
 	}
 }
+
+func TestStoreWriteStatus(t *testing.T) {
+	s, ns, lw := getTempClient()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	k := store.Key{Kind: "Handler", Namespace: ns, Name: "default"}
+	if err := lw.put(k, map[string]interface{}{"adapter": "noop"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Init(ctx, []string{"Handler", "Action"}); err != nil {
+		t.Fatal(err)
+	}
+	wch, err := s.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := &store.ResourceStatus{Validated: true, HandlerError: "failed", Active: true}
+	if err = s.WriteStatus(k, status); err != nil {
+		t.Fatalf("Got %v, Want nil", err)
+	}
+	want := `{"active":true,"handlerError":"failed","validated":true}`
+	lw.mu.RLock()
+	got := lw.data[k].GetAnnotations()[statusAnnotation]
+	lw.mu.RUnlock()
+	if got != want {
+		t.Errorf("Got %s, Want %s", got, want)
+	}
+	// The status update should not be seen as a config change.
+	if err = waitFor(wch, store.Update, k); err == nil {
+		t.Error("Got an update event, Want none")
+	}
+
+	// Writing the same status again does not update the resource.
+	if err = s.WriteStatus(k, status); err != nil {
+		t.Fatalf("Got %v, Want nil", err)
+	}
+	lw.mu.RLock()
+	patches := lw.patches
+	lw.mu.RUnlock()
+	if patches != 1 {
+		t.Errorf("Got %d patches, Want 1", patches)
+	}
+
+	if err = s.WriteStatus(store.Key{Kind: "Handler", Namespace: ns, Name: "unknown"}, status); err != store.ErrNotFound {
+		t.Errorf("Got %v, Want ErrNotFound", err)
+	}
+	if err = s.WriteStatus(store.Key{Kind: "Unknown", Namespace: ns, Name: "default"}, status); err != store.ErrNotFound {
+		t.Errorf("Got %v, Want ErrNotFound", err)
+	}
+
+	s.writeStatus = false
+	if err = s.WriteStatus(k, &store.ResourceStatus{}); err != nil {
+		t.Errorf("Got %v, Want nil", err)
+	}
+	lw.mu.RLock()
+	patches = lw.patches
+	lw.mu.RUnlock()
+	if patches != 1 {
+		t.Errorf("Got %d patches, Want 1 when the status is disabled", patches)
+	}
+}
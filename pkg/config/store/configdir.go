@@ -0,0 +1,108 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// Location is the position of a resource in a config file.
+type Location struct {
+	Path string
+	Line int
+}
+
+// String implements fmt.Stringer interface.
+func (l Location) String() string {
+	return fmt.Sprintf("%s:%d", l.Path, l.Line)
+}
+
+// FileResource is a resource read from a config file.
+type FileResource struct {
+	Key
+	APIVersion string
+	Spec       map[string]interface{}
+	Location
+}
+
+// FileError is a problem to read or parse a config file.
+type FileError struct {
+	Location
+	Err error
+}
+
+// Error implements error interface.
+func (e *FileError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Location, e.Err)
+}
+
+// ReadConfigDir reads the resources of all of the config files under the directory,
+// in the order of their paths and positions. Unlike fsStore2, every resource is
+// returned regardless of its kind, and the problems of the files are reported
+// instead of being skipped, so that the config can be checked offline.
+func ReadConfigDir(dir string) ([]*FileResource, []*FileError) {
+	var resources []*FileResource
+	var errs []*FileError
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && isHidden(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && supportedExtensions[filepath.Ext(path)] {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, []*FileError{{Location: Location{Path: dir}, Err: err}}
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			errs = append(errs, &FileError{Location: Location{Path: path}, Err: err})
+			continue
+		}
+		for _, doc := range splitDocuments(data) {
+			loc := Location{Path: path, Line: doc.line}
+			r, err := parseChunk(doc.data)
+			if err != nil {
+				errs = append(errs, &FileError{Location: loc, Err: err})
+				continue
+			}
+			if r == nil {
+				continue
+			}
+			resources = append(resources, &FileResource{
+				Key:        r.Key(),
+				APIVersion: r.APIVersion,
+				Spec:       r.Spec,
+				Location:   loc,
+			})
+		}
+	}
+	return resources, errs
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitDocuments(t *testing.T) {
+	for _, c := range []struct {
+		title string
+		data  string
+		lines []int
+	}{
+		{"empty", "", nil},
+		{"single", "kind: a\nspec:\n", []int{1}},
+		{"leading separator", "---\nkind: a\n---\nkind: b\n", []int{2, 4}},
+		{"multiline", "kind: a\nspec:\n  x: y\n---\n\n---\nkind: b\n---\n", []int{1, 5, 7}},
+	} {
+		docs := splitDocuments([]byte(c.data))
+		var lines []int
+		for _, d := range docs {
+			lines = append(lines, d.line)
+		}
+		if !reflect.DeepEqual(lines, c.lines) {
+			t.Errorf("%s: Got %v, Want %v", c.title, lines, c.lines)
+		}
+	}
+}
+
+const configDirData = `apiVersion: config.istio.io/v1alpha2
+kind: Handler
+metadata:
+  namespace: ns
+  name: h1
+spec:
+  adapter: a
+---
+# comments only
+---
+apiVersion: config.istio.io/v1alpha2
+kind: Rule
+metadata:
+  namespace: ns
+  name: r1
+spec:
+  match: "true"
+---
+kind: Broken
+metadata:
+  namespace: ns
+`
+
+func TestReadConfigDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	files := map[string]string{
+		"a.yaml":            configDirData,
+		"b/c.yml":           "kind: Instance\nmetadata:\n  namespace: ns\n  name: i1\n",
+		"notes.txt":         "kind: Ignored\n",
+		"..2017/hidden.yml": "kind: Hidden\nmetadata:\n  namespace: ns\n  name: h\n",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resources, errs := ReadConfigDir(dir)
+	var got []string
+	for _, r := range resources {
+		got = append(got, r.Key.String()+"@"+r.Location.String())
+	}
+	a := filepath.Join(dir, "a.yaml")
+	want := []string{
+		"h1.Handler.ns@" + a + ":1",
+		"r1.Rule.ns@" + a + ":11",
+		"i1.Instance.ns@" + filepath.Join(dir, "b/c.yml") + ":1",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Got %v\nWant %v", got, want)
+	}
+	if resources[0].APIVersion != "config.istio.io/v1alpha2" || resources[0].Spec["adapter"] != "a" {
+		t.Errorf("Got %+v, Want the apiVersion and spec of the resource", resources[0])
+	}
+	if len(errs) != 1 || errs[0].Location != (Location{Path: a, Line: 19}) {
+		t.Errorf("Got %v, Want an error at %s:19", errs, a)
+	}
+
+	if _, errs = ReadConfigDir(filepath.Join(dir, "missing")); len(errs) != 1 {
+		t.Errorf("Got %v, Want an error for a missing directory", errs)
+	}
+}
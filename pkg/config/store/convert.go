@@ -67,3 +67,16 @@ func convert(key Key, spec map[string]interface{}, target proto.Message) error {
 
 	return err
 }
+
+// ParseSpec converts the unstructured spec of a resource into the proto message
+// registered for the kind of the key.
+func ParseSpec(key Key, spec map[string]interface{}, kinds map[string]proto.Message) (proto.Message, error) {
+	pbSpec, err := cloneMessage(key.Kind, kinds)
+	if err != nil {
+		return nil, err
+	}
+	if err = convert(key, spec, pbSpec); err != nil {
+		return nil, err
+	}
+	return pbSpec, nil
+}
@@ -118,3 +118,23 @@ func TestCloneWithKind(t *testing.T) {
 		})
 	}
 }
+
+func TestParseSpec(t *testing.T) {
+	kinds := map[string]proto.Message{"Handler": &cfg.Handler{}}
+	spec, err := ParseSpec(Key{Kind: "Handler"}, map[string]interface{}{"name": "foo", "adapter": "a"}, kinds)
+	if err != nil {
+		t.Fatalf("Got %v, Want nil", err)
+	}
+	if want := (&cfg.Handler{Name: "foo", Adapter: "a"}); !reflect.DeepEqual(spec, want) {
+		t.Errorf("Got %+v, Want %+v", spec, want)
+	}
+	if _, err = ParseSpec(Key{Kind: "Unknown"}, map[string]interface{}{}, kinds); err == nil {
+		t.Error("Got nil, Want error for an unknown kind")
+	}
+	if _, err = ParseSpec(Key{Kind: "Handler"}, map[string]interface{}{"foo": 1}, kinds); err == nil {
+		t.Error("Got nil, Want error for an invalid spec")
+	}
+	if kinds["Handler"].(*cfg.Handler).Name != "" {
+		t.Error("ParseSpec should not modify the registered message")
+	}
+}
@@ -23,13 +23,28 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/ghodss/yaml"
 	"github.com/golang/glog"
 )
 
-const defaultDuration = time.Second / 2
+const (
+	// defaultDuration is the interval to re-read the files when the filesystem
+	// notifications are not available.
+	defaultDuration = time.Second / 2
+
+	// defaultDebounceDuration is the quiet period after the last filesystem
+	// notification before the changes are applied.
+	defaultDebounceDuration = time.Millisecond * 100
+)
+
+// k8sHiddenPrefix is the prefix of the entries which Kubernetes creates in
+// ConfigMap volumes, like the "..data" symlink to the current data directory.
+const k8sHiddenPrefix = ".."
 
 var supportedExtensions = map[string]bool{
 	".yaml": true,
@@ -55,19 +70,36 @@ func (r *resource) Key() Key {
 // fsStore2 is Store2Backend implementation using filesystem.
 type fsStore2 struct {
 	memstore
-	root          string
-	kinds         map[string]bool
-	checkDuration time.Duration
-	shas          map[Key][sha1.Size]byte
+	root             string
+	kinds            map[string]bool
+	checkDuration    time.Duration
+	debounceDuration time.Duration
+	shas             map[Key][sha1.Size]byte
+
+	// files caches the parsed resources of each file, so that only the changed
+	// files are parsed again.
+	files map[string][]*resource
+
+	// newWatcher creates the watcher of the filesystem. Replaced in unittests.
+	newWatcher func() (*fsnotify.Watcher, error)
 }
 
 var _ Store2Backend = &fsStore2{}
 
-// parseFile parses the data and returns as a slice of resources. "path" is only used
-// for error reporting.
-func parseFile(path string, data []byte) []*resource {
+// document is a single YAML document in a config file.
+type document struct {
+	data []byte
+
+	// line is the line number where the document starts in the file.
+	line int
+}
+
+// splitDocuments splits the content of a file into its YAML documents.
+func splitDocuments(data []byte) []document {
+	line := 1
 	if bytes.HasPrefix(data, []byte("---\n")) {
 		data = data[4:]
+		line++
 	}
 	if bytes.HasSuffix(data, []byte("\n")) {
 		data = data[:len(data)-1]
@@ -79,11 +111,24 @@ func parseFile(path string, data []byte) []*resource {
 		return nil
 	}
 	chunks := bytes.Split(data, []byte("\n---\n"))
-	resources := make([]*resource, 0, len(chunks))
-	for i, chunk := range chunks {
-		r, err := parseChunk(chunk)
+	docs := make([]document, 0, len(chunks))
+	for _, chunk := range chunks {
+		docs = append(docs, document{data: chunk, line: line})
+		// the lines of the chunk, and the separator.
+		line += bytes.Count(chunk, []byte("\n")) + 2
+	}
+	return docs
+}
+
+// parseFile parses the data and returns as a slice of resources. "path" is only used
+// for error reporting.
+func parseFile(path string, data []byte) []*resource {
+	docs := splitDocuments(data)
+	resources := make([]*resource, 0, len(docs))
+	for _, doc := range docs {
+		r, err := parseChunk(doc.data)
 		if err != nil {
-			glog.Errorf("Error processing %s[%d]: %v", path, i, err)
+			glog.Errorf("Error processing %s:%d: %v", path, doc.line, err)
 			continue
 		}
 		if r == nil {
@@ -119,27 +164,61 @@ func empty(r *resource) bool {
 	return reflect.DeepEqual(*r, *emptyResource)
 }
 
-func (s *fsStore2) readFiles() map[Key]*resource {
-	result := map[Key]*resource{}
+// isHidden returns true for the hidden entries that Kubernetes uses to project
+// ConfigMap volumes: the timestamped data directories and the "..data" symlink.
+func isHidden(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), k8sHiddenPrefix)
+}
+
+// readFile reads and parses a single file. Returns false if the file is not a
+// config file or it cannot be read.
+func (s *fsStore2) readFile(path string) ([]*resource, bool) {
+	if !supportedExtensions[filepath.Ext(path)] {
+		return nil, false
+	}
+	// os.Stat follows symlinks, like the ones to the "..data" directory of Kubernetes volumes.
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		glog.Warningf("Failed to read %s: %v", path, err)
+		return nil, false
+	}
+	resources := []*resource{}
+	for _, r := range parseFile(path, data) {
+		if s.kinds[r.Kind] {
+			resources = append(resources, r)
+		}
+	}
+	return resources, true
+}
 
-	err := filepath.Walk(s.root, func(path string, info os.FileInfo, err error) error {
+// readDir reads all of the config files under the directory. The hidden data directories
+// of Kubernetes volumes are skipped since their files are read through the symlinks.
+func (s *fsStore2) readDir(dir string) map[string][]*resource {
+	result := map[string][]*resource{}
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			return err
+			if path == dir {
+				return err
+			}
+			glog.Warningf("Failed to access %s: %v", path, err)
+			return nil
 		}
-		if !supportedExtensions[filepath.Ext(path)] || (info.Mode()&os.ModeType) != 0 {
+		if path != s.root && isHidden(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
-		data, err := ioutil.ReadFile(path)
-		if err != nil {
-			glog.Warningf("Failed to read %s: %v", path, err)
-			return err
+		if info.IsDir() {
+			return nil
 		}
-		for _, r := range parseFile(path, data) {
-			k := r.Key()
-			if !s.kinds[k.Kind] {
-				continue
-			}
-			result[r.Key()] = r
+		if resources, ok := s.readFile(path); ok {
+			result[path] = resources
 		}
 		return nil
 	})
@@ -149,65 +228,201 @@ func (s *fsStore2) readFiles() map[Key]*resource {
 	return result
 }
 
-func (s *fsStore2) checkAndUpdate() {
-	newData := s.readFiles()
+// removeFiles drops the cached resources of the path, and of the files under it
+// if the path was a directory.
+func (s *fsStore2) removeFiles(path string) {
+	prefix := path + string(filepath.Separator)
+	for p := range s.files {
+		if p == path || strings.HasPrefix(p, prefix) {
+			delete(s.files, p)
+		}
+	}
+}
+
+// resources merges the resources of all of the files. The files are merged in
+// the order of their paths, so that a duplicated key resolves deterministically.
+func (s *fsStore2) resources() map[Key]*resource {
+	paths := make([]string, 0, len(s.files))
+	for p := range s.files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	result := map[Key]*resource{}
+	for _, p := range paths {
+		for _, r := range s.files[p] {
+			result[r.Key()] = r
+		}
+	}
+	return result
+}
+
+// update compares the cached files with the data in the store, and applies
+// the differences.
+func (s *fsStore2) update() {
+	newData := s.resources()
 	updated := []Key{}
 	removed := map[Key]bool{}
-	for k := range s.data {
+	for k := range s.shas {
 		removed[k] = true
 	}
 	for k, r := range newData {
-		oldSha, ok := s.shas[k]
-		s.shas[k] = r.sha
-		if !ok {
-			updated = append(updated, k)
-			continue
-		}
 		delete(removed, k)
-		if r.sha != oldSha {
-			updated = append(updated, k)
+		if oldSha, ok := s.shas[k]; ok && oldSha == r.sha {
+			continue
 		}
-	}
-	if len(updated) == 0 && len(removed) == 0 {
-		return
+		s.shas[k] = r.sha
+		updated = append(updated, k)
 	}
 	for _, k := range updated {
 		s.Put(k, &BackEndResource{Metadata: newData[k].Metadata, Spec: newData[k].Spec})
 	}
 	for k := range removed {
+		delete(s.shas, k)
 		s.Delete(k)
 	}
 }
 
+// checkAndUpdate re-reads the whole tree.
+func (s *fsStore2) checkAndUpdate() {
+	s.files = s.readDir(s.root)
+	s.update()
+}
+
+// updatePaths re-reads only the given paths. A path can be a file, a new directory,
+// or an entry which has been removed.
+func (s *fsStore2) updatePaths(paths map[string]bool) {
+	for path := range paths {
+		s.removeFiles(path)
+		info, err := os.Stat(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				glog.Warningf("Failed to access %s: %v", path, err)
+			}
+			continue
+		}
+		if info.IsDir() {
+			for p, resources := range s.readDir(path) {
+				s.files[p] = resources
+			}
+			continue
+		}
+		if resources, ok := s.readFile(path); ok {
+			s.files[path] = resources
+		}
+	}
+	s.update()
+}
+
+// addWatches adds the directory and its subdirectories to the watcher.
+func (s *fsStore2) addWatches(w *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != s.root && isHidden(path) {
+			return filepath.SkipDir
+		}
+		return w.Add(path)
+	})
+}
+
+// watch applies the changes notified by the watcher until ctx is done. The notifications
+// are collected until none arrives for debounceDuration, so that a burst of edits leads
+// to a single update, and then only the changed paths are re-read.
+func (s *fsStore2) watch(ctx context.Context, w *fsnotify.Watcher) {
+	pending := map[string]bool{}
+	reload := false
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			if err := w.Close(); err != nil {
+				glog.Warningf("Failed to close the watcher for %s: %v", s.root, err)
+			}
+			return
+		case ev := <-w.Events:
+			if isHidden(ev.Name) {
+				// The "..data" symlink of a Kubernetes volume is swapped, which
+				// changes all of the files at once.
+				reload = true
+			} else {
+				pending[ev.Name] = true
+			}
+			if ev.Op&fsnotify.Create != 0 {
+				if err := s.addWatches(w, ev.Name); err != nil {
+					glog.Warningf("Failed to watch %s: %v", ev.Name, err)
+				}
+			}
+			debounce = time.After(s.debounceDuration)
+		case err := <-w.Errors:
+			// Notifications may be lost, e.g. because of a queue overflow.
+			glog.Warningf("Error while watching %s: %v", s.root, err)
+			reload = true
+			debounce = time.After(s.debounceDuration)
+		case <-debounce:
+			if reload {
+				s.checkAndUpdate()
+			} else {
+				s.updatePaths(pending)
+			}
+			pending = map[string]bool{}
+			reload = false
+			debounce = nil
+		}
+	}
+}
+
+// poll re-reads the whole tree every checkDuration until ctx is done.
+func (s *fsStore2) poll(ctx context.Context) {
+	tick := time.NewTicker(s.checkDuration)
+	for {
+		select {
+		case <-ctx.Done():
+			tick.Stop()
+			return
+		case <-tick.C:
+			s.checkAndUpdate()
+		}
+	}
+}
+
 // NewFsStore2 creates a new Store2Backend backed by the filesystem.
 func NewFsStore2(root string) Store2Backend {
 	return &fsStore2{
 		// Not using createMemstore to avoid access of MemstoreWriter for fsstore2.
-		memstore:      memstore{data: map[Key]*BackEndResource{}},
-		root:          root,
-		kinds:         map[string]bool{},
-		checkDuration: defaultDuration,
-		shas:          map[Key][sha1.Size]byte{},
+		memstore:         memstore{data: map[Key]*BackEndResource{}},
+		root:             root,
+		kinds:            map[string]bool{},
+		checkDuration:    defaultDuration,
+		debounceDuration: defaultDebounceDuration,
+		shas:             map[Key][sha1.Size]byte{},
+		files:            map[string][]*resource{},
+		newWatcher:       fsnotify.NewWatcher,
 	}
 }
 
-// Init implements Store2Backend interface.
+// Init implements Store2Backend interface. It watches the filesystem for the
+// changes, or falls back to polling when the notifications are not available.
 func (s *fsStore2) Init(ctx context.Context, kinds []string) error {
 	for _, k := range kinds {
 		s.kinds[k] = true
 	}
-	s.checkAndUpdate()
-	go func() {
-		tick := time.NewTicker(s.checkDuration)
-		for {
-			select {
-			case <-ctx.Done():
-				tick.Stop()
-				return
-			case <-tick.C:
-				s.checkAndUpdate()
-			}
+	// The watches are added before reading the files, so that no change is missed.
+	w, err := s.newWatcher()
+	if err == nil {
+		if err = s.addWatches(w, s.root); err != nil {
+			_ = w.Close()
 		}
-	}()
+	}
+	s.checkAndUpdate()
+	if err != nil {
+		glog.Warningf("Failed to watch %s, polling every %v instead: %v", s.root, s.checkDuration, err)
+		go s.poll(ctx)
+		return nil
+	}
+	go s.watch(ctx, w)
 	return nil
 }
@@ -16,6 +16,7 @@ package store
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -24,6 +25,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/ghodss/yaml"
 )
 
@@ -33,6 +35,7 @@ func getTempFSStore2() (*fsStore2, string) {
 	fsroot, _ := ioutil.TempDir("/tmp/", "fsStore2-")
 	s := NewFsStore2(fsroot).(*fsStore2)
 	s.checkDuration = testingCheckDuration
+	s.debounceDuration = testingCheckDuration
 	return s, fsroot
 }
 
@@ -380,3 +383,158 @@ spec:
 		})
 	}
 }
+
+func TestFSStore2Polling(t *testing.T) {
+	s, fsroot := getTempFSStore2()
+	defer cleanupRootIfOK(t, fsroot)
+	s.newWatcher = func() (*fsnotify.Watcher, error) {
+		return nil, errors.New("dummy")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Init(ctx, []string{"Handler"}); err != nil {
+		t.Fatal(err)
+	}
+	wch, err := s.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := Key{Kind: "Handler", Namespace: "ns", Name: "default"}
+	if err = write(fsroot, k, map[string]interface{}{"adapter": "noop"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(wch, Update, k)
+	if err = os.Remove(filepath.Join(fsroot, k.Kind, k.Namespace, k.Name+".yaml")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(wch, Delete, k)
+}
+
+func TestFSStore2Debounce(t *testing.T) {
+	s, fsroot := getTempFSStore2()
+	defer cleanupRootIfOK(t, fsroot)
+	s.debounceDuration = time.Millisecond * 50
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Init(ctx, []string{"Handler"}); err != nil {
+		t.Fatal(err)
+	}
+	wch, err := s.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := Key{Kind: "Handler", Namespace: "ns", Name: "default"}
+	for _, a := range []string{"a1", "a2", "a3"} {
+		if err = write(fsroot, k, map[string]interface{}{"adapter": a}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ev := <-wch
+	if ev.Type != Update || ev.Value.Spec["adapter"] != "a3" {
+		t.Errorf("Got %+v, Want the update with a3", ev)
+	}
+	select {
+	case ev = <-wch:
+		t.Errorf("Got %+v, Want no more events", ev)
+	case <-time.After(s.debounceDuration * 4):
+	}
+}
+
+func TestFSStore2ParseChangedOnly(t *testing.T) {
+	s, fsroot := getTempFSStore2()
+	defer cleanupRootIfOK(t, fsroot)
+	k1 := Key{Kind: "Handler", Namespace: "ns", Name: "h1"}
+	k2 := Key{Kind: "Handler", Namespace: "ns", Name: "h2"}
+	for _, k := range []Key{k1, k2} {
+		if err := write(fsroot, k, map[string]interface{}{"adapter": "noop"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The paths are updated directly rather than through the watcher, so that the
+	// cached files aren't accessed concurrently.
+	s.kinds[k1.Kind] = true
+	s.checkAndUpdate()
+	path1 := filepath.Join(fsroot, k1.Kind, k1.Namespace, k1.Name+".yaml")
+	path2 := filepath.Join(fsroot, k2.Kind, k2.Namespace, k2.Name+".yaml")
+	before := s.files[path2][0]
+	if err := write(fsroot, k1, map[string]interface{}{"adapter": "noop2"}); err != nil {
+		t.Fatal(err)
+	}
+	s.updatePaths(map[string]bool{path1: true})
+	r, err := s.Get(k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adapter := r.Spec["adapter"]; adapter != "noop2" {
+		t.Errorf("Got adapter %v, Want noop2", adapter)
+	}
+	if after := s.files[path2][0]; after != before {
+		t.Errorf("%s is parsed again, Want only the changed file to be parsed", path2)
+	}
+}
+
+// writeK8sVolume mimics the way kubelet updates ConfigMap volumes: the data is written
+// to a new timestamped directory, and the "..data" symlink is atomically swapped.
+func writeK8sVolume(fsroot, version string, files map[string][]byte) error {
+	dir := filepath.Join(fsroot, "..data_"+version)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return err
+		}
+		link := filepath.Join(fsroot, name)
+		if _, err := os.Lstat(link); err == nil {
+			continue
+		}
+		if err := os.Symlink(filepath.Join("..data", name), link); err != nil {
+			return err
+		}
+	}
+	tmp := filepath.Join(fsroot, "..data_tmp")
+	if err := os.Symlink(filepath.Base(dir), tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(fsroot, "..data"))
+}
+
+func TestFSStore2K8sVolume(t *testing.T) {
+	s, fsroot := getTempFSStore2()
+	defer cleanupRootIfOK(t, fsroot)
+	const tmpl = `
+kind: Handler
+apiVersion: config.istio.io/v1alpha2
+metadata:
+  namespace: ns
+  name: default
+spec:
+  adapter: %s
+`
+	k := Key{Kind: "Handler", Namespace: "ns", Name: "default"}
+	if err := writeK8sVolume(fsroot, "1", map[string][]byte{"handler.yaml": []byte(fmt.Sprintf(tmpl, "noop"))}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Init(ctx, []string{"Handler"}); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := s.Get(k); err != nil || r.Spec["adapter"] != "noop" {
+		t.Fatalf("Got %+v, %v, Want noop", r, err)
+	}
+	wch, err := s.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = writeK8sVolume(fsroot, "2", map[string][]byte{"handler.yaml": []byte(fmt.Sprintf(tmpl, "noop2"))}); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.RemoveAll(filepath.Join(fsroot, "..data_1")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(wch, Update, k)
+	if r, err := s.Get(k); err != nil || r.Spec["adapter"] != "noop2" {
+		t.Errorf("Got %+v, %v, Want noop2", r, err)
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// layerSeparator separates the URLs of the layers in a config store URL,
+// like fs:///etc/mixer/base,k8s://
+const layerSeparator = ","
+
+// Layer is a named Store2Backend which is merged with others by a layered store.
+type Layer struct {
+	// Name identifies the layer. It is recorded in ResourceMeta.Layer of the
+	// resources which come from this layer.
+	Name    string
+	Backend Store2Backend
+}
+
+// layeredStore2 is a Store2Backend which merges several backends. The layers
+// are ordered by their priority; when a key exists in more than one layer, the
+// resource in the layer with the highest index wins.
+type layeredStore2 struct {
+	layers []Layer
+}
+
+var _ Store2Backend = &layeredStore2{}
+var _ StatusWriter = &layeredStore2{}
+
+// layerEvent is a BackendEvent tagged with the index of the layer which sent it.
+type layerEvent struct {
+	BackendEvent
+	layer int
+}
+
+// NewLayeredStore2 creates a new Store2Backend which merges the given layers.
+// The layers are ordered from the lowest to the highest priority, so a resource
+// in a later layer overrides the resource with the same key in the earlier ones.
+func NewLayeredStore2(layers ...Layer) Store2Backend {
+	return &layeredStore2{layers: layers}
+}
+
+// withLayer returns a copy of the resource which records the layer it comes from.
+func withLayer(r *BackEndResource, layer string) *BackEndResource {
+	if r == nil {
+		return nil
+	}
+	copied := *r
+	copied.Metadata.Layer = layer
+	return &copied
+}
+
+// Init implements Store2Backend interface.
+func (s *layeredStore2) Init(ctx context.Context, kinds []string) error {
+	for _, l := range s.layers {
+		if err := l.Backend.Init(ctx, kinds); err != nil {
+			return fmt.Errorf("failed to initialize layer %s: %v", l.Name, err)
+		}
+	}
+	return nil
+}
+
+// Watch implements Store2Backend interface. The events from all of the layers
+// are merged into the returned channel. An event is dropped when the key is
+// shadowed by a layer with higher priority, and the deletion of a key reveals
+// the resource of the same key in the lower layers, if any.
+func (s *layeredStore2) Watch(ctx context.Context) (<-chan BackendEvent, error) {
+	chs := make([]<-chan BackendEvent, len(s.layers))
+	for i, l := range s.layers {
+		ch, err := l.Backend.Watch(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to watch layer %s: %v", l.Name, err)
+		}
+		chs[i] = ch
+	}
+	merged := make(chan layerEvent)
+	for i, ch := range chs {
+		go forwardLayerEvents(ctx, i, ch, merged)
+	}
+	out := make(chan BackendEvent)
+	go s.run(ctx, merged, out)
+	return out, nil
+}
+
+func forwardLayerEvents(ctx context.Context, layer int, chin <-chan BackendEvent, chout chan<- layerEvent) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-chin:
+			if !ok {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case chout <- layerEvent{BackendEvent: ev, layer: layer}:
+			}
+		}
+	}
+}
+
+func (s *layeredStore2) run(ctx context.Context, chin <-chan layerEvent, chout chan<- BackendEvent) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-chin:
+			merged, ok := s.resolve(ev)
+			if !ok {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case chout <- merged:
+			}
+		}
+	}
+}
+
+// resolve translates an event of a layer into the event of the merged view.
+// Returns false if the event is not visible in the merged view.
+func (s *layeredStore2) resolve(ev layerEvent) (BackendEvent, bool) {
+	if _, layer, err := s.lookup(ev.Key, ev.layer+1, len(s.layers)); err == nil {
+		glog.V(4).Infof("Event for %s in layer %s is shadowed by layer %s",
+			ev.Key, s.layers[ev.layer].Name, s.layers[layer].Name)
+		return BackendEvent{}, false
+	}
+	if ev.Type == Update {
+		return BackendEvent{
+			Key:   ev.Key,
+			Type:  Update,
+			Value: withLayer(ev.Value, s.layers[ev.layer].Name),
+		}, true
+	}
+	if r, layer, err := s.lookup(ev.Key, 0, ev.layer); err == nil {
+		return BackendEvent{
+			Key:   ev.Key,
+			Type:  Update,
+			Value: withLayer(r, s.layers[layer].Name),
+		}, true
+	}
+	return BackendEvent{Key: ev.Key, Type: Delete}, true
+}
+
+// lookup finds the key in the layers within [from, to), from the highest priority.
+// It returns the resource and the index of the layer which holds it.
+func (s *layeredStore2) lookup(key Key, from, to int) (*BackEndResource, int, error) {
+	for i := to - 1; i >= from; i-- {
+		r, err := s.layers[i].Backend.Get(key)
+		if err == ErrNotFound {
+			continue
+		}
+		return r, i, err
+	}
+	return nil, -1, ErrNotFound
+}
+
+// Get implements Store2Backend interface.
+func (s *layeredStore2) Get(key Key) (*BackEndResource, error) {
+	r, layer, err := s.lookup(key, 0, len(s.layers))
+	if err != nil {
+		return nil, err
+	}
+	return withLayer(r, s.layers[layer].Name), nil
+}
+
+// List implements Store2Backend interface.
+func (s *layeredStore2) List() map[Key]*BackEndResource {
+	result := map[Key]*BackEndResource{}
+	for _, l := range s.layers {
+		for k, r := range l.Backend.List() {
+			result[k] = withLayer(r, l.Name)
+		}
+	}
+	return result
+}
+
+// WriteStatus implements StatusWriter interface. The status is written to the
+// layer which the resource comes from.
+func (s *layeredStore2) WriteStatus(key Key, status *ResourceStatus) error {
+	_, layer, err := s.lookup(key, 0, len(s.layers))
+	if err != nil {
+		return err
+	}
+	if sw, ok := s.layers[layer].Backend.(StatusWriter); ok {
+		return sw.WriteStatus(key, status)
+	}
+	return nil
+}
+
+// splitLayers splits the config URL into the URLs of its layers.
+func splitLayers(configURL string) []string {
+	if !strings.Contains(configURL, layerSeparator) {
+		return []string{configURL}
+	}
+	return strings.Split(configURL, layerSeparator)
+}
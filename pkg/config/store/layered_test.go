@@ -0,0 +1,155 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func newTestLayers(names ...string) ([]Layer, []*memstore) {
+	layers := make([]Layer, 0, len(names))
+	mems := make([]*memstore, 0, len(names))
+	for _, n := range names {
+		m := &memstore{data: map[Key]*BackEndResource{}}
+		layers = append(layers, Layer{Name: n, Backend: m})
+		mems = append(mems, m)
+	}
+	return layers, mems
+}
+
+func TestLayeredStore2GetList(t *testing.T) {
+	layers, mems := newTestLayers("base", "override")
+	s := NewLayeredStore2(layers...)
+	if err := s.Init(context.Background(), []string{"Handler"}); err != nil {
+		t.Fatal(err)
+	}
+	k1 := Key{Kind: "Handler", Namespace: "ns", Name: "h1"}
+	k2 := Key{Kind: "Handler", Namespace: "ns", Name: "h2"}
+	mems[0].Put(k1, &BackEndResource{Spec: map[string]interface{}{"adapter": "base"}})
+	mems[0].Put(k2, &BackEndResource{Spec: map[string]interface{}{"adapter": "base"}})
+	mems[1].Put(k1, &BackEndResource{Spec: map[string]interface{}{"adapter": "override"}})
+
+	for _, c := range []struct {
+		key     Key
+		adapter string
+		layer   string
+	}{
+		{k1, "override", "override"},
+		{k2, "base", "base"},
+	} {
+		r, err := s.Get(c.key)
+		if err != nil {
+			t.Fatalf("Get(%s): got %v, want nil", c.key, err)
+		}
+		if r.Spec["adapter"] != c.adapter || r.Metadata.Layer != c.layer {
+			t.Errorf("Get(%s): got %v from %s, want %s from %s", c.key, r.Spec["adapter"], r.Metadata.Layer, c.adapter, c.layer)
+		}
+	}
+	if _, err := s.Get(Key{Kind: "Handler", Namespace: "ns", Name: "unknown"}); err != ErrNotFound {
+		t.Errorf("Got %v, want ErrNotFound", err)
+	}
+
+	lst := s.List()
+	if len(lst) != 2 {
+		t.Fatalf("Got %+v, want 2 elements", lst)
+	}
+	if lst[k1].Metadata.Layer != "override" || lst[k2].Metadata.Layer != "base" {
+		t.Errorf("Got layers %s and %s, want override and base", lst[k1].Metadata.Layer, lst[k2].Metadata.Layer)
+	}
+
+	// Layer information should not leak into the backends.
+	if r, _ := mems[1].Get(k1); r.Metadata.Layer != "" {
+		t.Errorf("Got %s, want the layer not recorded in the backend", r.Metadata.Layer)
+	}
+}
+
+func TestLayeredStore2Watch(t *testing.T) {
+	layers, mems := newTestLayers("base", "override")
+	s := NewLayeredStore2(layers...)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Init(ctx, []string{"Handler"}); err != nil {
+		t.Fatal(err)
+	}
+	ch, err := s.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k := Key{Kind: "Handler", Namespace: "ns", Name: "h1"}
+	base := &BackEndResource{Spec: map[string]interface{}{"adapter": "base"}}
+	override := &BackEndResource{Spec: map[string]interface{}{"adapter": "override"}}
+
+	mems[0].Put(k, base)
+	ev := <-ch
+	if ev.Type != Update || ev.Value.Metadata.Layer != "base" {
+		t.Errorf("Got %+v, want an update from base", ev)
+	}
+
+	mems[1].Put(k, override)
+	ev = <-ch
+	if ev.Type != Update || ev.Value.Metadata.Layer != "override" {
+		t.Errorf("Got %+v, want an update from override", ev)
+	}
+
+	// The update in the base layer is shadowed by the override unless the override
+	// is already removed; in both cases the next event carries the base resource.
+	mems[0].Put(k, &BackEndResource{Spec: map[string]interface{}{"adapter": "base2"}})
+	mems[1].Delete(k)
+	ev = <-ch
+	if ev.Type != Update || ev.Value.Metadata.Layer != "base" || ev.Value.Spec["adapter"] != "base2" {
+		t.Errorf("Got %+v, want the base resource to be revealed", ev)
+	}
+
+	mems[0].Delete(k)
+	waitFor(ch, Delete, k)
+}
+
+func TestLayeredStore2Fail(t *testing.T) {
+	ts := &testStore{memstore: memstore{data: map[Key]*BackEndResource{}}}
+	s := NewLayeredStore2(Layer{Name: "ok", Backend: &memstore{}}, Layer{Name: "test", Backend: ts})
+	ts.initErr = errors.New("dummy")
+	if err := s.Init(context.Background(), nil); err == nil {
+		t.Error("Got nil, want error")
+	}
+	ts.watchErr = errors.New("watch error")
+	if _, err := s.Watch(context.Background()); err == nil {
+		t.Error("Got nil, want error")
+	}
+}
+
+func TestRegistry2Layered(t *testing.T) {
+	r := NewRegistry2(registerTestStore)
+	for _, c := range []struct {
+		u  string
+		ok bool
+	}{
+		{"fs:///,memstore://" + t.Name(), true},
+		{"fs:///,mem://", false},
+		{"://,memstore://", false},
+	} {
+		s, err := r.NewStore2(c.u)
+		ok := err == nil
+		if ok != c.ok {
+			t.Errorf("%s: want %v, got %v, err %v", c.u, c.ok, ok, err)
+		}
+		if ok {
+			if _, layered := s.(*store2).backend.(*layeredStore2); !layered {
+				t.Errorf("%s: got %T, want a layered backend", c.u, s.(*store2).backend)
+			}
+		}
+	}
+}
@@ -29,14 +29,19 @@ type eventQueue struct {
 	chout chan Event
 	chin  <-chan BackendEvent
 	kinds map[string]proto.Message
+
+	// sw records the status of the resources whose spec cannot be converted. nil if
+	// the backend cannot record it.
+	sw StatusWriter
 }
 
-func newQueue(ctx context.Context, chin <-chan BackendEvent, kinds map[string]proto.Message) *eventQueue {
+func newQueue(ctx context.Context, chin <-chan BackendEvent, kinds map[string]proto.Message, sw StatusWriter) *eventQueue {
 	eq := &eventQueue{
 		ctx:   ctx,
 		chout: make(chan Event, choutBufSize),
 		chin:  chin,
 		kinds: kinds,
+		sw:    sw,
 	}
 	go eq.run()
 	return eq
@@ -51,6 +56,7 @@ func (q *eventQueue) convertValue(ev BackendEvent) (Event, error) {
 		return Event{Key: ev.Key, Type: ev.Type}, nil
 	}
 	if err = convert(ev.Key, ev.Value.Spec, pbSpec); err != nil {
+		reportInvalid(q.sw, ev.Key, err)
 		return Event{}, err
 	}
 	return Event{Key: ev.Key, Type: ev.Type, Value: &Resource{
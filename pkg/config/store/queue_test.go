@@ -29,7 +29,7 @@ func TestQueue(t *testing.T) {
 	count := 10
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	chin := make(chan BackendEvent)
-	q := newQueue(ctx, chin, map[string]proto.Message{"Handler": &cfg.Handler{}})
+	q := newQueue(ctx, chin, map[string]proto.Message{"Handler": &cfg.Handler{}}, nil)
 	defer cancel()
 	donec := make(chan struct{})
 	evs := []Event{}
@@ -67,7 +67,7 @@ func TestQueue(t *testing.T) {
 func TestQueueFail(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	chin := make(chan BackendEvent)
-	q := newQueue(ctx, chin, map[string]proto.Message{"Handler": &cfg.Handler{}})
+	q := newQueue(ctx, chin, map[string]proto.Message{"Handler": &cfg.Handler{}}, nil)
 	defer cancel()
 	chin <- BackendEvent{
 		Type:  Update,
@@ -97,7 +97,7 @@ func TestQueueSync(t *testing.T) {
 	count := 10
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	chin := make(chan BackendEvent)
-	q := newQueue(ctx, chin, map[string]proto.Message{"Handler": &cfg.Handler{}})
+	q := newQueue(ctx, chin, map[string]proto.Message{"Handler": &cfg.Handler{}}, nil)
 	defer cancel()
 	for i := 0; i < count; i++ {
 		chin <- BackendEvent{
@@ -117,7 +117,7 @@ func TestQueueSync(t *testing.T) {
 func TestQueueCancelClosesOutputChannel(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	chin := make(chan BackendEvent)
-	q := newQueue(ctx, chin, map[string]proto.Message{"Handler": &cfg.Handler{}})
+	q := newQueue(ctx, chin, map[string]proto.Message{"Handler": &cfg.Handler{}}, nil)
 	donec := make(chan struct{})
 	go func() {
 		for range q.chout {
@@ -131,7 +131,7 @@ func TestQueueCancelClosesOutputChannel(t *testing.T) {
 func TestQueueCancelSync(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
 	chin := make(chan BackendEvent)
-	q := newQueue(ctx, chin, map[string]proto.Message{"Handler": &cfg.Handler{}})
+	q := newQueue(ctx, chin, map[string]proto.Message{"Handler": &cfg.Handler{}}, nil)
 	for i := 0; i < choutBufSize+5; i++ {
 		chin <- BackendEvent{
 			Type:  Update,
@@ -52,6 +52,10 @@ type ResourceMeta struct {
 	Labels      map[string]string
 	Annotations map[string]string
 	Revision    string
+
+	// Layer is the name of the layer the resource comes from when the store
+	// merges several backends. Empty otherwise.
+	Layer string `json:"-"`
 }
 
 // BackEndResource represents a resources with a raw spec
@@ -88,6 +92,30 @@ type Validator interface {
 	Validate(t ChangeType, key Key, spec proto.Message) bool
 }
 
+// ResourceStatus is the status of a resource as seen by mixer.
+type ResourceStatus struct {
+	// Validated is true if the resource passed the validation.
+	Validated bool
+
+	// Error describes why the resource is not valid or not in effect.
+	Error string
+
+	// Active is true if the resource is in effect in the current config
+	// snapshot.
+	Active bool
+
+	// HandlerError is the error from the initialization of a handler. It is
+	// only set for handlers.
+	HandlerError string
+}
+
+// StatusWriter is implemented by the stores and backends which can record
+// the status of the resources, so that the users can see why a config does
+// not take effect.
+type StatusWriter interface {
+	WriteStatus(key Key, status *ResourceStatus) error
+}
+
 // Store2Backend defines the typeless storage backend for mixer.
 // TODO: rename to StoreBackend.
 type Store2Backend interface {
@@ -154,7 +182,7 @@ func (s *store2) Watch(ctx context.Context) (<-chan Event, error) {
 	if err != nil {
 		return nil, err
 	}
-	q := newQueue(ctx, ch, s.kinds)
+	q := newQueue(ctx, ch, s.kinds, s.statusWriter())
 	s.queue = q
 	go func() {
 		<-ctx.Done()
@@ -187,6 +215,7 @@ func (s *store2) List() map[Key]*Resource {
 		}
 		if err = convert(k, d.Spec, pbSpec); err != nil {
 			glog.Errorf("Failed to convert %s spec: %v", k, err)
+			reportInvalid(s.statusWriter(), k, err)
 			continue
 		}
 		result[k] = &Resource{
@@ -197,6 +226,36 @@ func (s *store2) List() map[Key]*Resource {
 	return result
 }
 
+// WriteStatus implements StatusWriter interface. The status is silently
+// dropped if the backend cannot record it.
+func (s *store2) WriteStatus(key Key, status *ResourceStatus) error {
+	if sw := s.statusWriter(); sw != nil {
+		return sw.WriteStatus(key, status)
+	}
+	return nil
+}
+
+// statusWriter returns the backend as a StatusWriter, nil if it cannot record the status.
+func (s *store2) statusWriter() StatusWriter {
+	sw, _ := s.backend.(StatusWriter)
+	return sw
+}
+
+// reportInvalid records that the spec of the resource cannot be converted, so that the
+// resource gets a status although it never reaches the config snapshots. The status is
+// written in the background, a slow backend does not hold the other resources back.
+func reportInvalid(sw StatusWriter, key Key, err error) {
+	if sw == nil {
+		return
+	}
+	status := &ResourceStatus{Error: fmt.Sprintf("invalid spec: %v", err)}
+	go func() {
+		if werr := sw.WriteStatus(key, status); werr != nil {
+			glog.Warningf("Unable to write the status of %s: %v", key, werr)
+		}
+	}()
+}
+
 // Store2Builder is the type of function to build a Store2Backend.
 type Store2Builder func(u *url.URL) (Store2Backend, error)
 
@@ -218,8 +277,31 @@ func NewRegistry2(inventory ...RegisterFunc2) *Registry2 {
 	return &Registry2{builders: b}
 }
 
-// NewStore2 creates a new Store2 instance with the specified backend.
+// NewStore2 creates a new Store2 instance with the specified backend. The
+// config URL can list several URLs separated by commas, which creates a layered
+// store; later URLs take precedence over earlier ones.
 func (r *Registry2) NewStore2(configURL string) (Store2, error) {
+	urls := splitLayers(configURL)
+	if len(urls) == 1 {
+		b, err := r.newBackend(configURL)
+		if err != nil {
+			return nil, err
+		}
+		return &store2{backend: b}, nil
+	}
+	layers := make([]Layer, 0, len(urls))
+	for _, u := range urls {
+		b, err := r.newBackend(u)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, Layer{Name: u, Backend: b})
+	}
+	return &store2{backend: NewLayeredStore2(layers...)}, nil
+}
+
+// newBackend creates a new Store2Backend for a single config URL.
+func (r *Registry2) newBackend(configURL string) (Store2Backend, error) {
 	u, err := url.Parse(configURL)
 
 	if err != nil {
@@ -241,7 +323,7 @@ func (r *Registry2) NewStore2(configURL string) (Store2, error) {
 		}
 	}
 	if b != nil {
-		return &store2{backend: b}, nil
+		return b, nil
 	}
 	return nil, fmt.Errorf("unknown config URL %s %v", configURL, u)
 }
@@ -31,6 +31,16 @@ type testStore struct {
 	memstore
 	initErr  error
 	watchErr error
+
+	// statuses receives the statuses written, when it is set.
+	statuses chan Key
+}
+
+func (t *testStore) WriteStatus(key Key, status *ResourceStatus) error {
+	if t.statuses != nil {
+		t.statuses <- key
+	}
+	return nil
 }
 
 func (t *testStore) Init(ctx context.Context, kinds []string) error {
@@ -159,7 +169,9 @@ func TestStore2Fail(t *testing.T) {
 		t.Errorf("Got %v, Want watch error", err)
 	}
 
-	ts.Put(Key{Kind: "Handler", Name: "name", Namespace: "ns"}, &BackEndResource{Spec: map[string]interface{}{
+	ts.statuses = make(chan Key, 2)
+	k := Key{Kind: "Handler", Name: "name", Namespace: "ns"}
+	ts.Put(k, &BackEndResource{Spec: map[string]interface{}{
 		"foo": 1,
 	}})
 	ts.Put(Key{Kind: "Unknown", Name: "unknown", Namespace: "ns"}, &BackEndResource{Spec: map[string]interface{}{
@@ -168,6 +180,15 @@ func TestStore2Fail(t *testing.T) {
 	if lst := s.List(); len(lst) != 0 {
 		t.Errorf("Got %v, Want empty", lst)
 	}
+	// only the spec which cannot be converted gets a status.
+	select {
+	case got := <-ts.statuses:
+		if got != k {
+			t.Errorf("Got the status of %s, Want %s", got, k)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Got no status, Want the status of %s", k)
+	}
 }
 
 func TestRegistry2(t *testing.T) {
@@ -73,6 +73,18 @@ type Controller struct {
 	// It is recreated when attributes change.
 	df expr.AttributeDescriptorFinder
 
+	// statusWriter records the status of the config resources after every
+	// snapshot. nil if the config store cannot record it.
+	statusWriter store.StatusWriter
+
+	// statusQueue writes the status computed on the controller loop to the
+	// statusWriter. It is created with the first snapshot.
+	statusQueue *statusQueue
+
+	// status collects the problems of the config resources found while
+	// publishing the current snapshot.
+	status *configStatus
+
 	// Fields below are used for testing an debugging.
 
 	// createHandlerFactory for testing.
@@ -110,6 +122,8 @@ type applyEventsFn func(events []*store.Event)
 // The previous handler table enables handler cleanup and reuse.
 // This code is single threaded, it only runs on a config change control loop.
 func (c *Controller) publishSnapShot() {
+	c.status = newConfigStatus()
+
 	// current view of attributes
 	// attribute manifests are used by type inference during handler creation.
 	attributes := c.processAttributeManifests()
@@ -144,6 +158,7 @@ func (c *Controller) publishSnapShot() {
 	// Initialize handlers that are used in the configuration.
 	// Some handlers may not initialize due to errors.
 	ht.Initialize(c.table)
+	c.invalidateFailedHandlers(ht.table)
 
 	// Combine rules with the handler table.
 	// Actions referring to handlers in error are logged and purged.
@@ -166,6 +181,9 @@ func (c *Controller) publishSnapShot() {
 
 	glog.Infof("Published snapshot[%d] with %d rules, %d handlers, previously %d rules", resolver.id, nrules, len(c.table), oldNrules)
 
+	// record why some of the config does not take effect.
+	c.writeStatus(ruleConfig, c.table)
+
 	// synchronous call to cleanup.
 	err := cleanupResolver(oldResolver, oldTable, maxCleanupDuration)
 	if err != nil {
@@ -186,7 +204,7 @@ const maxEvents = 50
 
 // watchChanges watches for changes on a channel and
 // publishes a batch of changes via applyEvents.
-// watchChanges is started in a goroutine.
+// watchChanges is started in a goroutine, it returns when the channel is closed.
 func watchChanges(wch <-chan store.Event, applyEvents applyEventsFn) {
 	// consume changes and apply them to data indefinitely
 	var timeChan <-chan time.Time
@@ -195,7 +213,14 @@ func watchChanges(wch <-chan store.Event, applyEvents applyEventsFn) {
 
 	for {
 		select {
-		case ev := <-wch:
+		case ev, ok := <-wch:
+			if !ok {
+				// the store stopped watching, no more snapshots are published.
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			}
 			if len(events) == 0 {
 				timer = time.NewTimer(watchFlushDuration)
 				timeChan = timer.C
@@ -211,6 +236,13 @@ func watchChanges(wch <-chan store.Event, applyEvents applyEventsFn) {
 	}
 }
 
+// stop releases the resources of the controller, once it no longer publishes snapshots.
+func (c *Controller) stop() {
+	if c.statusQueue != nil {
+		c.statusQueue.stop()
+	}
+}
+
 // applyEvents applies given events to config state and then publishes a snapshot.
 func (c *Controller) applyEvents(events []*store.Event) {
 	ck := make(map[string]bool)
@@ -381,7 +413,7 @@ func (c *Controller) processRules(handlerConfig map[string]*cpb.Handler,
 		cfg := obj.Spec
 		rulec := cfg.(*cpb.Rule)
 
-		acts := c.processActions(rulec.Actions, handlerConfig, instanceConfig, ht, k.Namespace)
+		acts := c.processActions(rulec.Actions, handlerConfig, instanceConfig, ht, k)
 
 		ruleActions := make(map[adptTmpl.TemplateVariety][]*Action)
 		for vr, amap := range acts {
@@ -394,6 +426,7 @@ func (c *Controller) processRules(handlerConfig map[string]*cpb.Handler,
 		rule, err := buildRule(k, rulec, rt)
 		if err != nil {
 			glog.Warningf("Unable to process match condition: %v", err)
+			c.status.invalidate(k, "unable to process match condition: %v", err)
 			continue
 		}
 		rule.actions = ruleActions
@@ -440,25 +473,27 @@ func canonicalizeInstanceNames(instances []string, namespace string) []string {
 // processActions prunes actions that lack referential integrity and associate instances with
 // handlers that are later used to create new handlers.
 func (c *Controller) processActions(acts []*cpb.Action, handlerConfig map[string]*cpb.Handler,
-	instanceConfig map[string]*cpb.Instance, ht *handlerTable, namespace string) map[adptTmpl.TemplateVariety]map[string]*Action {
+	instanceConfig map[string]*cpb.Instance, ht *handlerTable, ruleKey store.Key) map[adptTmpl.TemplateVariety]map[string]*Action {
 
 	actions := make(map[adptTmpl.TemplateVariety]map[string]*Action)
 
-	for _, ic := range canonicalizeHandlerNames(acts, namespace) {
+	for _, ic := range canonicalizeHandlerNames(acts, ruleKey.Namespace) {
 		var hc *cpb.Handler
 		if hc = handlerConfig[ic.Handler]; hc == nil {
 			if glog.V(3) {
 				glog.Warningf("ConfigWarning unknown handler: %s", ic.Handler)
 			}
+			c.status.warn(ruleKey, "unknown handler: %s", ic.Handler)
 			continue
 		}
 
-		for _, instName := range canonicalizeInstanceNames(ic.Instances, namespace) {
+		for _, instName := range canonicalizeInstanceNames(ic.Instances, ruleKey.Namespace) {
 			inst := instanceConfig[instName]
 			if inst == nil {
 				if glog.V(3) {
 					glog.Warningf("ConfigWarning unknown instance: %s", instName)
 				}
+				c.status.warn(ruleKey, "unknown instance: %s", instName)
 				continue
 			}
 
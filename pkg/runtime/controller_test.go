@@ -19,6 +19,7 @@ import (
 	"flag"
 	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
@@ -310,6 +311,138 @@ func TestController_workflow(t *testing.T) {
 	checkRulesInvariants(t, c.resolver.rules)
 }
 
+type fakeStatusWriter struct {
+	mu     sync.Mutex
+	status map[store.Key]*store.ResourceStatus
+	writes int
+}
+
+func (f *fakeStatusWriter) WriteStatus(key store.Key, status *store.ResourceStatus) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.status[key] = status
+	f.writes++
+	return nil
+}
+
+// waitForWrites waits until the status was written n times, and returns the status.
+func (f *fakeStatusWriter) waitForWrites(t *testing.T, n int) map[store.Key]*store.ResourceStatus {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(time.Millisecond) {
+		f.mu.Lock()
+		writes := f.writes
+		f.mu.Unlock()
+		if writes >= n {
+			break
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.writes != n {
+		t.Fatalf("got %d status writes, want %d", f.writes, n)
+	}
+	status := make(map[store.Key]*store.ResourceStatus, len(f.status))
+	for k, v := range f.status {
+		status[k] = v
+	}
+	return status
+}
+
+func TestController_writeStatus(t *testing.T) {
+	ns := DefaultConfigNamespace
+	r1 := store.Key{Kind: RulesKind, Namespace: ns, Name: "r1"}
+	r2 := store.Key{Kind: RulesKind, Namespace: ns, Name: "r2"}
+	m1 := store.Key{Kind: "metric", Namespace: ns, Name: "m1"}
+	a1 := store.Key{Kind: "AA", Namespace: ns, Name: "a1"}
+	for _, tc := range []struct {
+		desc     string
+		buildErr error
+		want     map[store.Key]*store.ResourceStatus
+	}{
+		{
+			desc: "success",
+			want: map[store.Key]*store.ResourceStatus{
+				r1: {Validated: true, Error: "unknown instance: BadInstance." + ns, Active: true},
+				m1: {Validated: true, Active: true},
+				a1: {Validated: true, Active: true},
+			},
+		},
+		{
+			desc:     "handler error",
+			buildErr: errors.New("bad handler"),
+			want: map[store.Key]*store.ResourceStatus{
+				r1: {Validated: true, Error: "unknown instance: BadInstance." + ns + "; handler a1.AA." + ns + " could not be initialized: bad handler"},
+				m1: {Validated: true, Error: "handler a1.AA." + ns + " could not be initialized: bad handler"},
+				a1: {Validated: true, HandlerError: "bad handler"},
+			},
+		},
+		{
+			desc:     "invalid handler",
+			buildErr: &validationError{resource: "a1.AA." + ns, err: errors.New("bad params")},
+			want: map[store.Key]*store.ResourceStatus{
+				r1: {Validated: true, Error: "unknown instance: BadInstance." + ns + "; handler a1.AA." + ns + " could not be initialized: bad params"},
+				m1: {Validated: true, Error: "handler a1.AA." + ns + " could not be initialized: bad params"},
+				a1: {Validated: false, Error: "bad params"},
+			},
+		},
+		{
+			desc:     "invalid instance",
+			buildErr: &validationError{resource: "m1.metric." + ns, err: errors.New("bad type")},
+			want: map[store.Key]*store.ResourceStatus{
+				r1: {Validated: true, Error: "unknown instance: BadInstance." + ns + "; handler a1.AA." + ns + " could not be initialized: bad type"},
+				m1: {Validated: false, Error: "bad type"},
+				a1: {Validated: true, HandlerError: "bad type"},
+			},
+		},
+	} {
+		t.Run(tc.desc, func(t *testing.T) {
+			sw := &fakeStatusWriter{status: make(map[store.Key]*store.ResourceStatus)}
+			c := &Controller{
+				adapterInfo:  map[string]*adapter.Info{"AA": {Name: "AA"}},
+				templateInfo: map[string]template.Info{"metric": {Name: "metric"}},
+				configState: map[store.Key]*store.Resource{
+					r1: {Spec: &cpb.Rule{
+						Actions: []*cpb.Action{{
+							Handler:   "a1.AA." + ns,
+							Instances: []string{"m1.metric." + ns, "BadInstance"},
+						}},
+					}},
+					r2: {Spec: &cpb.Rule{
+						Match:   "=$ 1",
+						Actions: []*cpb.Action{{Handler: "a1.AA." + ns, Instances: []string{"m1.metric." + ns}}},
+					}},
+					m1: {Spec: &wrappers.StringValue{Value: "metric1_config"}},
+					a1: {Spec: &wrappers.StringValue{Value: "AA_config"}},
+				},
+				dispatcher:             &fakedispatcher{},
+				resolver:               &resolver{},
+				identityAttribute:      DefaultIdentityAttribute,
+				defaultConfigNamespace: DefaultConfigNamespace,
+				statusWriter:           sw,
+				createHandlerFactory: func(templateInfo map[string]template.Info, expr expr.TypeChecker,
+					df expr.AttributeDescriptorFinder, builderInfo map[string]*adapter.Info) HandlerFactory {
+					return &fhbuilder{a: &fhandler{}, err: tc.buildErr}
+				},
+			}
+			c.publishSnapShot()
+			defer c.stop()
+			status := sw.waitForWrites(t, 4)
+			for k, want := range tc.want {
+				if got := status[k]; !reflect.DeepEqual(got, want) {
+					t.Errorf("%s: got %+v, want %+v", k, got, want)
+				}
+			}
+			if got := status[r2]; got == nil || got.Validated || got.Error == "" || got.Active {
+				t.Errorf("%s: got %+v, want an invalid rule", r2, got)
+			}
+
+			// the status does not change with the next snapshot, nothing is written.
+			c.publishSnapShot()
+			time.Sleep(10 * time.Millisecond)
+			sw.waitForWrites(t, 4)
+		})
+	}
+}
+
 func Test_cleanupResolver(t *testing.T) {
 	cr := cleanupSleepTime
 	cleanupSleepTime = 50 * time.Millisecond
@@ -417,6 +550,29 @@ func Test_WaitForChanges(t *testing.T) {
 	watchFlushDuration = wd
 }
 
+func Test_WaitForChangesClosed(t *testing.T) {
+	wch := make(chan store.Event)
+	done := make(chan bool)
+	go func() {
+		watchChanges(wch, func(events []*store.Event) {})
+		done <- true
+	}()
+
+	wch <- store.Event{}
+	close(wch)
+	waitFor(t, time.Second, done, "watchChanges did not return")
+}
+
+func TestStatusQueue_stop(t *testing.T) {
+	sw := &fakeStatusWriter{status: make(map[store.Key]*store.ResourceStatus)}
+	q := newStatusQueue(sw)
+	q.stop()
+	// the stopped queue writes nothing.
+	q.update(map[store.Key]store.ResourceStatus{{Kind: RulesKind, Name: "r1"}: {Validated: true}})
+	time.Sleep(10 * time.Millisecond)
+	sw.waitForWrites(t, 0)
+}
+
 func TestAttributeFinder_GetAttribute(t *testing.T) {
 	c := &Controller{}
 
@@ -17,7 +17,6 @@ package runtime
 import (
 	"bytes"
 	"context"
-	"errors"
 	"fmt"
 	"strconv"
 	"sync"
@@ -274,10 +273,38 @@ func (m *dispatcher) Quota(ctx context.Context, requestBag attribute.Bag,
 }
 
 // Preprocess runs the first phase of adapter processing before any other adapters are run.
-// Attribute producing adapters are run in this phase.
+// Attribute producing adapters are run in this phase. The attributes they produce are merged
+// into responseBag. When several adapters produce the same attribute, one value is kept.
 func (m *dispatcher) Preprocess(ctx context.Context, requestBag attribute.Bag, responseBag *attribute.MutableBag) error {
-	// FIXME
-	return errors.New("not implemented")
+	// guards responseBag, which is updated as the adapters complete.
+	var lock sync.Mutex
+
+	_, err := m.dispatch(ctx, requestBag, adptTmpl.TEMPLATE_VARIETY_ATTRIBUTE_GENERATOR,
+		func(call *Action) []dispatchFn {
+			ra := make([]dispatchFn, 0, len(call.instanceConfig))
+			for _, inst := range call.instanceConfig {
+				inst := inst
+				ra = append(ra,
+					func(ctx context.Context) *result {
+						out, err := call.processor.ProcessGenAttrs(ctx, inst.Name,
+							inst.Params.(proto.Message),
+							requestBag, m.mapper,
+							call.handler)
+						if err != nil {
+							return &result{err: err, callinfo: call}
+						}
+
+						lock.Lock()
+						err = responseBag.Merge(out)
+						lock.Unlock()
+						out.Done()
+						return &result{err: err, callinfo: call}
+					})
+			}
+			return ra
+		},
+	)
+	return err
 }
 
 // combineResults combines results
@@ -286,6 +313,7 @@ func combineResults(results []*result) (adapter.Result, error) {
 	var err *multierror.Error
 	var buf *bytes.Buffer
 	code := rpc.OK
+	var failure rpc.Status
 
 	for _, rs := range results {
 		if rs.err != nil {
@@ -305,8 +333,9 @@ func combineResults(results []*result) (adapter.Result, error) {
 		if !status.IsOK(st) {
 			if buf == nil {
 				buf = pool.GetBuffer()
-				// the first failure result's code becomes the result code for the output
+				// the first failure result's code and details become those of the output
 				code = rpc.Code(st.Code)
+				failure = st
 			} else {
 				buf.WriteString(", ")
 			}
@@ -316,7 +345,9 @@ func combineResults(results []*result) (adapter.Result, error) {
 	}
 
 	if buf != nil {
-		res.SetStatus(status.WithMessage(code, buf.String()))
+		st := status.WithMessage(code, buf.String())
+		st.Details = failure.Details
+		res.SetStatus(st)
 		pool.PutBuffer(buf)
 	}
 	return res, err.ErrorOrNil()
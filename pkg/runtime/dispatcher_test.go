@@ -20,10 +20,12 @@ import (
 	"flag"
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 
 	"github.com/gogo/protobuf/proto"
+	"github.com/gogo/protobuf/types"
 	google_rpc "github.com/googleapis/googleapis/google/rpc"
 
 	adptTmpl "istio.io/api/mixer/v1/template"
@@ -123,6 +125,8 @@ func TestCheck(t *testing.T) {
 	}{{tn: tname, ncalled: 6},
 		{tn: tname, ncalled: 6, cr: adapter.CheckResult{ValidUseCount: 200}},
 		{tn: tname, ncalled: 6, cr: adapter.CheckResult{ValidUseCount: 200, Status: status.WithPermissionDenied("bad user")}},
+		{tn: tname, ncalled: 6, cr: adapter.CheckResult{ValidUseCount: 200, Status: status.InvalidWithDetails("bad request",
+			&google_rpc.RetryInfo{RetryDelay: &types.Duration{Seconds: 10}})}},
 		{tn: tname, callErr: err1},
 		{tn: tname, callErr: err1, resolveErr: true},
 	} {
@@ -157,6 +161,10 @@ func TestCheck(t *testing.T) {
 			if !reflect.DeepEqual(fp.checkResult.Status.Code, cr.Status.Code) {
 				t.Fatalf("got %v, want %v", *cr, fp.checkResult)
 			}
+			// the details of the failure are kept along with its code
+			if !reflect.DeepEqual(fp.checkResult.Status.Details, cr.Status.Details) {
+				t.Fatalf("got details %v, want %v", cr.Status.Details, fp.checkResult.Status.Details)
+			}
 		})
 	}
 	gp.Close()
@@ -222,12 +230,58 @@ func TestQuota(t *testing.T) {
 }
 
 func TestPreprocess(t *testing.T) {
-	m := dispatcher{}
+	gp := pool.NewGoroutinePool(1, true)
+	tname := "kubernetes1"
+	err1 := errors.New("internal error")
 
-	err := m.Preprocess(context.TODO(), nil, nil)
-	if err == nil {
-		t.Fatalf("not working yet")
+	for _, s := range []struct {
+		tn         string
+		callErr    error
+		resolveErr bool
+		ncalled    int
+		want       []string
+	}{{tn: tname, ncalled: 6, want: []string{"instance.i1", "instance.i1B", "instance.i1X", "instance.i1Y"}},
+		{tn: tname, callErr: err1},
+		{tn: tname, callErr: err1, resolveErr: true},
+	} {
+		t.Run(fmt.Sprintf("%#v", s), func(t *testing.T) {
+			fp := &fakeProc{
+				err: s.callErr,
+			}
+			var resolveErr error
+			if s.resolveErr {
+				resolveErr = s.callErr
+			}
+			rt := newFakeResolver(s.tn, resolveErr, false, fp)
+			m := newDispatcher(nil, rt, gp)
+
+			responseBag := attribute.GetMutableBag(nil)
+			defer responseBag.Done()
+			err := m.Preprocess(context.Background(), nil, responseBag)
+
+			checkError(t, s.callErr, err)
+
+			if s.callErr != nil {
+				return
+			}
+			if fp.called != s.ncalled {
+				t.Fatalf("got %v, want %v", fp.called, s.ncalled)
+			}
+
+			// the attributes of all the instances are merged
+			names := responseBag.Names()
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, s.want) {
+				t.Fatalf("got attributes %v, want %v", names, s.want)
+			}
+			for _, n := range names {
+				if v, _ := responseBag.Get(n); "instance."+v.(string) != n {
+					t.Errorf("got %s=%v, want %s", n, v, strings.TrimPrefix(n, "instance."))
+				}
+			}
+		})
 	}
+	gp.Close()
 }
 
 // fakes
@@ -341,10 +395,11 @@ func newFakeResolver(tname string, resolveErr error, emptyResult bool, fproc *fa
 
 func newTemplate(name string, fproc *fakeProc) *template.Info {
 	return &template.Info{
-		Name:          name,
-		ProcessReport: fproc.ProcessReport,
-		ProcessCheck:  fproc.ProcessCheck,
-		ProcessQuota:  fproc.ProcessQuota,
+		Name:            name,
+		ProcessReport:   fproc.ProcessReport,
+		ProcessCheck:    fproc.ProcessCheck,
+		ProcessQuota:    fproc.ProcessQuota,
+		ProcessGenAttrs: fproc.ProcessGenAttrs,
 	}
 }
 
@@ -372,4 +427,15 @@ func (f *fakeProc) ProcessQuota(_ context.Context, _ string, _ proto.Message, _
 	return f.quotaResult, f.err
 }
 
+func (f *fakeProc) ProcessGenAttrs(_ context.Context, instName string, _ proto.Message, _ attribute.Bag,
+	_ expr.Evaluator, _ adapter.Handler) (*attribute.MutableBag, error) {
+	f.called++
+	if f.err != nil {
+		return nil, f.err
+	}
+	out := attribute.GetMutableBag(nil)
+	out.Set("instance."+instName, instName)
+	return out, nil
+}
+
 var _ = flag.Lookup("v").Value.Set("99")
@@ -60,8 +60,20 @@ type (
 	templateFinder struct {
 		templateInfo map[string]template.Info
 	}
+
+	// validationError is returned when a handler cannot be built because its configuration,
+	// or the configuration of one of its instances, fails validation.
+	validationError struct {
+		// resource is the fully qualified name of the invalid handler or instance.
+		resource string
+		err      error
+	}
 )
 
+func (e *validationError) Error() string {
+	return e.err.Error()
+}
+
 func (t *templateFinder) GetTemplateInfo(template string) (template.Info, bool) {
 	i, found := t.templateInfo[template]
 	return i, found
@@ -98,6 +110,40 @@ func (h *handlerFactory) Build(handler *pb.Handler, instances []*pb.Instance, en
 		return nil, err
 	}
 
+	info, bldr, err := h.newBuilder(handler)
+	if err != nil {
+		return nil, err
+	}
+
+	var hndlr adapter.Handler
+	hndlr, err = h.build(bldr, infrdTypsByTmpl, handler.Params, env)
+	if err != nil {
+		msg := fmt.Sprintf("cannot configure adapter '%s' in handler config '%s': %v", handler.Adapter, handler.Name, err)
+		glog.Warning(msg)
+		if _, ok := err.(*validationError); ok {
+			return nil, &validationError{resource: handler.Name, err: errors.New(msg)}
+		}
+		return nil, errors.New(msg)
+	}
+	// validate if the handler supports all the necessary interfaces
+	for _, tmplName := range info.SupportedTemplates {
+		// ti should be there for a valid configuration.
+		ti, _ := h.tmplRepo.GetTemplateInfo(tmplName)
+		if supports := ti.HandlerSupportsTemplate(hndlr); !supports {
+			// adapter is bad since it does not support the necessary interface
+			msg := fmt.Sprintf("adapter is invalid because it does not implement interface '%s'. "+
+				"Therefore, it cannot support template '%s'", ti.HndlrInterfaceName, tmplName)
+			glog.Error(msg)
+			return nil, fmt.Errorf(msg)
+		}
+	}
+
+	return hndlr, err
+}
+
+// newBuilder creates the HandlerBuilder of the adapter and checks that it supports all of the
+// templates that the adapter claims to support.
+func (h *handlerFactory) newBuilder(handler *pb.Handler) (*adapter.Info, adapter.HandlerBuilder, error) {
 	// HandlerBuilder should always be present for a valid configuration (reference integrity should already be checked).
 	info, _ := h.builderInfoFinder(handler.Adapter)
 
@@ -106,7 +152,7 @@ func (h *handlerFactory) Build(handler *pb.Handler, instances []*pb.Instance, en
 	if bldr == nil {
 		msg := fmt.Sprintf("nil HandlerBuilder instantiated for adapter '%s' in handler config '%s'", handler.Adapter, handler.Name)
 		glog.Warning(msg)
-		return nil, errors.New(msg)
+		return nil, nil, errors.New(msg)
 	}
 
 	// validate if the builder supports all the necessary interfaces
@@ -118,31 +164,56 @@ func (h *handlerFactory) Build(handler *pb.Handler, instances []*pb.Instance, en
 			msg := fmt.Sprintf("adapter is invalid because it does not implement interface '%s'. "+
 				"Therefore, it cannot support template '%s'", ti.BldrInterfaceName, tmplName)
 			glog.Error(msg)
-			return nil, fmt.Errorf(msg)
+			return nil, nil, fmt.Errorf(msg)
 		}
 	}
+	return info, bldr, nil
+}
+
+// validate runs the type inference of the instances and the validation of the adapter
+// configuration the same way as Build does, without building the handler.
+func (h *handlerFactory) validate(handler *pb.Handler, instances []*pb.Instance) (err error) {
+	infrdTypsByTmpl, err := h.inferTypesGrpdByTmpl(instances)
+	if err != nil {
+		return err
+	}
 
-	var hndlr adapter.Handler
-	hndlr, err = h.build(bldr, infrdTypsByTmpl, handler.Params, env)
+	info, bldr, err := h.newBuilder(handler)
 	if err != nil {
-		msg := fmt.Sprintf("cannot configure adapter '%s' in handler config '%s': %v", handler.Adapter, handler.Name, err)
-		glog.Warning(msg)
-		return nil, errors.New(msg)
+		return err
 	}
-	// validate if the handler supports all the necessary interfaces
-	for _, tmplName := range info.SupportedTemplates {
-		// ti should be there for a valid configuration.
-		ti, _ := h.tmplRepo.GetTemplateInfo(tmplName)
-		if supports := ti.HandlerSupportsTemplate(hndlr); !supports {
-			// adapter is bad since it does not support the necessary interface
-			msg := fmt.Sprintf("adapter is invalid because it does not implement interface '%s'. "+
-				"Therefore, it cannot support template '%s'", ti.HndlrInterfaceName, tmplName)
-			glog.Error(msg)
-			return nil, fmt.Errorf(msg)
+	for tmplName := range infrdTypsByTmpl {
+		if !containsTemplate(info.SupportedTemplates, tmplName) {
+			return fmt.Errorf("adapter '%s' in handler config '%s' does not support template '%s'",
+				handler.Adapter, handler.Name, tmplName)
 		}
 	}
 
-	return hndlr, err
+	// calls into the builder can panic, same as in build.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler panicked with '%v' when trying to validate the configuration of the associated adapter", r)
+		}
+	}()
+
+	for tmplName, typs := range infrdTypsByTmpl {
+		ti, _ := h.tmplRepo.GetTemplateInfo(tmplName)
+		ti.SetType(typs, bldr)
+	}
+	bldr.SetAdapterConfig(handler.Params.(proto.Message))
+	if ce := bldr.Validate(); ce != nil {
+		return fmt.Errorf("handler validation failed: %s", ce.Error())
+	}
+	return nil
+}
+
+func containsTemplate(tmpls []string, tmpl string) bool {
+	for _, t := range tmpls {
+		if t == tmpl {
+			return true
+		}
+	}
+	return false
 }
 
 func (h *handlerFactory) build(bldr adapter.HandlerBuilder, infrdTypesByTmpl map[string]typeMap,
@@ -174,7 +245,7 @@ func (h *handlerFactory) build(bldr adapter.HandlerBuilder, infrdTypesByTmpl map
 		msg := fmt.Sprintf("handler validation failed: %s", ce.Error())
 		glog.Error(msg)
 		hndlr = nil
-		err = errors.New(msg)
+		err = &validationError{err: errors.New(msg)}
 		return
 	}
 
@@ -219,7 +290,10 @@ func (h *handlerFactory) inferType(instance *pb.Instance) (proto.Message, error)
 		return h.typeChecker.EvalType(expr, h.attrDescFinder)
 	})
 	if err != nil {
-		return nil, fmt.Errorf("cannot infer type information from params in instance '%s': %v", instance.Name, err)
+		return nil, &validationError{
+			resource: instance.Name,
+			err:      fmt.Errorf("cannot infer type information from params in instance '%s': %v", instance.Name, err),
+		}
 	}
 
 	// obtain write lock
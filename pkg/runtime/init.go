@@ -108,9 +108,15 @@ func startController(s store.Store2, adapterInfo map[string]*adapter.Info,
 		table:                  make(map[string]*HandlerEntry),
 		createHandlerFactory:   newHandlerFactory,
 	}
+	if sw, ok := s.(store.StatusWriter); ok {
+		c.statusWriter = sw
+	}
 
 	c.publishSnapShot()
 	glog.Infof("Config controller has started with %d config elements", len(c.configState))
-	go watchChanges(watchChan, c.applyEvents)
+	go func() {
+		watchChanges(watchChan, c.applyEvents)
+		c.stop()
+	}()
 	return nil
 }
@@ -0,0 +1,115 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"sort"
+
+	"istio.io/mixer/pkg/adapter"
+	"istio.io/mixer/pkg/config/store"
+	"istio.io/mixer/pkg/expr"
+	"istio.io/mixer/pkg/template"
+)
+
+// Severity is the severity of a Diagnostic.
+type Severity string
+
+const (
+	// SeverityError is a problem which makes the resource invalid, or prevents
+	// it from taking effect.
+	SeverityError Severity = "error"
+
+	// SeverityWarning is a problem which does not affect the behavior of mixer,
+	// like an unused resource.
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic is a problem found in a config resource.
+type Diagnostic struct {
+	Key      store.Key
+	Severity Severity
+	Message  string
+}
+
+// Lint checks a complete set of config resources offline. specs are the unstructured
+// specs of the resources. In addition to the checks of the Validator, it reports the
+// rules which refer to missing handlers or instances, and the instances which are not
+// used by any rule. The diagnostics are sorted by the key of the resources.
+func Lint(specs map[store.Key]map[string]interface{}, adapterInfo map[string]*adapter.Info,
+	templateInfo map[string]template.Info, typeChecker expr.TypeChecker) []Diagnostic {
+	var diags []Diagnostic
+	kinds := kindMap(adapterInfo, templateInfo)
+	data := make(map[store.Key]*store.Resource, len(specs))
+	for k, spec := range specs {
+		pbSpec, err := store.ParseSpec(k, spec, kinds)
+		if err != nil {
+			diags = append(diags, Diagnostic{Key: k, Severity: SeverityError, Message: err.Error()})
+			continue
+		}
+		data[k] = &store.Resource{Spec: pbSpec}
+	}
+
+	v := newValidator(adapterInfo, templateInfo, typeChecker, data)
+	for k := range data {
+		if err := v.Validate(store.Update, k, specs[k]); err != nil {
+			// the failure of a handler is reported once, on the handler, rather than
+			// on each of the rules and instances associated with it.
+			if he, ok := err.(*handlerError); ok && he.handler != k.String() {
+				continue
+			}
+			diags = append(diags, Diagnostic{Key: k, Severity: SeverityError, Message: err.Error()})
+		}
+	}
+
+	c := &Controller{
+		adapterInfo:  adapterInfo,
+		templateInfo: templateInfo,
+		configState:  data,
+		status:       newConfigStatus(),
+	}
+	instanceConfig := c.validInstanceConfigs()
+	handlerConfig := c.validHandlerConfigs()
+	ht := newHandlerTable(instanceConfig, handlerConfig, nil)
+	c.processRules(handlerConfig, instanceConfig, ht)
+	for k, errs := range c.status.errors {
+		// invalid rules are already reported by the validator.
+		if c.status.invalid[k] {
+			continue
+		}
+		for _, e := range errs {
+			diags = append(diags, Diagnostic{Key: k, Severity: SeverityError, Message: e})
+		}
+	}
+
+	used := make(map[string]bool)
+	for _, he := range ht.table {
+		for inst := range he.Instances {
+			used[inst] = true
+		}
+	}
+	for k := range data {
+		if _, isInstance := templateInfo[k.Kind]; isInstance && !used[k.String()] {
+			diags = append(diags, Diagnostic{Key: k, Severity: SeverityWarning, Message: "instance is not used by any rule"})
+		}
+	}
+
+	sort.Slice(diags, func(i, j int) bool {
+		if ki, kj := diags[i].Key.String(), diags[j].Key.String(); ki != kj {
+			return ki < kj
+		}
+		return diags[i].Message < diags[j].Message
+	})
+	return diags
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"strings"
+	"testing"
+
+	"istio.io/mixer/pkg/config/store"
+)
+
+func TestLint(t *testing.T) {
+	adapterInfo, templateInfo, tc := testValidatorInfo(t)
+	specs := map[store.Key]map[string]interface{}{
+		testKey(AttributeManifestKind, "attrs"): {
+			"attributes": map[string]interface{}{"target.service": map[string]interface{}{"valueType": "STRING"}},
+		},
+		testKey("metric", "m1"):      {"name": "target.service"},
+		testKey("metric", "unused"):  {"name": "target.service"},
+		testKey("metric", "badexpr"): {"name": "source.service"},
+		testKey("AA", "a1"):          {"adapter": "good"},
+		testKey("AA", "bad"):         {"adapter": "bad"},
+		testKey(RulesKind, "r1"): {
+			"match":   `target.service == "abc"`,
+			"actions": []interface{}{map[string]interface{}{"handler": "a1.AA", "instances": []interface{}{"m1.metric", "missing.metric"}}},
+		},
+		testKey(RulesKind, "r2"): {
+			"actions": []interface{}{map[string]interface{}{"handler": "nohandler.AA", "instances": []interface{}{"m1.metric"}}},
+		},
+		testKey(RulesKind, "r3"): {"match": "target.service"},
+		testKey(RulesKind, "r4"): {
+			"actions": []interface{}{map[string]interface{}{"handler": "bad.AA", "instances": []interface{}{"m1.metric"}}},
+		},
+		testKey("unknown", "u1"): {},
+	}
+
+	want := []struct {
+		key      store.Key
+		severity Severity
+		message  string
+	}{
+		// reported once, on the handler rather than on r4 and m1.
+		{testKey("AA", "bad"), SeverityError, "handler validation failed"},
+		{testKey("metric", "badexpr"), SeverityError, "cannot infer type information"},
+		{testKey("metric", "badexpr"), SeverityWarning, "not used by any rule"},
+		{testKey(RulesKind, "r1"), SeverityError, "unknown instance: missing.metric.istio-system"},
+		{testKey(RulesKind, "r2"), SeverityError, "unknown handler: nohandler.AA.istio-system"},
+		{testKey(RulesKind, "r3"), SeverityError, "invalid match condition"},
+		{testKey("unknown", "u1"), SeverityError, "unrecognized kind"},
+		{testKey("metric", "unused"), SeverityWarning, "not used by any rule"},
+	}
+
+	diags := Lint(specs, adapterInfo, templateInfo, tc)
+	if len(diags) != len(want) {
+		t.Fatalf("Got %d diagnostics %v, Want %d", len(diags), diags, len(want))
+	}
+	for i, w := range want {
+		d := diags[i]
+		if d.Key != w.key || d.Severity != w.severity || !strings.Contains(d.Message, w.message) {
+			t.Errorf("diagnostic %d: Got %+v, Want %s %s containing %q", i, d, w.key, w.severity, w.message)
+		}
+	}
+}
@@ -107,6 +107,8 @@ const (
 // Resolution is performed in the following order
 // 1. Check rules from the defaultConfigNamespace -- these rules always apply
 // 2. Check rules from the target.service namespace
+// Attribute generators are resolved from the default namespace alone when the request
+// has no identity attribute yet.
 func (r *resolver) Resolve(attrs attribute.Bag, variety adptTmpl.TemplateVariety) (ra Actions, err error) {
 	nselected := 0
 	target := "unknown"
@@ -137,7 +139,11 @@ func (r *resolver) Resolve(attrs attribute.Bag, variety adptTmpl.TemplateVariety
 		resolveActions.With(lbls).Observe(float64(raLen))
 	}()
 
-	if target, ns, err = destAndNamespace(attrs, r.identityAttribute); err != nil {
+	if _, found := attrs.Get(r.identityAttribute); !found && variety == adptTmpl.TEMPLATE_VARIETY_ATTRIBUTE_GENERATOR {
+		// attribute generators may produce the identity attribute themselves,
+		// so only the rules of the default namespace apply to them without it.
+		ns = r.defaultConfigNamespace
+	} else if target, ns, err = destAndNamespace(attrs, r.identityAttribute); err != nil {
 		return nil, err
 	}
 
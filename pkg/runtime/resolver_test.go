@@ -126,6 +126,29 @@ func TestResolver_Resolve(t *testing.T) {
 			desc: "failure no identity",
 			err:  "identity not found",
 		},
+		{
+			desc: "success attribute generators without identity",
+			rules: []fakeRuleCfg{
+				{ns, 5},
+				{"myns", 3},
+			},
+			variety:     adptTmpl.TEMPLATE_VARIETY_ATTRIBUTE_GENERATOR,
+			callVariety: adptTmpl.TEMPLATE_VARIETY_ATTRIBUTE_GENERATOR,
+			nactions:    5,
+		},
+		{
+			desc: "success attribute generators with identity",
+			bag: map[string]interface{}{
+				ia: "myservice.myns",
+			},
+			rules: []fakeRuleCfg{
+				{ns, 5},
+				{"myns", 3},
+			},
+			variety:     adptTmpl.TEMPLATE_VARIETY_ATTRIBUTE_GENERATOR,
+			callVariety: adptTmpl.TEMPLATE_VARIETY_ATTRIBUTE_GENERATOR,
+			nactions:    8,
+		},
 		{
 			desc: "failure match error",
 			bag: map[string]interface{}{
@@ -0,0 +1,268 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang/glog"
+
+	cpb "istio.io/mixer/pkg/config/proto"
+	"istio.io/mixer/pkg/config/store"
+)
+
+// configStatus collects the problems found in the config resources while a snapshot
+// is published. A nil configStatus ignores all of them.
+type configStatus struct {
+	// errors are the problems found in each resource.
+	errors map[store.Key][]string
+
+	// invalid is the set of resources which failed validation.
+	invalid map[store.Key]bool
+}
+
+func newConfigStatus() *configStatus {
+	return &configStatus{
+		errors:  make(map[store.Key][]string),
+		invalid: make(map[store.Key]bool),
+	}
+}
+
+// warn records a problem which prevents a part of the resource from taking effect.
+// The same problem is recorded once.
+func (s *configStatus) warn(key store.Key, format string, args ...interface{}) {
+	if s == nil {
+		return
+	}
+	msg := fmt.Sprintf(format, args...)
+	for _, m := range s.errors[key] {
+		if m == msg {
+			return
+		}
+	}
+	s.errors[key] = append(s.errors[key], msg)
+}
+
+// invalidate records a validation failure of the resource.
+func (s *configStatus) invalidate(key store.Key, format string, args ...interface{}) {
+	if s == nil {
+		return
+	}
+	s.warn(key, format, args...)
+	s.invalid[key] = true
+}
+
+// invalidateFailedHandlers records the validation failures of the handlers and instances which
+// prevented the handlers of the table from being built.
+func (c *Controller) invalidateFailedHandlers(table map[string]*HandlerEntry) {
+	keys := make(map[string]store.Key, len(c.configState))
+	for key := range c.configState {
+		keys[key.String()] = key
+	}
+	for _, he := range table {
+		ve, ok := he.HandlerCreateError.(*validationError)
+		if !ok {
+			continue
+		}
+		if key, found := keys[ve.resource]; found {
+			c.status.invalidate(key, "%v", ve.err)
+		}
+	}
+}
+
+// invalidResource returns the name of the handler or instance whose validation failure
+// caused the error, or an empty string if the error is not a validation failure.
+func invalidResource(err error) string {
+	if ve, ok := err.(*validationError); ok {
+		return ve.resource
+	}
+	return ""
+}
+
+// resourceStatus computes the status of a config resource in the snapshot. Returns nil
+// for the resources which are not mixer config.
+func (c *Controller) resourceStatus(key store.Key, rules rulesMapByNamespace,
+	table map[string]*HandlerEntry, instanceHandlers map[string][]*HandlerEntry) *store.ResourceStatus {
+	errs := c.status.errors[key]
+	active := false
+	switch {
+	case key.Kind == RulesKind:
+		_, active = rules[key.Namespace][key.Name]
+		for _, act := range c.configState[key].Spec.(*cpb.Rule).Actions {
+			if he := table[act.Handler]; he != nil && he.HandlerCreateError != nil {
+				errs = append(errs, fmt.Sprintf("handler %s could not be initialized: %v", act.Handler, he.HandlerCreateError))
+			}
+		}
+		if !active && len(errs) == 0 {
+			errs = append(errs, "rule has no valid actions")
+		}
+	case key.Kind == AttributeManifestKind:
+		active = true
+	case c.adapterInfo[key.Kind] != nil:
+		he := table[key.String()]
+		active = he != nil && he.Handler != nil
+	default:
+		if _, found := c.templateInfo[key.Kind]; !found {
+			return nil
+		}
+		for _, he := range instanceHandlers[key.String()] {
+			if he.Handler != nil {
+				active = true
+			} else if invalidResource(he.HandlerCreateError) != key.String() {
+				// the validation failures of the instance itself are already recorded.
+				errs = append(errs, fmt.Sprintf("handler %s could not be initialized: %v", he.Name, he.HandlerCreateError))
+			}
+		}
+	}
+
+	st := &store.ResourceStatus{
+		Validated: !c.status.invalid[key],
+		Error:     strings.Join(errs, "; "),
+		Active:    active,
+	}
+	// the validation failures of the handler itself are reported in Error.
+	if he := table[key.String()]; he != nil && he.HandlerCreateError != nil && invalidResource(he.HandlerCreateError) != key.String() {
+		st.HandlerError = he.HandlerCreateError.Error()
+	}
+	return st
+}
+
+// writeStatus records the status of every config resource in the store. The status
+// is written in the background, and only for the resources whose status changed.
+func (c *Controller) writeStatus(rules rulesMapByNamespace, table map[string]*HandlerEntry) {
+	if c.statusWriter == nil {
+		return
+	}
+	if c.statusQueue == nil {
+		c.statusQueue = newStatusQueue(c.statusWriter)
+	}
+	instanceHandlers := make(map[string][]*HandlerEntry)
+	for _, he := range table {
+		for inst := range he.Instances {
+			instanceHandlers[inst] = append(instanceHandlers[inst], he)
+		}
+	}
+	// sort the handlers, so that the same problems lead to the same status.
+	for _, hes := range instanceHandlers {
+		sort.Slice(hes, func(i, j int) bool { return hes[i].Name < hes[j].Name })
+	}
+	statuses := make(map[store.Key]store.ResourceStatus, len(c.configState))
+	for key := range c.configState {
+		if st := c.resourceStatus(key, rules, table, instanceHandlers); st != nil {
+			statuses[key] = *st
+		}
+	}
+	c.statusQueue.update(statuses)
+}
+
+// statusQueue writes the status of the config resources off the controller loop.
+// Updates queued while a write is in progress are coalesced, the latest status of
+// each resource wins.
+type statusQueue struct {
+	w store.StatusWriter
+
+	mu sync.Mutex
+	// written is the last status queued for each resource.
+	written map[store.Key]store.ResourceStatus
+	// pending are the statuses which are not written yet.
+	pending map[store.Key]store.ResourceStatus
+
+	// ready signals that there are pending statuses.
+	ready chan struct{}
+
+	// done is closed to stop writing the statuses.
+	done chan struct{}
+}
+
+func newStatusQueue(w store.StatusWriter) *statusQueue {
+	q := &statusQueue{
+		w:       w,
+		written: make(map[store.Key]store.ResourceStatus),
+		pending: make(map[store.Key]store.ResourceStatus),
+		ready:   make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// update queues the statuses which differ from the last ones queued. The resources
+// missing from statuses are forgotten.
+func (q *statusQueue) update(statuses map[store.Key]store.ResourceStatus) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for key := range q.written {
+		if _, found := statuses[key]; !found {
+			delete(q.written, key)
+			delete(q.pending, key)
+		}
+	}
+	for key, st := range statuses {
+		if old, found := q.written[key]; found && old == st {
+			continue
+		}
+		q.written[key] = st
+		q.pending[key] = st
+	}
+	if len(q.pending) > 0 {
+		select {
+		case q.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// run writes the pending statuses as they are queued, until the queue is stopped.
+func (q *statusQueue) run() {
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-q.ready:
+		}
+
+		q.mu.Lock()
+		pending := q.pending
+		q.pending = make(map[store.Key]store.ResourceStatus)
+		q.mu.Unlock()
+
+		for key, st := range pending {
+			select {
+			case <-q.done:
+				return
+			default:
+			}
+			st := st
+			if err := q.w.WriteStatus(key, &st); err != nil {
+				glog.Warningf("Unable to write the status of %s: %v", key, err)
+				// write it again with the next snapshot.
+				q.mu.Lock()
+				if q.written[key] == st {
+					delete(q.written, key)
+				}
+				q.mu.Unlock()
+			}
+		}
+	}
+}
+
+// stop stops writing the statuses, the pending ones are dropped.
+func (q *statusQueue) stop() {
+	close(q.done)
+}
@@ -0,0 +1,214 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/glog"
+
+	pbd "istio.io/api/mixer/v1/config/descriptor"
+	"istio.io/mixer/pkg/adapter"
+	cpb "istio.io/mixer/pkg/config/proto"
+	"istio.io/mixer/pkg/config/store"
+	"istio.io/mixer/pkg/expr"
+	"istio.io/mixer/pkg/template"
+)
+
+// Validator checks a change of a config resource against the current config state
+// before it is accepted into the store. It runs the same checks as the Controller
+// does while publishing a snapshot: the type inference of instances, the validation
+// of the adapter configuration by the HandlerBuilder, and the type check of the
+// expressions against the attribute vocabulary.
+type Validator struct {
+	adapterInfo  map[string]*adapter.Info
+	templateInfo map[string]template.Info
+	typeChecker  expr.TypeChecker
+	kinds        map[string]proto.Message
+
+	// protects configState
+	mu          sync.RWMutex
+	configState map[store.Key]*store.Resource
+}
+
+// NewValidator creates a Validator which tracks the config state in the store.
+func NewValidator(s store.Store2, adapterInfo map[string]*adapter.Info,
+	templateInfo map[string]template.Info, typeChecker expr.TypeChecker) (*Validator, error) {
+	data, watchChan, err := startWatch(s, adapterInfo, templateInfo)
+	if err != nil {
+		return nil, err
+	}
+	v := newValidator(adapterInfo, templateInfo, typeChecker, data)
+	go watchChanges(watchChan, v.applyEvents)
+	return v, nil
+}
+
+func newValidator(adapterInfo map[string]*adapter.Info, templateInfo map[string]template.Info,
+	typeChecker expr.TypeChecker, data map[store.Key]*store.Resource) *Validator {
+	return &Validator{
+		adapterInfo:  adapterInfo,
+		templateInfo: templateInfo,
+		typeChecker:  typeChecker,
+		kinds:        kindMap(adapterInfo, templateInfo),
+		configState:  data,
+	}
+}
+
+// applyEvents keeps the config state of the validator in sync with the store.
+func (v *Validator) applyEvents(events []*store.Event) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, ev := range events {
+		switch ev.Type {
+		case store.Update:
+			v.configState[ev.Key] = ev.Value
+		case store.Delete:
+			delete(v.configState, ev.Key)
+		}
+	}
+}
+
+// Validate checks the change of the resource to the key. spec is the unstructured
+// spec of the resource, it is ignored for deletions. Deletions are always accepted,
+// since the runtime ignores the references to missing resources.
+func (v *Validator) Validate(t store.ChangeType, key store.Key, spec map[string]interface{}) error {
+	if t == store.Delete {
+		return nil
+	}
+	pbSpec, err := store.ParseSpec(key, spec, v.kinds)
+	if err != nil {
+		return err
+	}
+
+	// the controller is only used to compute the config state after the change,
+	// it never publishes a snapshot.
+	c := &Controller{
+		adapterInfo:  v.adapterInfo,
+		templateInfo: v.templateInfo,
+		configState:  v.stateWith(key, &store.Resource{Spec: pbSpec}),
+	}
+	df := c.processAttributeManifests()
+	hf := newHandlerFactory(v.templateInfo, v.typeChecker, df, v.adapterInfo).(*handlerFactory)
+	instanceConfig := c.validInstanceConfigs()
+	handlerConfig := c.validHandlerConfigs()
+	ht := newHandlerTable(instanceConfig, handlerConfig, nil)
+	c.processRules(handlerConfig, instanceConfig, ht)
+
+	var handlers []string
+	switch {
+	case key.Kind == AttributeManifestKind:
+		return validateAttributeManifest(pbSpec.(*cpb.AttributeManifest))
+	case key.Kind == RulesKind:
+		rule := pbSpec.(*cpb.Rule)
+		if err = v.validateMatch(key, rule, df); err != nil {
+			return err
+		}
+		for _, act := range rule.Actions {
+			handlers = append(handlers, act.Handler)
+		}
+	case v.adapterInfo[key.Kind] != nil:
+		handlers = []string{key.String()}
+	default:
+		if _, err = hf.inferType(instanceConfig[key.String()]); err != nil {
+			return err
+		}
+		for name, he := range ht.table {
+			if he.Instances[key.String()] {
+				handlers = append(handlers, name)
+			}
+		}
+	}
+	return validateHandlers(hf, handlers, handlerConfig, instanceConfig, ht)
+}
+
+// stateWith returns a copy of the current config state with the resource updated.
+// The specs are copied too, since processing the rules canonicalizes them in place,
+// and concurrent validations must not share them.
+func (v *Validator) stateWith(key store.Key, res *store.Resource) map[store.Key]*store.Resource {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	state := make(map[store.Key]*store.Resource, len(v.configState)+1)
+	for k, r := range v.configState {
+		state[k] = &store.Resource{Metadata: r.Metadata, Spec: proto.Clone(r.Spec)}
+	}
+	state[key] = res
+	return state
+}
+
+// validateMatch checks that the match condition of the rule is a boolean expression
+// which the resolver can process.
+func (v *Validator) validateMatch(key store.Key, rule *cpb.Rule, df expr.AttributeDescriptorFinder) error {
+	if len(rule.Match) == 0 {
+		return nil
+	}
+	if err := v.typeChecker.AssertType(rule.Match, df, pbd.BOOL); err != nil {
+		return fmt.Errorf("invalid match condition %q: %v", rule.Match, err)
+	}
+	if _, err := buildRule(key, rule, defaultResourcetype()); err != nil {
+		return fmt.Errorf("unable to process match condition %q: %v", rule.Match, err)
+	}
+	return nil
+}
+
+// validateAttributeManifest checks that every attribute has a value type.
+func validateAttributeManifest(m *cpb.AttributeManifest) error {
+	for name, info := range m.Attributes {
+		if info.ValueType == pbd.VALUE_TYPE_UNSPECIFIED {
+			return fmt.Errorf("attribute %s has no value type", name)
+		}
+	}
+	return nil
+}
+
+// validateHandlers validates the configuration of the handlers together with the
+// instances associated with them. References to unknown handlers are ignored, same
+// as the runtime does.
+func validateHandlers(hf *handlerFactory, handlers []string, handlerConfig map[string]*cpb.Handler,
+	instanceConfig map[string]*cpb.Instance, ht *handlerTable) error {
+	sort.Strings(handlers)
+	for _, name := range handlers {
+		hc := handlerConfig[name]
+		if hc == nil {
+			continue
+		}
+		var insts []*cpb.Instance
+		if he := ht.table[name]; he != nil {
+			for inst := range he.Instances {
+				insts = append(insts, instanceConfig[inst])
+			}
+		}
+		if err := hf.validate(hc, insts); err != nil {
+			if glog.V(3) {
+				glog.Infof("handler %s failed validation: %v", name, err)
+			}
+			return &handlerError{handler: name, err: err}
+		}
+	}
+	return nil
+}
+
+// handlerError is returned when a handler fails validation together with its instances.
+type handlerError struct {
+	// handler is the fully qualified name of the handler.
+	handler string
+	err     error
+}
+
+func (e *handlerError) Error() string {
+	return fmt.Sprintf("handler %s: %v", e.handler, e.err)
+}
@@ -0,0 +1,217 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package runtime
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/wrappers"
+
+	pbd "istio.io/api/mixer/v1/config/descriptor"
+	"istio.io/mixer/pkg/adapter"
+	cpb "istio.io/mixer/pkg/config/proto"
+	"istio.io/mixer/pkg/config/store"
+	"istio.io/mixer/pkg/expr"
+	"istio.io/mixer/pkg/template"
+)
+
+// vHndlrBldr rejects the handler configs whose adapter field is "bad".
+type vHndlrBldr struct {
+	cfg adapter.Config
+}
+
+func (b *vHndlrBldr) SetAdapterConfig(cfg adapter.Config) { b.cfg = cfg }
+
+func (b *vHndlrBldr) Validate() (ce *adapter.ConfigErrors) {
+	if b.cfg.(*cpb.Handler).Adapter == "bad" {
+		ce = ce.Append("adapter", errors.New("bad adapter config"))
+	}
+	return ce
+}
+
+func (b *vHndlrBldr) Build(context.Context, adapter.Env) (adapter.Handler, error) {
+	return nil, errors.New("validation should not build handlers")
+}
+
+// vTemplate infers the type of an instance from the expression in its name field.
+func vTemplate(name string) template.Info {
+	return template.Info{
+		Name:   name,
+		CtrCfg: &cpb.Instance{},
+		InferType: func(cfg proto.Message, tEvalFn template.TypeEvalFn) (proto.Message, error) {
+			if _, err := tEvalFn(cfg.(*cpb.Instance).Name); err != nil {
+				return nil, err
+			}
+			return &wrappers.StringValue{}, nil
+		},
+		SetType:                 func(map[string]proto.Message, adapter.HandlerBuilder) {},
+		BuilderSupportsTemplate: func(adapter.HandlerBuilder) bool { return true },
+	}
+}
+
+// testValidatorInfo returns the adapter "AA" which supports the template "metric",
+// the templates "metric" and "other", and the type checker to validate them.
+func testValidatorInfo(t *testing.T) (map[string]*adapter.Info, map[string]template.Info, expr.TypeChecker) {
+	tc, err := expr.NewCEXLEvaluator(expr.DefaultCacheSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	adapterInfo := map[string]*adapter.Info{
+		"AA": {
+			Name:               "AA",
+			DefaultConfig:      &cpb.Handler{},
+			SupportedTemplates: []string{"metric"},
+			NewBuilder:         func() adapter.HandlerBuilder { return &vHndlrBldr{} },
+		},
+	}
+	templateInfo := map[string]template.Info{
+		"metric": vTemplate("metric"),
+		"other":  vTemplate("other"),
+	}
+	return adapterInfo, templateInfo, tc
+}
+
+func newTestValidator(t *testing.T) *Validator {
+	adapterInfo, templateInfo, tc := testValidatorInfo(t)
+	ns := DefaultConfigNamespace
+	data := map[store.Key]*store.Resource{
+		{AttributeManifestKind, ns, "attrs"}: {Spec: &cpb.AttributeManifest{
+			Attributes: map[string]*cpb.AttributeManifest_AttributeInfo{
+				"target.service": {ValueType: pbd.STRING},
+			},
+		}},
+		{"metric", ns, "m1"}: {Spec: &cpb.Instance{Name: "target.service"}},
+		{"other", ns, "o1"}:  {Spec: &cpb.Instance{Name: "target.service"}},
+		{"AA", ns, "a1"}:     {Spec: &cpb.Handler{Adapter: "good"}},
+		{RulesKind, ns, "r1"}: {Spec: &cpb.Rule{
+			Actions: []*cpb.Action{{Handler: "a1.AA", Instances: []string{"m1.metric"}}},
+		}},
+	}
+	return newValidator(adapterInfo, templateInfo, tc, data)
+}
+
+func testKey(kind, name string) store.Key {
+	return store.Key{Kind: kind, Namespace: DefaultConfigNamespace, Name: name}
+}
+
+func TestValidator_Validate(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		t       store.ChangeType
+		key     store.Key
+		spec    map[string]interface{}
+		wantErr string
+	}{
+		{"rule", store.Update, testKey(RulesKind, "r2"), map[string]interface{}{
+			"match":   `target.service == "abc"`,
+			"actions": []interface{}{map[string]interface{}{"handler": "a1.AA", "instances": []interface{}{"m1.metric"}}},
+		}, ""},
+		{"rule dangling references", store.Update, testKey(RulesKind, "r2"), map[string]interface{}{
+			"actions": []interface{}{map[string]interface{}{"handler": "a2.AA", "instances": []interface{}{"m2.metric"}}},
+		}, ""},
+		{"rule match not boolean", store.Update, testKey(RulesKind, "r2"), map[string]interface{}{
+			"match": "target.service",
+		}, "invalid match condition"},
+		{"rule match unknown attribute", store.Update, testKey(RulesKind, "r2"), map[string]interface{}{
+			"match": `source.service == "abc"`,
+		}, "invalid match condition"},
+		{"rule unsupported template", store.Update, testKey(RulesKind, "r2"), map[string]interface{}{
+			"actions": []interface{}{map[string]interface{}{"handler": "a1.AA", "instances": []interface{}{"o1.other"}}},
+		}, "does not support template 'other'"},
+		{"rule unknown field", store.Update, testKey(RulesKind, "r2"), map[string]interface{}{
+			"foo": "bar",
+		}, "unknown field"},
+		{"instance", store.Update, testKey("metric", "m1"), map[string]interface{}{
+			"name": `target.service | "unknown"`,
+		}, ""},
+		{"instance type error", store.Update, testKey("metric", "m1"), map[string]interface{}{
+			"name": "source.service",
+		}, "cannot infer type information from params in instance 'm1.metric.istio-system'"},
+		{"unused instance type error", store.Update, testKey("metric", "m2"), map[string]interface{}{
+			"name": "source.service",
+		}, "cannot infer type information"},
+		{"handler", store.Update, testKey("AA", "a1"), map[string]interface{}{
+			"adapter": "better",
+		}, ""},
+		{"handler validation", store.Update, testKey("AA", "a1"), map[string]interface{}{
+			"adapter": "bad",
+		}, "handler a1.AA.istio-system: handler validation failed"},
+		{"unused handler validation", store.Update, testKey("AA", "a2"), map[string]interface{}{
+			"adapter": "bad",
+		}, "handler validation failed"},
+		{"attribute manifest", store.Update, testKey(AttributeManifestKind, "attrs2"), map[string]interface{}{
+			"attributes": map[string]interface{}{"source.service": map[string]interface{}{"valueType": "STRING"}},
+		}, ""},
+		{"attribute manifest without type", store.Update, testKey(AttributeManifestKind, "attrs2"), map[string]interface{}{
+			"attributes": map[string]interface{}{"source.service": map[string]interface{}{}},
+		}, "attribute source.service has no value type"},
+		{"unknown kind", store.Update, testKey("unknown", "u1"), map[string]interface{}{}, "unrecognized kind"},
+		{"delete", store.Delete, testKey("AA", "a1"), nil, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newTestValidator(t)
+			err := v.Validate(tt.t, tt.key, tt.spec)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Got %v, Want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Got %v, Want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidator_applyEvents(t *testing.T) {
+	v := newTestValidator(t)
+	attrs := testKey(AttributeManifestKind, "attrs")
+	inst := testKey("metric", "m2")
+	spec := map[string]interface{}{"name": "target.service"}
+
+	if err := v.Validate(store.Update, inst, spec); err != nil {
+		t.Fatalf("Got %v, Want nil", err)
+	}
+	v.applyEvents([]*store.Event{{Key: attrs, Type: store.Delete}})
+	if err := v.Validate(store.Update, inst, spec); err == nil {
+		t.Fatal("Got nil, Want error after the attribute is removed")
+	}
+	v.applyEvents([]*store.Event{{Key: attrs, Type: store.Update, Value: &store.Resource{Spec: &cpb.AttributeManifest{
+		Attributes: map[string]*cpb.AttributeManifest_AttributeInfo{
+			"target.service": {ValueType: pbd.STRING},
+		},
+	}}}})
+	if err := v.Validate(store.Update, inst, spec); err != nil {
+		t.Fatalf("Got %v, Want nil", err)
+	}
+}
+
+func TestValidator_ValidateDoesNotModifyState(t *testing.T) {
+	v := newTestValidator(t)
+	spec := map[string]interface{}{"adapter": "better"}
+	if err := v.Validate(store.Update, testKey("AA", "a1"), spec); err != nil {
+		t.Fatalf("Got %v, Want nil", err)
+	}
+
+	act := v.configState[testKey(RulesKind, "r1")].Spec.(*cpb.Rule).Actions[0]
+	if act.Handler != "a1.AA" || act.Instances[0] != "m1.metric" {
+		t.Errorf("Got action %v, Want the rule in the config state to be left as is", act)
+	}
+}
@@ -17,6 +17,7 @@ package template
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/gogo/protobuf/proto"
 	multierror "github.com/hashicorp/go-multierror"
@@ -53,6 +54,11 @@ type (
 	ProcessReportFn func(ctx context.Context, instCfg map[string]proto.Message, attrs attribute.Bag,
 		mapper expr.Evaluator, handler adapter.Handler) error
 
+	// ProcessGenAttrsFn instantiates the instance object and dispatches it to the handler. It returns the
+	// attributes bound to the output of the handler.
+	ProcessGenAttrsFn func(ctx context.Context, instName string, instCfg proto.Message, attrs attribute.Bag,
+		mapper expr.Evaluator, handler adapter.Handler) (*attribute.MutableBag, error)
+
 	// BuilderSupportsTemplateFn check if the handlerBuilder supports template.
 	BuilderSupportsTemplateFn func(hndlrBuilder adapter.HandlerBuilder) bool
 
@@ -75,6 +81,7 @@ type (
 		ProcessReport           ProcessReportFn
 		ProcessCheck            ProcessCheckFn
 		ProcessQuota            ProcessQuotaFn
+		ProcessGenAttrs         ProcessGenAttrsFn
 	}
 
 	// templateRepo implements Repository
@@ -127,6 +134,19 @@ func (t repo) SupportsTemplate(hndlrBuilder adapter.HandlerBuilder, tmpl string)
 	return true, ""
 }
 
+// outputPrefix prefixes the output fields of attribute generating adapters in attribute bindings.
+const outputPrefix = "$out."
+
+// OutputFieldName returns the name of the output field referred to by an attribute binding,
+// such as "sourceLabels" for "$out.sourceLabels", or "" if the binding refers to no output field.
+func OutputFieldName(binding string) string {
+	binding = strings.TrimSpace(binding)
+	if !strings.HasPrefix(binding, outputPrefix) {
+		return ""
+	}
+	return binding[len(outputPrefix):]
+}
+
 // EvalAll evaluates the value of the expression map using the passed in attributes.
 func EvalAll(expressions map[string]string, attrs attribute.Bag, eval expr.Evaluator) (map[string]interface{}, error) {
 	result := &multierror.Error{}
@@ -75,3 +75,22 @@ func TestSupportsTemplate(t *testing.T) {
 		})
 	}
 }
+
+func TestOutputFieldName(t *testing.T) {
+	for _, tst := range []struct {
+		binding string
+		want    string
+	}{
+		{"$out.sourceLabels", "sourceLabels"},
+		{" $out.podIp ", "podIp"},
+		{"sourceLabels", ""},
+		{"$out.", ""},
+		{"", ""},
+	} {
+		t.Run(tst.binding, func(t *testing.T) {
+			if got := OutputFieldName(tst.binding); got != tst.want {
+				t.Errorf("OutputFieldName(%q) = %q, want %q", tst.binding, got, tst.want)
+			}
+		})
+	}
+}
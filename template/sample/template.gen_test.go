@@ -34,6 +34,7 @@ import (
 	"istio.io/mixer/pkg/adapter"
 	"istio.io/mixer/pkg/attribute"
 	"istio.io/mixer/pkg/expr"
+	sample_apa "istio.io/mixer/template/sample/apa"
 	sample_check "istio.io/mixer/template/sample/check"
 	sample_quota "istio.io/mixer/template/sample/quota"
 	sample_report "istio.io/mixer/template/sample/report"
@@ -112,6 +113,26 @@ func (h *fakeQuotaHandler) SetQuotaTypes(t map[string]*sample_quota.Type) {
 func (h *fakeQuotaHandler) Validate() *adapter.ConfigErrors     { return nil }
 func (h *fakeQuotaHandler) SetAdapterConfig(cfg adapter.Config) {}
 
+type fakeAPAHandler struct {
+	adapter.Handler
+	retError      error
+	retOutput     *sample_apa.Output
+	cnfgCallInput interface{}
+	procCallInput interface{}
+}
+
+func (h *fakeAPAHandler) Close() error { return nil }
+func (h *fakeAPAHandler) HandleApa(ctx context.Context, instance *sample_apa.Instance) (*sample_apa.Output, error) {
+	h.procCallInput = instance
+	return h.retOutput, h.retError
+}
+func (h *fakeAPAHandler) Build(context.Context, adapter.Env) (adapter.Handler, error) {
+	return nil, nil
+}
+func (h *fakeAPAHandler) SetApaTypes(t map[string]*sample_apa.Type) { h.cnfgCallInput = t }
+func (h *fakeAPAHandler) Validate() *adapter.ConfigErrors           { return nil }
+func (h *fakeAPAHandler) SetAdapterConfig(cfg adapter.Config)       {}
+
 type fakeBag struct{}
 
 func (f fakeBag) Get(name string) (value interface{}, found bool) { return nil, false }
@@ -152,6 +173,14 @@ func TestGeneratedFields(t *testing.T) {
 			hndlrName: sample_quota.TemplateName + "." + "Handler",
 			name:      sample_quota.TemplateName,
 		},
+		{
+			tmpl:      sample_apa.TemplateName,
+			ctrCfg:    &sample_apa.InstanceParam{},
+			variety:   adpTmpl.TEMPLATE_VARIETY_ATTRIBUTE_GENERATOR,
+			bldrName:  sample_apa.TemplateName + "." + "HandlerBuilder",
+			hndlrName: sample_apa.TemplateName + "." + "Handler",
+			name:      sample_apa.TemplateName,
+		},
 	} {
 		t.Run(tst.tmpl, func(t *testing.T) {
 			if !reflect.DeepEqual(SupportedTmplInfo[tst.tmpl].CtrCfg, tst.ctrCfg) {
@@ -209,6 +238,16 @@ func TestHandlerSupportsTemplate(t *testing.T) {
 			hndlr:  &fakeQuotaHandler{},
 			result: true,
 		},
+		{
+			tmpl:   sample_apa.TemplateName,
+			hndlr:  fakeBadHandler{},
+			result: false,
+		},
+		{
+			tmpl:   sample_apa.TemplateName,
+			hndlr:  &fakeAPAHandler{},
+			result: true,
+		},
 	} {
 		t.Run(tst.tmpl, func(t *testing.T) {
 			c := SupportedTmplInfo[tst.tmpl].HandlerSupportsTemplate(tst.hndlr)
@@ -255,6 +294,16 @@ func TestBuilderSupportsTemplate(t *testing.T) {
 			hndlrBldr: &fakeQuotaHandler{},
 			result:    true,
 		},
+		{
+			tmpl:      sample_apa.TemplateName,
+			hndlrBldr: fakeBadHandler{},
+			result:    false,
+		},
+		{
+			tmpl:      sample_apa.TemplateName,
+			hndlrBldr: &fakeAPAHandler{},
+			result:    true,
+		},
 	} {
 		t.Run(tst.tmpl, func(t *testing.T) {
 			c := SupportedTmplInfo[tst.tmpl].BuilderSupportsTemplate(tst.hndlrBldr)
@@ -568,6 +617,84 @@ dimensions:
 	}
 }
 
+func TestInferTypeForSampleApa(t *testing.T) {
+	for _, tst := range []inferTypeTest{
+		{
+			name: "SimpleValid",
+			ctrCnfg: `
+uid: source.string
+stringMap:
+  a: source.string
+attribute_bindings:
+  source.name: $out.podName
+  source.labels: $out.labels
+`,
+			cstrParam: &sample_apa.InstanceParam{},
+		},
+		{
+			name: "UnknownOutputField",
+			ctrCnfg: `
+uid: source.string
+attribute_bindings:
+  source.name: $out.notAField
+`,
+			cstrParam: &sample_apa.InstanceParam{},
+			wantErr:   "attribute binding for 'source.name' refers to an unknown output field: '$out.notAField'",
+		},
+		{
+			name: "MissingOutputPrefix",
+			ctrCnfg: `
+uid: source.string
+attribute_bindings:
+  source.name: podName
+`,
+			cstrParam: &sample_apa.InstanceParam{},
+			wantErr:   "refers to an unknown output field: 'podName'",
+		},
+		{
+			name: "InferredTypeNotMatch",
+			ctrCnfg: `
+uid: source.int64
+`,
+			cstrParam: &sample_apa.InstanceParam{},
+			wantErr:   "error type checking for field Uid: Evaluated expression type INT64 want STRING",
+		},
+		{
+			name:      "NotValidInstanceParam",
+			ctrCnfg:   ``,
+			cstrParam: &empty.Empty{}, // cnstr type mismatch
+			willPanic: true,
+		},
+	} {
+		t.Run(tst.name, func(t *testing.T) {
+			cp := tst.cstrParam
+			_ = fillProto(tst.ctrCnfg, cp)
+			typeEvalFn := getExprEvalFunc(tst.typeEvalError)
+			defer func() {
+				r := recover()
+				if tst.willPanic && r == nil {
+					t.Errorf("Expected to recover from panic for %s, but recover was nil.", tst.name)
+				} else if !tst.willPanic && r != nil {
+					t.Errorf("got panic %v, expected success.", r)
+				}
+			}()
+			_, cerr := SupportedTmplInfo[sample_apa.TemplateName].InferType(cp.(proto.Message), typeEvalFn)
+			if tst.willPanic {
+				t.Error("Should not reach this statement due to panic.")
+			}
+			if tst.wantErr == "" {
+				if cerr != nil {
+					t.Errorf("got err %v\nwant <nil>", cerr)
+				}
+			} else {
+				if cerr == nil || !strings.Contains(cerr.Error(), tst.wantErr) {
+					t.Errorf("got error %v\nwant %v", cerr, tst.wantErr)
+				}
+			}
+		})
+	}
+}
+
 type SetTypeTest struct {
 	name     string
 	tmpl     string
@@ -945,6 +1072,91 @@ func TestProcessQuota(t *testing.T) {
 	}
 }
 
+func TestProcessGenAttrs(t *testing.T) {
+	out := sample_apa.NewOutput()
+	out.SetPodName("pod1")
+	out.SetLabels(map[string]string{"app": "foo"})
+
+	for _, tst := range []struct {
+		name         string
+		instName     string
+		inst         proto.Message
+		hdlr         adapter.Handler
+		wantInstance interface{}
+		wantAttrs    map[string]interface{}
+		wantError    string
+	}{
+		{
+			name:     "Simple",
+			instName: "foo",
+			inst: &sample_apa.InstanceParam{
+				Uid:       `"kubernetes://pod1.ns"`,
+				StringMap: map[string]string{"a": `"aaa"`},
+				AttributeBindings: map[string]string{
+					"source.name":   "$out.podName",
+					"source.labels": "$out.labels",
+					"source.ip":     "$out.podIp", // not set by the adapter
+				},
+			},
+			hdlr: &fakeAPAHandler{
+				retOutput: out,
+			},
+			wantInstance: &sample_apa.Instance{Name: "foo", Uid: "kubernetes://pod1.ns", StringMap: map[string]string{"a": "aaa"}},
+			wantAttrs: map[string]interface{}{
+				"source.name":   "pod1",
+				"source.labels": map[string]string{"app": "foo"},
+			},
+		},
+		{
+			name:     "EvalError",
+			instName: "foo",
+			inst: &sample_apa.InstanceParam{
+				Uid:       `"kubernetes://pod1.ns"`,
+				StringMap: map[string]string{"a": "bad.attribute"},
+			},
+			wantError: "unresolved attribute bad.attribute",
+		},
+		{
+			name:     "ProcessError",
+			instName: "foo",
+			inst: &sample_apa.InstanceParam{
+				Uid:       `"kubernetes://pod1.ns"`,
+				StringMap: map[string]string{"a": `"aaa"`},
+			},
+			hdlr: &fakeAPAHandler{
+				retError: fmt.Errorf("some error"),
+			},
+			wantError: "some error",
+		},
+	} {
+		t.Run(tst.name, func(t *testing.T) {
+			h := &tst.hdlr
+			ev, _ := expr.NewCEXLEvaluator(expr.DefaultCacheSize)
+			bag, err := SupportedTmplInfo[sample_apa.TemplateName].ProcessGenAttrs(context.TODO(), tst.instName, tst.inst, fakeBag{}, ev, *h)
+
+			if tst.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tst.wantError) {
+					t.Errorf("ProcessGenAttrsSample got error = %v, want %s", err, tst.wantError)
+				}
+			} else {
+				v := (*h).(*fakeAPAHandler).procCallInput
+				if !reflect.DeepEqual(v, tst.wantInstance) {
+					t.Errorf("ProcessGenAttrsSample handler "+
+						"invoked value = %v want %v", spew.Sdump(v), spew.Sdump(tst.wantInstance))
+				}
+				if len(bag.Names()) != len(tst.wantAttrs) {
+					t.Errorf("ProcessGenAttrsSample attributes = %v, want %v", bag.Names(), tst.wantAttrs)
+				}
+				for name, want := range tst.wantAttrs {
+					if got, _ := bag.Get(name); !reflect.DeepEqual(got, want) {
+						t.Errorf("ProcessGenAttrsSample attribute %s = %v, want %v", name, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
 func cmp(m interface{}, n interface{}) bool {
 	a := InterfaceSlice(m)
 	b := InterfaceSlice(n)
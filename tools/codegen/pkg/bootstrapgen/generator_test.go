@@ -45,6 +45,9 @@ func TestGenerator_Generate(t *testing.T) {
 			"testdata/quota_proto.descriptor_set":   "istio.io/mixer/template/quota",
 			"testdata/report1_proto.descriptor_set": "istio.io/mixer/template/log"},
 			"testdata/AllTemplates.go.golden"},
+		{"APATemplate", map[string]string{
+			"testdata/apa_proto.descriptor_set": "istio.io/mixer/template/apa"},
+			"testdata/APATemplate.go.golden"},
 	}
 	for _, v := range tests {
 		t.Run(v.name, func(t *testing.T) {
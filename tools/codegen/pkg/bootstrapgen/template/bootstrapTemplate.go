@@ -117,6 +117,17 @@ var (
 						{{end}}
 					{{end}}
 				{{end}}
+				{{if eq .VarietyName "TEMPLATE_VARIETY_ATTRIBUTE_GENERATOR"}}
+					for attrName, outExpr := range cpb.AttributeBindings {
+						switch template.OutputFieldName(outExpr) {
+						{{range .OutputTemplateMessage.Fields}}
+							case "{{.ProtoName}}":
+						{{end}}
+						default:
+							return nil, fmt.Errorf("attribute binding for '%s' refers to an unknown output field: '%s'", attrName, outExpr)
+						}
+					}
+				{{end}}
 				_ = cpb
 				return infrdType, err
 			},
@@ -217,6 +228,65 @@ var (
 					}
 					return handler.({{.GoPackageName}}.Handler).Handle{{.InterfaceName}}(ctx, instance)
 				},
+			{{else if eq .VarietyName "TEMPLATE_VARIETY_ATTRIBUTE_GENERATOR"}}
+				ProcessGenAttrs: func(ctx context.Context, instName string, inst proto.Message, attrs attribute.Bag,
+				mapper expr.Evaluator, handler adapter.Handler) (*attribute.MutableBag, error) {
+					castedInst := inst.(*{{.GoPackageName}}.InstanceParam)
+					{{range .TemplateMessage.Fields}}
+						{{if .GoType.IsMap}}
+							{{.GoName}}, err := template.EvalAll(castedInst.{{.GoName}}, attrs, mapper)
+						{{else}}
+							{{.GoName}}, err := mapper.Eval(castedInst.{{.GoName}}, attrs)
+						{{end}}
+							if err != nil {
+								msg := fmt.Sprintf("failed to eval {{.GoName}} for instance '%s': %v", instName, err)
+								glog.Error(msg)
+								return nil, errors.New(msg)
+							}
+					{{end}}
+
+					instance := &{{.GoPackageName}}.Instance{
+						Name:	instName,
+						{{range .TemplateMessage.Fields}}
+							{{if containsValueType .GoType}}
+								{{.GoName}}: {{.GoName}},
+							{{else}}
+								{{if .GoType.IsMap}}
+									{{.GoName}}: func(m map[string]interface{}) map[string]{{.GoType.MapValue.Name}} {
+										res := make(map[string]{{.GoType.MapValue.Name}}, len(m))
+										for k, v := range m {
+											res[k] = v.({{.GoType.MapValue.Name}})
+										}
+										return res
+									}({{.GoName}}),
+								{{else}}
+									{{.GoName}}: {{.GoName}}.({{.GoType.Name}}),{{reportTypeUsed .GoType}}
+								{{end}}
+							{{end}}
+						{{end}}
+					}
+
+					out, err := handler.({{.GoPackageName}}.Handler).Handle{{.InterfaceName}}(ctx, instance)
+					if err != nil {
+						return nil, err
+					}
+
+					// only the fields set by the adapter become attributes
+					abag := attribute.GetMutableBag(nil)
+					for attrName, outExpr := range castedInst.AttributeBindings {
+						field := template.OutputFieldName(outExpr)
+						if !out.WasSet(field) {
+							continue
+						}
+						switch field {
+						{{range .OutputTemplateMessage.Fields}}
+							case "{{.ProtoName}}":
+								abag.Set(attrName, out.{{.GoName}})
+						{{end}}
+						}
+					}
+					return abag, nil
+				},
 			{{else}}
 				ProcessQuota: func(ctx context.Context, quotaName string, inst proto.Message, attrs attribute.Bag,
 				 mapper expr.Evaluator, handler adapter.Handler, args adapter.QuotaArgs) (adapter.QuotaResult, error) {
@@ -43,7 +43,37 @@ type Instance struct {
   {{.GoName}} {{replaceGoValueTypeToInterface .GoType}}{{reportTypeUsed .GoType}}
   {{end}}
 }
+{{if eq .VarietyName "TEMPLATE_VARIETY_ATTRIBUTE_GENERATOR"}}
+// Output is returned by adapters that generate attributes for the '{{.TemplateName}}' template.
+// Mixer turns the fields set by the adapter into attributes, as configured by the
+// attribute bindings of the instance.{{if ne .OutputTemplateMessage.Comment ""}}
+//
+{{.OutputTemplateMessage.Comment}}{{end}}
+type Output struct {
+  fieldsSet map[string]bool
+  {{range .OutputTemplateMessage.Fields}}
+  {{.Comment}}
+  {{.GoName}} {{.GoType.Name}}{{reportTypeUsed .GoType}}
+  {{end}}
+}
 
+// NewOutput returns an Output with none of its fields set.
+func NewOutput() *Output {
+  return &Output{fieldsSet: make(map[string]bool)}
+}
+{{range .OutputTemplateMessage.Fields}}
+// Set{{.GoName}} sets the value of the '{{.ProtoName}}' field.
+func (o *Output) Set{{.GoName}}(val {{.GoType.Name}}) {
+  o.fieldsSet["{{.ProtoName}}"] = true
+  o.{{.GoName}} = val
+}
+{{end}}
+// WasSet returns true if the adapter set the value of the given field.
+func (o *Output) WasSet(field string) bool {
+  _, found := o.fieldsSet[field]
+  return found
+}
+{{end}}
 // HandlerBuilder must be implemented by adapters if they want to
 // process data associated with the '{{.TemplateName}}' template.
 //
@@ -76,6 +106,8 @@ type Handler interface {
     Handle{{.InterfaceName}}(context.Context, *Instance) (adapter.CheckResult, error)
   {{else if eq .VarietyName "TEMPLATE_VARIETY_QUOTA" -}}
     Handle{{.InterfaceName}}(context.Context, *Instance, adapter.QuotaArgs) (adapter.QuotaResult, error)
+  {{else if eq .VarietyName "TEMPLATE_VARIETY_ATTRIBUTE_GENERATOR" -}}
+    Handle{{.InterfaceName}}(context.Context, *Instance) (*Output, error)
   {{else -}}
     Handle{{.InterfaceName}}(context.Context, []*Instance) error
   {{end}}
@@ -40,6 +40,12 @@ message Type {
 message InstanceParam {
   {{range .TemplateMessage.Fields}}
   {{stringify .ProtoType}} {{.ProtoName}} = {{.Number}};
+  {{end}}{{if eq .VarietyName "TEMPLATE_VARIETY_ATTRIBUTE_GENERATOR"}}
+  // Attribute names to output field mapping. Each value names a field of the output
+  // of the adapter, with the '$out.<fieldName>' notation. For example, with
+  // 'source.labels: $out.sourceLabels', the 'source.labels' attribute is set to the
+  // 'sourceLabels' field of the output.
+  map<string, string> attribute_bindings = 72295728;
   {{end}}
 }
 `
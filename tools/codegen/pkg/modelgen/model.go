@@ -68,6 +68,10 @@ type (
 		PackageName     string
 		TemplateMessage MessageInfo
 
+		// Info for the values produced by attribute generating adapters. Only
+		// set for templates of the TEMPLATE_VARIETY_ATTRIBUTE_GENERATOR variety.
+		OutputTemplateMessage MessageInfo
+
 		// Warnings/Errors in the Template proto file.
 		diags []diag
 	}
@@ -139,11 +143,20 @@ func (m *Model) fillModel(templateProto *FileDescriptor, parser *FileDescriptorS
 
 	m.addTopLevelFields(templateProto)
 	// ensure Template is present
-	if tmplDesc, ok := getRequiredTmplMsg(templateProto); !ok {
+	if tmplDesc, ok := getRequiredMsg(templateProto, "Template"); !ok {
 		m.addError(templateProto.GetName(), unknownLine, "message 'Template' not defined")
 	} else {
 		m.addTemplateMessage(parser, templateProto, tmplDesc)
 	}
+
+	// attribute generating templates also describe the values produced by the adapters
+	if m.VarietyName == tmpl.TEMPLATE_VARIETY_ATTRIBUTE_GENERATOR.String() {
+		if outDesc, ok := getRequiredMsg(templateProto, "OutputTemplate"); !ok {
+			m.addError(templateProto.GetName(), unknownLine, "message 'OutputTemplate' not defined")
+		} else {
+			m.addOutputTemplateMessage(parser, templateProto, outDesc)
+		}
+	}
 }
 
 func (m *Model) addTemplateMessage(parser *FileDescriptorSetParser, tmplProto *FileDescriptor, tmplDesc *Descriptor) {
@@ -180,6 +193,29 @@ func (m *Model) addTemplateMessage(parser *FileDescriptorSetParser, tmplProto *F
 	}
 }
 
+func (m *Model) addOutputTemplateMessage(parser *FileDescriptorSetParser, tmplProto *FileDescriptor, outDesc *Descriptor) {
+	m.OutputTemplateMessage.Comment = tmplProto.getComment(outDesc.path)
+	m.OutputTemplateMessage.Fields = make([]FieldInfo, 0)
+	for i, fieldDesc := range outDesc.Field {
+		fieldName := fieldDesc.GetName()
+
+		protoTypeInfo, goTypeInfo, err := getOutputTypeName(parser, fieldDesc)
+		if err != nil {
+			m.addError(outDesc.file.GetName(),
+				tmplProto.getLineNumber(getPathForField(outDesc, i)),
+				err.Error())
+		}
+		m.OutputTemplateMessage.Fields = append(m.OutputTemplateMessage.Fields, FieldInfo{
+			ProtoName: fieldName,
+			GoName:    camelCase(fieldName),
+			GoType:    goTypeInfo,
+			ProtoType: protoTypeInfo,
+			Number:    strconv.Itoa(int(fieldDesc.GetNumber())),
+			Comment:   tmplProto.getComment(getPathForField(outDesc, i)),
+		})
+	}
+}
+
 // Find the file that has the options TemplateVariety and TemplateName. There should only be one such file.
 func getTmplFileDesc(fds []*FileDescriptor) (*FileDescriptor, []diag) {
 	var templateDescriptorProto *FileDescriptor
@@ -256,10 +292,10 @@ func getLastSegment(pkg string) (string, error) {
 	return "", fmt.Errorf("the last segment of package name '%s' must match the reges '%s'", pkg, pkgLaskSeg)
 }
 
-func getRequiredTmplMsg(fdp *FileDescriptor) (*Descriptor, bool) {
+func getRequiredMsg(fdp *FileDescriptor, name string) (*Descriptor, bool) {
 	var cstrDesc *Descriptor
 	for _, desc := range fdp.desc {
-		if desc.GetName() == "Template" {
+		if desc.GetName() == name {
 			cstrDesc = desc
 			break
 		}
@@ -276,6 +312,21 @@ func createInvalidTypeError(field string, err error) error {
 	return fmt.Errorf(errStr+": %v", err)
 
 }
+
+// getOutputTypeName returns the type of an OutputTemplate field. Output fields hold values rather
+// than expressions, so ValueType is not supported, while bytes is, for values such as IP addresses.
+func getOutputTypeName(g *FileDescriptorSetParser, field *descriptor.FieldDescriptorProto) (protoType TypeInfo, goType TypeInfo, err error) {
+	if field.GetType() == descriptor.FieldDescriptorProto_TYPE_BYTES {
+		return TypeInfo{Name: "bytes"}, TypeInfo{Name: sBYTES}, nil
+	}
+	protoType, goType, err = getTypeName(g, field)
+	if err != nil || protoType.IsValueType || protoType.IsMap && protoType.MapValue.IsValueType {
+		return TypeInfo{}, TypeInfo{}, fmt.Errorf("unsupported type for output field '%s'. Supported types are '%s'",
+			field.GetName(), supportedOutputTypes)
+	}
+	return protoType, goType, nil
+}
+
 func getTypeName(g *FileDescriptorSetParser, field *descriptor.FieldDescriptorProto) (protoType TypeInfo, goType TypeInfo, err error) {
 	switch *field.Type {
 	case descriptor.FieldDescriptorProto_TYPE_STRING:
@@ -44,6 +44,10 @@ func TestErrorInTemplate(t *testing.T) {
 		{"testdata/unsupported_field_type_enum.descriptor_set", "unsupported type for field 'o'."},
 		{"testdata/wrong_pkg_name.descriptor_set", "WrongPkgName.proto:2: the last segment of package " +
 			"name 'foo.badStrNumbersNotAllowed123' must match the reges '^[a-zA-Z]+$'"},
+		{"testdata/missing_output_template_message.descriptor_set", "message 'OutputTemplate' not defined"},
+		{"testdata/unsupported_output_field_type.descriptor_set", "UnsupportedOutputFieldType.proto:15: " +
+			"unsupported type for output field 'o'. Supported types are 'string, int64, double, bool, bytes, " +
+			"map<string, string | int64 | double | bool>'"},
 	}
 
 	for idx, tt := range tests {
@@ -193,6 +197,52 @@ func TestTypeFields(t *testing.T) {
 		}, "")
 }
 
+func TestOutputTemplateFields(t *testing.T) {
+	testFilename := "testdata/attribute_generator_template.descriptor_set"
+	model, err := createTestModel(t, testFilename)
+	if err != nil {
+		t.Fatalf("model creation failed %v", err)
+	}
+
+	if model.VarietyName != "TEMPLATE_VARIETY_ATTRIBUTE_GENERATOR" {
+		t.Errorf("CreateModel(%s).VarietyName = %v, wanted %s", testFilename, model.VarietyName, "TEMPLATE_VARIETY_ATTRIBUTE_GENERATOR")
+	}
+	if len(model.TemplateMessage.Fields) != 2 {
+		t.Errorf("len(CreateModel(%s).TemplateMessage.Fields) = %v, wanted %d", testFilename, len(model.TemplateMessage.Fields), 2)
+	}
+	if model.OutputTemplateMessage.Comment != "// My OutputTemplate comment" {
+		t.Errorf("CreateModel(%s).OutputTemplateMessage.Comment = %s, wanted %s", testFilename,
+			model.OutputTemplateMessage.Comment, "// My OutputTemplate comment")
+	}
+	if len(model.OutputTemplateMessage.Fields) != 4 {
+		t.Fatalf("len(CreateModel(%s).OutputTemplateMessage.Fields) = %v, wanted %d", testFilename, len(model.OutputTemplateMessage.Fields), 4)
+	}
+
+	testField(t, model.OutputTemplateMessage.Fields,
+		"podName", TypeInfo{Name: "string"}, "PodName", TypeInfo{Name: "string"}, "single line comment")
+
+	testField(t, model.OutputTemplateMessage.Fields,
+		"podIp", TypeInfo{Name: "bytes"}, "PodIp", TypeInfo{Name: "[]byte"}, "")
+
+	testField(t, model.OutputTemplateMessage.Fields,
+		"port", TypeInfo{Name: "int64"}, "Port", TypeInfo{Name: "int64"}, "")
+
+	testField(t, model.OutputTemplateMessage.Fields,
+		"labels",
+		TypeInfo{Name: "map<string, string>",
+			IsMap:    true,
+			MapKey:   &TypeInfo{Name: "string"},
+			MapValue: &TypeInfo{Name: "string"},
+		},
+		"Labels",
+		TypeInfo{
+			Name:     "map[string]string",
+			IsMap:    true,
+			MapKey:   &TypeInfo{Name: "string"},
+			MapValue: &TypeInfo{Name: "string"},
+		}, "")
+}
+
 func testField(t *testing.T, fields []FieldInfo, protoFldName string, protoFldType TypeInfo,
 	goFldName string, goFldType TypeInfo, comment string) {
 	testFilename := "testdata/simple_template.descriptor_set"
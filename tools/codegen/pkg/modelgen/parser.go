@@ -312,6 +312,7 @@ const (
 	sFLOAT64 = "float64"
 	sBOOL    = "bool"
 	sSTRING  = "string"
+	sBYTES   = "[]byte"
 )
 
 // protoType returns a Proto type name for a Field's DescriptorProto.
@@ -320,6 +321,10 @@ var supportedPrimitives = []string{"string", "int64", "double", "bool"}
 var supportedTypes = strings.Join(supportedPrimitives, ", ") + ", " + fullProtoNameOfValueTypeEnum + ", " +
 	fmt.Sprintf("map<string, %s | %s>", fullProtoNameOfValueTypeEnum, strings.Join(supportedPrimitives, " | "))
 
+// OutputTemplate fields hold the values produced by attribute generating adapters, rather than expressions.
+var supportedOutputTypes = strings.Join(supportedPrimitives, ", ") + ", bytes, " +
+	fmt.Sprintf("map<string, %s>", strings.Join(supportedPrimitives, " | "))
+
 // TypeName returns a full name for the underlying Object type.
 func (g *FileDescriptorSetParser) TypeName(obj Object) string {
 	return g.DefaultPackageName(obj) + camelCaseSlice(obj.TypeName())